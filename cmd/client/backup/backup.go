@@ -1,6 +1,12 @@
 package backup
 
 import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/johann/ib/internal/backup"
+	"github.com/johann/ib/internal/client"
 	"github.com/spf13/cobra"
 )
 
@@ -12,6 +18,43 @@ var Cmd = &cobra.Command{
 
 func init() {
 	Cmd.AddCommand(createCmd)
+	Cmd.AddCommand(runCmd)
 	Cmd.AddCommand(listCmd)
+	Cmd.AddCommand(historyCmd)
+	Cmd.AddCommand(dedupCmd)
+	Cmd.AddCommand(diffCmd)
 	Cmd.AddCommand(restoreCmd)
+	Cmd.AddCommand(verifyCmd)
+}
+
+// fetchManifest fetches a manifest by ID, or the latest one matching tags
+// (key=value format)
+func fetchManifest(ctx context.Context, c *client.Client, id string, tagArgs []string) (*backup.Manifest, error) {
+	c.ServerInfo(ctx) // Warns if the server speaks a different API
+	if id != "" {
+		fmt.Printf("Fetching backup %s...\n", id)
+		manifest, err := c.GetManifest(ctx, id)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch manifest: %w", err)
+		}
+		return manifest, nil
+	}
+
+	tags := make(map[string]string)
+	for _, t := range tagArgs {
+		parts := strings.SplitN(t, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid tag format: %s (expected key=value)", t)
+		}
+		tags[parts[0]] = parts[1]
+	}
+	fmt.Printf("Fetching latest backup with tags %v...\n", tags)
+	manifest, err := c.GetLatestManifest(ctx, tags)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch manifest: %w", err)
+	}
+	if manifest == nil {
+		return nil, fmt.Errorf("no backup found matching tags")
+	}
+	return manifest, nil
 }
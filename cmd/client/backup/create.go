@@ -2,13 +2,21 @@ package backup
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
 	"strings"
 	"time"
 
+	"github.com/johann/ib/cmd/client/key"
 	"github.com/johann/ib/internal/backup"
 	"github.com/johann/ib/internal/client"
 	"github.com/johann/ib/internal/config"
+	"github.com/johann/ib/internal/tracing"
+	"github.com/johann/ib/internal/version"
 	"github.com/spf13/cobra"
 )
 
@@ -26,59 +34,383 @@ Example: ib backup create --tag name=myapp --tag env=prod ./data`,
 }
 
 var (
-	createTags        []string
-	createConcurrency int
+	createTags           []string
+	createExcludes       []string
+	createMarkers        []string
+	createSkipCaches     bool
+	createIncludes       []string
+	createFilterFile     string
+	createConcurrency    int
+	createMinConcurrency int
+	createMaxConcurrency int
+	createChunkSize      int
+	createCDC            bool
+	createChunkMin       int
+	createChunkAvg       int
+	createChunkMax       int
+	createKuboCompat     bool
+	createCompression    string
+	createLevel          int
+	createReaders        int
+	createQueueDepth     int
+	createDelta          bool
+	createRescan         bool
+	createOnError        string
+	createChanges        string
+	createFollowSymlinks bool
+	createOneFileSystem  bool
+	createKeyFile        string
+	createPingURL        string
+	createMaxMemory      string
+	createNoHostTags     bool
+	createResume         bool
+	createXattrs         bool
+	createACLs           bool
+	createPreHook        string
+	createPostHook       string
+	createKeep           backup.RetentionPolicy
 )
 
+// Version is the client version recorded in the ib_version tag, set by main
+var Version = "dev"
+
 func init() {
-	createCmd.Flags().StringArrayVar(&createTags, "tag", nil, "Tag in key=value format (can be repeated)")
-	createCmd.Flags().IntVar(&createConcurrency, "concurrency", 16, "Number of concurrent upload workers")
+	addCreateFlags(createCmd)
+}
+
+// addCreateFlags registers the flags shared by 'backup create' and 'backup run'
+func addCreateFlags(cmd *cobra.Command) {
+	cmd.Flags().StringArrayVar(&createTags, "tag", nil, "Tag in key=value format (can be repeated)")
+	cmd.Flags().StringArrayVar(&createExcludes, "exclude", nil, "Skip paths matching this .gitignore-style pattern (can be repeated)")
+	cmd.Flags().StringArrayVar(&createMarkers, "exclude-if-present", nil, "Skip directories that contain a file with this name, e.g. .nobackup (can be repeated)")
+	cmd.Flags().BoolVar(&createSkipCaches, "exclude-caches", false, "Skip cache directories tagged with a CACHEDIR.TAG file, like those of pip, cargo and browsers")
+	cmd.Flags().StringArrayVar(&createIncludes, "include", nil, "Keep paths matching this .gitignore-style pattern even if --exclude or an ignore file skips them (can be repeated)")
+	cmd.Flags().BoolVar(&createFollowSymlinks, "follow-symlinks", false, "Back up what symlinks point to instead of the links, e.g. for trees that link into other volumes (links that would loop stay links)")
+	cmd.Flags().BoolVar(&createOneFileSystem, "one-file-system", false, "Don't descend into other file systems, e.g. /proc or network mounts when backing up / (mount points are kept as empty directories)")
+	cmd.Flags().StringVar(&createFilterFile, "filter-file", "", "Apply ordered rsync-style rules ('+ pattern' includes, '- pattern' excludes, first match wins) before .gitignore/.ibignore/--exclude")
+	cmd.Flags().IntVar(&createConcurrency, "concurrency", 0, "Number of concurrent upload workers (default: adapt to upload latency and errors)")
+	cmd.Flags().IntVar(&createMinConcurrency, "min-concurrency", backup.DefaultMinConcurrency, "Lower bound of the adaptive concurrency")
+	cmd.Flags().IntVar(&createMaxConcurrency, "max-concurrency", backup.DefaultMaxConcurrency, "Upper bound of the adaptive concurrency")
+	cmd.Flags().IntVar(&createQueueDepth, "queue-depth", backup.DefaultQueueDepth, "Chunks read ahead and waiting for the upload workers; reading pauses when the queue is full")
+	cmd.Flags().StringVar(&createMaxMemory, "max-memory", "512MiB", "Memory for chunks read ahead and waiting for upload, e.g. 256MiB or 2GiB (0 for no limit)")
+	cmd.Flags().IntVar(&createChunkSize, "chunk-size", 0, "Chunk size in bytes (default 8MiB)")
+	cmd.Flags().BoolVar(&createCDC, "cdc", false, "Split files at content-defined boundaries (FastCDC), so inserts and prepends only change the chunks around them")
+	cmd.Flags().IntVar(&createChunkMin, "chunk-min", 0, "Minimum content-defined chunk size in bytes (default 512KiB)")
+	cmd.Flags().IntVar(&createChunkAvg, "chunk-avg", 0, "Average content-defined chunk size in bytes, a power of two (default 2MiB)")
+	cmd.Flags().IntVar(&createChunkMax, "chunk-max", 0, "Maximum content-defined chunk size in bytes (default 8MiB)")
+	cmd.Flags().StringVar(&createChanges, "change-detection", string(backup.ChangeMtime), "How unchanged files are found without reading them: mtime (and size), ctime or inode (also compare those), or checksum (read every file, upload only changed chunks)")
+	cmd.Flags().StringVar(&createOnError, "on-error", backup.OnErrorSkip, "What to do about files and directories that can't be read: skip (leave them out, listed in the manifest) or fail")
+	cmd.Flags().BoolVar(&createRescan, "rescan", false, "Read and chunk every file instead of trusting the previous backup for unchanged ones, e.g. after suspected bit rot (blocks the server has are still not uploaded)")
+	cmd.Flags().BoolVar(&createDelta, "delta", false, "Upload only the chunks of a changed file that its previous version doesn't have, without checking the others with the server (best with --cdc)")
+	cmd.Flags().BoolVar(&createKuboCompat, "kubo-compat", false, "Chunk like 'ipfs add --cid-version=1' (256KiB chunks) so file CIDs match kubo")
+	cmd.Flags().StringVar(&createCompression, "compression", backup.CompressionLZ4, "Block compression, lz4 or zstd (smaller, slower)")
+	cmd.Flags().IntVar(&createLevel, "compression-level", 0, "zstd compression level, 1-22 (default 3)")
+	cmd.Flags().IntVar(&createReaders, "readers", 1, "Read, hash and compress files of 4 or more chunks with this many readers at once (not with --cdc)")
+	cmd.Flags().BoolVar(&createXattrs, "xattrs", false, "Back up extended attributes (Linux and macOS)")
+	cmd.Flags().BoolVar(&createACLs, "acls", false, "Back up POSIX ACLs, as set with setfacl (Linux)")
+	cmd.Flags().StringVar(&createKeyFile, "key-file", "", "Encrypt the backup with this key file (default: encryption_key_file from the config)")
+	cmd.Flags().BoolVar(&createNoHostTags, "no-host-tags", false, "Don't add the host, os, arch, user and ib_version tags (default: no_host_tags from the config)")
+	cmd.Flags().BoolVar(&createResume, "resume", false, "Continue the interrupted backup of this path and name from its checkpoint instead of starting over")
+	cmd.Flags().StringVar(&createPreHook, "pre-hook", "", "Shell command to run before the backup, e.g. to quiesce a database; the backup fails if it does")
+	cmd.Flags().StringVar(&createPostHook, "post-hook", "", "Shell command to run after the backup, successful or not, with IB_RESULT, IB_MANIFEST_ID and IB_ERROR set")
+	cmd.Flags().IntVar(&createKeep.Last, "keep-last", 0, "After the backup, delete all but the N newest backups with the same name (needs an admin token)")
+	cmd.Flags().IntVar(&createKeep.Daily, "keep-daily", 0, "After the backup, keep the newest backup of each of the last N days with one")
+	cmd.Flags().IntVar(&createKeep.Weekly, "keep-weekly", 0, "After the backup, keep the newest backup of each of the last N weeks with one")
+	cmd.Flags().IntVar(&createKeep.Monthly, "keep-monthly", 0, "After the backup, keep the newest backup of each of the last N months with one")
+	cmd.Flags().StringVar(&createPingURL, "ping-url", "", "Healthchecks.io-style URL to ping on start (/start), success and failure (/fail) (default IB_PING_URL)")
+}
+
+// createOptions are the settings of one backup
+type createOptions struct {
+	path        string
+	tags        map[string]string
+	excludes    []string
+	markers     []string // Names of --exclude-if-present
+	skipCaches  bool
+	includes    []string
+	filterFile  string
+	follow      bool
+	oneFS       bool
+	concurrency int
+	chunkSize   int
+	cdc         bool
+	chunkMin    int
+	chunkAvg    int
+	chunkMax    int
+	kuboCompat  bool
+	xattrs      bool
+	acls        bool
+	compression string
+	level       int
+	readers     int
+	queueDepth  int
+	delta       bool
+	rescan      bool
+	onError     string
+	changes     string // See --change-detection
+	keyFile     string
+	pingURL     string
+	preHook     string
+	postHook    string
+	keep        backup.RetentionPolicy
+	resume      bool
 }
 
 func runCreate(cmd *cobra.Command, args []string) error {
-	path := args[0]
+	tags, err := parseTags(createTags)
+	if err != nil {
+		return err
+	}
+
+	cfg, err := config.LoadClient()
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+
+	return createBackup(cfg, createOptions{
+		path:        args[0],
+		tags:        tags,
+		excludes:    createExcludes,
+		markers:     createMarkers,
+		skipCaches:  createSkipCaches,
+		includes:    createIncludes,
+		filterFile:  createFilterFile,
+		concurrency: createConcurrency,
+		chunkSize:   createChunkSize,
+		cdc:         createCDC,
+		chunkMin:    createChunkMin,
+		chunkAvg:    createChunkAvg,
+		chunkMax:    createChunkMax,
+		kuboCompat:  createKuboCompat,
+		xattrs:      createXattrs,
+		acls:        createACLs,
+		compression: createCompression,
+		level:       createLevel,
+		readers:     createReaders,
+		queueDepth:  createQueueDepth,
+		delta:       createDelta,
+		rescan:      createRescan,
+		onError:     createOnError,
+		changes:     createChanges,
+		follow:      createFollowSymlinks,
+		oneFS:       createOneFileSystem,
+		keyFile:     createKeyFile,
+		pingURL:     createPingURL,
+		preHook:     createPreHook,
+		postHook:    createPostHook,
+		keep:        createKeep,
+		resume:      createResume,
+	})
+}
 
+// parseTags parses key=value tag arguments
+func parseTags(args []string) (map[string]string, error) {
 	tags := make(map[string]string)
-	for _, t := range createTags {
+	for _, t := range args {
 		parts := strings.SplitN(t, "=", 2)
 		if len(parts) != 2 {
-			return fmt.Errorf("invalid tag format: %s (expected key=value)", t)
+			return nil, fmt.Errorf("invalid tag format: %s (expected key=value)", t)
 		}
 		tags[parts[0]] = parts[1]
 	}
+	return tags, nil
+}
+
+func createBackup(cfg *config.ClientConfig, opts createOptions) (err error) {
+	path, tags := opts.path, opts.tags
+
+	pingURL := opts.pingURL
+	if pingURL == "" {
+		pingURL = os.Getenv("IB_PING_URL")
+	}
+	ping(pingURL, "/start", "")
+	var summary string
+	defer func() {
+		if err != nil {
+			ping(pingURL, "/fail", err.Error())
+		} else {
+			ping(pingURL, "", summary)
+		}
+	}()
 
 	// Require the name tag for organizing backups
 	if tags["name"] == "" {
 		return fmt.Errorf("the 'name' tag is required: use --tag name=<backup-name>")
 	}
+	// Tags given explicitly override the host tags
+	if !createNoHostTags && !cfg.NoHostTags {
+		for k, v := range backup.HostTags(Version) {
+			if _, ok := tags[k]; !ok {
+				tags[k] = v
+			}
+		}
+	}
+	if opts.chunkSize != 0 {
+		if opts.kuboCompat {
+			return fmt.Errorf("--chunk-size can't be combined with --kubo-compat")
+		}
+		if opts.chunkSize < backup.MinChunkSize || opts.chunkSize > backup.MaxChunkSize {
+			return fmt.Errorf("chunk size must be between %d and %d bytes", backup.MinChunkSize, backup.MaxChunkSize)
+		}
+	}
+	if opts.cdc {
+		if opts.kuboCompat || opts.chunkSize != 0 {
+			return fmt.Errorf("--cdc can't be combined with --chunk-size or --kubo-compat")
+		}
+		if opts.chunkMin == 0 {
+			opts.chunkMin = backup.DefaultCDCMinSize
+		}
+		if opts.chunkAvg == 0 {
+			opts.chunkAvg = backup.DefaultCDCAvgSize
+		}
+		if opts.chunkMax == 0 {
+			opts.chunkMax = backup.DefaultCDCMaxSize
+		}
+		if err := backup.ValidateCDCSizes(opts.chunkMin, opts.chunkAvg, opts.chunkMax); err != nil {
+			return err
+		}
+	} else if opts.chunkMin != 0 || opts.chunkAvg != 0 || opts.chunkMax != 0 {
+		return fmt.Errorf("--chunk-min, --chunk-avg and --chunk-max need --cdc")
+	}
+	if opts.compression == "" {
+		opts.compression = backup.CompressionLZ4
+	}
+	if opts.compression == backup.CompressionZstd && opts.level == 0 {
+		opts.level = backup.DefaultZstdLevel
+	}
+	if err := backup.ValidateCompression(opts.compression, opts.level); err != nil {
+		return err
+	}
+	switch opts.onError {
+	case "":
+		opts.onError = backup.OnErrorSkip
+	case backup.OnErrorSkip, backup.OnErrorFail:
+	default:
+		return fmt.Errorf("unknown --on-error %q (expected skip or fail)", opts.onError)
+	}
+	if opts.changes == "" {
+		opts.changes = string(backup.ChangeMtime)
+	}
+	changes, err := backup.ParseChangeDetection(opts.changes)
+	if err != nil {
+		return err
+	}
+	if err := opts.keep.Validate(); err != nil {
+		return err
+	}
+	if opts.queueDepth < 1 {
+		return fmt.Errorf("--queue-depth must be at least 1")
+	}
+	if opts.readers < 1 {
+		return fmt.Errorf("--readers must be at least 1")
+	}
+	if opts.readers > 1 && opts.cdc {
+		return fmt.Errorf("--readers can't be combined with --cdc, content-defined chunks are cut in order")
+	}
 
 	fmt.Printf("Creating backup: %s\n", tags["name"])
 	fmt.Printf("Path: %s\n", path)
 	fmt.Printf("Tags: %v\n", tags)
-	fmt.Printf("Concurrency: %d workers\n", createConcurrency)
+	if opts.concurrency > 0 {
+		fmt.Printf("Concurrency: %d workers\n", opts.concurrency)
+	} else {
+		fmt.Printf("Concurrency: adaptive, %d-%d workers\n", createMinConcurrency, createMaxConcurrency)
+	}
+	if opts.cdc {
+		fmt.Printf("Chunking: FastCDC, %d-%d bytes, %d on average\n", opts.chunkMin, opts.chunkMax, opts.chunkAvg)
+	}
+	if opts.compression == backup.CompressionZstd {
+		fmt.Printf("Compression: zstd, level %d\n", opts.level)
+	}
+	if opts.readers > 1 {
+		fmt.Printf("Readers: %d per large file\n", opts.readers)
+	}
+	if opts.delta {
+		fmt.Println("Delta: changed files refer to the blocks of their previous version")
+	}
+	if changes != backup.ChangeMtime {
+		fmt.Printf("Change detection: %s\n", changes)
+	}
+	if opts.follow {
+		fmt.Println("Following symlinks")
+	}
+	if opts.oneFS {
+		fmt.Println("Staying on one file system")
+	}
+	if len(opts.excludes) > 0 {
+		fmt.Printf("Excludes: %s\n", strings.Join(opts.excludes, ", "))
+	}
+	if len(opts.markers) > 0 {
+		fmt.Printf("Excluding directories containing: %s\n", strings.Join(opts.markers, ", "))
+	}
+	if opts.skipCaches {
+		fmt.Println("Excluding cache directories (CACHEDIR.TAG)")
+	}
+	if len(opts.includes) > 0 {
+		fmt.Printf("Includes: %s\n", strings.Join(opts.includes, ", "))
+	}
+	var filter *backup.FilterRules
+	if opts.filterFile != "" {
+		if filter, err = backup.LoadFilterFile(opts.filterFile); err != nil {
+			return fmt.Errorf("failed to load filter file: %w", err)
+		}
+		fmt.Printf("Filter file: %s\n", opts.filterFile)
+	}
 	fmt.Println()
 
-	// Load client config
-	cfg, err := config.LoadClient()
+	// Create client
+	c, err := client.New(cfg)
 	if err != nil {
-		return fmt.Errorf("failed to load config: %w", err)
+		return err
 	}
 
-	// Create client
-	c, err := client.New(cfg)
+	encKey, err := key.Load(cfg, opts.keyFile)
 	if err != nil {
 		return err
 	}
+	if encKey != nil {
+		if opts.kuboCompat {
+			return fmt.Errorf("--kubo-compat can't be combined with encryption")
+		}
+		fmt.Println("Encryption: enabled (zero-knowledge)")
+	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 24*time.Hour)
 	defer cancel()
 
+	ctx, span := tracing.Tracer("client").Start(ctx, "backup.create")
+	defer span.End()
+
+	// Fail early on what the server can't store faithfully
+	if encKey != nil && !c.Supports(ctx, version.Encryption) {
+		return fmt.Errorf("the server doesn't support encrypted backups, upgrade it or back up without a key")
+	}
+	if cfg.SigningKeyFile != "" && !c.Supports(ctx, version.SignedManifests) {
+		return fmt.Errorf("the server would drop the manifest signature, upgrade it or remove signing_key_file")
+	}
+	// Encrypted blocks carry their codec inside, the server never decompresses
+	// them
+	if opts.compression == backup.CompressionZstd && encKey == nil && !c.Supports(ctx, version.Zstd) {
+		return fmt.Errorf("the server doesn't support zstd blocks, upgrade it or use --compression lz4")
+	}
+
 	// Fetch previous manifest for incremental backup
 	var prevManifest *backup.Manifest
 	fmt.Println("Checking for previous backup...")
-	prevManifest, err = c.GetLatestManifest(ctx, tags)
+	// The previous backup may have been made by an older client
+	prevTags := maps.Clone(tags)
+	if prevTags[backup.TagVersion] == Version {
+		delete(prevTags, backup.TagVersion)
+	}
+	prevManifest, err = c.GetLatestManifest(ctx, prevTags)
+	if err == nil && prevManifest != nil {
+		// Without the right key, fall back to a full backup
+		prevManifest, err = backup.OpenManifest(encKey, prevManifest)
+	}
 	if err != nil {
 		fmt.Printf("Warning: could not fetch previous manifest: %v\n", err)
+	} else if prevManifest != nil && opts.rescan {
+		fmt.Printf("Found previous backup: %s (rescanning every file)\n", prevManifest.ID)
 	} else if prevManifest != nil {
 		fmt.Printf("Found previous backup: %s (will use for incremental)\n", prevManifest.ID)
 	} else {
@@ -87,20 +419,150 @@ func runCreate(cmd *cobra.Command, args []string) error {
 	fmt.Println()
 
 	// Create backup
-	creator := backup.NewCreator(c, createConcurrency)
+	budget, err := memoryBudget(createMaxMemory)
+	if err != nil {
+		return err
+	}
+	creator := backup.NewCreator(c, opts.concurrency)
+	creator.SetMemoryBudget(budget)
+	if opts.concurrency <= 0 {
+		creator.SetAdaptiveConcurrency(createMinConcurrency, createMaxConcurrency)
+	}
+	creator.SetKuboCompat(opts.kuboCompat)
+	if opts.chunkSize != 0 {
+		creator.SetChunkSize(opts.chunkSize)
+	}
+	if opts.cdc {
+		creator.SetCDC(opts.chunkMin, opts.chunkAvg, opts.chunkMax)
+	}
+	if opts.compression == backup.CompressionZstd {
+		creator.SetCompression(opts.compression, opts.level)
+	}
+	creator.SetReaders(opts.readers)
+	creator.SetQueueDepth(opts.queueDepth)
+	creator.SetDelta(opts.delta)
+	creator.SetRescan(opts.rescan)
+	creator.SetOnError(opts.onError)
+	creator.SetChangeDetection(changes)
+	creator.SetBlockHeader(c.Supports(ctx, version.BlockHeader))
+	creator.SetFollowSymlinks(opts.follow)
+	creator.SetOneFileSystem(opts.oneFS)
+	creator.SetExcludes(opts.excludes)
+	creator.SetExcludeIfPresent(opts.markers)
+	creator.SetExcludeCaches(opts.skipCaches)
+	creator.SetIncludes(opts.includes)
+	creator.SetFilter(filter)
+	creator.SetXattrs(backup.XattrOptions{Xattrs: opts.xattrs, ACLs: opts.acls})
+	creator.SetKey(encKey)
+	if cpPath, err := checkpointPath(tags["name"], path); err != nil {
+		if opts.resume {
+			return err
+		}
+		fmt.Printf("Warning: backup can't be resumed if interrupted: %v\n", err)
+	} else {
+		creator.SetCheckpoint(cpPath, opts.resume)
+	}
+
+	// The post-hook runs whenever the pre-hook did, to undo what it did
+	var manifestID string
+	if opts.postHook != "" {
+		defer func() { runPostHook(opts.postHook, path, tags, manifestID, err) }()
+	}
+	if opts.preHook != "" {
+		fmt.Println("Running pre-hook...")
+		if err := runHook(ctx, opts.preHook, hookEnv("pre", path, tags)); err != nil {
+			return fmt.Errorf("pre-hook failed: %w", err)
+		}
+		fmt.Println()
+	}
+
 	manifest, err := creator.Create(ctx, path, tags, prevManifest)
 	if err != nil {
+		reportRun(ctx, c, creator, tags, "", err, encKey != nil)
 		return fmt.Errorf("backup failed: %w", err)
 	}
 
+	// In zero-knowledge mode the server only gets the sealed manifest
+	upload := manifest
+	if encKey != nil {
+		upload, err = backup.SealManifest(encKey, manifest)
+		if err != nil {
+			return fmt.Errorf("failed to encrypt manifest: %w", err)
+		}
+	}
+
+	if cfg.SigningKeyFile != "" {
+		priv, err := backup.LoadSigningKey(cfg.SigningKeyFile)
+		if err != nil {
+			return err
+		}
+		if err := backup.SignManifest(priv, upload); err != nil {
+			return fmt.Errorf("failed to sign manifest: %w", err)
+		}
+	}
+
 	// Upload manifest
 	fmt.Println("\nUploading manifest...")
-	if err := c.UploadManifest(ctx, manifest); err != nil {
-		return fmt.Errorf("failed to upload manifest: %w", err)
+	if err := c.UploadManifest(ctx, upload); err != nil {
+		err = fmt.Errorf("failed to upload manifest: %w", err)
+		reportRun(ctx, c, creator, tags, "", err, encKey != nil)
+		return err
+	}
+	reportRun(ctx, c, creator, tags, manifest.ID, nil, encKey != nil)
+	manifestID = manifest.ID
+	if !opts.keep.Empty() {
+		applyRetention(ctx, c, tags["name"], opts.keep)
+	}
+	if err := creator.RemoveCheckpoint(); err != nil {
+		fmt.Printf("Warning: could not remove checkpoint: %v\n", err)
 	}
 
+	if JSONOutput {
+		result := creator.Summary(tags)
+		result.ManifestID = manifest.ID
+		if err := printJSON(result); err != nil {
+			return err
+		}
+	}
 	fmt.Printf("\nManifest ID: %s\n", manifest.ID)
 	fmt.Printf("Total entries: %d\n", len(manifest.Entries))
 
+	summary = fmt.Sprintf("Manifest ID: %s\nTotal entries: %d\n", manifest.ID, len(manifest.Entries))
+
 	return nil
 }
+
+// checkpointPath is where backups of path named name keep their checkpoint
+// for --resume, under the config directory
+func checkpointPath(name, path string) (string, error) {
+	dir, err := config.Dir()
+	if err != nil {
+		return "", err
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256([]byte(name + "\x00" + abs))
+	return filepath.Join(dir, "checkpoints", hex.EncodeToString(sum[:8])+".jsonl"), nil
+}
+
+// reportRun sends the run summary to the server. The backup's outcome
+// doesn't depend on it, so failures only print a warning. Error messages
+// can name files, so encrypted backups only report that the run failed.
+func reportRun(ctx context.Context, c *client.Client, creator *backup.Creator, tags map[string]string, manifestID string, runErr error, encrypted bool) {
+	summary := creator.Summary(tags)
+	if summary == nil || !c.Supports(ctx, version.Runs) {
+		return
+	}
+	summary.ManifestID = manifestID
+	if runErr != nil {
+		summary.Error = runErr.Error()
+		if encrypted {
+			summary.Error = "backup failed"
+		}
+	}
+	if err := c.ReportRun(ctx, summary); err != nil {
+		fmt.Printf("Warning: could not report backup run: %v\n", err)
+	}
+}
@@ -0,0 +1,72 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/johann/ib/internal/client"
+	"github.com/johann/ib/internal/config"
+	"github.com/johann/ib/internal/version"
+	"github.com/spf13/cobra"
+)
+
+var dedupCmd = &cobra.Command{
+	Use:   "dedup <manifest-id>",
+	Short: "Show which backups share a backup's data",
+	Long: `Show how many of a backup's stored bytes are shared with each other
+backup, most first, and how many only it refers to. Those exclusive bytes
+are what deleting the backup would free once garbage is collected.`,
+	Args: cobra.ExactArgs(1),
+	RunE: runDedup,
+}
+
+func runDedup(cmd *cobra.Command, args []string) error {
+	cfg, err := config.LoadClient()
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+	c, err := client.New(cfg)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+
+	if !c.Supports(ctx, version.DedupReport) {
+		return fmt.Errorf("the server doesn't support dedup reports, upgrade it to see them")
+	}
+
+	report, err := c.ManifestDedup(ctx, args[0])
+	if err != nil {
+		return err
+	}
+	if JSONOutput {
+		return printJSON(report)
+	}
+
+	fmt.Printf("Manifest:  %s\n", report.ManifestID)
+	fmt.Printf("Stored:    %s in %d blocks (%s before compression)\n", formatBytes(report.Bytes), report.Blocks, formatBytes(report.OriginalBytes))
+	fmt.Printf("Exclusive: %s in %d blocks, freed if deleted\n", formatBytes(report.ExclusiveBytes), report.ExclusiveBlocks)
+	if len(report.Shared) == 0 {
+		fmt.Println("\nNo blocks are shared with other backups")
+		return nil
+	}
+
+	fmt.Println()
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "SHARED WITH\tNAME\tCREATED\tBLOCKS\tBYTES\tOF STORED")
+	for _, s := range report.Shared {
+		share := 0.0
+		if report.Bytes > 0 {
+			share = float64(s.Bytes) / float64(report.Bytes) * 100
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%s\t%.0f%%\n",
+			s.ManifestID, s.Tags["name"], s.CreatedAt.Local().Format("2006-01-02 15:04"),
+			s.Blocks, formatBytes(s.Bytes), share)
+	}
+	return w.Flush()
+}
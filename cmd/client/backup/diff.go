@@ -0,0 +1,158 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/johann/ib/cmd/client/key"
+	"github.com/johann/ib/internal/backup"
+	"github.com/johann/ib/internal/client"
+	"github.com/johann/ib/internal/config"
+	"github.com/johann/ib/internal/version"
+	"github.com/spf13/cobra"
+)
+
+var diffCmd = &cobra.Command{
+	Use:   "diff [flags] [<old-id> <new-id>]",
+	Short: "Show what changed between two backups",
+	Long: `Show the files added (+), removed (-) and modified (M) between two
+backups, with their sizes and how much the total size changed.
+
+Compare two manifests by ID, or the latest backup matching --tag against
+--against, by default the backup before it with the same tags.
+
+Example: ib backup diff --tag name=myapp`,
+	Args: cobra.MaximumNArgs(2),
+	RunE: runDiff,
+}
+
+var (
+	diffTags    []string
+	diffAgainst string
+	diffKeyFile string
+)
+
+func init() {
+	diffCmd.Flags().StringArrayVar(&diffTags, "tag", nil, "Compare the latest backup matching tags (key=value format)")
+	diffCmd.Flags().StringVar(&diffAgainst, "against", "", "Manifest ID to compare the --tag backup against (default: the backup before it)")
+	diffCmd.Flags().StringVar(&diffKeyFile, "key-file", "", "Decrypt the backups with this key file (default: encryption_key_file from the config)")
+}
+
+func runDiff(cmd *cobra.Command, args []string) error {
+	switch {
+	case len(args) == 2 && len(diffTags) == 0 && diffAgainst == "":
+	case len(args) == 0 && len(diffTags) > 0:
+	default:
+		return fmt.Errorf("specify either two manifest IDs or --tag (with --against)")
+	}
+
+	cfg, err := config.LoadClient()
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+	c, err := client.New(cfg)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	var oldID, newID string
+	if len(args) == 2 {
+		oldID, newID = args[0], args[1]
+	} else {
+		tags, err := parseTags(diffTags)
+		if err != nil {
+			return err
+		}
+		// Newest first
+		infos, err := c.ListManifests(ctx, tags)
+		if err != nil {
+			return fmt.Errorf("failed to list manifests: %w", err)
+		}
+		if len(infos) == 0 {
+			return fmt.Errorf("no backup found matching tags")
+		}
+		newID, oldID = infos[0].ID, diffAgainst
+		if oldID == "" {
+			if len(infos) < 2 {
+				return fmt.Errorf("%s is the only backup matching tags, nothing to compare against", newID)
+			}
+			oldID = infos[1].ID
+		}
+	}
+
+	// The server compares unencrypted backups itself, unless signatures
+	// have to be checked on the full manifests
+	if len(cfg.TrustedKeys) == 0 {
+		if info, err := c.ServerInfo(ctx); err == nil && info.Has(version.ManifestDiff) {
+			changes, err := c.DiffManifests(ctx, oldID, newID)
+			if err != nil {
+				return err
+			}
+			if changes != nil {
+				return printDiff(oldID, newID, changes)
+			}
+		}
+	}
+
+	encKey, err := key.Load(cfg, diffKeyFile)
+	if err != nil {
+		return err
+	}
+	load := func(id string) (*backup.Manifest, error) {
+		manifest, err := c.GetManifest(ctx, id)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch manifest %s: %w", id, err)
+		}
+		if len(cfg.TrustedKeys) > 0 {
+			if err := backup.VerifyManifest(manifest, cfg.TrustedKeys); err != nil {
+				return nil, fmt.Errorf("signature check of %s failed: %w", id, err)
+			}
+		}
+		return backup.OpenManifest(encKey, manifest)
+	}
+	oldManifest, err := load(oldID)
+	if err != nil {
+		return err
+	}
+	newManifest, err := load(newID)
+	if err != nil {
+		return err
+	}
+
+	return printDiff(oldManifest.ID, newManifest.ID, backup.DiffManifests(oldManifest, newManifest))
+}
+
+// printDiff prints the changes from backup oldID to newID
+func printDiff(oldID, newID string, changes []backup.EntryChange) error {
+	if JSONOutput {
+		return printJSON(changes)
+	}
+
+	fmt.Printf("\n%s -> %s\n", oldID, newID)
+	var added, removed, modified int
+	var delta int64
+	for _, ch := range changes {
+		name := ch.Path
+		if ch.Type == backup.FileTypeDir {
+			name += "/"
+		}
+		switch ch.Change {
+		case backup.ChangeAdded:
+			added++
+			fmt.Printf("+ %s (%s)\n", name, formatBytes(ch.NewSize))
+		case backup.ChangeRemoved:
+			removed++
+			fmt.Printf("- %s (%s)\n", name, formatBytes(ch.OldSize))
+		case backup.ChangeModified:
+			modified++
+			fmt.Printf("M %s (%s)\n", name, formatSizeChange(ch.NewSize-ch.OldSize))
+		}
+		delta += ch.NewSize - ch.OldSize
+	}
+	fmt.Printf("\n%d added, %d removed, %d modified, %s\n", added, removed, modified, formatSizeChange(delta))
+	return nil
+}
@@ -0,0 +1,153 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/johann/ib/internal/client"
+	"github.com/johann/ib/internal/config"
+	"github.com/johann/ib/internal/version"
+	"github.com/spf13/cobra"
+)
+
+var historyCmd = &cobra.Command{
+	Use:   "history <name>",
+	Short: "Show the snapshots of a backup and how they changed",
+	Long: `Show the snapshots with the given name tag, oldest first, with the size,
+new bytes uploaded, dedup ratio and duration of the run that created each
+one. Snapshots whose client didn't report a run show "-".`,
+	Args: cobra.ExactArgs(1),
+	RunE: runHistory,
+}
+
+// historyRuns is how many runs are fetched to match against snapshots, the
+// server's maximum
+const historyRuns = 1000
+
+// historyEntry is one snapshot in the history. The run fields are only set
+// if a run was reported for it.
+type historyEntry struct {
+	ManifestID    string    `json:"manifest_id"`
+	CreatedAt     time.Time `json:"created_at"`
+	Reported      bool      `json:"reported"`
+	TotalFiles    int64     `json:"total_files,omitempty"`
+	TotalBytes    int64     `json:"total_bytes,omitempty"`
+	SizeChange    *int64    `json:"size_change,omitempty"` // Compared to the previous reported snapshot
+	UploadedBytes int64     `json:"uploaded_bytes,omitempty"`
+	DedupRatio    float64   `json:"dedup_ratio,omitempty"`
+	DurationMs    int64     `json:"duration_ms,omitempty"`
+}
+
+func runHistory(cmd *cobra.Command, args []string) error {
+	cfg, err := config.LoadClient()
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+	c, err := client.New(cfg)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	if !c.Supports(ctx, version.Runs) {
+		return fmt.Errorf("the server doesn't record backup runs, upgrade it to see the history")
+	}
+
+	tags := map[string]string{"name": args[0]}
+	manifests, err := c.ListManifests(ctx, tags)
+	if err != nil {
+		return fmt.Errorf("failed to list manifests: %w", err)
+	}
+	runs, err := c.ListRuns(ctx, tags, historyRuns)
+	if err != nil {
+		return fmt.Errorf("failed to list runs: %w", err)
+	}
+
+	history := buildHistory(manifests, runs)
+	if JSONOutput {
+		return printJSON(history)
+	}
+
+	if len(history) == 0 {
+		fmt.Printf("No backups found for %s\n", args[0])
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(w, "CREATED\tID\tFILES\tSIZE\tCHANGE\tUPLOADED\tDEDUP\tDURATION\t")
+	for _, h := range history {
+		if !h.Reported {
+			fmt.Fprintf(w, "%s\t%s\t-\t-\t-\t-\t-\t-\t\n", h.CreatedAt.Local().Format("2006-01-02 15:04"), h.ManifestID)
+			continue
+		}
+		change := "-"
+		if h.SizeChange != nil {
+			change = formatSizeChange(*h.SizeChange)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%d\t%s\t%s\t%s\t%.0f%%\t%s\t\n",
+			h.CreatedAt.Local().Format("2006-01-02 15:04"), h.ManifestID, h.TotalFiles,
+			formatBytes(h.TotalBytes), change, formatBytes(h.UploadedBytes), h.DedupRatio*100,
+			(time.Duration(h.DurationMs) * time.Millisecond).Round(time.Second))
+	}
+	return w.Flush()
+}
+
+// buildHistory orders manifests oldest first and attaches the run that
+// created each one
+func buildHistory(manifests []client.ManifestInfo, runs []client.BackupRun) []historyEntry {
+	byManifest := make(map[string]*client.BackupRun, len(runs))
+	for i := range runs {
+		// Runs are newest first, keep the latest report for a manifest
+		if _, ok := byManifest[runs[i].ManifestID]; !ok && runs[i].ManifestID != "" {
+			byManifest[runs[i].ManifestID] = &runs[i]
+		}
+	}
+
+	history := make([]historyEntry, 0, len(manifests))
+	var prevSize int64 = -1
+	for i := len(manifests) - 1; i >= 0; i-- {
+		m := manifests[i]
+		h := historyEntry{ManifestID: m.ID, CreatedAt: m.CreatedAt}
+		if run, ok := byManifest[m.ID]; ok {
+			h.Reported = true
+			h.TotalFiles = run.TotalFiles
+			h.TotalBytes = run.TotalBytes
+			h.UploadedBytes = run.UploadedBytes
+			h.DedupRatio = run.DedupRatio()
+			h.DurationMs = run.DurationMs
+			if prevSize >= 0 {
+				change := run.TotalBytes - prevSize
+				h.SizeChange = &change
+			}
+			prevSize = run.TotalBytes
+		}
+		history = append(history, h)
+	}
+	return history
+}
+
+func formatBytes(b int64) string {
+	const unit = 1024
+	if b < unit {
+		return fmt.Sprintf("%d B", b)
+	}
+	div, exp := int64(unit), 0
+	for n := b / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(b)/float64(div), "KMGTPE"[exp])
+}
+
+// formatSizeChange formats a size difference with its sign
+func formatSizeChange(n int64) string {
+	if n < 0 {
+		return "-" + formatBytes(-n)
+	}
+	return "+" + formatBytes(n)
+}
@@ -0,0 +1,64 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"os"
+	"os/exec"
+	"runtime"
+	"slices"
+	"strings"
+)
+
+// runHook runs a --pre-hook or --post-hook command through the shell, with
+// the backup described in IB_* environment variables and its output passed
+// through
+func runHook(ctx context.Context, command string, env map[string]string) error {
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.CommandContext(ctx, "cmd", "/C", command)
+	} else {
+		cmd = exec.CommandContext(ctx, "sh", "-c", command)
+	}
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Env = os.Environ()
+	for _, k := range slices.Sorted(maps.Keys(env)) {
+		cmd.Env = append(cmd.Env, k+"="+env[k])
+	}
+	return cmd.Run()
+}
+
+// hookEnv describes a backup to its hooks: IB_HOOK is pre or post,
+// IB_BACKUP_NAME, IB_BACKUP_PATH and IB_TAGS (sorted key=value pairs
+// separated by commas) say which backup it is
+func hookEnv(hook, path string, tags map[string]string) map[string]string {
+	pairs := make([]string, 0, len(tags))
+	for _, k := range slices.Sorted(maps.Keys(tags)) {
+		pairs = append(pairs, k+"="+tags[k])
+	}
+	return map[string]string{
+		"IB_HOOK":        hook,
+		"IB_BACKUP_NAME": tags["name"],
+		"IB_BACKUP_PATH": path,
+		"IB_TAGS":        strings.Join(pairs, ","),
+	}
+}
+
+// runPostHook runs the post-hook with the outcome of the backup in
+// IB_RESULT (success or failure), IB_MANIFEST_ID and IB_ERROR. The backup
+// is done either way, so failures are only printed.
+func runPostHook(command, path string, tags map[string]string, manifestID string, backupErr error) {
+	env := hookEnv("post", path, tags)
+	env["IB_RESULT"] = "success"
+	env["IB_MANIFEST_ID"] = manifestID
+	if backupErr != nil {
+		env["IB_RESULT"] = "failure"
+		env["IB_ERROR"] = backupErr.Error()
+	}
+	fmt.Println("\nRunning post-hook...")
+	if err := runHook(context.Background(), command, env); err != nil {
+		fmt.Printf("Warning: post-hook failed: %v\n", err)
+	}
+}
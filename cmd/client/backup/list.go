@@ -18,10 +18,14 @@ var listCmd = &cobra.Command{
 	RunE:  runList,
 }
 
-var listTags []string
+var (
+	listTags  []string
+	listLimit int
+)
 
 func init() {
 	listCmd.Flags().StringArrayVar(&listTags, "tag", nil, "Filter by tag in key=value format (can be repeated)")
+	listCmd.Flags().IntVar(&listLimit, "limit", 0, "Only list the newest N backups (default all)")
 }
 
 func runList(cmd *cobra.Command, args []string) error {
@@ -50,10 +54,16 @@ func runList(cmd *cobra.Command, args []string) error {
 	defer cancel()
 
 	// Fetch manifests
-	manifests, err := c.ListManifests(ctx, tags)
+	manifests, err := c.ListRecentManifests(ctx, tags, listLimit)
 	if err != nil {
 		return fmt.Errorf("failed to list manifests: %w", err)
 	}
+	if JSONOutput {
+		if manifests == nil {
+			manifests = []client.ManifestInfo{}
+		}
+		return printJSON(manifests)
+	}
 
 	if len(manifests) == 0 {
 		fmt.Println("No backups found")
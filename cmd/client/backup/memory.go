@@ -0,0 +1,24 @@
+package backup
+
+import (
+	"fmt"
+	"runtime/debug"
+
+	"github.com/johann/ib/internal/backup"
+	"github.com/johann/ib/internal/config"
+)
+
+// memoryBudget parses a --max-memory value such as 512MiB, 2G or 0 (no
+// limit). The Go runtime is asked to stay near the budget too, so buffers
+// that were released but not yet collected don't pile up.
+func memoryBudget(value string) (*backup.MemoryBudget, error) {
+	size, err := config.ParseSize(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid --max-memory %q: %w", value, err)
+	}
+	if size > 0 {
+		// Headroom for everything besides chunk data
+		debug.SetMemoryLimit(size + 256<<20)
+	}
+	return backup.NewMemoryBudget(size), nil
+}
@@ -0,0 +1,34 @@
+package backup
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+)
+
+// JSONOutput is set by the global --json flag, see EnableJSONOutput
+var JSONOutput bool
+
+// resultOut is where results are printed as JSON
+var resultOut io.Writer = os.Stdout
+
+// EnableJSONOutput makes commands print their result as JSON to stdout.
+// Everything else they print goes to stderr instead, so scripts can parse
+// stdout as a whole.
+func EnableJSONOutput() {
+	JSONOutput = true
+	resultOut = os.Stdout
+	os.Stdout = os.Stderr
+}
+
+// printJSON prints a command's result
+func printJSON(v any) error {
+	enc := json.NewEncoder(resultOut)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// PrintJSONError prints err as the result of a failed command
+func PrintJSONError(err error) {
+	printJSON(map[string]string{"error": err.Error()})
+}
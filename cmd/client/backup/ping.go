@@ -0,0 +1,66 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// maxPingLog is how much of a run's output is sent with a ping;
+// healthchecks.io keeps the first 100KB
+const maxPingLog = 10000
+
+// ping reports to a healthchecks.io-style URL: base+"/start" when a run
+// begins, base on success and base+"/fail" on failure, with an optional
+// log as the body. Monitoring must never break a backup, so failures are
+// only printed.
+func ping(base, suffix, log string) {
+	if base == "" {
+		return
+	}
+	if len(log) > maxPingLog {
+		log = log[:maxPingLog]
+	}
+	url := strings.TrimRight(base, "/") + suffix
+
+	var err error
+	for attempt := 0; attempt < 3; attempt++ {
+		if attempt > 0 {
+			time.Sleep(time.Duration(attempt) * 2 * time.Second)
+		}
+		if err = sendPing(url, log); err == nil {
+			return
+		}
+	}
+	fmt.Printf("Warning: could not ping %s: %v\n", suffixName(suffix), err)
+}
+
+func sendPing(url, log string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, "POST", url, strings.NewReader(log))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func suffixName(suffix string) string {
+	if suffix == "" {
+		return "success URL"
+	}
+	return strings.TrimPrefix(suffix, "/") + " URL"
+}
@@ -3,12 +3,15 @@ package backup
 import (
 	"context"
 	"fmt"
-	"strings"
+	"os"
 	"time"
 
+	"github.com/johann/ib/cmd/client/key"
 	"github.com/johann/ib/internal/backup"
 	"github.com/johann/ib/internal/client"
 	"github.com/johann/ib/internal/config"
+	"github.com/johann/ib/internal/ipfsnode"
+	"github.com/johann/ib/internal/tracing"
 	"github.com/spf13/cobra"
 )
 
@@ -18,7 +21,11 @@ var restoreCmd = &cobra.Command{
 	Long: `Restore a backup to a directory.
 
 Specify the backup to restore using either --id or --tag flags.
-If using tags, the latest backup matching all tags will be restored.`,
+If using tags, the latest backup matching all tags will be restored.
+
+With --p2p, blocks are fetched over libp2p/bitswap instead of HTTP. Pass the
+server (or replica) addresses with --peer; without --peer, providers are
+looked up in the DHT. The manifest is still fetched over HTTP.`,
 	Args: cobra.ExactArgs(1),
 	RunE: runRestore,
 }
@@ -27,12 +34,32 @@ var (
 	restoreID          string
 	restoreTags        []string
 	restoreConcurrency int
+	restoreP2P         bool
+	restorePeers       []string
+	restoreKeyFile     string
+	restoreMaxMemory   string
+	restoreXattrs      bool
+	restoreACLs        bool
+	restorePaths       []string
+	restoreGlobs       []string
+	restoreOverwrite   string
+	restoreJSON        bool
 )
 
 func init() {
 	restoreCmd.Flags().StringVar(&restoreID, "id", "", "Manifest ID to restore")
 	restoreCmd.Flags().StringArrayVar(&restoreTags, "tag", nil, "Restore latest backup matching tags (key=value format)")
 	restoreCmd.Flags().IntVar(&restoreConcurrency, "concurrency", 4, "Number of concurrent download workers")
+	restoreCmd.Flags().BoolVar(&restoreP2P, "p2p", false, "Fetch blocks over libp2p/bitswap instead of HTTP")
+	restoreCmd.Flags().StringArrayVar(&restorePeers, "peer", nil, "Peer multiaddr with /p2p/<peer-id> to fetch from (repeatable, implies --p2p)")
+	restoreCmd.Flags().StringVar(&restoreMaxMemory, "max-memory", "512MiB", "Memory for downloaded blocks waiting to be written, e.g. 256MiB or 2GiB (0 for no limit)")
+	restoreCmd.Flags().StringArrayVar(&restorePaths, "path", nil, "Only restore this file or directory, relative to the backup root (can be repeated)")
+	restoreCmd.Flags().StringArrayVar(&restoreGlobs, "glob", nil, "Only restore paths matching this .gitignore-style pattern (can be repeated)")
+	restoreCmd.Flags().StringVar(&restoreOverwrite, "overwrite", backup.OverwriteAlways, "What to do with files that already exist: always, never, if-newer or if-different (compares size, mtime and content, skipping identical files)")
+	restoreCmd.Flags().BoolVar(&restoreJSON, "json-progress", false, "Write the progress to stderr as one JSON object per line, every second")
+	restoreCmd.Flags().BoolVar(&restoreXattrs, "xattrs", false, "Restore extended attributes backed up with --xattrs (outside the user namespace this needs root)")
+	restoreCmd.Flags().BoolVar(&restoreACLs, "acls", false, "Restore POSIX ACLs backed up with --acls")
+	restoreCmd.Flags().StringVar(&restoreKeyFile, "key-file", "", "Decrypt the backup with this key file (default: encryption_key_file from the config)")
 }
 
 func runRestore(cmd *cobra.Command, args []string) error {
@@ -41,6 +68,9 @@ func runRestore(cmd *cobra.Command, args []string) error {
 	if restoreID == "" && len(restoreTags) == 0 {
 		return fmt.Errorf("must specify either --id or --tag")
 	}
+	if err := backup.ValidateOverwrite(restoreOverwrite); err != nil {
+		return err
+	}
 
 	// Load client config
 	cfg, err := config.LoadClient()
@@ -57,50 +87,95 @@ func runRestore(cmd *cobra.Command, args []string) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 24*time.Hour)
 	defer cancel()
 
+	ctx, span := tracing.Tracer("client").Start(ctx, "backup.restore")
+	defer span.End()
+
 	// Fetch manifest
-	var manifest *backup.Manifest
-	if restoreID != "" {
-		fmt.Printf("Fetching backup %s...\n", restoreID)
-		manifest, err = c.GetManifest(ctx, restoreID)
-		if err != nil {
-			return fmt.Errorf("failed to fetch manifest: %w", err)
-		}
-	} else {
-		tags := make(map[string]string)
-		for _, t := range restoreTags {
-			parts := strings.SplitN(t, "=", 2)
-			if len(parts) != 2 {
-				return fmt.Errorf("invalid tag format: %s (expected key=value)", t)
-			}
-			tags[parts[0]] = parts[1]
-		}
-		fmt.Printf("Fetching latest backup with tags %v...\n", tags)
-		manifest, err = c.GetLatestManifest(ctx, tags)
-		if err != nil {
-			return fmt.Errorf("failed to fetch manifest: %w", err)
-		}
-		if manifest == nil {
-			return fmt.Errorf("no backup found matching tags")
+	manifest, err := fetchManifest(ctx, c, restoreID, restoreTags)
+	if err != nil {
+		return err
+	}
+
+	// Reject manifests not signed by a trusted client
+	if len(cfg.TrustedKeys) > 0 {
+		if err := backup.VerifyManifest(manifest, cfg.TrustedKeys); err != nil {
+			return fmt.Errorf("signature check failed: %w", err)
 		}
+		fmt.Printf("Signature: valid (%s)\n", manifest.SignedBy)
+	}
+
+	encKey, err := key.Load(cfg, restoreKeyFile)
+	if err != nil {
+		return err
+	}
+	manifest, err = backup.OpenManifest(encKey, manifest)
+	if err != nil {
+		return err
 	}
 
 	fmt.Printf("Restoring backup %s to %s\n", manifest.ID, outputPath)
-	fmt.Printf("Total entries: %d\n", len(manifest.Entries))
+	selection := backup.NewSelection(restorePaths, restoreGlobs)
+	if selection != nil {
+		fmt.Printf("Selected entries: %d of %d\n", len(selection.Filter(manifest.Entries)), len(manifest.Entries))
+	} else {
+		fmt.Printf("Total entries: %d\n", len(manifest.Entries))
+	}
+	if len(manifest.Errors) > 0 {
+		fmt.Printf("Missing from backup: %d (unreadable when backed up)\n", len(manifest.Errors))
+		for _, e := range manifest.Errors {
+			fmt.Printf("  %s: %s\n", e.Path, e.Error)
+		}
+	}
 	fmt.Printf("Concurrency: %d workers\n", restoreConcurrency)
 
-	// Create restorer with decompressing block fetcher
-	fetcher := &decompressingFetcher{client: c}
+	// Create restorer with decompressing block fetcher, or bitswap in p2p mode
+	var fetcher backup.BlockFetcher = &decompressingFetcher{client: c}
+	if restoreP2P || len(restorePeers) > 0 {
+		p2pFetcher, err := ipfsnode.NewFetcher(ctx, &ipfsnode.FetcherConfig{
+			Peers:  restorePeers,
+			UseDHT: len(restorePeers) == 0,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to start p2p fetcher: %w", err)
+		}
+		defer p2pFetcher.Close()
+		fetcher = p2pFetcher
+		fmt.Printf("Fetching blocks over bitswap\n")
+	}
+	budget, err := memoryBudget(restoreMaxMemory)
+	if err != nil {
+		return err
+	}
 	restorer := backup.NewRestorer(fetcher, restoreConcurrency)
+	restorer.SetKey(encKey)
+	restorer.SetMemoryBudget(budget)
+	restorer.SetXattrs(backup.XattrOptions{Xattrs: restoreXattrs, ACLs: restoreACLs})
+	restorer.SetSelection(selection)
+	restorer.SetOverwrite(restoreOverwrite)
+	if restoreJSON {
+		restorer.SetProgressJSON(os.Stderr)
+	}
 
 	// Restore
 	if err := restorer.Restore(ctx, manifest, outputPath); err != nil {
 		return fmt.Errorf("restore failed: %w", err)
 	}
 
+	if JSONOutput {
+		return printJSON(restoreResult{ManifestID: manifest.ID, Path: outputPath, Missing: manifest.Errors, RestoreStatus: restorer.Status()})
+	}
 	fmt.Println("Restore complete!")
 	return nil
 }
 
+// restoreResult is what restore prints with --json
+type restoreResult struct {
+	ManifestID string              `json:"manifest_id"`
+	Path       string              `json:"path"`
+	Missing    []backup.EntryError `json:"missing,omitempty"` // Left out of the backup, see Manifest.Errors
+	backup.RestoreStatus
+}
+
 // decompressingFetcher wraps client to decompress blocks
 type decompressingFetcher struct {
 	client *client.Client
@@ -111,7 +186,6 @@ func (f *decompressingFetcher) DownloadBlock(ctx context.Context, cid string) ([
 	if err != nil {
 		return nil, err
 	}
-	// Note: The server should return decompressed data, or we need to track original size
-	// For now, return as-is (server will handle decompression)
+	// The client decompresses by the codec the server sends with the block
 	return data, nil
 }
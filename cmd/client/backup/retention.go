@@ -0,0 +1,42 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/johann/ib/internal/backup"
+	"github.com/johann/ib/internal/client"
+)
+
+// applyRetention deletes the backups named name that the policy doesn't
+// keep. They go to the server's trash, from where they can be undeleted
+// until it is purged. The backup itself succeeded, so failures are only
+// printed.
+func applyRetention(ctx context.Context, c *client.Client, name string, policy backup.RetentionPolicy) {
+	infos, err := c.ListManifests(ctx, map[string]string{"name": name})
+	if err != nil {
+		fmt.Printf("Warning: could not apply retention policy: %v\n", err)
+		return
+	}
+	created := make([]time.Time, len(infos))
+	for i, info := range infos {
+		created[i] = info.CreatedAt
+	}
+	keep := policy.Keep(created)
+
+	var expired []client.ManifestInfo
+	for i, info := range infos {
+		if !keep[i] {
+			expired = append(expired, info)
+		}
+	}
+	fmt.Printf("\nRetention (%s): keeping %d backups, deleting %d\n", policy, len(infos)-len(expired), len(expired))
+	for _, info := range expired {
+		if err := c.Admin(ctx, "DELETE", "/api/manifests/"+info.ID, nil, nil); err != nil {
+			fmt.Printf("Warning: could not delete %s (retention needs a token with admin scope): %v\n", info.ID, err)
+			return
+		}
+		fmt.Printf("  Deleted %s (%s)\n", info.ID, info.CreatedAt.Local().Format("2006-01-02 15:04"))
+	}
+}
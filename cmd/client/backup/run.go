@@ -0,0 +1,222 @@
+package backup
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+
+	"github.com/johann/ib/internal/backup"
+	"github.com/johann/ib/internal/config"
+	"github.com/spf13/cobra"
+)
+
+var runCmd = &cobra.Command{
+	Use:   "run <name>",
+	Short: "Run a backup defined in the client config",
+	Long: `Run a named backup from the "backups" section of the client config.
+
+Flags override the definition's settings; --tag, --exclude and --include add to them.
+
+Example config:
+  "backups": {
+    "db": {
+      "path": "/var/lib/postgresql",
+      "tags": {"env": "prod"},
+      "excludes": ["*.tmp", "pg_wal/"],
+      "ping_url": "https://hc-ping.com/<uuid>"
+    }
+  }`,
+	Args: cobra.ExactArgs(1),
+	RunE: runRun,
+}
+
+func init() {
+	addCreateFlags(runCmd)
+}
+
+func runRun(cmd *cobra.Command, args []string) error {
+	name := args[0]
+
+	cfg, err := config.LoadClient()
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+	opts, err := definitionOptions(cfg, name)
+	if err != nil {
+		return err
+	}
+
+	flagTags, err := parseTags(createTags)
+	if err != nil {
+		return err
+	}
+	maps.Copy(opts.tags, flagTags)
+	opts.excludes = append(opts.excludes, createExcludes...)
+	opts.includes = append(opts.includes, createIncludes...)
+	opts.markers = append(opts.markers, createMarkers...)
+	opts.resume = createResume
+	opts.rescan = createRescan
+
+	flags := cmd.Flags()
+	if flags.Changed("exclude-caches") {
+		opts.skipCaches = createSkipCaches
+	}
+	if flags.Changed("concurrency") {
+		opts.concurrency = createConcurrency
+	}
+	if flags.Changed("filter-file") {
+		opts.filterFile = createFilterFile
+	}
+	if flags.Changed("chunk-size") {
+		opts.chunkSize = createChunkSize
+	}
+	if flags.Changed("cdc") {
+		opts.cdc = createCDC
+	}
+	if flags.Changed("chunk-min") {
+		opts.chunkMin = createChunkMin
+	}
+	if flags.Changed("chunk-avg") {
+		opts.chunkAvg = createChunkAvg
+	}
+	if flags.Changed("chunk-max") {
+		opts.chunkMax = createChunkMax
+	}
+	if flags.Changed("kubo-compat") {
+		opts.kuboCompat = createKuboCompat
+	}
+	if flags.Changed("xattrs") {
+		opts.xattrs = createXattrs
+	}
+	if flags.Changed("acls") {
+		opts.acls = createACLs
+	}
+	if flags.Changed("compression") {
+		opts.compression = createCompression
+	}
+	if flags.Changed("compression-level") {
+		opts.level = createLevel
+	}
+	if flags.Changed("readers") {
+		opts.readers = createReaders
+	}
+	if flags.Changed("queue-depth") {
+		opts.queueDepth = createQueueDepth
+	}
+	if flags.Changed("delta") {
+		opts.delta = createDelta
+	}
+	if flags.Changed("on-error") {
+		opts.onError = createOnError
+	}
+	if flags.Changed("change-detection") {
+		opts.changes = createChanges
+	}
+	if flags.Changed("follow-symlinks") {
+		opts.follow = createFollowSymlinks
+	}
+	if flags.Changed("one-file-system") {
+		opts.oneFS = createOneFileSystem
+	}
+	if flags.Changed("key-file") {
+		opts.keyFile = createKeyFile
+	}
+	if flags.Changed("pre-hook") {
+		opts.preHook = createPreHook
+	}
+	if flags.Changed("post-hook") {
+		opts.postHook = createPostHook
+	}
+	if flags.Changed("keep-last") {
+		opts.keep.Last = createKeep.Last
+	}
+	if flags.Changed("keep-daily") {
+		opts.keep.Daily = createKeep.Daily
+	}
+	if flags.Changed("keep-weekly") {
+		opts.keep.Weekly = createKeep.Weekly
+	}
+	if flags.Changed("keep-monthly") {
+		opts.keep.Monthly = createKeep.Monthly
+	}
+	if flags.Changed("ping-url") {
+		opts.pingURL = createPingURL
+	}
+
+	return createBackup(cfg, opts)
+}
+
+// RunDefinition runs the backup name of the client config with the
+// definition's settings, for the daemon
+func RunDefinition(cfg *config.ClientConfig, name string) error {
+	opts, err := definitionOptions(cfg, name)
+	if err != nil {
+		return err
+	}
+	return createBackup(cfg, opts)
+}
+
+// definitionOptions returns the options of the backup name of the client
+// config, with the defaults of 'ib backup create' for what it leaves out
+func definitionOptions(cfg *config.ClientConfig, name string) (createOptions, error) {
+	def, ok := cfg.Backups[name]
+	if !ok {
+		if len(cfg.Backups) == 0 {
+			return createOptions{}, fmt.Errorf("no backup named %q: the client config defines no backups", name)
+		}
+		return createOptions{}, fmt.Errorf("no backup named %q (defined: %v)", name, slices.Sorted(maps.Keys(cfg.Backups)))
+	}
+	if def.Path == "" {
+		return createOptions{}, fmt.Errorf("backup %q has no path", name)
+	}
+
+	tags := map[string]string{"name": name}
+	maps.Copy(tags, def.Tags)
+	opts := createOptions{
+		path:        def.Path,
+		tags:        tags,
+		excludes:    slices.Clone(def.Excludes),
+		markers:     slices.Clone(def.Markers),
+		skipCaches:  def.SkipCaches,
+		includes:    slices.Clone(def.Includes),
+		filterFile:  def.FilterFile,
+		concurrency: def.Concurrency,
+		chunkSize:   def.ChunkSize,
+		cdc:         def.CDC,
+		chunkMin:    def.ChunkMin,
+		chunkAvg:    def.ChunkAvg,
+		chunkMax:    def.ChunkMax,
+		kuboCompat:  def.KuboCompat,
+		xattrs:      def.Xattrs,
+		acls:        def.ACLs,
+		compression: def.Compression,
+		level:       def.Level,
+		readers:     def.Readers,
+		queueDepth:  def.QueueDepth,
+		delta:       def.Delta,
+		changes:     def.Changes,
+		onError:     def.OnError,
+		follow:      def.FollowLinks,
+		oneFS:       def.OneFS,
+		keyFile:     def.KeyFile,
+		pingURL:     def.PingURL,
+		preHook:     def.PreHook,
+		postHook:    def.PostHook,
+		keep: backup.RetentionPolicy{
+			Last:    def.KeepLast,
+			Daily:   def.KeepDaily,
+			Weekly:  def.KeepWeekly,
+			Monthly: def.KeepMonthly,
+		},
+	}
+	if opts.concurrency == 0 {
+		opts.concurrency = createConcurrency
+	}
+	if opts.readers == 0 {
+		opts.readers = createReaders
+	}
+	if opts.queueDepth == 0 {
+		opts.queueDepth = createQueueDepth
+	}
+	return opts, nil
+}
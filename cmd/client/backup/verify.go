@@ -0,0 +1,70 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/johann/ib/internal/backup"
+	"github.com/johann/ib/internal/client"
+	"github.com/johann/ib/internal/config"
+	"github.com/spf13/cobra"
+)
+
+var verifyCmd = &cobra.Command{
+	Use:   "verify [flags]",
+	Short: "Verify a backup's manifest signature",
+	Long: `Check that a manifest was signed by a trusted client key and has not been
+modified since, even if the server or its database was compromised.
+
+Trust keys with 'ib key trust <public-key>'.`,
+	RunE: runVerify,
+}
+
+var (
+	verifyID   string
+	verifyTags []string
+)
+
+func init() {
+	verifyCmd.Flags().StringVar(&verifyID, "id", "", "Manifest ID to verify")
+	verifyCmd.Flags().StringArrayVar(&verifyTags, "tag", nil, "Verify latest backup matching tags (key=value format)")
+}
+
+func runVerify(cmd *cobra.Command, args []string) error {
+	if verifyID == "" && len(verifyTags) == 0 {
+		return fmt.Errorf("must specify either --id or --tag")
+	}
+
+	cfg, err := config.LoadClient()
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+	if len(cfg.TrustedKeys) == 0 {
+		return fmt.Errorf("no trusted keys configured, run 'ib key trust <public-key>'")
+	}
+
+	c, err := client.New(cfg)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	manifest, err := fetchManifest(ctx, c, verifyID, verifyTags)
+	if err != nil {
+		return err
+	}
+
+	if err := backup.VerifyManifest(manifest, cfg.TrustedKeys); err != nil {
+		return err
+	}
+
+	if JSONOutput {
+		return printJSON(map[string]any{"manifest_id": manifest.ID, "valid": true, "signed_by": manifest.SignedBy})
+	}
+	fmt.Printf("Manifest %s: signature valid\n", manifest.ID)
+	fmt.Printf("Signed by: %s\n", manifest.SignedBy)
+	return nil
+}
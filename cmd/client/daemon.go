@@ -0,0 +1,148 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"os"
+	"os/signal"
+	"slices"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/johann/ib/cmd/client/backup"
+	"github.com/johann/ib/internal/config"
+	"github.com/johann/ib/internal/cron"
+	"github.com/spf13/cobra"
+)
+
+var daemonCmd = &cobra.Command{
+	Use:   "daemon",
+	Short: "Run the named backups that have a schedule, in the foreground",
+	Long: `Run the named backups of the client config on their "schedule", a cron
+expression in local time ("30 2 * * *", "0 */6 * * 1-5", @hourly, @daily,
+@weekly, @monthly), until interrupted. Backups without a schedule are left
+out; retention (keep_last, keep_daily, ...) applies after each run as with
+'ib backup run'.
+
+A backup still running when it is due again is skipped until its next turn,
+so runs of the same backup never overlap. Runs missed while the daemon isn't
+running are not caught up; use install-schedule for that. On SIGINT or
+SIGTERM the daemon waits for running backups to finish.
+
+Example config:
+  "backups": {
+    "db": {
+      "path": "/var/lib/postgresql",
+      "schedule": "30 2 * * *",
+      "keep_daily": 7
+    }
+  }`,
+	Args: cobra.NoArgs,
+	RunE: runDaemon,
+}
+
+func init() {
+	rootCmd.AddCommand(daemonCmd)
+}
+
+// daemonJob is a scheduled backup
+type daemonJob struct {
+	name     string
+	schedule *cron.Schedule
+	next     time.Time
+	running  bool // Guarded by the daemon's mutex
+}
+
+func runDaemon(cmd *cobra.Command, args []string) error {
+	cfg, err := config.LoadClient()
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+
+	now := time.Now()
+	var jobs []*daemonJob
+	for _, name := range slices.Sorted(maps.Keys(cfg.Backups)) {
+		def := cfg.Backups[name]
+		if def.Schedule == "" {
+			continue
+		}
+		sched, err := cron.Parse(def.Schedule)
+		if err != nil {
+			return fmt.Errorf("backup %q: %w", name, err)
+		}
+		next := sched.Next(now)
+		if next.IsZero() {
+			return fmt.Errorf("backup %q: schedule %q never runs", name, def.Schedule)
+		}
+		jobs = append(jobs, &daemonJob{name: name, schedule: sched, next: next})
+		fmt.Printf("Scheduled %s (%s), next run at %s\n", name, def.Schedule, next.Format(time.DateTime))
+	}
+	if len(jobs) == 0 {
+		return fmt.Errorf("no backup in the client config has a schedule")
+	}
+
+	ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	var (
+		mu sync.Mutex
+		wg sync.WaitGroup
+	)
+	for {
+		due := jobs[0]
+		for _, job := range jobs[1:] {
+			if job.next.Before(due.next) {
+				due = job
+			}
+		}
+
+		timer := time.NewTimer(time.Until(due.next))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			mu.Lock()
+			var running []string
+			for _, job := range jobs {
+				if job.running {
+					running = append(running, job.name)
+				}
+			}
+			mu.Unlock()
+			if len(running) > 0 {
+				fmt.Printf("Waiting for running backups to finish: %v\n", running)
+			}
+			wg.Wait()
+			return nil
+		case <-timer.C:
+		}
+
+		// From now, so runs missed while suspended aren't caught up one
+		// after the other
+		due.next = due.schedule.Next(time.Now())
+
+		mu.Lock()
+		if due.running {
+			mu.Unlock()
+			fmt.Fprintf(os.Stderr, "Warning: skipping %s, the previous run is still in progress\n", due.name)
+			continue
+		}
+		due.running = true
+		mu.Unlock()
+
+		fmt.Printf("Starting %s\n", due.name)
+		wg.Add(1)
+		go func(job *daemonJob, next time.Time) {
+			defer wg.Done()
+			err := backup.RunDefinition(cfg, job.name)
+
+			mu.Lock()
+			job.running = false
+			mu.Unlock()
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Backup %s failed: %v\n", job.name, err)
+			}
+			fmt.Printf("Next run of %s at %s\n", job.name, next.Format(time.DateTime))
+		}(due, due.next)
+	}
+}
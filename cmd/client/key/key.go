@@ -0,0 +1,277 @@
+package key
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/johann/ib/internal/config"
+	"github.com/johann/ib/internal/encryption"
+	"github.com/spf13/cobra"
+)
+
+var Cmd = &cobra.Command{
+	Use:   "key",
+	Short: "Encryption key management",
+	Long: `Create and manage the key used for client-side encryption.
+
+A key file is either a raw random key, or a master key protected by one or
+more passphrase slots (argon2id), so each team member can use their own
+passphrase. Set IB_PASSPHRASE to unlock a protected key file without a prompt.`,
+}
+
+var stdin = bufio.NewReader(os.Stdin)
+
+func init() {
+	Cmd.AddCommand(initCmd)
+	Cmd.AddCommand(addSlotCmd)
+	Cmd.AddCommand(removeSlotCmd)
+	Cmd.AddCommand(listCmd)
+}
+
+// Load returns the encryption key from keyFile, falling back to the key file
+// in the client config. Returns nil if encryption is not configured.
+func Load(cfg *config.ClientConfig, keyFile string) (*encryption.Key, error) {
+	if keyFile == "" {
+		keyFile = cfg.EncryptionKeyFile
+	}
+	if keyFile == "" {
+		return nil, nil
+	}
+	return encryption.LoadKeyFile(keyFile, func() (string, error) {
+		return readPassphrase("Passphrase")
+	})
+}
+
+var initCmd = &cobra.Command{
+	Use:   "init",
+	Short: "Create a new encryption key",
+	Long: `Create a new encryption key and use it for future backups.
+
+Without --passphrase a raw random key file is written. With --passphrase the
+key is protected by a passphrase; add more with 'ib key add-slot'.
+
+Keep a copy of the key file: without it, encrypted backups can't be restored.`,
+	RunE: runInit,
+}
+
+var addSlotCmd = &cobra.Command{
+	Use:   "add-slot",
+	Short: "Add a passphrase slot to the key file",
+	Long:  "Add a passphrase slot to the key file. A raw key file is converted to a passphrase-protected one.",
+	RunE:  runAddSlot,
+}
+
+var removeSlotCmd = &cobra.Command{
+	Use:   "remove-slot <name>",
+	Short: "Remove a passphrase slot from the key file",
+	Args:  cobra.ExactArgs(1),
+	RunE:  runRemoveSlot,
+}
+
+var listCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the passphrase slots of the key file",
+	RunE:  runList,
+}
+
+var (
+	keyPath       string
+	keyPassphrase bool
+	keySlotName   string
+)
+
+func init() {
+	for _, cmd := range []*cobra.Command{initCmd, addSlotCmd, removeSlotCmd, listCmd} {
+		cmd.Flags().StringVar(&keyPath, "path", "", "Key file path (default: the configured key file, or backup.key in the config directory)")
+	}
+	initCmd.Flags().BoolVar(&keyPassphrase, "passphrase", false, "Protect the key with a passphrase")
+	initCmd.Flags().StringVar(&keySlotName, "name", "default", "Name of the passphrase slot")
+	addSlotCmd.Flags().StringVar(&keySlotName, "name", "", "Name of the new passphrase slot (required)")
+	addSlotCmd.MarkFlagRequired("name")
+}
+
+func runInit(cmd *cobra.Command, args []string) error {
+	cfg, err := config.LoadClient()
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+
+	path, err := resolvePath(cfg)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(path); err == nil {
+		return fmt.Errorf("key file %s already exists", path)
+	}
+
+	master, err := encryption.GenerateMasterKey()
+	if err != nil {
+		return err
+	}
+
+	if keyPassphrase {
+		pass, err := newPassphrase()
+		if err != nil {
+			return err
+		}
+		f := &encryption.KeyFile{}
+		if err := f.AddSlot(master, keySlotName, pass); err != nil {
+			return err
+		}
+		if err := f.Save(path); err != nil {
+			return fmt.Errorf("failed to write key file: %w", err)
+		}
+	} else {
+		if err := encryption.WriteRawKeyFile(path, master); err != nil {
+			return fmt.Errorf("failed to write key file: %w", err)
+		}
+	}
+
+	cfg.EncryptionKeyFile = path
+	if err := config.SaveClient(cfg); err != nil {
+		return fmt.Errorf("failed to save config: %w", err)
+	}
+
+	fmt.Printf("Key written to %s, new backups will be encrypted.\n", path)
+	fmt.Println("Keep a copy of it somewhere safe: without it, encrypted backups can't be restored.")
+	return nil
+}
+
+func runAddSlot(cmd *cobra.Command, args []string) error {
+	cfg, err := config.LoadClient()
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+	path, err := resolvePath(cfg)
+	if err != nil {
+		return err
+	}
+
+	master, err := encryption.LoadMasterKey(path, func() (string, error) {
+		return readPassphrase("Existing passphrase")
+	})
+	if err != nil {
+		return err
+	}
+
+	// A raw key file becomes a passphrase-protected one
+	f, err := encryption.ReadKeyFile(path)
+	if err != nil {
+		f = &encryption.KeyFile{}
+	}
+
+	pass, err := newPassphrase()
+	if err != nil {
+		return err
+	}
+	if err := f.AddSlot(master, keySlotName, pass); err != nil {
+		return err
+	}
+	if err := f.Save(path); err != nil {
+		return fmt.Errorf("failed to write key file: %w", err)
+	}
+
+	fmt.Printf("Added key slot %q to %s\n", keySlotName, path)
+	return nil
+}
+
+func runRemoveSlot(cmd *cobra.Command, args []string) error {
+	cfg, err := config.LoadClient()
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+	path, err := resolvePath(cfg)
+	if err != nil {
+		return err
+	}
+
+	f, err := encryption.ReadKeyFile(path)
+	if err != nil {
+		return err
+	}
+	if err := f.RemoveSlot(args[0]); err != nil {
+		return err
+	}
+	if err := f.Save(path); err != nil {
+		return fmt.Errorf("failed to write key file: %w", err)
+	}
+
+	fmt.Printf("Removed key slot %q from %s\n", args[0], path)
+	return nil
+}
+
+func runList(cmd *cobra.Command, args []string) error {
+	cfg, err := config.LoadClient()
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+	path, err := resolvePath(cfg)
+	if err != nil {
+		return err
+	}
+
+	f, err := encryption.ReadKeyFile(path)
+	if err != nil {
+		if _, statErr := os.Stat(path); statErr == nil {
+			fmt.Printf("%s: raw key, no passphrase slots\n", path)
+			return nil
+		}
+		return err
+	}
+
+	fmt.Printf("%s: %d passphrase slot(s)\n\n", path, len(f.Slots))
+	for _, slot := range f.Slots {
+		fmt.Printf("Name: %s\n", slot.Name)
+		fmt.Printf("  KDF: argon2id (t=%d, m=%d KiB, p=%d)\n", slot.Time, slot.Memory, slot.Threads)
+		fmt.Println()
+	}
+	return nil
+}
+
+// resolvePath returns the key file path from --path, the config, or the default
+func resolvePath(cfg *config.ClientConfig) (string, error) {
+	if keyPath != "" {
+		return filepath.Abs(keyPath)
+	}
+	if cfg.EncryptionKeyFile != "" {
+		return cfg.EncryptionKeyFile, nil
+	}
+	dir, err := config.Dir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "backup.key"), nil
+}
+
+// readPassphrase returns IB_PASSPHRASE or prompts for a passphrase
+func readPassphrase(label string) (string, error) {
+	if pass := os.Getenv("IB_PASSPHRASE"); pass != "" {
+		return pass, nil
+	}
+	fmt.Printf("%s: ", label)
+	input, err := stdin.ReadString('\n')
+	if err != nil && input == "" {
+		return "", fmt.Errorf("failed to read passphrase: %w", err)
+	}
+	return strings.TrimRight(input, "\r\n"), nil
+}
+
+// newPassphrase prompts for a new passphrase twice
+func newPassphrase() (string, error) {
+	fmt.Print("New passphrase: ")
+	pass, _ := stdin.ReadString('\n')
+	fmt.Print("Repeat passphrase: ")
+	confirm, _ := stdin.ReadString('\n')
+
+	pass = strings.TrimRight(pass, "\r\n")
+	if pass == "" {
+		return "", fmt.Errorf("passphrase must not be empty")
+	}
+	if pass != strings.TrimRight(confirm, "\r\n") {
+		return "", fmt.Errorf("passphrases don't match")
+	}
+	return pass, nil
+}
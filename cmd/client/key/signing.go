@@ -0,0 +1,129 @@
+package key
+
+import (
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/johann/ib/internal/backup"
+	"github.com/johann/ib/internal/config"
+	"github.com/spf13/cobra"
+)
+
+var signingInitCmd = &cobra.Command{
+	Use:   "signing-init",
+	Short: "Create an Ed25519 key for signing manifests",
+	Long: `Create an Ed25519 key that signs every manifest this client uploads.
+
+Its public key is trusted automatically on this machine. Run
+'ib key trust <public-key>' on restore hosts so they reject manifests that
+were not signed by this client, even if the server was compromised.`,
+	RunE: runSigningInit,
+}
+
+var trustCmd = &cobra.Command{
+	Use:   "trust <public-key>",
+	Short: "Trust manifests signed by a public key",
+	Args:  cobra.ExactArgs(1),
+	RunE:  runTrust,
+}
+
+var untrustCmd = &cobra.Command{
+	Use:   "untrust <public-key>",
+	Short: "Stop trusting a public key",
+	Args:  cobra.ExactArgs(1),
+	RunE:  runUntrust,
+}
+
+func init() {
+	Cmd.AddCommand(signingInitCmd)
+	Cmd.AddCommand(trustCmd)
+	Cmd.AddCommand(untrustCmd)
+}
+
+func runSigningInit(cmd *cobra.Command, args []string) error {
+	cfg, err := config.LoadClient()
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+
+	dir, err := config.Dir()
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(dir, "signing.key")
+	if _, err := os.Stat(path); err == nil {
+		return fmt.Errorf("signing key %s already exists", path)
+	}
+
+	pub, err := backup.GenerateSigningKey(path)
+	if err != nil {
+		return err
+	}
+
+	cfg.SigningKeyFile = path
+	cfg.TrustedKeys = appendKey(cfg.TrustedKeys, pub)
+	if err := config.SaveClient(cfg); err != nil {
+		return fmt.Errorf("failed to save config: %w", err)
+	}
+
+	fmt.Printf("Signing key written to %s\n", path)
+	fmt.Printf("Public key: %s\n", pub)
+	fmt.Println("Run 'ib key trust <public-key>' on restore hosts to verify manifests from this client.")
+	return nil
+}
+
+func runTrust(cmd *cobra.Command, args []string) error {
+	pub := strings.ToLower(args[0])
+	if b, err := hex.DecodeString(pub); err != nil || len(b) != 32 {
+		return fmt.Errorf("invalid public key: expected 64 hex characters")
+	}
+
+	cfg, err := config.LoadClient()
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+	cfg.TrustedKeys = appendKey(cfg.TrustedKeys, pub)
+	if err := config.SaveClient(cfg); err != nil {
+		return fmt.Errorf("failed to save config: %w", err)
+	}
+
+	fmt.Printf("Trusting manifests signed by %s\n", pub)
+	return nil
+}
+
+func runUntrust(cmd *cobra.Command, args []string) error {
+	cfg, err := config.LoadClient()
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+
+	var kept []string
+	for _, k := range cfg.TrustedKeys {
+		if !strings.EqualFold(k, args[0]) {
+			kept = append(kept, k)
+		}
+	}
+	if len(kept) == len(cfg.TrustedKeys) {
+		return fmt.Errorf("key %s is not trusted", args[0])
+	}
+
+	cfg.TrustedKeys = kept
+	if err := config.SaveClient(cfg); err != nil {
+		return fmt.Errorf("failed to save config: %w", err)
+	}
+
+	fmt.Printf("No longer trusting %s\n", args[0])
+	return nil
+}
+
+func appendKey(keys []string, pub string) []string {
+	for _, k := range keys {
+		if strings.EqualFold(k, pub) {
+			return keys
+		}
+	}
+	return append(keys, pub)
+}
@@ -2,6 +2,8 @@ package main
 
 import (
 	"fmt"
+	"os"
+	"path/filepath"
 
 	"github.com/johann/ib/internal/config"
 	"github.com/spf13/cobra"
@@ -15,10 +17,16 @@ var loginCmd = &cobra.Command{
 	RunE:  runLogin,
 }
 
-var loginToken string
+var (
+	loginToken   string
+	loginKeyFile string
+	loginSign    bool
+)
 
 func init() {
 	loginCmd.Flags().StringVar(&loginToken, "token", "", "Authentication token for uploads")
+	loginCmd.Flags().StringVar(&loginKeyFile, "key-file", "", "Encrypt backups client-side with this key file")
+	loginCmd.Flags().BoolVar(&loginSign, "sign", false, "Sign requests instead of sending the token (for tokens created with --signed)")
 }
 
 func runLogin(cmd *cobra.Command, args []string) error {
@@ -33,6 +41,17 @@ func runLogin(cmd *cobra.Command, args []string) error {
 	if loginToken != "" {
 		cfg.Token = loginToken
 	}
+	cfg.SignRequests = loginSign
+	if loginKeyFile != "" {
+		absPath, err := filepath.Abs(loginKeyFile)
+		if err != nil {
+			return err
+		}
+		if _, err := os.Stat(absPath); err != nil {
+			return fmt.Errorf("key file: %w", err)
+		}
+		cfg.EncryptionKeyFile = absPath
+	}
 
 	if err := config.SaveClient(cfg); err != nil {
 		return fmt.Errorf("failed to save config: %w", err)
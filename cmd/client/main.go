@@ -2,10 +2,31 @@ package main
 
 import (
 	"os"
+	"time"
+
+	"github.com/johann/ib/cmd/client/backup"
+	"github.com/johann/ib/internal/errreport"
 )
 
+// version is set at build time with -ldflags "-X main.version=..."
+var version = "dev"
+
 func main() {
-	if err := rootCmd.Execute(); err != nil {
+	defer func() {
+		if v := recover(); v != nil {
+			errreport.Panic(v)
+			errreport.Flush(5 * time.Second)
+			panic(v)
+		}
+	}()
+
+	cmd, err := rootCmd.ExecuteC()
+	if err != nil {
+		if backup.JSONOutput {
+			backup.PrintJSONError(err)
+		}
+		errreport.Error(cmd.CommandPath(), err)
+		errreport.Flush(5 * time.Second)
 		os.Exit(1)
 	}
 }
@@ -0,0 +1,13 @@
+package migrate
+
+import "github.com/spf13/cobra"
+
+var Cmd = &cobra.Command{
+	Use:   "migrate",
+	Short: "Import backups from other tools",
+	Long:  "Re-create the history of another backup tool as ib backups.",
+}
+
+func init() {
+	Cmd.AddCommand(resticCmd)
+}
@@ -0,0 +1,283 @@
+package migrate
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/johann/ib/cmd/client/key"
+	"github.com/johann/ib/internal/backup"
+	"github.com/johann/ib/internal/client"
+	"github.com/johann/ib/internal/config"
+	"github.com/johann/ib/internal/encryption"
+	"github.com/johann/ib/internal/version"
+	"github.com/spf13/cobra"
+)
+
+var resticCmd = &cobra.Command{
+	Use:   "restic",
+	Short: "Import the snapshots of a restic repository",
+	Long: `Re-create every snapshot of a restic repository as an ib backup, oldest
+first, keeping the snapshot time and original paths.
+
+Each snapshot is restored with the restic binary into a temporary directory
+and backed up from there, incrementally against the previous snapshot of
+the same host and name. The manifest ID is derived from the snapshot time
+and ID, and a restic_snapshot tag records the full snapshot ID, so an
+interrupted migration can simply be run again: migrated snapshots are
+skipped.
+
+The name tag defaults to the base name of the snapshot's first path. The
+host and user tags come from the snapshot, its restic tags are kept in a
+restic_tags tag. Snapshots older than the server's retention are removed
+by its next prune.
+
+Example: ib migrate restic --repo /srv/restic --password-file ~/.restic-pass`,
+	Args: cobra.NoArgs,
+	RunE: runRestic,
+}
+
+var (
+	resticRepo         string
+	resticPasswordFile string
+	resticBinary       string
+	resticName         string
+	resticHost         string
+	resticTmpDir       string
+	resticKeyFile      string
+)
+
+func init() {
+	resticCmd.Flags().StringVar(&resticRepo, "repo", "", "Restic repository (default RESTIC_REPOSITORY)")
+	resticCmd.Flags().StringVar(&resticPasswordFile, "password-file", "", "File with the repository password (default RESTIC_PASSWORD_FILE)")
+	resticCmd.Flags().StringVar(&resticBinary, "restic", "restic", "Path to the restic binary")
+	resticCmd.Flags().StringVar(&resticName, "name", "", "Name tag for all snapshots (default: base name of the snapshot's first path)")
+	resticCmd.Flags().StringVar(&resticHost, "host", "", "Only migrate snapshots of this host")
+	resticCmd.Flags().StringVar(&resticTmpDir, "tmp-dir", "", "Where to restore snapshots (needs room for the largest one, default the system temp dir)")
+	resticCmd.Flags().StringVar(&resticKeyFile, "key-file", "", "Encrypt the backups with this key file (default: encryption_key_file from the config)")
+}
+
+// resticSnapshot is an entry of `restic snapshots --json`
+type resticSnapshot struct {
+	ID       string    `json:"id"`
+	ShortID  string    `json:"short_id"`
+	Time     time.Time `json:"time"`
+	Paths    []string  `json:"paths"`
+	Hostname string    `json:"hostname"`
+	Username string    `json:"username"`
+	Tags     []string  `json:"tags"`
+}
+
+// name returns the ib name tag of the snapshot
+func (s *resticSnapshot) name() string {
+	if resticName != "" {
+		return resticName
+	}
+	if len(s.Paths) == 0 {
+		return "restic"
+	}
+	return path.Base(filepath.ToSlash(s.Paths[0]))
+}
+
+// manifestID returns a manifest ID in ib's format, from the snapshot time
+// and short ID
+func (s *resticSnapshot) manifestID() string {
+	return s.Time.UTC().Format("20060102-150405") + "-" + s.ShortID
+}
+
+func runRestic(cmd *cobra.Command, args []string) error {
+	ctx := cmd.Context()
+
+	cfg, err := config.LoadClient()
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+	c, err := client.New(cfg)
+	if err != nil {
+		return err
+	}
+	encKey, err := key.Load(cfg, resticKeyFile)
+	if err != nil {
+		return err
+	}
+	if encKey != nil && !c.Supports(ctx, version.Encryption) {
+		return fmt.Errorf("the server doesn't support encrypted backups, upgrade it or migrate without a key")
+	}
+
+	snapshots, err := listResticSnapshots(ctx)
+	if err != nil {
+		return err
+	}
+	if len(snapshots) == 0 {
+		fmt.Println("No snapshots found")
+		return nil
+	}
+
+	migrated := make(map[string]string) // Restic snapshot ID -> manifest ID
+	existing, err := c.ListManifests(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to list manifests: %w", err)
+	}
+	for _, m := range existing {
+		if id := m.Tags["restic_snapshot"]; id != "" {
+			migrated[id] = m.ID
+		}
+	}
+
+	// The previous snapshot of each host and name, for incremental backups
+	prevIDs := make(map[string]string)
+	prevManifests := make(map[string]*backup.Manifest)
+
+	fmt.Printf("Migrating %d snapshot(s)\n", len(snapshots))
+	for i := range snapshots {
+		snap := &snapshots[i]
+		group := snap.Hostname + "\x00" + snap.name()
+
+		if id, ok := migrated[snap.ID]; ok {
+			fmt.Printf("Skipping snapshot %s (%s), already migrated as %s\n", snap.ShortID, snap.Time.Format(time.RFC3339), id)
+			prevIDs[group] = id
+			delete(prevManifests, group)
+			continue
+		}
+
+		prev := prevManifests[group]
+		if prev == nil && prevIDs[group] != "" {
+			prev, err = c.GetManifest(ctx, prevIDs[group])
+			if err == nil {
+				prev, err = backup.OpenManifest(encKey, prev)
+			}
+			if err != nil {
+				fmt.Printf("Warning: could not fetch previous manifest %s: %v\n", prevIDs[group], err)
+				prev = nil
+			}
+		}
+
+		fmt.Printf("\nSnapshot %s (%s) of %s:%s\n", snap.ShortID, snap.Time.Format(time.RFC3339), snap.Hostname, strings.Join(snap.Paths, ", "))
+		manifest, err := migrateSnapshot(ctx, c, cfg, encKey, snap, prev)
+		if err != nil {
+			return fmt.Errorf("snapshot %s: %w", snap.ShortID, err)
+		}
+		fmt.Printf("Stored as %s\n", manifest.ID)
+		prevIDs[group] = manifest.ID
+		prevManifests[group] = manifest
+	}
+	return nil
+}
+
+// restic runs the restic binary with the repository flags
+func restic(ctx context.Context, args ...string) *exec.Cmd {
+	var flags []string
+	if resticRepo != "" {
+		flags = append(flags, "--repo", resticRepo)
+	}
+	if resticPasswordFile != "" {
+		flags = append(flags, "--password-file", resticPasswordFile)
+	}
+	cmd := exec.CommandContext(ctx, resticBinary, append(flags, args...)...)
+	cmd.Stderr = os.Stderr
+	return cmd
+}
+
+// listResticSnapshots returns the snapshots to migrate, oldest first
+func listResticSnapshots(ctx context.Context) ([]resticSnapshot, error) {
+	args := []string{"snapshots", "--json"}
+	if resticHost != "" {
+		args = append(args, "--host", resticHost)
+	}
+	out, err := restic(ctx, args...).Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list restic snapshots: %w", err)
+	}
+
+	var snapshots []resticSnapshot
+	if err := json.NewDecoder(bytes.NewReader(out)).Decode(&snapshots); err != nil {
+		return nil, fmt.Errorf("failed to parse restic snapshots: %w", err)
+	}
+	sort.SliceStable(snapshots, func(i, j int) bool {
+		return snapshots[i].Time.Before(snapshots[j].Time)
+	})
+	return snapshots, nil
+}
+
+// migrateSnapshot restores a snapshot to a temporary directory, backs it
+// up and uploads the manifest
+func migrateSnapshot(ctx context.Context, c *client.Client, cfg *config.ClientConfig, encKey *encryption.Key, snap *resticSnapshot, prev *backup.Manifest) (*backup.Manifest, error) {
+	dir, err := os.MkdirTemp(resticTmpDir, "ib-restic-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temp dir: %w", err)
+	}
+	defer os.RemoveAll(dir)
+
+	fmt.Println("Restoring with restic...")
+	if err := restic(ctx, "restore", snap.ID, "--target", dir).Run(); err != nil {
+		return nil, fmt.Errorf("restic restore failed: %w", err)
+	}
+
+	// restic restores below the full original paths. A single path becomes
+	// the backup root, several are kept relative to /.
+	root, rootPath := dir, "/"
+	if len(snap.Paths) == 1 {
+		rootPath = snap.Paths[0]
+		rel := filepath.ToSlash(rootPath)
+		if vol := filepath.VolumeName(rootPath); len(vol) == 2 {
+			rel = vol[:1] + rel[2:] // C:\data is restored to C/data
+		}
+		root = filepath.Join(dir, filepath.FromSlash(strings.TrimPrefix(rel, "/")))
+	}
+
+	tags := map[string]string{
+		"name":            snap.name(),
+		"restic_snapshot": snap.ID,
+	}
+	if snap.Hostname != "" {
+		tags[backup.TagHost] = snap.Hostname
+	}
+	if snap.Username != "" {
+		tags[backup.TagUser] = snap.Username
+	}
+	if len(snap.Tags) > 0 {
+		tags["restic_tags"] = strings.Join(snap.Tags, ",")
+	}
+
+	creator := backup.NewCreator(c, 0)
+	creator.SetAdaptiveConcurrency(backup.DefaultMinConcurrency, backup.DefaultMaxConcurrency)
+	creator.SetKey(encKey)
+	creator.SetBlockHeader(c.Supports(ctx, version.BlockHeader))
+	manifest, err := creator.Create(ctx, root, tags, prev)
+	if err != nil {
+		return nil, fmt.Errorf("backup failed: %w", err)
+	}
+	manifest.ID = snap.manifestID()
+	manifest.CreatedAt = snap.Time.UTC()
+	manifest.RootPath = rootPath
+
+	upload := manifest
+	if encKey != nil {
+		upload, err = backup.SealManifest(encKey, manifest)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encrypt manifest: %w", err)
+		}
+	}
+	if cfg.SigningKeyFile != "" {
+		priv, err := backup.LoadSigningKey(cfg.SigningKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		if err := backup.SignManifest(priv, upload); err != nil {
+			return nil, fmt.Errorf("failed to sign manifest: %w", err)
+		}
+	}
+
+	if err := c.UploadManifest(ctx, upload); err != nil {
+		return nil, fmt.Errorf("failed to upload manifest: %w", err)
+	}
+	return manifest, nil
+}
@@ -1,7 +1,17 @@
 package main
 
 import (
+	"context"
+	"io"
+	"os"
+
 	"github.com/johann/ib/cmd/client/backup"
+	"github.com/johann/ib/cmd/client/key"
+	"github.com/johann/ib/cmd/client/migrate"
+	"github.com/johann/ib/internal/client"
+	"github.com/johann/ib/internal/errreport"
+	"github.com/johann/ib/internal/logging"
+	"github.com/johann/ib/internal/tracing"
 	"github.com/spf13/cobra"
 )
 
@@ -9,9 +19,69 @@ var rootCmd = &cobra.Command{
 	Use:   "ib",
 	Short: "Incremental backup tool",
 	Long:  "ib is an incremental backup tool for efficiently backing up and restoring large directories.",
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		if jsonOutput {
+			backup.EnableJSONOutput()
+		}
+		level := logLevel
+		if level == "" {
+			level = os.Getenv("IB_LOG_LEVEL")
+		}
+		file := logFile
+		if file == "" {
+			file = os.Getenv("IB_LOG_FILE")
+		}
+		output := io.Writer(os.Stderr)
+		if file != "" {
+			// Scheduled runs log to a file that rotates like the server's defaults
+			f := &logging.RotatingFile{Path: file, MaxSize: 100 << 20, MaxBackups: 10}
+			if err := f.Open(); err != nil {
+				return err
+			}
+			output = f
+		}
+		if err := logging.Setup(level, os.Getenv("IB_LOG_FORMAT"), output); err != nil {
+			return err
+		}
+
+		if err := errreport.Setup(os.Getenv("IB_ERROR_REPORT_DSN"), "ib"); err != nil {
+			return err
+		}
+		logging.SetErrorHook(errreport.LogRecord)
+
+		// Traces continue on the server, so slow backups can be followed end to end
+		var err error
+		shutdownTracing, err = tracing.Setup(cmd.Context(), "ib", os.Getenv("IB_OTLP_ENDPOINT"), 1)
+		return err
+	},
+	PersistentPostRunE: func(cmd *cobra.Command, args []string) error {
+		if shutdownTracing == nil {
+			return nil
+		}
+		return shutdownTracing(context.Background())
+	},
 }
 
+var shutdownTracing func(context.Context) error
+
+var (
+	logLevel   string
+	logFile    string
+	jsonOutput bool
+)
+
 func init() {
+	rootCmd.PersistentFlags().StringVar(&logLevel, "log-level", "", "Log level: debug, info, warn or error (default info, or IB_LOG_LEVEL)")
+	rootCmd.PersistentFlags().StringVar(&logFile, "log-file", "", "Write logs to this file, rotated at 100MB keeping 10 (default stderr, or IB_LOG_FILE)")
+	rootCmd.PersistentFlags().DurationVar(&client.Overrides.Timeout, "timeout", 0, "Timeout of each request to the server (default timeout_seconds from the config, or 5m)")
+	rootCmd.PersistentFlags().IntVar(&client.Overrides.Retries, "retries", 0, "Retries of block requests after transient failures, -1 for none (default retries from the config, or 5)")
+	rootCmd.PersistentFlags().BoolVar(&jsonOutput, "json", false, "Print the result as JSON to stdout, and everything else to stderr")
+
+	rootCmd.Version = version
+	backup.Version = version
+
 	rootCmd.AddCommand(loginCmd)
 	rootCmd.AddCommand(backup.Cmd)
+	rootCmd.AddCommand(key.Cmd)
+	rootCmd.AddCommand(migrate.Cmd)
 }
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"slices"
+
+	"github.com/johann/ib/internal/config"
+	"github.com/spf13/cobra"
+)
+
+var installScheduleCmd = &cobra.Command{
+	Use:   "install-schedule [name...]",
+	Short: "Schedule named backups with systemd timers or Windows scheduled tasks",
+	Long: `Schedule 'ib backup run <name>' for each named backup in the client config
+(all of them by default). Missed runs are caught up later.
+
+On Linux this installs systemd timers. Backups run at low CPU and I/O
+priority in a sandbox that can read the whole file system but only write to
+the ib config directory. Run as root to install system units, or as another
+user to install user units (which only run while the user is logged in,
+unless lingering is enabled with 'loginctl enable-linger').
+
+On Windows this registers tasks under \ib\ in Task Scheduler that run as
+the current user. --on-calendar takes hourly, daily or weekly (Sundays),
+starting at --at.`,
+	RunE: runInstallSchedule,
+}
+
+var uninstallScheduleCmd = &cobra.Command{
+	Use:   "uninstall-schedule [name...]",
+	Short: "Remove scheduled backups",
+	Long:  "Remove the timers or scheduled tasks for the given backups, or all of them.",
+	RunE:  runUninstallSchedule,
+}
+
+var (
+	scheduleOnCalendar string
+	scheduleAt         string
+	scheduleDelay      string
+	schedulePrint      bool
+	scheduleNoStart    bool
+)
+
+// validScheduleName matches names usable as systemd instance and task names
+var validScheduleName = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+func init() {
+	installScheduleCmd.Flags().StringVar(&scheduleOnCalendar, "on-calendar", "daily", "When to run: a systemd calendar expression, or hourly, daily or weekly on Windows")
+	installScheduleCmd.Flags().StringVar(&scheduleAt, "at", "02:00", "Time of day to start on Windows (HH:MM)")
+	installScheduleCmd.Flags().StringVar(&scheduleDelay, "randomized-delay", "15m", "Random delay added to each run")
+	installScheduleCmd.Flags().BoolVar(&schedulePrint, "print", false, "Print the units or task definitions instead of installing them")
+	installScheduleCmd.Flags().BoolVar(&scheduleNoStart, "no-start", false, "Install without enabling the schedule")
+
+	rootCmd.AddCommand(installScheduleCmd)
+	rootCmd.AddCommand(uninstallScheduleCmd)
+}
+
+func runInstallSchedule(cmd *cobra.Command, args []string) error {
+	cfg, err := config.LoadClient()
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+	names := args
+	if len(names) == 0 {
+		names = slices.Sorted(maps.Keys(cfg.Backups))
+	}
+	if len(names) == 0 {
+		return fmt.Errorf("the client config defines no backups; add them under \"backups\" first")
+	}
+	for _, name := range names {
+		if _, ok := cfg.Backups[name]; !ok {
+			return fmt.Errorf("no backup named %q", name)
+		}
+		if !validScheduleName.MatchString(name) {
+			return fmt.Errorf("backup name %q can't be scheduled: use letters, digits, '.', '_' and '-' only", name)
+		}
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	if exe, err = filepath.EvalSymlinks(exe); err != nil {
+		return err
+	}
+	configDir, err := config.Dir()
+	if err != nil {
+		return err
+	}
+
+	switch runtime.GOOS {
+	case "linux":
+		return installSystemdSchedule(exe, configDir, names)
+	case "windows":
+		return installTaskSchedule(exe, names)
+	default:
+		return fmt.Errorf("install-schedule is not supported on %s", runtime.GOOS)
+	}
+}
+
+func runUninstallSchedule(cmd *cobra.Command, args []string) error {
+	switch runtime.GOOS {
+	case "linux":
+		return uninstallSystemdSchedule(args)
+	case "windows":
+		return uninstallTaskSchedule(args)
+	default:
+		return fmt.Errorf("uninstall-schedule is not supported on %s", runtime.GOOS)
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/johann/ib/internal/systemd"
+)
+
+// backupServiceFile is the template service the timers start, one
+// instance per backup name
+const backupServiceFile = "ib-backup@.service"
+
+func installSystemdSchedule(exe, configDir string, names []string) error {
+	user := os.Geteuid() != 0
+
+	units := []*systemd.Unit{backupServiceUnit(exe, configDir, user)}
+	var timers []string
+	for _, name := range names {
+		unit := backupTimerUnit(name)
+		units = append(units, unit)
+		timers = append(timers, unit.FileName)
+	}
+
+	if schedulePrint {
+		for i, u := range units {
+			if i > 0 {
+				fmt.Println()
+			}
+			fmt.Printf("# %s\n%s", u.FileName, u.String())
+		}
+		return nil
+	}
+
+	var enable []string
+	if !scheduleNoStart {
+		enable = timers
+	}
+	dir, err := systemd.Install(units, user, enable...)
+	if err != nil {
+		return err
+	}
+
+	scope := ""
+	if user {
+		scope = " --user"
+	}
+	fmt.Printf("Installed %d timer(s) in %s\n", len(timers), dir)
+	if scheduleNoStart {
+		fmt.Printf("Enable them with: systemctl%s enable --now %s\n", scope, timers[0])
+	} else {
+		fmt.Printf("Next runs: systemctl%s list-timers 'ib-backup@*'\n", scope)
+	}
+	fmt.Printf("Run one now: systemctl%s start ib-backup@%s\n", scope, names[0])
+	return nil
+}
+
+// uninstallSystemdSchedule removes the timers for names, or all timers and
+// the template service
+func uninstallSystemdSchedule(names []string) error {
+	user := os.Geteuid() != 0
+
+	var files []string
+	if len(names) == 0 {
+		dir, err := systemd.UnitDir(user)
+		if err != nil {
+			return err
+		}
+		matches, _ := filepath.Glob(filepath.Join(dir, "ib-backup@*.timer"))
+		for _, m := range matches {
+			files = append(files, filepath.Base(m))
+		}
+		files = append(files, backupServiceFile)
+	} else {
+		for _, name := range names {
+			files = append(files, backupTimerUnit(name).FileName)
+		}
+	}
+
+	if err := systemd.Uninstall(files, user); err != nil {
+		return err
+	}
+	fmt.Printf("Removed %s\n", strings.Join(files, ", "))
+	return nil
+}
+
+func backupServiceUnit(exe, configDir string, user bool) *systemd.Unit {
+	service := []systemd.Option{
+		{Key: "Type", Value: "oneshot"},
+		{Key: "ExecStart", Value: systemd.Command(exe, "backup", "run") + " %i"},
+		{Key: "Environment", Value: systemd.Quote("XDG_CONFIG_HOME=" + filepath.Dir(configDir))},
+		{Key: "Nice", Value: "10"},
+		{Key: "IOSchedulingClass", Value: "idle"},
+	}
+	service = append(service, systemd.Hardening(configDir)...)
+	if !user {
+		// Root still needs to read files it doesn't own
+		service = append(service, systemd.Option{Key: "CapabilityBoundingSet", Value: "CAP_DAC_READ_SEARCH"})
+	}
+
+	return &systemd.Unit{
+		FileName: backupServiceFile,
+		Sections: []systemd.Section{
+			{Name: "Unit", Options: []systemd.Option{
+				{Key: "Description", Value: "ib backup %i"},
+				{Key: "Wants", Value: "network-online.target"},
+				{Key: "After", Value: "network-online.target"},
+			}},
+			{Name: "Service", Options: service},
+		},
+	}
+}
+
+func backupTimerUnit(name string) *systemd.Unit {
+	return &systemd.Unit{
+		FileName: "ib-backup@" + name + ".timer",
+		Sections: []systemd.Section{
+			{Name: "Unit", Options: []systemd.Option{
+				{Key: "Description", Value: "Scheduled ib backup " + name},
+			}},
+			{Name: "Timer", Options: []systemd.Option{
+				{Key: "OnCalendar", Value: scheduleOnCalendar},
+				{Key: "RandomizedDelaySec", Value: scheduleDelay},
+				{Key: "Persistent", Value: "true"},
+			}},
+			{Name: "Install", Options: []systemd.Option{{Key: "WantedBy", Value: "timers.target"}}},
+		},
+	}
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/xml"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+// taskFolder holds the Windows scheduled tasks, one per backup name
+const taskFolder = `\ib\`
+
+func taskName(name string) string {
+	return taskFolder + "backup-" + name
+}
+
+func installTaskSchedule(exe string, names []string) error {
+	trigger, err := taskTrigger()
+	if err != nil {
+		return err
+	}
+
+	for _, name := range names {
+		def := taskXML(exe, name, trigger)
+		if schedulePrint {
+			fmt.Printf("<!-- %s -->\n%s\n", taskName(name), def)
+			continue
+		}
+		if err := createTask(taskName(name), def); err != nil {
+			return err
+		}
+		fmt.Printf("Registered task %s\n", taskName(name))
+	}
+	if !schedulePrint {
+		fmt.Printf("Run one now: schtasks /Run /TN %s\n", taskName(names[0]))
+	}
+	return nil
+}
+
+// uninstallTaskSchedule deletes the tasks for names, or every ib backup task
+func uninstallTaskSchedule(names []string) error {
+	var tasks []string
+	if len(names) == 0 {
+		var err error
+		if tasks, err = listTasks(); err != nil {
+			return err
+		}
+	} else {
+		for _, name := range names {
+			tasks = append(tasks, taskName(name))
+		}
+	}
+
+	for _, task := range tasks {
+		if out, err := exec.Command("schtasks", "/Delete", "/TN", task, "/F").CombinedOutput(); err != nil {
+			return fmt.Errorf("failed to delete task %s: %w: %s", task, err, strings.TrimSpace(string(out)))
+		}
+		fmt.Printf("Removed task %s\n", task)
+	}
+	return nil
+}
+
+// taskTrigger returns the CalendarTrigger for the schedule flags
+func taskTrigger() (string, error) {
+	at, err := time.Parse("15:04", scheduleAt)
+	if err != nil {
+		return "", fmt.Errorf("invalid --at %q (expected HH:MM)", scheduleAt)
+	}
+	delay, err := time.ParseDuration(scheduleDelay)
+	if err != nil {
+		return "", fmt.Errorf("invalid --randomized-delay %q: %w", scheduleDelay, err)
+	}
+
+	now := time.Now()
+	start := time.Date(now.Year(), now.Month(), now.Day(), at.Hour(), at.Minute(), 0, 0, time.Local)
+
+	var schedule string
+	switch scheduleOnCalendar {
+	case "hourly":
+		schedule = "<Repetition><Interval>PT1H</Interval></Repetition><ScheduleByDay><DaysInterval>1</DaysInterval></ScheduleByDay>"
+	case "daily":
+		schedule = "<ScheduleByDay><DaysInterval>1</DaysInterval></ScheduleByDay>"
+	case "weekly":
+		schedule = "<ScheduleByWeek><WeeksInterval>1</WeeksInterval><DaysOfWeek><Sunday /></DaysOfWeek></ScheduleByWeek>"
+	default:
+		return "", fmt.Errorf("invalid --on-calendar %q (expected hourly, daily or weekly on Windows)", scheduleOnCalendar)
+	}
+
+	return fmt.Sprintf("<CalendarTrigger><StartBoundary>%s</StartBoundary><RandomDelay>PT%dS</RandomDelay>%s</CalendarTrigger>",
+		start.Format("2006-01-02T15:04:05"), int(delay.Seconds()), schedule), nil
+}
+
+// taskXML returns a Task Scheduler definition that catches up missed runs,
+// runs on battery and at below-normal priority
+func taskXML(exe, name, trigger string) string {
+	enabled := "true"
+	if scheduleNoStart {
+		enabled = "false"
+	}
+	return fmt.Sprintf(`<?xml version="1.0" encoding="UTF-16"?>
+<Task version="1.2" xmlns="http://schemas.microsoft.com/windows/2004/02/mit/task">
+  <RegistrationInfo><Description>ib backup %s</Description></RegistrationInfo>
+  <Triggers>%s</Triggers>
+  <Settings>
+    <Enabled>%s</Enabled>
+    <StartWhenAvailable>true</StartWhenAvailable>
+    <RunOnlyIfNetworkAvailable>true</RunOnlyIfNetworkAvailable>
+    <DisallowStartIfOnBatteries>false</DisallowStartIfOnBatteries>
+    <StopIfGoingOnBatteries>false</StopIfGoingOnBatteries>
+    <MultipleInstancesPolicy>IgnoreNew</MultipleInstancesPolicy>
+    <ExecutionTimeLimit>PT0S</ExecutionTimeLimit>
+    <Priority>7</Priority>
+  </Settings>
+  <Actions Context="Author">
+    <Exec>
+      <Command>%s</Command>
+      <Arguments>backup run %s</Arguments>
+    </Exec>
+  </Actions>
+</Task>`, xmlEscape(name), trigger, enabled, xmlEscape(exe), xmlEscape(name))
+}
+
+func xmlEscape(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+// createTask registers a task from its XML definition, replacing any
+// existing task of that name
+func createTask(name, def string) error {
+	f, err := os.CreateTemp("", "ib-task-*.xml")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	// schtasks expects UTF-16 with a byte order mark
+	var buf bytes.Buffer
+	buf.Write([]byte{0xff, 0xfe})
+	for _, u := range utf16.Encode([]rune(def)) {
+		buf.WriteByte(byte(u))
+		buf.WriteByte(byte(u >> 8))
+	}
+	if _, err := f.Write(buf.Bytes()); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	out, err := exec.Command("schtasks", "/Create", "/TN", name, "/XML", f.Name(), "/F").CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("failed to register task %s: %w: %s", name, err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// listTasks returns the names of all ib backup tasks
+func listTasks() ([]string, error) {
+	out, err := exec.Command("schtasks", "/Query", "/FO", "CSV", "/NH").Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list tasks: %w", err)
+	}
+	r := csv.NewReader(bytes.NewReader(out))
+	r.FieldsPerRecord = -1
+	records, err := r.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse task list: %w", err)
+	}
+
+	seen := make(map[string]bool)
+	var tasks []string
+	for _, rec := range records {
+		if len(rec) > 0 && strings.HasPrefix(rec[0], taskName("")) && !seen[rec[0]] {
+			seen[rec[0]] = true
+			tasks = append(tasks, rec[0])
+		}
+	}
+	return tasks, nil
+}
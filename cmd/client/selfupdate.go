@@ -0,0 +1,172 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+
+	"github.com/johann/ib/internal/client"
+	"github.com/johann/ib/internal/config"
+	"github.com/johann/ib/internal/release"
+	apiversion "github.com/johann/ib/internal/version"
+	"github.com/spf13/cobra"
+)
+
+// releaseKey is the public key (hex) that signs the client binaries, set
+// at build time with -ldflags "-X main.releaseKey=..."
+var releaseKey string
+
+var selfUpdateCmd = &cobra.Command{
+	Use:   "self-update",
+	Short: "Replace this binary with the client the server provides",
+	Long: `Download the client binary for this platform from the server and replace
+the running binary with it.
+
+The download is checked against the server's published checksums. When a
+release key is known (built in, release_key in the config, or
+--release-key), the checksums must carry a valid signature by that key, so
+a compromised server can't push a modified client.`,
+	Args: cobra.NoArgs,
+	RunE: runSelfUpdate,
+}
+
+var (
+	selfUpdateCheck      bool
+	selfUpdateReleaseKey string
+)
+
+func init() {
+	selfUpdateCmd.Flags().BoolVar(&selfUpdateCheck, "check", false, "Only report whether an update is available")
+	selfUpdateCmd.Flags().StringVar(&selfUpdateReleaseKey, "release-key", "", "Require the checksums to be signed by this public key (hex)")
+
+	rootCmd.AddCommand(selfUpdateCmd)
+}
+
+func runSelfUpdate(cmd *cobra.Command, args []string) error {
+	ctx := cmd.Context()
+
+	cfg, err := config.LoadClient()
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+	c, err := client.New(cfg)
+	if err != nil {
+		return err
+	}
+
+	if !c.Supports(ctx, apiversion.CLIChecksums) {
+		return fmt.Errorf("the server doesn't publish client checksums, upgrade it first")
+	}
+
+	sums, sig, err := c.CLIChecksums(ctx)
+	if err != nil {
+		return err
+	}
+	key := selfUpdateReleaseKey
+	if key == "" {
+		key = cfg.ReleaseKey
+	}
+	if key == "" {
+		key = releaseKey
+	}
+	switch {
+	case key != "" && sig == nil:
+		return fmt.Errorf("the server's client binaries are not signed, refusing to update")
+	case key != "":
+		if err := release.Verify(key, sums, sig); err != nil {
+			return err
+		}
+	default:
+		fmt.Println("Warning: no release key configured, the download is only checked against the server's checksums")
+	}
+
+	name := release.BinaryName(runtime.GOOS, runtime.GOARCH)
+	want, err := release.Lookup(sums, name)
+	if err != nil {
+		return fmt.Errorf("server has no client for %s/%s: %w", runtime.GOOS, runtime.GOARCH, err)
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	if exe, err = filepath.EvalSymlinks(exe); err != nil {
+		return err
+	}
+	current, err := fileSHA256(exe)
+	if err != nil {
+		return err
+	}
+	if current == want {
+		fmt.Println("Already up to date")
+		return nil
+	}
+	if selfUpdateCheck {
+		fmt.Printf("Update available (%s)\n", want)
+		return nil
+	}
+
+	// Download next to the binary so the final rename stays on one filesystem
+	tmp, err := os.CreateTemp(filepath.Dir(exe), ".ib-update-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temp file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	h := sha256.New()
+	if err := c.DownloadCLI(ctx, runtime.GOOS, runtime.GOARCH, io.MultiWriter(tmp, h)); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if got := hex.EncodeToString(h.Sum(nil)); got != want {
+		return fmt.Errorf("checksum mismatch for %s: got %s, want %s", name, got, want)
+	}
+	if err := os.Chmod(tmp.Name(), 0755); err != nil {
+		return err
+	}
+
+	if err := replaceExecutable(exe, tmp.Name()); err != nil {
+		return fmt.Errorf("failed to replace %s: %w", exe, err)
+	}
+	fmt.Printf("Updated %s\n", exe)
+	return nil
+}
+
+// replaceExecutable moves the new binary into place. Windows can't
+// overwrite a running executable, but it can rename it out of the way.
+func replaceExecutable(exe, next string) error {
+	if runtime.GOOS == "windows" {
+		old := exe + ".old"
+		os.Remove(old) // Left over from the previous update
+		if err := os.Rename(exe, old); err != nil {
+			return err
+		}
+		if err := os.Rename(next, exe); err != nil {
+			os.Rename(old, exe)
+			return err
+		}
+		return nil
+	}
+	return os.Rename(next, exe)
+}
+
+func fileSHA256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
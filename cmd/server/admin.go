@@ -0,0 +1,430 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/johann/ib/internal/auth"
+	"github.com/johann/ib/internal/client"
+	"github.com/johann/ib/internal/config"
+	"github.com/johann/ib/internal/storage"
+	"github.com/spf13/cobra"
+)
+
+var adminCmd = &cobra.Command{
+	Use:   "admin",
+	Short: "Manage a running server over its API",
+	Long: `Manage a running server over HTTP with an admin token, for deployments
+without shell access to the server.
+
+The server URL and token come from --server and --token, IB_SERVER_URL and
+IB_ADMIN_TOKEN, or the client config written by 'ib login'.`,
+}
+
+var (
+	adminServer string
+	adminToken  string
+	adminTags   []string
+)
+
+func init() {
+	adminCmd.PersistentFlags().StringVar(&adminServer, "server", "", "Server URL (default IB_SERVER_URL or the client config)")
+	adminCmd.PersistentFlags().StringVar(&adminToken, "token", "", "Admin token (default IB_ADMIN_TOKEN or the client config)")
+
+	adminScrubCmd.Flags().StringArrayVar(&adminTags, "tag", nil, "Only verify manifests with this tag (key=value, repeatable)")
+
+	adminTokenCreateCmd.Flags().StringVar(&tokenCreateScope, "scope", auth.ScopeAdmin, "Token scope: read, write or admin")
+	adminTokenCreateCmd.Flags().BoolVar(&tokenCreateAppendOnly, "append-only", false, "Forbid deleting manifests and changing tags with this token")
+	adminTokenCreateCmd.Flags().BoolVar(&tokenCreateSigned, "signed", false, "Only accept HMAC-signed requests from this token, never the raw token")
+	adminTokenCreateCmd.Flags().StringVar(&tokenCreateNamespace, "namespace", "", "Limit the token to backups with this name and names below it like <namespace>/db")
+	adminTokenCreateCmd.Flags().DurationVar(&tokenCreateExpires, "expires", 0, "Stop accepting the token after this long, e.g. 2160h (default never)")
+	adminTokenCmd.AddCommand(adminTokenListCmd, adminTokenCreateCmd, adminTokenRevokeCmd)
+
+	adminBlocksCmd.AddCommand(adminBlocksListCmd, adminBlocksClearCmd)
+
+	adminCmd.AddCommand(adminStatsCmd, adminPruneCmd, adminGCCmd, adminScrubCmd, adminTrashCmd,
+		adminUndeleteCmd, adminReloadCmd, adminLogLevelCmd, adminTokenCmd, adminBlocksCmd)
+	rootCmd.AddCommand(adminCmd)
+}
+
+// adminClient connects to the server named by the flags, environment or
+// client config
+func adminClient() (*client.Client, error) {
+	cfg := &config.ClientConfig{ServerURL: adminServer, Token: adminToken}
+	if cfg.ServerURL == "" {
+		cfg.ServerURL = os.Getenv("IB_SERVER_URL")
+	}
+	if cfg.Token == "" {
+		cfg.Token = os.Getenv("IB_ADMIN_TOKEN")
+	}
+	if cfg.ServerURL == "" || cfg.Token == "" {
+		local, err := config.LoadClient()
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client config: %w", err)
+		}
+		if cfg.ServerURL == "" {
+			cfg.ServerURL = local.ServerURL
+		}
+		if cfg.Token == "" {
+			cfg.Token = local.Token
+			cfg.SignRequests = local.SignRequests
+		}
+	}
+	if cfg.ServerURL == "" {
+		return nil, fmt.Errorf("no server URL: use --server, IB_SERVER_URL or 'ib login'")
+	}
+	cfg.ServerURL = strings.TrimRight(cfg.ServerURL, "/")
+	return client.New(cfg)
+}
+
+// adminCall runs one admin request
+func adminCall(method, path string, in, out any) error {
+	c, err := adminClient()
+	if err != nil {
+		return err
+	}
+	return c.Admin(context.Background(), method, path, in, out)
+}
+
+var adminStatsCmd = &cobra.Command{
+	Use:   "stats",
+	Short: "Show storage and backup statistics",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		var stats struct {
+			Blocks        int64  `json:"blocks"`
+			Bytes         int64  `json:"bytes"`
+			Manifests     int64  `json:"manifests"`
+			Trashed       int64  `json:"trashed"`
+			RetentionDays int    `json:"retention_days"`
+			TrashDays     int    `json:"trash_days"`
+			IPFSRunning   bool   `json:"ipfs_running"`
+			StartedAt     string `json:"started_at"`
+			Backups       []struct {
+				Name      string `json:"name"`
+				Manifests int64  `json:"manifests"`
+				Latest    string `json:"latest"`
+			} `json:"backups"`
+		}
+		if err := adminCall("GET", "/api/admin/stats", nil, &stats); err != nil {
+			return err
+		}
+
+		fmt.Printf("Manifests: %d (%d in the trash)\n", stats.Manifests, stats.Trashed)
+		fmt.Printf("Blocks: %d (%s stored)\n", stats.Blocks, formatBytes(stats.Bytes))
+		fmt.Printf("Retention: %d days\n", stats.RetentionDays)
+		fmt.Printf("Trash: %d days\n", stats.TrashDays)
+		ipfs := "stopped"
+		if stats.IPFSRunning {
+			ipfs = "running"
+		}
+		fmt.Printf("IPFS: %s\n", ipfs)
+		fmt.Printf("Started: %s\n", stats.StartedAt)
+		if len(stats.Backups) > 0 {
+			fmt.Println()
+			for _, b := range stats.Backups {
+				fmt.Printf("%s\n  Snapshots: %d\n  Latest: %s\n", b.Name, b.Manifests, b.Latest)
+			}
+		}
+		return nil
+	},
+}
+
+// pruneResponse is returned by the prune and gc endpoints
+type pruneResponse struct {
+	Result *storage.PruneResult `json:"result"`
+	Errors []string             `json:"errors"`
+}
+
+func printPruneResult(r *storage.PruneResult) {
+	if r == nil {
+		return
+	}
+	verb := "Removed"
+	if r.DryRun {
+		verb = "Would remove"
+	}
+	for _, m := range r.Pruned {
+		fmt.Printf("  %s  %s  %s\n", m.ID, m.CreatedAt.Format("2006-01-02 15:04"), m.Tags["name"])
+	}
+	fmt.Printf("%s %d manifests, %d blocks (%s) and %d DAG nodes\n",
+		verb, r.Manifests, r.Blocks, formatBytes(r.Bytes), r.Nodes)
+	if r.S3DeleteErrors > 0 {
+		fmt.Printf("Warning: %d S3 objects could not be deleted\n", r.S3DeleteErrors)
+	}
+}
+
+var adminPruneCmd = &cobra.Command{
+	Use:   "prune",
+	Short: "Apply retention now and collect garbage",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cutoff, err := pruneCutoff()
+		if err != nil {
+			return err
+		}
+		req := map[string]any{"dry_run": pruneDryRun}
+		if !cutoff.IsZero() {
+			req["cutoff"] = cutoff.UTC().Format(time.RFC3339)
+		}
+
+		var resp pruneResponse
+		err = adminCall("POST", "/api/admin/prune", req, &resp)
+		printPruneResult(resp.Result)
+		return err
+	},
+}
+
+var adminGCCmd = &cobra.Command{
+	Use:   "gc",
+	Short: "Remove blocks and DAG nodes no manifest refers to",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		var resp pruneResponse
+		err := adminCall("POST", "/api/admin/gc", nil, &resp)
+		printPruneResult(resp.Result)
+		return err
+	},
+}
+
+var adminScrubCmd = &cobra.Command{
+	Use:   "scrub",
+	Short: "Verify that every manifest's blocks and DAG nodes are stored",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		q := url.Values{}
+		for _, t := range adminTags {
+			k, v, ok := strings.Cut(t, "=")
+			if !ok {
+				return fmt.Errorf("invalid tag %q (expected key=value)", t)
+			}
+			q.Set(k, v)
+		}
+		path := "/api/admin/scrub"
+		if len(q) > 0 {
+			path += "?" + q.Encode()
+		}
+
+		var resp struct {
+			Checked    int `json:"checked"`
+			Skipped    int `json:"skipped"`
+			Incomplete []struct {
+				ID      string `json:"id"`
+				Missing int    `json:"missing"`
+				Checked int    `json:"checked"`
+			} `json:"incomplete"`
+			Errors []struct {
+				ID    string `json:"id"`
+				Error string `json:"error"`
+			} `json:"errors"`
+		}
+		if err := adminCall("POST", path, nil, &resp); err != nil {
+			return err
+		}
+
+		fmt.Printf("Verified %d manifests (%d without a DAG skipped)\n", resp.Checked, resp.Skipped)
+		for _, m := range resp.Incomplete {
+			fmt.Printf("INCOMPLETE %s: %d of %d blocks and nodes missing\n", m.ID, m.Missing, m.Checked)
+		}
+		for _, e := range resp.Errors {
+			fmt.Printf("ERROR %s: %s\n", e.ID, e.Error)
+		}
+		if len(resp.Incomplete) > 0 || len(resp.Errors) > 0 {
+			return fmt.Errorf("%d incomplete, %d failed", len(resp.Incomplete), len(resp.Errors))
+		}
+		return nil
+	},
+}
+
+var adminTrashCmd = &cobra.Command{
+	Use:   "trash",
+	Short: "List deleted manifests that can still be undeleted",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		var trashed []struct {
+			ID           string            `json:"id"`
+			Tags         map[string]string `json:"tags"`
+			DeletedAt    time.Time         `json:"deleted_at"`
+			TrashedUntil time.Time         `json:"trashed_until"`
+		}
+		if err := adminCall("GET", "/api/trash", nil, &trashed); err != nil {
+			return err
+		}
+		if len(trashed) == 0 {
+			fmt.Println("The trash is empty")
+			return nil
+		}
+		for _, m := range trashed {
+			fmt.Printf("%s (%s): deleted %s, purged after %s\n", m.ID, m.Tags["name"],
+				m.DeletedAt.Local().Format(time.RFC3339), m.TrashedUntil.Local().Format(time.RFC3339))
+		}
+		return nil
+	},
+}
+
+var adminUndeleteCmd = &cobra.Command{
+	Use:   "undelete <manifest-id>",
+	Short: "Restore a manifest from the trash",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := adminCall("POST", "/api/trash/"+url.PathEscape(args[0])+"/undelete", nil, nil); err != nil {
+			return err
+		}
+		fmt.Printf("Undeleted %s\n", args[0])
+		return nil
+	},
+}
+
+var adminReloadCmd = &cobra.Command{
+	Use:   "reload",
+	Short: "Make the server re-read its configuration",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := adminCall("POST", "/api/config/reload", nil, nil); err != nil {
+			return err
+		}
+		fmt.Println("Configuration reloaded")
+		return nil
+	},
+}
+
+var adminLogLevelCmd = &cobra.Command{
+	Use:   "log-level [level]",
+	Short: "Show or change the log level",
+	Args:  cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		var resp struct {
+			Level string `json:"level"`
+		}
+		var err error
+		if len(args) == 0 {
+			err = adminCall("GET", "/api/log-level", nil, &resp)
+		} else {
+			err = adminCall("PUT", "/api/log-level", map[string]string{"level": args[0]}, &resp)
+		}
+		if err != nil {
+			return err
+		}
+		fmt.Println(resp.Level)
+		return nil
+	},
+}
+
+var adminTokenCmd = &cobra.Command{
+	Use:   "token",
+	Short: "Manage named tokens",
+}
+
+var adminTokenListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List named tokens",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		var tokens []storage.TokenInfo
+		if err := adminCall("GET", "/api/tokens", nil, &tokens); err != nil {
+			return err
+		}
+		printTokens(tokens)
+		return nil
+	},
+}
+
+var adminTokenCreateCmd = &cobra.Command{
+	Use:   "create <name>",
+	Short: "Create a named token",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		req := map[string]any{
+			"name":        args[0],
+			"scope":       tokenCreateScope,
+			"append_only": tokenCreateAppendOnly,
+			"signed":      tokenCreateSigned,
+			"namespace":   tokenCreateNamespace,
+		}
+		if tokenCreateExpires > 0 {
+			req["expires_in"] = tokenCreateExpires.String()
+		}
+		var resp struct {
+			Token string            `json:"token"`
+			Info  storage.TokenInfo `json:"info"`
+		}
+		if err := adminCall("POST", "/api/tokens", req, &resp); err != nil {
+			return err
+		}
+		fmt.Printf("Created token %q (scope %s), save it now:\n", resp.Info.Name, resp.Info.Scope)
+		fmt.Println(resp.Token)
+		return nil
+	},
+}
+
+var adminTokenRevokeCmd = &cobra.Command{
+	Use:   "revoke <name>",
+	Short: "Revoke a named token",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := adminCall("DELETE", "/api/tokens/"+url.PathEscape(args[0]), nil, nil); err != nil {
+			return err
+		}
+		fmt.Printf("Revoked token %q\n", args[0])
+		return nil
+	},
+}
+
+var adminBlocksCmd = &cobra.Command{
+	Use:   "auth-blocks",
+	Short: "Manage blocks after failed authentication",
+}
+
+var adminBlocksListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List blocked IPs and tokens",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		var blocks []storage.AuthBlock
+		if err := adminCall("GET", "/api/auth/blocks", nil, &blocks); err != nil {
+			return err
+		}
+		if len(blocks) == 0 {
+			fmt.Println("No blocks")
+			return nil
+		}
+		for _, b := range blocks {
+			until := b.BlockedUntil.Format(time.RFC3339)
+			if b.Banned {
+				until = "cleared"
+			}
+			fmt.Printf("%s: %d failures, blocked until %s\n", b.Key, b.Failures, until)
+		}
+		return nil
+	},
+}
+
+var adminBlocksClearCmd = &cobra.Command{
+	Use:   "clear <key>",
+	Short: "Clear the block of an IP or token:<id>@<ip>",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := adminCall("DELETE", "/api/auth/blocks/"+url.PathEscape(args[0]), nil, nil); err != nil {
+			return err
+		}
+		fmt.Printf("Cleared %s\n", args[0])
+		return nil
+	},
+}
+
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/johann/ib/internal/config"
+	"github.com/johann/ib/internal/storage"
+	"github.com/spf13/cobra"
+)
+
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Inspect the server configuration",
+}
+
+var configValidateCmd = &cobra.Command{
+	Use:   "validate",
+	Short: "Check the configuration, paths and S3 credentials",
+	Long: `Load the server configuration (server.json, server.yaml or server.toml,
+plus IB_* environment overrides) and check it before starting the server:
+unknown keys and invalid values, that the database and log directories are
+writable, and that the S3 bucket can be written, read and deleted from.`,
+	RunE: runConfigValidate,
+}
+
+var configSkipS3 bool
+
+func init() {
+	configValidateCmd.Flags().BoolVar(&configSkipS3, "skip-s3", false, "Don't connect to S3")
+	configCmd.AddCommand(configValidateCmd)
+}
+
+func runConfigValidate(cmd *cobra.Command, args []string) error {
+	fmt.Printf("Config file: %s\n", configPath())
+
+	cfg, err := config.LoadServer()
+	if err != nil {
+		return err
+	}
+
+	failed := false
+	check := func(name string, err error) {
+		if err != nil {
+			failed = true
+			fmt.Printf("FAIL  %s\n      %v\n", name, err)
+			return
+		}
+		fmt.Printf("ok    %s\n", name)
+	}
+
+	check("settings", cfg.Validate())
+	check("database directory", checkWritableDir(filepath.Dir(cfg.DBPath)))
+	if cfg.LogFile != "" {
+		check("log directory", checkWritableDir(filepath.Dir(cfg.LogFile)))
+	}
+
+	if !configSkipS3 && cfg.S3Bucket != "" {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		s3Client, err := storage.NewS3Client(cfg)
+		if err == nil {
+			err = s3Client.CheckAccess(ctx)
+		}
+		check("S3 access", err)
+	}
+
+	if failed {
+		return fmt.Errorf("configuration has errors")
+	}
+	fmt.Println("Configuration is valid")
+	return nil
+}
+
+// checkWritableDir creates dir if needed and checks files can be created in it
+func checkWritableDir(dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(dir, ".ib-validate-*")
+	if err != nil {
+		return err
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
@@ -70,6 +70,7 @@ func runInit(cmd *cobra.Command, args []string) error {
 
 	if enableIPFS {
 		cfg.IPFSGatewayAddr = prompt(reader, "IPFS Gateway Address", cfg.IPFSGatewayAddr, ":8081")
+		cfg.IPFSMDNS = promptYesNo(reader, "Enable mDNS local peer discovery?", cfg.IPFSMDNS)
 		fmt.Println()
 		fmt.Println("IPFS will also listen on:")
 		fmt.Println("  - TCP  :4001 (libp2p)")
@@ -82,13 +83,13 @@ func runInit(cmd *cobra.Command, args []string) error {
 	fmt.Println("Authentication")
 	fmt.Println("--------------")
 
-	if cfg.Token == "" {
+	if cfg.Token == "" && cfg.TokenHash == "" {
 		token, err := generateToken()
 		if err != nil {
 			return fmt.Errorf("failed to generate token: %w", err)
 		}
 		cfg.Token = token
-		fmt.Printf("Generated new token: %s\n", token)
+		fmt.Printf("Generated new token (stored hashed, save it now): %s\n", token)
 	} else {
 		if promptYesNo(reader, "Regenerate authentication token?", false) {
 			token, err := generateToken()
@@ -96,9 +97,9 @@ func runInit(cmd *cobra.Command, args []string) error {
 				return fmt.Errorf("failed to generate token: %w", err)
 			}
 			cfg.Token = token
-			fmt.Printf("New token: %s\n", token)
+			fmt.Printf("New token (stored hashed, save it now): %s\n", token)
 		} else {
-			fmt.Printf("Keeping existing token: %s\n", cfg.Token)
+			fmt.Println("Keeping existing token")
 		}
 	}
 
@@ -109,9 +110,8 @@ func runInit(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("failed to save config: %w", err)
 	}
 
-	configDir, _ := config.Dir()
 	fmt.Println("Configuration saved!")
-	fmt.Printf("Config file: %s/server.json\n", configDir)
+	fmt.Printf("Config file: %s\n", configPath())
 	fmt.Println()
 	fmt.Println("Start the server with:")
 	fmt.Println("  ib-server serve")
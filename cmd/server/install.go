@@ -0,0 +1,155 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/johann/ib/internal/config"
+	"github.com/johann/ib/internal/systemd"
+	"github.com/spf13/cobra"
+)
+
+var installServiceCmd = &cobra.Command{
+	Use:   "install-service",
+	Short: "Install a systemd service for the server",
+	Long: `Generate a sandboxed systemd unit running 'ib-server serve', install it and
+start it. The unit uses the config directory of the user running this
+command; only that directory, the database and the log file directory are
+writable by the service.
+
+Run as root to install a system service (optionally running as --run-as),
+or as another user to install a user service.`,
+	RunE: runInstallService,
+}
+
+var (
+	installPrint   bool
+	installRunAs   string
+	installNoStart bool
+	installTitle   string
+	installMetrics int
+)
+
+func init() {
+	installServiceCmd.Flags().BoolVar(&installPrint, "print", false, "Print the unit instead of installing it")
+	installServiceCmd.Flags().StringVar(&installRunAs, "run-as", "", "User to run a system service as (default root)")
+	installServiceCmd.Flags().BoolVar(&installNoStart, "no-start", false, "Install without enabling and starting the service")
+	installServiceCmd.Flags().StringVar(&installTitle, "title", "", "Title for the web UI")
+	installServiceCmd.Flags().IntVar(&installMetrics, "metrics-port", 0, "Port for Prometheus metrics (disabled if 0)")
+
+	rootCmd.AddCommand(installServiceCmd)
+}
+
+func runInstallService(cmd *cobra.Command, args []string) error {
+	cfg, err := config.LoadServer()
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	if exe, err = filepath.EvalSymlinks(exe); err != nil {
+		return err
+	}
+	configDir, err := config.Dir()
+	if err != nil {
+		return err
+	}
+
+	user := os.Geteuid() != 0
+	if user && installRunAs != "" {
+		return fmt.Errorf("--run-as needs a system service; run as root")
+	}
+
+	execArgs := []string{exe, "serve"}
+	if installTitle != "" {
+		execArgs = append(execArgs, "--title", installTitle)
+	}
+	if installMetrics > 0 {
+		execArgs = append(execArgs, "--metrics-port", strconv.Itoa(installMetrics))
+	}
+
+	service := []systemd.Option{
+		{Key: "Type", Value: "simple"},
+		{Key: "ExecStart", Value: systemd.Command(execArgs...)},
+		{Key: "ExecReload", Value: "/bin/kill -HUP $MAINPID"},
+		{Key: "Environment", Value: systemd.Quote("XDG_CONFIG_HOME=" + filepath.Dir(configDir))},
+		{Key: "Restart", Value: "on-failure"},
+		{Key: "RestartSec", Value: "5"},
+		{Key: "LimitNOFILE", Value: "65536"},
+	}
+	if installRunAs != "" {
+		service = append(service, systemd.Option{Key: "User", Value: installRunAs})
+	}
+	writable := []string{configDir, filepath.Dir(cfg.DBPath)}
+	if cfg.LogFile != "" {
+		writable = append(writable, filepath.Dir(cfg.LogFile))
+	}
+	if cfg.ACMECacheDir != "" {
+		writable = append(writable, cfg.ACMECacheDir)
+	}
+	service = append(service, systemd.Hardening(writable...)...)
+	// ACME answers HTTP challenges on port 80
+	if privilegedPort(cfg.ListenAddr) || privilegedPort(cfg.IPFSGatewayAddr) || len(cfg.ACMEDomains) > 0 {
+		service = append(service,
+			systemd.Option{Key: "CapabilityBoundingSet", Value: "CAP_NET_BIND_SERVICE"},
+			systemd.Option{Key: "AmbientCapabilities", Value: "CAP_NET_BIND_SERVICE"})
+	}
+
+	wantedBy := "multi-user.target"
+	if user {
+		wantedBy = "default.target"
+	}
+	unit := &systemd.Unit{
+		FileName: "ib-server.service",
+		Sections: []systemd.Section{
+			{Name: "Unit", Options: []systemd.Option{
+				{Key: "Description", Value: "ib backup server"},
+				{Key: "Wants", Value: "network-online.target"},
+				{Key: "After", Value: "network-online.target"},
+			}},
+			{Name: "Service", Options: service},
+			{Name: "Install", Options: []systemd.Option{{Key: "WantedBy", Value: wantedBy}}},
+		},
+	}
+
+	if installPrint {
+		fmt.Print(unit.String())
+		return nil
+	}
+
+	var enable []string
+	if !installNoStart {
+		enable = []string{unit.FileName}
+	}
+	dir, err := systemd.Install([]*systemd.Unit{unit}, user, enable...)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Installed %s\n", filepath.Join(dir, unit.FileName))
+	scope := ""
+	if user {
+		scope = " --user"
+	}
+	if installNoStart {
+		fmt.Printf("Start it with: systemctl%s enable --now ib-server\n", scope)
+	} else {
+		fmt.Printf("Service started; logs: journalctl%s -u ib-server\n", scope)
+	}
+	return nil
+}
+
+// privilegedPort reports whether addr listens on a port below 1024
+func privilegedPort(addr string) bool {
+	_, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	n, err := strconv.Atoi(port)
+	return err == nil && n > 0 && n < 1024
+}
@@ -2,18 +2,35 @@ package main
 
 import (
 	"os"
+	"time"
 
 	ib "github.com/johann/ib"
+	"github.com/johann/ib/internal/errreport"
 	"github.com/johann/ib/internal/server"
 )
 
+// version is set at build time with -ldflags "-X main.version=..."
+var version = "dev"
+
 func init() {
 	// Set embedded files for the server
 	server.SetEmbeddedFiles(ib.Frontend, ib.ClientBinaries)
+	server.SetVersion(version)
 }
 
 func main() {
-	if err := rootCmd.Execute(); err != nil {
+	defer func() {
+		if v := recover(); v != nil {
+			errreport.Panic(v)
+			errreport.Flush(5 * time.Second)
+			panic(v)
+		}
+	}()
+
+	cmd, err := rootCmd.ExecuteC()
+	if err != nil {
+		errreport.Error(cmd.CommandPath(), err)
+		errreport.Flush(5 * time.Second)
 		os.Exit(1)
 	}
 }
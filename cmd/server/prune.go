@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/johann/ib/internal/config"
+	"github.com/johann/ib/internal/server"
+	"github.com/johann/ib/internal/storage"
+	"github.com/spf13/cobra"
+)
+
+var pruneCmd = &cobra.Command{
+	Use:   "prune",
+	Short: "Apply retention now and collect garbage",
+	Long: `Remove the manifests retention policies don't keep, those older than the
+retention period for names without a policy and those in the trash longer
+than the trash period, then the blocks and DAG nodes only they referred
+to. This works on the local database and storage, so it also works while
+the server is stopped; use 'ib-server admin prune' for a remote server.
+
+--older-than or --before prune manifests created before that time instead
+of applying the retention period and policies. --dry-run only lists what
+would be removed.`,
+	Args: cobra.NoArgs,
+	RunE: runPrune,
+}
+
+var (
+	pruneOlderThan time.Duration
+	pruneBefore    string
+	pruneDryRun    bool
+)
+
+func init() {
+	for _, cmd := range []*cobra.Command{pruneCmd, adminPruneCmd} {
+		cmd.Flags().DurationVar(&pruneOlderThan, "older-than", 0, "Prune manifests older than this, e.g. 720h (default the retention period)")
+		cmd.Flags().StringVar(&pruneBefore, "before", "", "Prune manifests created before this date (2006-01-02 or RFC 3339)")
+		cmd.Flags().BoolVar(&pruneDryRun, "dry-run", false, "Only show what would be removed")
+		cmd.MarkFlagsMutuallyExclusive("older-than", "before")
+	}
+	rootCmd.AddCommand(pruneCmd)
+}
+
+// pruneCutoff returns the cutoff given by --older-than or --before, zero
+// for the retention period
+func pruneCutoff() (time.Time, error) {
+	switch {
+	case pruneOlderThan < 0:
+		return time.Time{}, fmt.Errorf("invalid --older-than %s", pruneOlderThan)
+	case pruneOlderThan > 0:
+		return time.Now().Add(-pruneOlderThan), nil
+	case pruneBefore != "":
+		if t, err := time.Parse(time.RFC3339, pruneBefore); err == nil {
+			return t, nil
+		}
+		t, err := time.ParseInLocation("2006-01-02", pruneBefore, time.Local)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid --before %q (expected 2006-01-02 or RFC 3339)", pruneBefore)
+		}
+		return t, nil
+	}
+	return time.Time{}, nil
+}
+
+func runPrune(cmd *cobra.Command, args []string) error {
+	cutoff, err := pruneCutoff()
+	if err != nil {
+		return err
+	}
+	cfg, err := config.LoadServer()
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+	store, err := storage.New(cfg)
+	if err != nil {
+		return fmt.Errorf("failed to open storage: %w", err)
+	}
+	defer store.Close()
+
+	ctx := context.Background()
+	now := time.Now()
+	opts := storage.PruneOptions{
+		Cutoff:      cutoff,
+		TrashCutoff: now.Add(-cfg.TrashPeriod()),
+		DryRun:      pruneDryRun,
+	}
+	if cutoff.IsZero() {
+		opts.Cutoff = now.AddDate(0, 0, -cfg.RetentionDays)
+		if opts.Policies, err = server.RetentionPolicies(ctx, store, cfg); err != nil {
+			return err
+		}
+	}
+	result, err := store.PruneManifests(ctx, opts)
+	printPruneResult(result)
+	return err
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/johann/ib/internal/backup"
+	"github.com/johann/ib/internal/release"
+	"github.com/spf13/cobra"
+)
+
+var releaseCmd = &cobra.Command{
+	Use:   "release",
+	Short: "Checksum and sign client binaries before embedding them",
+	Long: `Create the checksums and signature the server publishes at /cli/checksums
+and /cli/checksums.sig. 'make build' runs 'release sign' after building the
+clients; set RELEASE_KEY to sign, and RELEASE_PUBLIC_KEY so the clients
+trust that key for 'ib self-update'.`,
+}
+
+var releaseKeygenCmd = &cobra.Command{
+	Use:   "keygen <key-file>",
+	Short: "Create an Ed25519 key for signing client binaries",
+	Args:  cobra.ExactArgs(1),
+	RunE:  runReleaseKeygen,
+}
+
+var releaseSignCmd = &cobra.Command{
+	Use:   "sign <dir>",
+	Short: "Write SHA256SUMS for the client binaries in dir, and sign it with --key",
+	Args:  cobra.ExactArgs(1),
+	RunE:  runReleaseSign,
+}
+
+var releaseKeyFile string
+
+func init() {
+	releaseSignCmd.Flags().StringVar(&releaseKeyFile, "key", "", "Sign the checksums with this key from 'release keygen'")
+
+	releaseCmd.AddCommand(releaseKeygenCmd)
+	releaseCmd.AddCommand(releaseSignCmd)
+}
+
+func runReleaseKeygen(cmd *cobra.Command, args []string) error {
+	path := args[0]
+	if _, err := os.Stat(path); err == nil {
+		return fmt.Errorf("key file %s already exists", path)
+	}
+	pub, err := backup.GenerateSigningKey(path)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Release key written to %s\n", path)
+	fmt.Printf("Public key: %s\n", pub)
+	fmt.Println()
+	fmt.Println("Build with:")
+	fmt.Printf("  make build RELEASE_KEY=%s RELEASE_PUBLIC_KEY=%s\n", path, pub)
+	return nil
+}
+
+func runReleaseSign(cmd *cobra.Command, args []string) error {
+	dir := args[0]
+
+	sums, err := release.Checksums(os.DirFS(dir), ".")
+	if err != nil {
+		return fmt.Errorf("failed to hash client binaries: %w", err)
+	}
+	if len(sums) == 0 {
+		return fmt.Errorf("no client binaries in %s", dir)
+	}
+	if err := os.WriteFile(filepath.Join(dir, release.ChecksumsFile), sums, 0644); err != nil {
+		return err
+	}
+	fmt.Printf("Wrote %s\n", filepath.Join(dir, release.ChecksumsFile))
+
+	sigPath := filepath.Join(dir, release.SignatureFile)
+	if releaseKeyFile == "" {
+		// Don't publish a stale signature for new checksums
+		if err := os.Remove(sigPath); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		fmt.Println("Warning: no --key given, the checksums are not signed")
+		return nil
+	}
+
+	priv, err := backup.LoadSigningKey(releaseKeyFile)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(sigPath, release.Sign(priv, sums), 0644); err != nil {
+		return err
+	}
+	fmt.Printf("Wrote %s\n", sigPath)
+	return nil
+}
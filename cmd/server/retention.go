@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+
+	"github.com/johann/ib/internal/backup"
+	"github.com/spf13/cobra"
+)
+
+var adminRetentionCmd = &cobra.Command{
+	Use:   "retention",
+	Short: "Manage retention policies per backup name",
+	Long: `Retention policies decide per name tag which backups the daily prune
+keeps: the N newest, and the newest of each of the last days, weeks and
+months with a backup. "*" applies to all names without their own policy;
+names without any policy keep backups for the retention period.
+
+Policies set here take precedence over retention_policies in the server
+config.`,
+}
+
+var adminRetentionKeep backup.RetentionPolicy
+
+func init() {
+	adminRetentionSetCmd.Flags().IntVar(&adminRetentionKeep.Last, "keep-last", 0, "Keep the N newest backups")
+	adminRetentionSetCmd.Flags().IntVar(&adminRetentionKeep.Daily, "keep-daily", 0, "Keep the newest backup of each of the last N days with one")
+	adminRetentionSetCmd.Flags().IntVar(&adminRetentionKeep.Weekly, "keep-weekly", 0, "Keep the newest backup of each of the last N weeks with one")
+	adminRetentionSetCmd.Flags().IntVar(&adminRetentionKeep.Monthly, "keep-monthly", 0, "Keep the newest backup of each of the last N months with one")
+
+	adminRetentionCmd.AddCommand(adminRetentionListCmd, adminRetentionSetCmd, adminRetentionUnsetCmd)
+	adminCmd.AddCommand(adminRetentionCmd)
+}
+
+var adminRetentionListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List retention policies",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		var resp struct {
+			RetentionDays int `json:"retention_days"`
+			Policies      []struct {
+				Name string `json:"name"`
+				backup.RetentionPolicy
+				Source string `json:"source"`
+			} `json:"policies"`
+		}
+		if err := adminCall("GET", "/api/retention", nil, &resp); err != nil {
+			return err
+		}
+
+		for _, p := range resp.Policies {
+			policy := p.RetentionPolicy.String()
+			if policy == "" {
+				policy = "keep all"
+			}
+			fmt.Printf("%s\n  Keep: %s\n  From: %s\n", p.Name, policy, p.Source)
+		}
+		fmt.Printf("Names without a policy: %d days\n", resp.RetentionDays)
+		return nil
+	},
+}
+
+var adminRetentionSetCmd = &cobra.Command{
+	Use:   "set <name>",
+	Short: "Set the retention policy of a backup name, or \"*\" for all others",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := adminRetentionKeep.Validate(); err != nil {
+			return err
+		}
+		if err := adminCall("PUT", "/api/retention/"+url.PathEscape(args[0]), adminRetentionKeep, nil); err != nil {
+			return err
+		}
+		policy := adminRetentionKeep.String()
+		if policy == "" {
+			policy = "keep all"
+		}
+		fmt.Printf("Retention policy of %q: %s\n", args[0], policy)
+		return nil
+	},
+}
+
+var adminRetentionUnsetCmd = &cobra.Command{
+	Use:   "unset <name>",
+	Short: "Remove the retention policy set for a backup name",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := adminCall("DELETE", "/api/retention/"+url.PathEscape(args[0]), nil, nil); err != nil {
+			return err
+		}
+		fmt.Printf("Removed the retention policy of %q\n", args[0])
+		return nil
+	},
+}
@@ -13,4 +13,6 @@ var rootCmd = &cobra.Command{
 func init() {
 	rootCmd.AddCommand(serveCmd)
 	rootCmd.AddCommand(tokenCmd)
+	rootCmd.AddCommand(configCmd)
+	rootCmd.AddCommand(releaseCmd)
 }
@@ -1,12 +1,19 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"io"
 	"os"
 	"strconv"
+	"time"
 
 	"github.com/johann/ib/internal/config"
+	"github.com/johann/ib/internal/errreport"
+	"github.com/johann/ib/internal/logging"
+	"github.com/johann/ib/internal/secrets"
 	"github.com/johann/ib/internal/server"
+	"github.com/johann/ib/internal/tracing"
 	"github.com/spf13/cobra"
 )
 
@@ -21,12 +28,18 @@ var (
 	serveListenAddr  string
 	serveMetricsPort int
 	serveTitle       string
+	serveTLSCert     string
+	serveTLSKey      string
+	serveACMEDomains []string
 )
 
 func init() {
 	serveCmd.Flags().StringVar(&serveListenAddr, "listen", "", "Listen address (default from config or :8080)")
 	serveCmd.Flags().IntVar(&serveMetricsPort, "metrics-port", 0, "Port for Prometheus metrics (disabled if 0)")
 	serveCmd.Flags().StringVar(&serveTitle, "title", "ib Backup", "Title for the web UI")
+	serveCmd.Flags().StringVar(&serveTLSCert, "tls-cert", "", "Serve HTTPS with this PEM certificate (default from config)")
+	serveCmd.Flags().StringVar(&serveTLSKey, "tls-key", "", "PEM private key for --tls-cert")
+	serveCmd.Flags().StringSliceVar(&serveACMEDomains, "acme-domain", nil, "Serve HTTPS with Let's Encrypt certificates for this domain (repeatable)")
 }
 
 func runServe(cmd *cobra.Command, args []string) error {
@@ -38,6 +51,52 @@ func runServe(cmd *cobra.Command, args []string) error {
 	if serveListenAddr != "" {
 		cfg.ListenAddr = serveListenAddr
 	}
+	if serveTLSCert != "" {
+		cfg.TLSCert, cfg.TLSKey = serveTLSCert, serveTLSKey
+	}
+	if len(serveACMEDomains) > 0 {
+		cfg.ACMEDomains = serveACMEDomains
+		// An explicit --acme-domain wins over a certificate from the config
+		if serveTLSCert == "" {
+			cfg.TLSCert, cfg.TLSKey = "", ""
+		}
+	}
+	logOutput := io.Writer(os.Stderr)
+	if cfg.LogFile != "" {
+		maxSize, maxBackups, maxAge := cfg.LogRotation()
+		logFile := &logging.RotatingFile{
+			Path:       cfg.LogFile,
+			MaxSize:    maxSize,
+			Daily:      cfg.LogRotateDaily,
+			MaxBackups: maxBackups,
+			MaxAge:     maxAge,
+		}
+		if err := logFile.Open(); err != nil {
+			return err
+		}
+		defer logFile.Close()
+		logOutput = logFile
+	}
+	if err := logging.Setup(cfg.LogLevel, cfg.LogFormat, logOutput); err != nil {
+		return err
+	}
+	shutdownTracing, err := tracing.Setup(context.Background(), "ib-server", cfg.OTLPEndpoint, cfg.TraceSampleRatio)
+	if err != nil {
+		return err
+	}
+	defer shutdownTracing(context.Background())
+
+	dsn := cfg.ErrorReportDSN
+	if secrets.IsRef(dsn) {
+		if dsn, _, err = secrets.Resolve(context.Background(), dsn); err != nil {
+			return fmt.Errorf("failed to resolve error report DSN: %w", err)
+		}
+	}
+	if err := errreport.Setup(dsn, "ib-server"); err != nil {
+		return err
+	}
+	logging.SetErrorHook(errreport.LogRecord)
+	defer errreport.Flush(5 * time.Second)
 
 	// Environment variable for title
 	if v := os.Getenv("IB_TITLE"); v != "" && serveTitle == "ib Backup" {
@@ -51,8 +110,8 @@ func runServe(cmd *cobra.Command, args []string) error {
 	}
 
 	// Validate required config
-	if cfg.S3Bucket == "" {
-		return fmt.Errorf("S3 bucket not configured. Run 'ib-server token show' and edit %s", configPath())
+	if err := cfg.Validate(); err != nil {
+		return fmt.Errorf("invalid config in %s (see 'ib-server config validate'):\n%w", configPath(), err)
 	}
 
 	srv, err := server.New(cfg, serveMetricsPort, serveTitle)
@@ -60,7 +119,11 @@ func runServe(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("failed to create server: %w", err)
 	}
 
-	fmt.Printf("Starting server on %s\n", cfg.ListenAddr)
+	scheme := "HTTP"
+	if cfg.TLSCert != "" || len(cfg.ACMEDomains) > 0 {
+		scheme = "HTTPS"
+	}
+	fmt.Printf("Starting %s server on %s\n", scheme, cfg.ListenAddr)
 	if serveMetricsPort > 0 {
 		fmt.Printf("Prometheus metrics on :%d\n", serveMetricsPort)
 	}
@@ -69,6 +132,10 @@ func runServe(cmd *cobra.Command, args []string) error {
 }
 
 func configPath() string {
-	dir, _ := config.Dir()
-	return dir + "/server.json"
+	path, err := config.ServerPath()
+	if err != nil {
+		dir, _ := config.Dir()
+		return dir
+	}
+	return path
 }
@@ -1,11 +1,18 @@
 package main
 
 import (
+	"context"
 	"crypto/rand"
 	"encoding/hex"
 	"fmt"
+	"os"
+	"time"
 
+	"github.com/johann/ib/internal/auth"
 	"github.com/johann/ib/internal/config"
+	"github.com/johann/ib/internal/secrets"
+	"github.com/johann/ib/internal/server"
+	"github.com/johann/ib/internal/storage"
 	"github.com/spf13/cobra"
 )
 
@@ -17,12 +24,69 @@ var tokenCmd = &cobra.Command{
 var tokenShowCmd = &cobra.Command{
 	Use:   "show",
 	Short: "Show or generate authentication token",
-	Long:  "Show the current authentication token, or generate one if it doesn't exist.",
-	RunE:  runTokenShow,
+	Long: `Show the current authentication token, or generate one if it doesn't exist.
+
+Tokens are stored hashed, so a configured token can only be shown while it
+comes from IB_TOKEN or a config file written by an older version. Saving
+the config replaces such a plaintext token with its hash.`,
+	RunE: runTokenShow,
+}
+
+var tokenRotateCmd = &cobra.Command{
+	Use:   "rotate",
+	Short: "Issue a new token, keeping the old one valid for a grace period",
+	Long: `Generate a new authentication token. The previous token keeps working
+until the grace period ends, and responses to requests using it carry an
+X-IB-Token-Expires header so clients can be migrated gradually.
+
+A running server picks up the new token within 30 seconds.`,
+	RunE: runTokenRotate,
+}
+
+var tokenCreateCmd = &cobra.Command{
+	Use:   "create",
+	Short: "Create a named token for a client",
+	RunE:  runTokenCreate,
 }
 
+var tokenListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List named tokens",
+	RunE:  runTokenList,
+}
+
+var tokenRevokeCmd = &cobra.Command{
+	Use:   "revoke <name>",
+	Short: "Revoke a named token",
+	Args:  cobra.ExactArgs(1),
+	RunE:  runTokenRevoke,
+}
+
+var (
+	tokenRotateGrace      time.Duration
+	tokenCreateName       string
+	tokenCreateScope      string
+	tokenCreateAppendOnly bool
+	tokenCreateSigned     bool
+	tokenCreateExpires    time.Duration
+	tokenCreateNamespace  string
+)
+
 func init() {
+	tokenRotateCmd.Flags().DurationVar(&tokenRotateGrace, "grace", 7*24*time.Hour, "How long the previous token stays valid")
+	tokenCreateCmd.Flags().StringVar(&tokenCreateName, "name", "", "Token name, e.g. the client's hostname")
+	tokenCreateCmd.Flags().StringVar(&tokenCreateScope, "scope", auth.ScopeAdmin, "Token scope: read, write or admin")
+	tokenCreateCmd.Flags().BoolVar(&tokenCreateAppendOnly, "append-only", false, "Forbid deleting manifests and changing tags with this token")
+	tokenCreateCmd.Flags().BoolVar(&tokenCreateSigned, "signed", false, "Only accept HMAC-signed requests from this token ('ib login --sign'), never the raw token")
+	tokenCreateCmd.Flags().DurationVar(&tokenCreateExpires, "expires", 0, "Stop accepting the token after this long, e.g. 2160h (default never)")
+	tokenCreateCmd.Flags().StringVar(&tokenCreateNamespace, "namespace", "", "Limit the token to backups with this name and names below it like <namespace>/db")
+	tokenCreateCmd.MarkFlagRequired("name")
+
 	tokenCmd.AddCommand(tokenShowCmd)
+	tokenCmd.AddCommand(tokenRotateCmd)
+	tokenCmd.AddCommand(tokenCreateCmd)
+	tokenCmd.AddCommand(tokenListCmd)
+	tokenCmd.AddCommand(tokenRevokeCmd)
 }
 
 func runTokenShow(cmd *cobra.Command, args []string) error {
@@ -31,24 +95,181 @@ func runTokenShow(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("failed to load config: %w", err)
 	}
 
-	if cfg.Token == "" {
-		token, err := generateToken()
+	if cfg.Token != "" {
+		token, _, err := secrets.Resolve(context.Background(), cfg.Token)
 		if err != nil {
-			return fmt.Errorf("failed to generate token: %w", err)
+			return err
 		}
-		cfg.Token = token
+		fmt.Println(token)
+		return nil
+	}
+
+	if cfg.TokenHash != "" {
+		return fmt.Errorf("token is stored hashed and cannot be shown; run 'ib-server init' to regenerate it")
+	}
+
+	token, err := generateToken()
+	if err != nil {
+		return fmt.Errorf("failed to generate token: %w", err)
+	}
+	cfg.Token = token
+
+	if err := config.SaveServer(cfg); err != nil {
+		return fmt.Errorf("failed to save config: %w", err)
+	}
 
-		if err := config.SaveServer(cfg); err != nil {
-			return fmt.Errorf("failed to save config: %w", err)
+	fmt.Println("Generated new token (stored hashed, save it now):")
+	fmt.Println(token)
+	return nil
+}
+
+func runTokenRotate(cmd *cobra.Command, args []string) error {
+	if os.Getenv("IB_TOKEN") != "" {
+		return fmt.Errorf("token is set via IB_TOKEN; rotate it in the environment instead")
+	}
+
+	cfg, err := config.LoadServer()
+	if err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+
+	if secrets.IsRef(cfg.Token) || secrets.IsRef(cfg.TokenHash) {
+		return fmt.Errorf("token is read from a secret store; rotate it there instead")
+	}
+
+	// Keep the current token valid through the grace period
+	currentHash := cfg.TokenHash
+	if cfg.Token != "" {
+		currentHash, err = auth.HashToken(cfg.Token)
+		if err != nil {
+			return err
 		}
+	}
+	if currentHash == "" {
+		return fmt.Errorf("no token configured; run 'ib-server token show' to generate one")
+	}
 
-		fmt.Println("Generated new token:")
+	token, err := generateToken()
+	if err != nil {
+		return fmt.Errorf("failed to generate token: %w", err)
 	}
 
-	fmt.Println(cfg.Token)
+	expires := time.Now().Add(tokenRotateGrace)
+	cfg.PreviousTokenHash = currentHash
+	cfg.PreviousTokenExpires = expires.Unix()
+	cfg.Token = token
+	cfg.TokenHash = ""
+
+	if err := config.SaveServer(cfg); err != nil {
+		return fmt.Errorf("failed to save config: %w", err)
+	}
+
+	fmt.Println("New token (stored hashed, save it now):")
+	fmt.Println(token)
+	fmt.Printf("The previous token stays valid until %s\n", expires.Format(time.RFC3339))
 	return nil
 }
 
+func runTokenCreate(cmd *cobra.Command, args []string) error {
+	store, err := openStorage()
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	info, token, err := server.CreateNamedToken(context.Background(), store, &storage.TokenInfo{
+		Name:       tokenCreateName,
+		Scope:      tokenCreateScope,
+		AppendOnly: tokenCreateAppendOnly,
+		Signed:     tokenCreateSigned,
+		Namespace:  tokenCreateNamespace,
+	}, tokenCreateExpires)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Created token %q (scope %s), save it now:\n", info.Name, info.Scope)
+	fmt.Println(token)
+	return nil
+}
+
+func runTokenList(cmd *cobra.Command, args []string) error {
+	store, err := openStorage()
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	tokens, err := store.ListTokens(context.Background())
+	if err != nil {
+		return fmt.Errorf("failed to list tokens: %w", err)
+	}
+	printTokens(tokens)
+	return nil
+}
+
+func printTokens(tokens []storage.TokenInfo) {
+	if len(tokens) == 0 {
+		fmt.Println("No named tokens")
+		return
+	}
+
+	for _, t := range tokens {
+		lastUsed := "never"
+		if !t.LastUsedAt.IsZero() {
+			lastUsed = t.LastUsedAt.Format(time.RFC3339)
+		}
+		fmt.Printf("%s\n", t.Name)
+		fmt.Printf("  Scope: %s\n", t.Scope)
+		if t.Namespace != "" {
+			fmt.Printf("  Namespace: %s\n", t.Namespace)
+		}
+		if t.AppendOnly {
+			fmt.Println("  Append-only: yes")
+		}
+		if t.Signed {
+			fmt.Println("  Signed requests only: yes")
+		}
+		fmt.Printf("  Created: %s\n", t.CreatedAt.Format(time.RFC3339))
+		fmt.Printf("  Last used: %s\n", lastUsed)
+		switch {
+		case t.ExpiresAt.IsZero():
+		case t.Expired(time.Now()):
+			fmt.Printf("  Expired: %s\n", t.ExpiresAt.Format(time.RFC3339))
+		default:
+			fmt.Printf("  Expires: %s\n", t.ExpiresAt.Format(time.RFC3339))
+		}
+		fmt.Println()
+	}
+}
+
+func runTokenRevoke(cmd *cobra.Command, args []string) error {
+	store, err := openStorage()
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	if err := store.DeleteToken(context.Background(), args[0]); err != nil {
+		return err
+	}
+	fmt.Printf("Revoked token %q\n", args[0])
+	return nil
+}
+
+// openStorage opens the server database for token management
+func openStorage() (*storage.Storage, error) {
+	cfg, err := config.LoadServer()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load config: %w", err)
+	}
+	store, err := storage.New(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open storage: %w", err)
+	}
+	return store, nil
+}
+
 func generateToken() (string, error) {
 	b := make([]byte, 32)
 	if _, err := rand.Read(b); err != nil {
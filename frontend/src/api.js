@@ -6,8 +6,13 @@ export async function fetchConfig() {
   return res.json()
 }
 
-export async function fetchManifests() {
-  const res = await fetch(`${API_BASE}/manifests`)
+// Manifests matching tags, newest first; all of them without a limit
+export async function fetchManifests({ tags = {}, sort = 'created_at', order = 'desc', offset = 0, limit = 0 } = {}) {
+  const params = new URLSearchParams({ sort, order })
+  for (const [k, v] of Object.entries(tags)) params.set(`tag.${k}`, v)
+  if (offset) params.set('offset', offset)
+  if (limit) params.set('limit', limit)
+  const res = await fetch(`${API_BASE}/manifests?${params}`)
   if (!res.ok) throw new Error('Failed to fetch manifests')
   return res.json()
 }
@@ -18,6 +23,22 @@ export async function fetchManifest(id) {
   return res.json()
 }
 
+// Manifest without its entries, plus file/dir counts and total size
+export async function fetchManifestSummary(id) {
+  const res = await fetch(`${API_BASE}/manifests/${id}/summary`)
+  if (!res.ok) throw new Error('Failed to fetch manifest')
+  return res.json()
+}
+
+// One page of a directory's children, sorted and filtered by the server
+export async function fetchTree(id, { path = '', sort = 'name', order = 'asc', filter = '', offset = 0, limit = 200 } = {}) {
+  const params = new URLSearchParams({ path, sort, order, offset, limit })
+  if (filter) params.set('filter', filter)
+  const res = await fetch(`${API_BASE}/manifests/${id}/tree?${params}`)
+  if (!res.ok) throw new Error('Failed to fetch directory')
+  return res.json()
+}
+
 export function getDownloadUrl(id, format) {
   return `${API_BASE}/download/${id}.${format}`
 }
@@ -29,3 +50,16 @@ export function getFileDownloadUrl(manifestId, path) {
 export function getFolderDownloadUrl(manifestId, path, format = 'tar.gz') {
   return `${API_BASE}/download/${manifestId}/folder/${path}.${format}`
 }
+
+// Live server events (manifest_created, manifest_deleted, upload, prune),
+// each passed to its handler. Returns a function closing the stream. The
+// stream needs a read token, e.g. added by a reverse proxy; without one
+// the server answers 401 and the browser gives up, leaving the page
+// without live updates.
+export function subscribeEvents(handlers) {
+  const source = new EventSource(`${API_BASE}/events`)
+  for (const [type, handler] of Object.entries(handlers)) {
+    source.addEventListener(type, (e) => handler(JSON.parse(e.data)))
+  }
+  return () => source.close()
+}
@@ -1,113 +1,139 @@
-import { useState, useMemo } from 'preact/hooks'
+import { useState, useEffect } from 'preact/hooks'
 import { formatSize } from '../utils'
-import { getFileDownloadUrl, getFolderDownloadUrl } from '../api'
-
-// Build tree structure from flat entries array
-function buildTree(entries) {
-  const root = { name: '', children: {}, type: 'dir', path: '' }
-
-  for (const entry of entries) {
-    const path = entry.Path || entry.path
-    const type = entry.Type || entry.type
-    const parts = path.split('/')
-    let current = root
-
-    for (let i = 0; i < parts.length; i++) {
-      const part = parts[i]
-      if (!part) continue
-
-      if (!current.children[part]) {
-        current.children[part] = {
-          name: part,
-          children: {},
-          type: i === parts.length - 1 ? type : 'dir',
-          path: parts.slice(0, i + 1).join('/'),
-        }
-      }
-
-      if (i === parts.length - 1) {
-        // Attach full entry data to leaf node
-        current.children[part].entry = entry
-        current.children[part].type = type
-      }
-
-      current = current.children[part]
-    }
+import { fetchTree, getFileDownloadUrl, getFolderDownloadUrl } from '../api'
+
+const PAGE_SIZE = 200
+
+// Children of one directory, loaded from the server a page at a time
+function DirectoryChildren({ manifestId, path, depth, query }) {
+  const [items, setItems] = useState([])
+  const [total, setTotal] = useState(0)
+  const [loading, setLoading] = useState(true)
+  const [error, setError] = useState(null)
+
+  const load = (offset) => {
+    setLoading(true)
+    return fetchTree(manifestId, { path, ...query, offset, limit: PAGE_SIZE })
+      .then((page) => {
+        setItems((prev) => (offset === 0 ? page.entries : prev.concat(page.entries)))
+        setTotal(page.total)
+        setError(null)
+      })
+      .catch((err) => setError(err.message))
+      .finally(() => setLoading(false))
   }
 
-  return root
-}
+  useEffect(() => {
+    load(0)
+  }, [manifestId, path, query.sort, query.order, query.filter])
+
+  const indent = { paddingLeft: `${depth * 12 + 4}px` }
+
+  if (error) {
+    return <div class="tree-item tree-status" style={indent}>Failed to load: {error}</div>
+  }
 
-// Sort children: folders first, then alphabetically
-function sortChildren(children) {
-  return Object.values(children).sort((a, b) => {
-    if (a.type === 'dir' && b.type !== 'dir') return -1
-    if (a.type !== 'dir' && b.type === 'dir') return 1
-    return a.name.localeCompare(b.name)
-  })
+  return (
+    <>
+      {items.map((entry) => (
+        <TreeNode key={entry.path} entry={entry} manifestId={manifestId} depth={depth} query={query} />
+      ))}
+      {loading && <div class="tree-item tree-status" style={indent}>Loading...</div>}
+      {!loading && items.length < total && (
+        <div class="tree-item tree-status" style={indent}>
+          <button class="tree-btn" onClick={() => load(items.length)}>
+            Load more ({(total - items.length).toLocaleString()} remaining)
+          </button>
+        </div>
+      )}
+    </>
+  )
 }
 
-function TreeNode({ node, manifestId, depth = 0 }) {
-  const [expanded, setExpanded] = useState(depth < 2)
-  const hasChildren = Object.keys(node.children).length > 0
-  const isDir = node.type === 'dir'
-  const entry = node.entry
-  const size = entry?.Size || entry?.size || 0
+function TreeNode({ entry, manifestId, depth, query }) {
+  const [expanded, setExpanded] = useState(false)
+  const isDir = entry.type === 'dir'
+  const hasChildren = isDir && entry.children > 0
 
   return (
     <div class="tree-node">
       <div
         class={`tree-item ${isDir ? 'tree-folder' : 'tree-file'}`}
         style={{ paddingLeft: `${depth * 12 + 4}px` }}
-        onClick={() => isDir && hasChildren && setExpanded(!expanded)}
+        onClick={() => hasChildren && setExpanded(!expanded)}
       >
         <span class="tree-icon">
           {isDir ? (hasChildren ? (expanded ? <ChevronDown /> : <ChevronRight />) : <FolderIcon />) : <FileIcon />}
         </span>
-        <span class="tree-name">{node.name}</span>
-        {!isDir && size > 0 && <span class="tree-size">{formatSize(size)}</span>}
+        <span class="tree-name">{entry.name}</span>
+        {isDir && hasChildren && <span class="tree-size">{entry.children.toLocaleString()}</span>}
+        {!isDir && entry.size > 0 && <span class="tree-size">{formatSize(entry.size)}</span>}
         <span class="tree-actions">
-          {isDir && node.path ? (
-            <>
-              <a href={getFolderDownloadUrl(manifestId, node.path, 'tar.gz')} download class="tree-btn" title="Download .tar.gz" onClick={(e) => e.stopPropagation()}>.tar.gz</a>
-              <a href={getFolderDownloadUrl(manifestId, node.path, 'zip')} download class="tree-btn" title="Download .zip" onClick={(e) => e.stopPropagation()}>.zip</a>
-            </>
-          ) : !isDir && node.path ? (
+          {isDir ? (
             <>
-              <a href={getFileDownloadUrl(manifestId, node.path)} download class="tree-btn" title="Download raw" onClick={(e) => e.stopPropagation()}>raw</a>
+              <a href={getFolderDownloadUrl(manifestId, entry.path, 'tar.gz')} download class="tree-btn" title="Download .tar.gz" onClick={(e) => e.stopPropagation()}>.tar.gz</a>
+              <a href={getFolderDownloadUrl(manifestId, entry.path, 'zip')} download class="tree-btn" title="Download .zip" onClick={(e) => e.stopPropagation()}>.zip</a>
             </>
-          ) : null}
+          ) : (
+            <a href={getFileDownloadUrl(manifestId, entry.path)} download class="tree-btn" title="Download raw" onClick={(e) => e.stopPropagation()}>raw</a>
+          )}
         </span>
       </div>
-      {isDir && expanded && hasChildren && (
+      {expanded && (
         <div class="tree-children">
-          {sortChildren(node.children).map((child) => (
-            <TreeNode key={child.path} node={child} manifestId={manifestId} depth={depth + 1} />
-          ))}
+          <DirectoryChildren manifestId={manifestId} path={entry.path} depth={depth + 1} query={query} />
         </div>
       )}
     </div>
   )
 }
 
-export function FileTree({ entries, manifestId }) {
-  const tree = useMemo(() => buildTree(entries), [entries])
-  const children = sortChildren(tree.children)
-
-  if (children.length === 0) {
+// File browser that only loads the directories being looked at, so large
+// backups don't ship every entry to the browser. Sorting and the name
+// filter apply to each open directory and run on the server.
+export function FileTree({ manifestId, count }) {
+  const [sort, setSort] = useState('name')
+  const [order, setOrder] = useState('asc')
+  const [input, setInput] = useState('')
+  const [filter, setFilter] = useState('')
+
+  // Don't query the server on every keystroke
+  useEffect(() => {
+    const timer = setTimeout(() => setFilter(input.trim()), 300)
+    return () => clearTimeout(timer)
+  }, [input])
+
+  if (count === 0) {
     return <div class="file-tree-empty">No files in this backup</div>
   }
 
+  const query = { sort, order, filter }
+
   return (
     <div class="file-tree">
       <div class="file-tree-header">
         <h3>Files</h3>
-        <span class="file-tree-count">{entries.length} items</span>
+        <div class="file-tree-controls">
+          <input
+            type="search"
+            class="file-tree-filter"
+            placeholder="Filter names"
+            value={input}
+            onInput={(e) => setInput(e.target.value)}
+          />
+          <select value={sort} onChange={(e) => setSort(e.target.value)}>
+            <option value="name">Name</option>
+            <option value="size">Size</option>
+            <option value="mtime">Modified</option>
+          </select>
+          <button class="tree-btn" title="Reverse order" onClick={() => setOrder(order === 'asc' ? 'desc' : 'asc')}>
+            {order === 'asc' ? '↑' : '↓'}
+          </button>
+          <span class="file-tree-count">{count.toLocaleString()} items</span>
+        </div>
       </div>
       <div class="file-tree-content">
-        {children.map((child) => (
-          <TreeNode key={child.path} node={child} manifestId={manifestId} depth={0} />
-        ))}
+        <DirectoryChildren manifestId={manifestId} path="" depth={0} query={query} />
       </div>
     </div>
   )
@@ -1,12 +1,16 @@
 import { useState, useEffect } from 'preact/hooks'
 import { Link } from 'preact-router/match'
 import { marked } from 'marked'
-import { fetchManifest, fetchManifests, getDownloadUrl, getFileDownloadUrl } from '../api'
+import { fetchManifestSummary, fetchManifests, fetchTree, getDownloadUrl, getFileDownloadUrl } from '../api'
 import { formatSize, formatRelativeDate } from '../utils'
 import { FileTree } from '../components/FileTree'
 
+// Newest backups with the same name shown on the detail page
+const relatedLimit = 20
+
 export function Detail({ id }) {
   const [manifest, setManifest] = useState(null)
+  const [summary, setSummary] = useState(null)
   const [loading, setLoading] = useState(true)
   const [error, setError] = useState(null)
   const [activeTab, setActiveTab] = useState('http')
@@ -18,48 +22,34 @@ export function Detail({ id }) {
     setNotesHtml(null)
     setRelatedBackups([])
 
-    fetchManifest(id)
+    fetchManifestSummary(id)
       .then((data) => {
-        setManifest(data)
+        setManifest(data.manifest)
+        setSummary(data)
         setLoading(false)
 
-        // Check for notes.md and fetch it
-        const entries = data.Entries || data.entries || []
-        const notesEntry = entries.find((e) => {
-          const path = e.Path || e.path
-          const type = e.Type || e.type
-          return type === 'file' && (path === 'notes.md' || path === 'NOTES.md' || path === 'Notes.md')
-        })
-
-        if (notesEntry) {
-          const manifestId = data.ID || data.id
-          const notesPath = notesEntry.Path || notesEntry.path
-          fetch(getFileDownloadUrl(manifestId, notesPath))
-            .then((res) => res.ok ? res.text() : null)
-            .then((text) => {
-              if (text) {
-                setNotesHtml(marked.parse(text))
-              }
-            })
-            .catch(() => {})
-        }
+        // Check for notes.md in the backup root and fetch it
+        fetchTree(id, { filter: 'notes.md', limit: 10 })
+          .then((page) => {
+            const notesEntry = page.entries.find((e) => e.type === 'file' && ['notes.md', 'NOTES.md', 'Notes.md'].includes(e.path))
+            if (!notesEntry) return null
+            return fetch(getFileDownloadUrl(id, notesEntry.path)).then((res) => (res.ok ? res.text() : null))
+          })
+          .then((text) => {
+            if (text) {
+              setNotesHtml(marked.parse(text))
+            }
+          })
+          .catch(() => {})
 
         // Fetch related backups with the same name
-        const tags = data.Tags || data.tags || {}
+        const tags = data.manifest.tags || {}
         if (tags.name) {
-          fetchManifests()
-            .then((allManifests) => {
-              const related = (allManifests || [])
-                .filter((m) => {
-                  const mTags = m.Tags || m.tags || {}
-                  const mId = m.ID || m.id
-                  return mTags.name === tags.name && mId !== id
-                })
-                .sort((a, b) => {
-                  const dateA = new Date(a.CreatedAt || a.created_at)
-                  const dateB = new Date(b.CreatedAt || b.created_at)
-                  return dateB - dateA
-                })
+          fetchManifests({ tags: { name: tags.name }, limit: relatedLimit + 1 })
+            .then((manifests) => {
+              const related = (manifests || [])
+                .filter((m) => (m.ID || m.id) !== id)
+                .slice(0, relatedLimit)
               setRelatedBackups(related)
             })
             .catch(() => {})
@@ -95,14 +85,10 @@ export function Detail({ id }) {
   const manifestId = manifest.ID || manifest.id
   const date = new Date(manifest.CreatedAt || manifest.created_at)
   const tags = manifest.Tags || manifest.tags || {}
-  const entries = manifest.Entries || manifest.entries || []
   const rootCid = manifest.RootCID || manifest.root_cid || null
   const displayName = tags.name || manifestId
   const displayTags = Object.entries(tags).filter(([k]) => k !== 'name')
 
-  const files = entries.filter((e) => (e.Type || e.type) === 'file')
-  const dirs = entries.filter((e) => (e.Type || e.type) === 'dir')
-  const totalSize = files.reduce((sum, e) => sum + (e.Size || e.size || 0), 0)
 
   const origin = typeof window !== 'undefined' ? window.location.origin : ''
 
@@ -149,15 +135,15 @@ export function Detail({ id }) {
           )}
           <div class="info-item">
             <label>Files</label>
-            <span>{files.length.toLocaleString()}</span>
+            <span>{summary.files.toLocaleString()}</span>
           </div>
           <div class="info-item">
             <label>Directories</label>
-            <span>{dirs.length.toLocaleString()}</span>
+            <span>{summary.dirs.toLocaleString()}</span>
           </div>
           <div class="info-item">
             <label>Total Size</label>
-            <span>{formatSize(totalSize)}</span>
+            <span>{formatSize(summary.size)}</span>
           </div>
         </div>
 
@@ -171,7 +157,7 @@ export function Detail({ id }) {
           </div>
         )}
 
-        <FileTree entries={entries} manifestId={manifestId} />
+        <FileTree manifestId={manifestId} count={summary.files + summary.dirs} />
 
         <div class="download-section">
           <h2>Download</h2>
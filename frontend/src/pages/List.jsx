@@ -1,15 +1,19 @@
 import { useState, useEffect, useMemo } from 'preact/hooks'
 import { Link } from 'preact-router/match'
-import { fetchManifests } from '../api'
-import { formatRelativeDate } from '../utils'
+import { fetchManifests, subscribeEvents } from '../api'
+import { formatRelativeDate, formatSize } from '../utils'
+
+// How long a token shows as uploading after its last upload event
+const uploadIdleMs = 5000
 
 export function List() {
   const [manifests, setManifests] = useState([])
   const [loading, setLoading] = useState(true)
   const [error, setError] = useState(null)
   const [filters, setFilters] = useState({})
+  const [uploads, setUploads] = useState({})
 
-  useEffect(() => {
+  const load = () =>
     fetchManifests()
       .then((data) => {
         setManifests(data || [])
@@ -19,6 +23,32 @@ export function List() {
         setError(err.message)
         setLoading(false)
       })
+
+  useEffect(() => {
+    load()
+  }, [])
+
+  // Live updates instead of polling: new and deleted manifests, uploads
+  // in progress, and a reload after pruning
+  useEffect(() => {
+    const close = subscribeEvents({
+      manifest_created: (m) => setManifests((prev) => [m, ...prev]),
+      manifest_deleted: ({ id }) => setManifests((prev) => prev.filter((m) => (m.ID || m.id) !== id)),
+      upload: (u) => setUploads((prev) => ({ ...prev, [u.token]: { ...u, at: Date.now() } })),
+      prune: () => load(),
+    })
+    const expire = setInterval(() => {
+      setUploads((prev) => {
+        const now = Date.now()
+        const active = Object.values(prev).filter((u) => now - u.at < uploadIdleMs)
+        if (active.length === Object.keys(prev).length) return prev
+        return Object.fromEntries(active.map((u) => [u.token, u]))
+      })
+    }, 1000)
+    return () => {
+      close()
+      clearInterval(expire)
+    }
   }, [])
 
   // Extract unique tag keys and values (excluding 'name')
@@ -92,6 +122,16 @@ export function List() {
     <div class="card">
       <h2>Backups</h2>
 
+      {Object.keys(uploads).length > 0 && (
+        <div class="uploads">
+          {Object.values(uploads).map((u) => (
+            <div key={u.token} class="backup-meta">
+              Uploading from {u.token}: {formatSize(u.bytes)}/s
+            </div>
+          ))}
+        </div>
+      )}
+
       {tagKeys.length > 0 && (
         <div class="filters">
           {tagKeys.map((key) => (
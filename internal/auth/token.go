@@ -0,0 +1,102 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// hashPrefix identifies the token hash format
+const hashPrefix = "sha256"
+
+// HashToken returns a salted hash of the token in the form
+// "sha256:<salt-hex>:<hash-hex>". Tokens are random 256-bit values, so a
+// single salted SHA-256 is enough; no slow KDF is needed.
+func HashToken(token string) (string, error) {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return "", fmt.Errorf("failed to generate salt: %w", err)
+	}
+	sum := hashWithSalt(salt, token)
+	return hashPrefix + ":" + hex.EncodeToString(salt) + ":" + hex.EncodeToString(sum), nil
+}
+
+// VerifyToken reports whether token matches a hash from HashToken.
+// The comparison is constant-time.
+func VerifyToken(token, hash string) bool {
+	parts := strings.Split(hash, ":")
+	if len(parts) != 3 || parts[0] != hashPrefix {
+		return false
+	}
+	salt, err := hex.DecodeString(parts[1])
+	if err != nil {
+		return false
+	}
+	expected, err := hex.DecodeString(parts[2])
+	if err != nil {
+		return false
+	}
+	return subtle.ConstantTimeCompare(hashWithSalt(salt, token), expected) == 1
+}
+
+// Equal compares two plaintext tokens in constant time
+func Equal(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
+
+func hashWithSalt(salt []byte, token string) []byte {
+	h := sha256.New()
+	h.Write(salt)
+	h.Write([]byte(token))
+	return h.Sum(nil)
+}
+
+// Token scopes
+const (
+	ScopeRead  = "read"  // List, download and restore
+	ScopeWrite = "write" // Upload blocks and manifests
+	ScopeAdmin = "admin" // Everything, including deletion and maintenance
+)
+
+// ValidScope reports whether s is a known token scope
+func ValidScope(s string) bool {
+	switch s {
+	case ScopeRead, ScopeWrite, ScopeAdmin:
+		return true
+	}
+	return false
+}
+
+// namedTokenPrefix marks tokens issued by GenerateNamedToken
+const namedTokenPrefix = "ib_"
+
+// GenerateNamedToken creates a token of the form ib_<id>_<secret>. The ID
+// is stored in clear so the token record can be looked up directly.
+func GenerateNamedToken() (id, token string, err error) {
+	idBytes := make([]byte, 8)
+	secret := make([]byte, 32)
+	if _, err := rand.Read(idBytes); err != nil {
+		return "", "", err
+	}
+	if _, err := rand.Read(secret); err != nil {
+		return "", "", err
+	}
+	id = hex.EncodeToString(idBytes)
+	return id, namedTokenPrefix + id + "_" + hex.EncodeToString(secret), nil
+}
+
+// ParseNamedToken extracts the ID from a token made by GenerateNamedToken
+func ParseNamedToken(token string) (id string, ok bool) {
+	rest, found := strings.CutPrefix(token, namedTokenPrefix)
+	if !found {
+		return "", false
+	}
+	id, _, found = strings.Cut(rest, "_")
+	if !found || id == "" {
+		return "", false
+	}
+	return id, true
+}
@@ -0,0 +1,93 @@
+package backup
+
+import "context"
+
+// BlockBatchChecker is implemented by uploaders that can check many blocks
+// in one request
+type BlockBatchChecker interface {
+	MissingBlocks(ctx context.Context, cids []string) ([]string, error)
+}
+
+// maxExistsBatch is the most blocks checked in one request
+const maxExistsBatch = 1000
+
+// existsBatcher coalesces the block checks of concurrent upload workers:
+// checks that come in while a batch is in flight go out together in the
+// next one, so a high-latency link costs one round trip per batch instead
+// of one per block
+type existsBatcher struct {
+	checker  BlockBatchChecker
+	requests chan existsRequest
+}
+
+type existsRequest struct {
+	cid    string
+	result chan existsResult
+}
+
+type existsResult struct {
+	exists bool
+	err    error
+}
+
+// newExistsBatcher starts a batcher that runs until ctx is done
+func newExistsBatcher(ctx context.Context, checker BlockBatchChecker) *existsBatcher {
+	b := &existsBatcher{checker: checker, requests: make(chan existsRequest)}
+	go b.run(ctx)
+	return b
+}
+
+func (b *existsBatcher) run(ctx context.Context) {
+	for {
+		var batch []existsRequest
+		select {
+		case <-ctx.Done():
+			return
+		case req := <-b.requests:
+			batch = append(batch, req)
+		}
+		// Take everything else that is waiting
+	drain:
+		for len(batch) < maxExistsBatch {
+			select {
+			case req := <-b.requests:
+				batch = append(batch, req)
+			default:
+				break drain
+			}
+		}
+
+		cids := make([]string, 0, len(batch))
+		seen := make(map[string]bool, len(batch))
+		for _, req := range batch {
+			if !seen[req.cid] {
+				seen[req.cid] = true
+				cids = append(cids, req.cid)
+			}
+		}
+		missing, err := b.checker.MissingBlocks(ctx, cids)
+		isMissing := make(map[string]bool, len(missing))
+		for _, cid := range missing {
+			isMissing[cid] = true
+		}
+		for _, req := range batch {
+			req.result <- existsResult{exists: err == nil && !isMissing[req.cid], err: err}
+		}
+	}
+}
+
+// exists checks whether the server has block cid, in the next batch
+func (b *existsBatcher) exists(ctx context.Context, cid string) (bool, error) {
+	req := existsRequest{cid: cid, result: make(chan existsResult, 1)}
+	select {
+	case <-ctx.Done():
+		return false, ctx.Err()
+	case b.requests <- req:
+	}
+	select {
+	case <-ctx.Done():
+		return false, ctx.Err()
+	case res := <-req.result:
+		return res.exists, res.err
+	}
+}
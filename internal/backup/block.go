@@ -0,0 +1,155 @@
+package backup
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+)
+
+// Blocks written with a header describe themselves, so readers don't have
+// to guess from sizes whether and how a block is compressed, and can tell
+// a damaged block from a wrong codec. The header is
+//
+//	"IBLK" | version (1) | codec (1) | original size (4) | CRC-32C of the original data (4)
+//
+// with sizes in bytes and integers big endian, followed by the data as the
+// codec left it. Blocks stored before headers are told apart by their
+// recorded codec, not by the magic, since raw data may start with anything.
+const (
+	BlockHeaderSize = 14
+
+	blockMagic   = "IBLK"
+	blockVersion = 1
+)
+
+// CompressionHeader is the codec recorded for blocks that start with a
+// header. The actual codec is in the header.
+const CompressionHeader = "header"
+
+// BlockMediaType is the Accept header of clients that decode blocks with a
+// header themselves; others get them decoded by the server
+const BlockMediaType = "application/vnd.ib.block"
+
+// Codecs in block headers, and in the first byte of encrypted payloads,
+// which carry their codec and size inside the ciphertext instead
+const (
+	blockRaw  byte = 0
+	blockLZ4  byte = 1
+	blockZstd byte = 2
+)
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// BlockHeader is the parsed header of a block
+type BlockHeader struct {
+	Codec        string // Empty for raw data
+	OriginalSize int64
+	Checksum     uint32 // CRC-32C of the original data
+}
+
+// PutBlockHeader writes the header of a block holding original, compressed
+// with codec (empty for raw), into the first BlockHeaderSize bytes of dst
+func PutBlockHeader(dst []byte, codec string, original []byte) {
+	copy(dst, blockMagic)
+	dst[4] = blockVersion
+	switch codec {
+	case CompressionLZ4:
+		dst[5] = blockLZ4
+	case CompressionZstd:
+		dst[5] = blockZstd
+	default:
+		dst[5] = blockRaw
+	}
+	binary.BigEndian.PutUint32(dst[6:10], uint32(len(original)))
+	binary.BigEndian.PutUint32(dst[10:14], crc32.Checksum(original, castagnoli))
+}
+
+// HasBlockHeader reports whether data starts like a block with a header
+func HasBlockHeader(data []byte) bool {
+	return len(data) >= BlockHeaderSize && bytes.HasPrefix(data, []byte(blockMagic))
+}
+
+// ParseBlockHeader parses the header of a block and returns it with the
+// data that follows
+func ParseBlockHeader(data []byte) (BlockHeader, []byte, error) {
+	if !HasBlockHeader(data) {
+		return BlockHeader{}, nil, fmt.Errorf("block has no header")
+	}
+	if data[4] != blockVersion {
+		return BlockHeader{}, nil, fmt.Errorf("unsupported block version %d", data[4])
+	}
+
+	var h BlockHeader
+	switch data[5] {
+	case blockRaw:
+	case blockLZ4:
+		h.Codec = CompressionLZ4
+	case blockZstd:
+		h.Codec = CompressionZstd
+	default:
+		return BlockHeader{}, nil, fmt.Errorf("unknown block codec %d", data[5])
+	}
+	h.OriginalSize = int64(binary.BigEndian.Uint32(data[6:10]))
+	h.Checksum = binary.BigEndian.Uint32(data[10:14])
+	if h.OriginalSize > MaxChunkSize {
+		return BlockHeader{}, nil, fmt.Errorf("block original size %d exceeds %d", h.OriginalSize, MaxChunkSize)
+	}
+	return h, data[BlockHeaderSize:], nil
+}
+
+// DecodeBlock returns the original data of a block with a header,
+// checking it against the header's checksum
+func DecodeBlock(data []byte) ([]byte, error) {
+	h, payload, err := ParseBlockHeader(data)
+	if err != nil {
+		return nil, err
+	}
+	if h.Codec == "" {
+		if err := h.verify(payload); err != nil {
+			return nil, err
+		}
+		return payload, nil
+	}
+	dst := make([]byte, h.OriginalSize)
+	n, err := DecodeBlockInto(data, dst)
+	if err != nil {
+		return nil, err
+	}
+	return dst[:n], nil
+}
+
+// DecodeBlockInto decodes a block with a header into dst, which must hold
+// the original size, and returns the decoded length
+func DecodeBlockInto(data, dst []byte) (int, error) {
+	h, payload, err := ParseBlockHeader(data)
+	if err != nil {
+		return 0, err
+	}
+	if int64(len(dst)) < h.OriginalSize {
+		return 0, fmt.Errorf("buffer of %d bytes too small for a block of %d", len(dst), h.OriginalSize)
+	}
+
+	if h.Codec == "" {
+		if err := h.verify(payload); err != nil {
+			return 0, err
+		}
+		return copy(dst, payload), nil
+	}
+	n, err := DecompressBlockInto(h.Codec, payload, dst)
+	if err != nil {
+		return 0, err
+	}
+	return n, h.verify(dst[:n])
+}
+
+// verify checks decoded data against the header
+func (h BlockHeader) verify(data []byte) error {
+	if int64(len(data)) != h.OriginalSize {
+		return fmt.Errorf("block decoded to %d bytes, header says %d", len(data), h.OriginalSize)
+	}
+	if crc32.Checksum(data, castagnoli) != h.Checksum {
+		return fmt.Errorf("block checksum mismatch")
+	}
+	return nil
+}
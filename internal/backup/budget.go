@@ -0,0 +1,52 @@
+package backup
+
+import (
+	"context"
+
+	"golang.org/x/sync/semaphore"
+)
+
+// MemoryBudget bounds the bytes of chunk data held in memory at once. The
+// chunker, the uploads and the restorer share one budget, so memory use no
+// longer grows with concurrency times chunk size. A nil budget is
+// unlimited.
+type MemoryBudget struct {
+	sem  *semaphore.Weighted
+	size int64
+}
+
+// NewMemoryBudget returns a budget of size bytes, or nil (unlimited) if
+// size is not positive
+func NewMemoryBudget(size int64) *MemoryBudget {
+	if size <= 0 {
+		return nil
+	}
+	return &MemoryBudget{sem: semaphore.NewWeighted(size), size: size}
+}
+
+// Acquire blocks until n bytes are available. Requests larger than the
+// whole budget wait for all of it, so a single large chunk still proceeds.
+func (b *MemoryBudget) Acquire(ctx context.Context, n int64) error {
+	if b == nil || n <= 0 {
+		return nil
+	}
+	return b.sem.Acquire(ctx, min(n, b.size))
+}
+
+// Release returns n bytes taken with Acquire. Requests taken in one
+// Acquire and returned in parts must be split from grant(n), not n, or
+// more is returned than was taken.
+func (b *MemoryBudget) Release(n int64) {
+	if b == nil || n <= 0 {
+		return
+	}
+	b.sem.Release(min(n, b.size))
+}
+
+// grant returns how much of the budget Acquire(n) takes
+func (b *MemoryBudget) grant(n int64) int64 {
+	if b == nil {
+		return n
+	}
+	return min(n, b.size)
+}
@@ -0,0 +1,59 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestChunkerBudgetSmallerThanChunk(t *testing.T) {
+	const chunkSize = 1 << 20
+
+	// Random data doesn't compress, repeated data does: both ways of
+	// splitting a chunk's budget are covered
+	random := make([]byte, 3*chunkSize)
+	if _, err := rand.Read(random); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string][]byte{
+		"random":     random,
+		"repetitive": bytes.Repeat([]byte("ib"), 3*chunkSize/2),
+	}
+
+	for name, data := range files {
+		for _, header := range []bool{false, true} {
+			path := filepath.Join(t.TempDir(), name)
+			if err := os.WriteFile(path, data, 0o600); err != nil {
+				t.Fatal(err)
+			}
+
+			budget := NewMemoryBudget(chunkSize / 2)
+			chunker := NewChunkerWithSize(chunkSize)
+			chunker.SetBudget(budget)
+			chunker.SetBlockHeader(header)
+
+			chunks := 0
+			for chunk := range chunker.ChunkFile(context.Background(), path) {
+				if chunk.Error != nil {
+					t.Fatalf("%s (header %v): %v", name, header, chunk.Error)
+				}
+				chunker.Release(chunk)
+				chunks++
+			}
+			if chunks != 3 {
+				t.Errorf("%s (header %v): got %d chunks, want 3", name, header, chunks)
+			}
+
+			// Everything was returned: the whole budget can be taken again
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			if err := budget.Acquire(ctx, chunkSize/2); err != nil {
+				t.Errorf("%s (header %v): budget not fully released: %v", name, header, err)
+			}
+			cancel()
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package backup
+
+import "fmt"
+
+// ChangeDetection is how incremental backups decide that a file is
+// unchanged since the previous backup and reuse its blocks without
+// reading it
+type ChangeDetection string
+
+const (
+	// ChangeMtime compares modification time and size. Edits that keep
+	// both, or tools that restore the modification time, go unnoticed.
+	ChangeMtime ChangeDetection = "mtime"
+
+	// ChangeCtime also compares the inode change time, which every write
+	// and every change of the modification time updates
+	ChangeCtime ChangeDetection = "ctime"
+
+	// ChangeInode also compares the inode number, catching files replaced
+	// by another one with the same time and size
+	ChangeInode ChangeDetection = "inode"
+
+	// ChangeChecksum reads every file and compares its blocks. Blocks of
+	// the previous version are reused without asking the server, as with
+	// SetDelta, so unchanged files cost reading and hashing only.
+	ChangeChecksum ChangeDetection = "checksum"
+)
+
+// ParseChangeDetection parses a change detection mode, checking that this
+// platform records what it compares
+func ParseChangeDetection(mode string) (ChangeDetection, error) {
+	switch m := ChangeDetection(mode); m {
+	case ChangeMtime, ChangeChecksum:
+		return m, nil
+	case ChangeCtime:
+		if !haveChangeTime {
+			return "", fmt.Errorf("change detection %q isn't supported on this platform", mode)
+		}
+		return m, nil
+	case ChangeInode:
+		if !haveInode {
+			return "", fmt.Errorf("change detection %q isn't supported on this platform", mode)
+		}
+		return m, nil
+	default:
+		return "", fmt.Errorf("unknown change detection %q (expected mtime, ctime, inode or checksum)", mode)
+	}
+}
+
+// unchanged reports whether the file cur is the same as prev from the
+// previous backup. Fields prev doesn't have, because it was backed up with
+// another mode, count as changed.
+func (m ChangeDetection) unchanged(prev, cur *Entry) bool {
+	if prev.Mtime != cur.Mtime || prev.Size != cur.Size {
+		return false
+	}
+	switch m {
+	case ChangeCtime:
+		return prev.Ctime != 0 && prev.Ctime == cur.Ctime
+	case ChangeInode:
+		return prev.Inode != 0 && prev.Inode == cur.Inode
+	case ChangeChecksum:
+		return false
+	default:
+		return true
+	}
+}
@@ -0,0 +1,197 @@
+package backup
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+
+	"github.com/johann/ib/internal/encryption"
+)
+
+// checkpoint records the progress of a backup in a file, one JSON record per
+// line, so an interrupted backup can be resumed: the first line is the
+// manifest without entries, the others are the blocks the server has and
+// the files that are done. Records are appended as they happen, so a crash
+// loses at most the last, partly written, line.
+type checkpoint struct {
+	mu     sync.Mutex
+	file   *os.File
+	failed bool // Writing failed, stop trying
+}
+
+type checkpointHeader struct {
+	Manifest *Manifest `json:"manifest"` // Without entries
+	Key      string    `json:"key,omitempty"`
+}
+
+type checkpointRecord struct {
+	Block string `json:"block,omitempty"` // Block on the server
+	Entry *Entry `json:"entry,omitempty"` // File uploaded completely
+}
+
+// resumeState is what an interrupted backup already did
+type resumeState struct {
+	entries map[string]*Entry
+	blocks  map[string]bool
+}
+
+// keyFingerprint identifies an encryption key without revealing it, as
+// blocks sealed with one key are useless in a backup with another
+func keyFingerprint(key *encryption.Key) string {
+	if key == nil {
+		return ""
+	}
+	sum := sha256.Sum256(key.SealConvergent([]byte("ib checkpoint")))
+	return hex.EncodeToString(sum[:8])
+}
+
+// loadCheckpoint reads the checkpoint at path and checks that it belongs to
+// manifest, returning the interrupted manifest and what it got done. It
+// returns nil if there is no checkpoint.
+func loadCheckpoint(path string, manifest *Manifest, key *encryption.Key) (*Manifest, *resumeState, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+
+	dec := json.NewDecoder(f)
+	var header checkpointHeader
+	if err := dec.Decode(&header); err != nil || header.Manifest == nil {
+		return nil, nil, fmt.Errorf("invalid checkpoint %s", path)
+	}
+	prev := header.Manifest
+	if prev.RootPath != manifest.RootPath || !maps.Equal(prev.Tags, manifest.Tags) ||
+		!prev.SameChunking(manifest) || header.Key != keyFingerprint(key) {
+		return nil, nil, fmt.Errorf("the checkpoint is of a backup with another path, tags, chunking or key, run without --resume to start over")
+	}
+
+	state := &resumeState{entries: make(map[string]*Entry), blocks: make(map[string]bool)}
+	for {
+		var rec checkpointRecord
+		if err := dec.Decode(&rec); err != nil {
+			if !errors.Is(err, io.EOF) {
+				logger.Warn("ignoring the rest of the checkpoint", "path", path, "error", err)
+			}
+			break
+		}
+		if rec.Block != "" {
+			state.blocks[rec.Block] = true
+		}
+		if rec.Entry != nil {
+			state.entries[rec.Entry.Path] = rec.Entry
+			for _, b := range rec.Entry.Blocks {
+				state.blocks[b] = true
+			}
+		}
+	}
+	return prev, state, nil
+}
+
+// verify drops the blocks the server no longer has from the state, and the
+// files with any of them, so they are uploaded again. Until a manifest
+// refers to them, blocks of an interrupted backup can be collected as
+// orphans by a prune on the server. It returns the number of blocks
+// dropped.
+func (s *resumeState) verify(ctx context.Context, uploader BlockUploader) (int, error) {
+	cids := slices.Collect(maps.Keys(s.blocks))
+	var missing []string
+	if checker, ok := uploader.(BlockBatchChecker); ok {
+		for batch := range slices.Chunk(cids, maxExistsBatch) {
+			m, err := checker.MissingBlocks(ctx, batch)
+			if err != nil {
+				return 0, err
+			}
+			missing = append(missing, m...)
+		}
+	} else {
+		for _, cid := range cids {
+			exists, err := uploader.BlockExists(ctx, cid)
+			if err != nil {
+				return 0, err
+			}
+			if !exists {
+				missing = append(missing, cid)
+			}
+		}
+	}
+
+	for _, cid := range missing {
+		delete(s.blocks, cid)
+	}
+	if len(missing) > 0 {
+		for path, e := range s.entries {
+			if slices.ContainsFunc(e.Blocks, func(cid string) bool { return !s.blocks[cid] }) {
+				delete(s.entries, path)
+			}
+		}
+	}
+	return len(missing), nil
+}
+
+// createCheckpoint starts the checkpoint at path for manifest, replacing
+// any previous one. With resumed set it appends to the checkpoint instead,
+// which must be of manifest.
+func createCheckpoint(path string, manifest *Manifest, key *encryption.Key, resumed bool) (*checkpoint, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	if resumed {
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0600)
+		if err != nil {
+			return nil, err
+		}
+		return &checkpoint{file: f}, nil
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return nil, err
+	}
+	header := *manifest
+	header.Entries = nil
+	if err := json.NewEncoder(f).Encode(checkpointHeader{Manifest: &header, Key: keyFingerprint(key)}); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &checkpoint{file: f}, nil
+}
+
+// record appends rec. The backup doesn't depend on its checkpoint, so
+// failures are only logged, once.
+func (cp *checkpoint) record(rec checkpointRecord) {
+	if cp == nil {
+		return
+	}
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return
+	}
+	cp.mu.Lock()
+	defer cp.mu.Unlock()
+	if cp.failed {
+		return
+	}
+	if _, err := cp.file.Write(append(data, '\n')); err != nil {
+		logger.Warn("could not write checkpoint, the backup can't be resumed", "error", err)
+		cp.failed = true
+	}
+}
+
+func (cp *checkpoint) close() {
+	if cp != nil {
+		cp.file.Close()
+	}
+}
@@ -1,6 +1,7 @@
 package backup
 
 import (
+	"context"
 	"io"
 	"os"
 
@@ -11,6 +12,16 @@ import (
 const (
 	// ChunkSize is the maximum size of a chunk (8MB)
 	ChunkSize = 8 * 1024 * 1024
+
+	// KuboChunkSize is kubo's default chunk size (size-262144)
+	KuboChunkSize = 256 * 1024
+
+	// Limits for custom chunk sizes
+	MinChunkSize = 64 * 1024
+	MaxChunkSize = 32 * 1024 * 1024
+
+	// Smaller files are chunked by one reader, see SetReaders
+	parallelMinChunks = 4
 )
 
 // ChunkResult represents a processed chunk
@@ -18,19 +29,88 @@ type ChunkResult struct {
 	CID          string
 	Data         []byte // Compressed data
 	OriginalSize int64
+	Compression  string // Codec of Data, empty if stored raw, CompressionHeader if it has a header
 	Error        error
+	held         int64 // Bytes of the memory budget held for Data
 }
 
-// Chunker splits files into content-addressed chunks
-type Chunker struct{}
+// Chunker splits files into content-addressed chunks, either of a fixed
+// size or at content-defined boundaries (see NewCDCChunker)
+type Chunker struct {
+	chunkSize  int      // Maximum chunk size
+	cdc        *fastCDC // Nil for fixed-size chunks
+	compressor *compressor
+	budget     *MemoryBudget
+	readers    int  // See SetReaders
+	header     bool // See SetBlockHeader
+}
 
 // NewChunker creates a new chunker
 func NewChunker() *Chunker {
-	return &Chunker{}
+	return NewChunkerWithSize(ChunkSize)
+}
+
+// NewChunkerWithSize creates a new chunker with a custom chunk size
+func NewChunkerWithSize(chunkSize int) *Chunker {
+	return &Chunker{chunkSize: chunkSize, compressor: &compressor{codec: CompressionLZ4}}
+}
+
+// NewCDCChunker creates a chunker that cuts chunks of minSize to maxSize
+// bytes, averaging avgSize, at content-defined boundaries, so data shifted
+// by an insert keeps its chunks. Check the sizes with ValidateCDCSizes.
+func NewCDCChunker(minSize, avgSize, maxSize int) *Chunker {
+	return &Chunker{
+		chunkSize:  maxSize,
+		cdc:        newFastCDC(minSize, avgSize, maxSize),
+		compressor: &compressor{codec: CompressionLZ4},
+	}
+}
+
+// SetCompression changes the codec chunks are compressed with from LZ4.
+// level is the zstd level, check both with ValidateCompression.
+func (c *Chunker) SetCompression(codec string, level int) error {
+	comp, err := newCompressor(codec, level)
+	if err != nil {
+		return err
+	}
+	c.compressor = comp
+	return nil
+}
+
+// ChunkSize returns the chunk size used by the chunker, the maximum for
+// content-defined chunks
+func (c *Chunker) ChunkSize() int {
+	return c.chunkSize
 }
 
-// ChunkFile splits a file into chunks and returns them via channel
-func (c *Chunker) ChunkFile(path string) <-chan ChunkResult {
+// SetBudget makes ChunkFile wait for room in the budget before reading
+// each chunk. Consumers return it with Release once done with a chunk.
+func (c *Chunker) SetBudget(budget *MemoryBudget) {
+	c.budget = budget
+}
+
+// SetReaders makes ChunkFile read files of at least parallelMinChunks
+// fixed-size chunks with n readers at once, so hashing and compressing a
+// large file uses more than one core. Chunks still come out in order.
+func (c *Chunker) SetReaders(n int) {
+	c.readers = n
+}
+
+// SetBlockHeader makes the chunker write blocks with a header (see
+// PutBlockHeader) recording their codec, size and checksum. Only servers
+// announcing version.BlockHeader accept them.
+func (c *Chunker) SetBlockHeader(on bool) {
+	c.header = on
+}
+
+// Release returns the memory budget held for a chunk from ChunkFile
+func (c *Chunker) Release(chunk ChunkResult) {
+	c.budget.Release(chunk.held)
+}
+
+// ChunkFile splits a file into chunks and returns them via channel.
+// Cancelling ctx stops it while it waits for the memory budget.
+func (c *Chunker) ChunkFile(ctx context.Context, path string) <-chan ChunkResult {
 	results := make(chan ChunkResult, 4)
 
 	go func() {
@@ -43,50 +123,55 @@ func (c *Chunker) ChunkFile(path string) <-chan ChunkResult {
 		}
 		defer file.Close()
 
-		buffer := make([]byte, ChunkSize)
-
-		for {
-			n, err := io.ReadFull(file, buffer)
-			if err == io.EOF {
-				break
-			}
-			if err != nil && err != io.ErrUnexpectedEOF {
-				results <- ChunkResult{Error: err}
+		if c.cdc == nil && c.readers > 1 {
+			if info, err := file.Stat(); err == nil && info.Size() >= parallelMinChunks*int64(c.chunkSize) {
+				c.chunkFileParallel(ctx, file, info.Size(), results)
 				return
 			}
+		}
 
-			chunk := buffer[:n]
+		// Room for the chunk and its compressed copy
+		need := c.chunkNeed()
 
-			// Generate CID from original data
-			chunkCID, err := cid.Generate(chunk)
-			if err != nil {
+		// Data read past the last content-defined boundary
+		var carry []byte
+		for {
+			if err := c.budget.Acquire(ctx, need); err != nil {
 				results <- ChunkResult{Error: err}
 				return
 			}
 
-			// Compress the chunk
-			compressed := make([]byte, lz4.CompressBlockBound(n))
-			compressedSize, err := lz4.CompressBlock(chunk, compressed, nil)
-			if err != nil {
+			// A new buffer per chunk: the previous one may still be uploading
+			buffer := make([]byte, c.chunkSize)
+			k := copy(buffer, carry)
+			n, err := io.ReadFull(file, buffer[k:])
+			n += k
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			if n == 0 {
+				c.budget.Release(need)
+				break
+			}
+			if err != nil && err != io.ErrUnexpectedEOF {
+				c.budget.Release(need)
 				results <- ChunkResult{Error: err}
 				return
 			}
 
-			// If compression didn't help, store uncompressed
-			var data []byte
-			if compressedSize > 0 && compressedSize < n {
-				data = compressed[:compressedSize]
-			} else {
-				data = chunk
+			cut := n
+			if c.cdc != nil {
+				cut = c.cdc.cut(buffer[:n])
 			}
+			carry = buffer[cut:n]
 
-			results <- ChunkResult{
-				CID:          chunkCID,
-				Data:         data,
-				OriginalSize: int64(n),
+			result := c.process(buffer[:cut], need)
+			results <- result
+			if result.Error != nil {
+				return
 			}
 
-			if err == io.ErrUnexpectedEOF {
+			if err == io.ErrUnexpectedEOF && len(carry) == 0 {
 				break
 			}
 		}
@@ -95,41 +180,116 @@ func (c *Chunker) ChunkFile(path string) <-chan ChunkResult {
 	return results
 }
 
+// chunkNeed is the memory budget a chunk is read with: room for the chunk
+// and its compressed copy, with its header, or the whole budget if that is
+// smaller
+func (c *Chunker) chunkNeed() int64 {
+	return c.budget.grant(int64(c.chunkSize + BlockHeaderSize + lz4.CompressBlockBound(c.chunkSize)))
+}
+
+// process hashes and compresses chunk, read with need bytes of the memory
+// budget, and keeps only what the result holds of them. On errors it
+// releases all of them.
+func (c *Chunker) process(chunk []byte, need int64) ChunkResult {
+	chunkCID, err := cid.Generate(chunk)
+	if err != nil {
+		c.budget.Release(need)
+		return ChunkResult{Error: err}
+	}
+
+	compressed, compressedSize, err := c.compress(chunk)
+	if err != nil {
+		c.budget.Release(need)
+		return ChunkResult{Error: err}
+	}
+
+	// If compression didn't help, store uncompressed. Only the buffer that
+	// is kept stays in the budget.
+	result := ChunkResult{CID: chunkCID, OriginalSize: int64(len(chunk))}
+	switch {
+	case compressedSize > 0 && compressedSize < len(chunk):
+		result.Data = compressed[:c.headerSize()+compressedSize]
+		result.held = need - int64(c.chunkSize)
+		result.Compression = c.compressor.codec
+	case c.header:
+		// Behind the header, in the buffer that already has room for it
+		result.Data = compressed[:BlockHeaderSize+copy(compressed[BlockHeaderSize:], chunk)]
+		result.held = need - int64(c.chunkSize)
+	default:
+		result.Data = chunk
+		result.held = int64(c.chunkSize)
+	}
+	// With a budget smaller than a chunk the result keeps all of it
+	result.held = max(0, min(result.held, need))
+	c.finish(&result, chunk)
+	c.budget.Release(need - result.held)
+	return result
+}
+
+// compress compresses chunk behind room for its header, if the chunker
+// writes them, and returns the buffer and the compressed length
+func (c *Chunker) compress(chunk []byte) ([]byte, int, error) {
+	offset := c.headerSize()
+	compressed := make([]byte, offset+lz4.CompressBlockBound(len(chunk)))
+	n, err := c.compressor.compress(chunk, compressed[offset:])
+	return compressed, n, err
+}
+
+func (c *Chunker) headerSize() int {
+	if c.header {
+		return BlockHeaderSize
+	}
+	return 0
+}
+
+// finish writes the header of result, whose Data has room for it, if the
+// chunker writes them
+func (c *Chunker) finish(result *ChunkResult, chunk []byte) {
+	if !c.header {
+		return
+	}
+	PutBlockHeader(result.Data, result.Compression, chunk)
+	result.Compression = CompressionHeader
+}
+
 // ChunkData splits data into chunks (for small files or in-memory data)
 func (c *Chunker) ChunkData(data []byte) ([]ChunkResult, error) {
 	var results []ChunkResult
 
-	for offset := 0; offset < len(data); offset += ChunkSize {
-		end := offset + ChunkSize
+	for offset := 0; offset < len(data); {
+		end := offset + c.chunkSize
 		if end > len(data) {
 			end = len(data)
 		}
+		if c.cdc != nil {
+			end = offset + c.cdc.cut(data[offset:end])
+		}
 
 		chunk := data[offset:end]
+		offset = end
 
 		chunkCID, err := cid.Generate(chunk)
 		if err != nil {
 			return nil, err
 		}
 
-		compressed := make([]byte, lz4.CompressBlockBound(len(chunk)))
-		compressedSize, err := lz4.CompressBlock(chunk, compressed, nil)
+		compressed, compressedSize, err := c.compress(chunk)
 		if err != nil {
 			return nil, err
 		}
 
-		var resultData []byte
-		if compressedSize > 0 && compressedSize < len(chunk) {
-			resultData = compressed[:compressedSize]
-		} else {
-			resultData = chunk
+		result := ChunkResult{CID: chunkCID, OriginalSize: int64(len(chunk))}
+		switch {
+		case compressedSize > 0 && compressedSize < len(chunk):
+			result.Data = compressed[:c.headerSize()+compressedSize]
+			result.Compression = c.compressor.codec
+		case c.header:
+			result.Data = compressed[:BlockHeaderSize+copy(compressed[BlockHeaderSize:], chunk)]
+		default:
+			result.Data = chunk
 		}
-
-		results = append(results, ChunkResult{
-			CID:          chunkCID,
-			Data:         resultData,
-			OriginalSize: int64(len(chunk)),
-		})
+		c.finish(&result, chunk)
+		results = append(results, result)
 	}
 
 	return results, nil
@@ -149,6 +309,12 @@ func Decompress(compressed []byte, originalSize int64) ([]byte, error) {
 	return decompressed[:n], nil
 }
 
+// DecompressInto decompresses LZ4 compressed data into dst, which must
+// hold the original size, and returns the decompressed length
+func DecompressInto(compressed, dst []byte) (int, error) {
+	return lz4.UncompressBlock(compressed, dst)
+}
+
 // CompressBlock compresses data using LZ4
 func CompressBlock(src, dst []byte) (int, error) {
 	return lz4.CompressBlock(src, dst, nil)
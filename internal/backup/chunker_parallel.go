@@ -0,0 +1,69 @@
+package backup
+
+import (
+	"context"
+	"io"
+	"os"
+)
+
+// chunkFileParallel splits a file of size bytes into fixed-size chunks
+// with c.readers chunks read, hashed and compressed at once, and sends them
+// to results in file order. The memory budget is taken in file order too,
+// so the chunk to send next never waits for budget held by later ones.
+func (c *Chunker) chunkFileParallel(ctx context.Context, file *os.File, size int64, results chan<- ChunkResult) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	need := c.chunkNeed()
+
+	// Chunks being read, in file order. With the one waited for below,
+	// c.readers are in flight.
+	pending := make(chan chan ChunkResult, c.readers-1)
+	go func() {
+		defer close(pending)
+		for offset := int64(0); offset < size; offset += int64(c.chunkSize) {
+			done := make(chan ChunkResult, 1)
+			if err := c.budget.Acquire(ctx, need); err != nil {
+				done <- ChunkResult{Error: err}
+				pending <- done
+				return
+			}
+			pending <- done
+			go func() {
+				done <- c.readChunkAt(file, offset, min(int64(c.chunkSize), size-offset), need)
+			}()
+		}
+	}()
+
+	// Every chunk is waited for, so none is read after the file is closed
+	failed := false
+	for done := range pending {
+		result := <-done
+		switch {
+		case failed:
+			c.Release(result)
+		case result.Error != nil:
+			failed = true
+			cancel()
+			results <- result
+		case result.CID == "":
+			// Past the end of a file that shrank
+		default:
+			results <- result
+		}
+	}
+}
+
+// readChunkAt reads and processes the chunk of length bytes at offset
+func (c *Chunker) readChunkAt(file *os.File, offset, length, need int64) ChunkResult {
+	buffer := make([]byte, length)
+	n, err := file.ReadAt(buffer, offset)
+	if err != nil && err != io.EOF {
+		c.budget.Release(need)
+		return ChunkResult{Error: err}
+	}
+	if n == 0 {
+		c.budget.Release(need)
+		return ChunkResult{}
+	}
+	return c.process(buffer[:n], need)
+}
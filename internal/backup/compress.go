@@ -0,0 +1,115 @@
+package backup
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/pierrec/lz4/v4"
+)
+
+// Block compression codecs. Blocks the codec didn't shrink are stored raw,
+// with their stored size equal to the original size.
+const (
+	CompressionLZ4  = "lz4"
+	CompressionZstd = "zstd"
+)
+
+// DefaultZstdLevel is the zstd level used when none is given, zstd's own
+// default
+const DefaultZstdLevel = 3
+
+// ValidateCompression checks a codec and level for the chunker
+func ValidateCompression(codec string, level int) error {
+	switch codec {
+	case CompressionLZ4:
+		if level != 0 {
+			return fmt.Errorf("lz4 has no compression levels")
+		}
+	case CompressionZstd:
+		if level < 1 || level > 22 {
+			return fmt.Errorf("zstd compression level must be between 1 and 22")
+		}
+	default:
+		return fmt.Errorf("unknown compression %q (expected lz4 or zstd)", codec)
+	}
+	return nil
+}
+
+// zstdDecoder decompresses blocks of any level. DecodeAll is safe for
+// concurrent use.
+var zstdDecoder = sync.OnceValue(func() *zstd.Decoder {
+	d, err := zstd.NewReader(nil, zstd.WithDecoderConcurrency(0), zstd.WithDecoderMaxMemory(MaxChunkSize))
+	if err != nil {
+		panic(err) // Only fails on invalid options
+	}
+	return d
+})
+
+// compressor compresses chunks with one codec and level
+type compressor struct {
+	codec string
+	zstd  *zstd.Encoder
+}
+
+func newCompressor(codec string, level int) (*compressor, error) {
+	if codec != CompressionZstd {
+		return &compressor{codec: CompressionLZ4}, nil
+	}
+	enc, err := zstd.NewWriter(nil,
+		zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(level)),
+		zstd.WithEncoderConcurrency(1),
+		zstd.WithZeroFrames(true))
+	if err != nil {
+		return nil, err
+	}
+	return &compressor{codec: codec, zstd: enc}, nil
+}
+
+// compress compresses src into dst, which should hold
+// lz4.CompressBlockBound(len(src)) bytes, and returns the compressed
+// length. Zero means src didn't compress.
+func (c *compressor) compress(src, dst []byte) (int, error) {
+	if c.zstd == nil {
+		return lz4.CompressBlock(src, dst, nil)
+	}
+	out := c.zstd.EncodeAll(src, dst[:0])
+	if len(out) > len(dst) {
+		return 0, nil // Outgrew dst, so larger than src anyway
+	}
+	return len(out), nil
+}
+
+// DecompressBlock returns the original data of a stored block. An empty
+// codec is LZ4, which all blocks used before codecs were recorded.
+func DecompressBlock(codec string, data []byte, originalSize int64) ([]byte, error) {
+	if int64(len(data)) == originalSize {
+		return data, nil
+	}
+	dst := make([]byte, originalSize)
+	n, err := DecompressBlockInto(codec, data, dst)
+	if err != nil {
+		return nil, err
+	}
+	return dst[:n], nil
+}
+
+// DecompressBlockInto decompresses a stored block into dst, which must hold
+// the original size, and returns the decompressed length
+func DecompressBlockInto(codec string, data, dst []byte) (int, error) {
+	switch codec {
+	case "", CompressionLZ4:
+		return lz4.UncompressBlock(data, dst)
+	case CompressionZstd:
+		out, err := zstdDecoder().DecodeAll(data, dst[:0])
+		if err != nil {
+			return 0, err
+		}
+		if len(out) > len(dst) {
+			return 0, fmt.Errorf("zstd block larger than its original size")
+		}
+		return len(out), nil
+	default:
+		return 0, fmt.Errorf("unknown block compression %q", codec)
+	}
+}
@@ -5,22 +5,77 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"runtime"
+	"sort"
 	"sync"
 	"sync/atomic"
 	"time"
+
+	"github.com/johann/ib/internal/encryption"
+	"github.com/johann/ib/internal/logging"
+)
+
+var logger = logging.For("backup")
+
+// DefaultQueueDepth is how many chunks can wait for upload, see
+// SetQueueDepth
+const DefaultQueueDepth = 32
+
+// What to do about files and directories that can't be read, see
+// SetOnError
+const (
+	OnErrorSkip = "skip" // Leave them out and list them in Manifest.Errors
+	OnErrorFail = "fail" // Fail the backup
 )
 
 // BlockUploader is an interface for checking and uploading blocks
 type BlockUploader interface {
 	BlockExists(ctx context.Context, cid string) (bool, error)
-	UploadBlock(ctx context.Context, cid string, data []byte, originalSize int64) error
+	UploadBlock(ctx context.Context, cid string, data []byte, originalSize int64, compression string) error
 }
 
 // Creator handles backup creation
 type Creator struct {
-	uploader    BlockUploader
-	concurrency int
-	chunker     *Chunker
+	uploader       BlockUploader
+	concurrency    int
+	minConcurrency int // Adaptive concurrency bounds, see SetAdaptiveConcurrency
+	maxConcurrency int
+	chunker        *Chunker
+	kuboCompat     bool
+	cdcMin, cdcAvg int // FastCDC sizes, zero for fixed-size chunks
+	compression    string
+	level          int
+	key            *encryption.Key
+	excludes       []string
+	markers        []string // See SetExcludeIfPresent
+	skipCaches     bool
+	includes       []string
+	filter         *FilterRules
+	xattrs         XattrOptions
+	budget         *MemoryBudget
+	changes        ChangeDetection
+	onError        string
+	readers        int       // Per large file, see SetReaders
+	queueDepth     int       // See SetQueueDepth
+	delta          bool      // See SetDelta
+	rescan         bool      // See SetRescan
+	followSymlinks bool      // See Scanner.SetFollowSymlinks
+	oneFileSystem  bool      // See Scanner.SetOneFileSystem
+	blockHeader    bool      // See SetBlockHeader
+	progress       *Progress // Of the last Create call
+
+	checkpointPath string // See SetCheckpoint
+	resume         bool
+	checkpoint     *checkpoint // Of the running Create call
+	resumed        resumeState
+
+	// BlockExists of the uploader, or of a batcher, in the running Create
+	// call
+	exists func(ctx context.Context, cid string) (bool, error)
+
+	// Paths the running Create call left out, see skip
+	skippedMu sync.Mutex
+	skipped   []EntryError
 }
 
 // NewCreator creates a new backup creator
@@ -32,41 +87,265 @@ func NewCreator(uploader BlockUploader, concurrency int) *Creator {
 	}
 }
 
+// SetKuboCompat switches to kubo-compatible chunking (256KiB chunks, raw
+// leaves, balanced DAG) so file CIDs match `ipfs add --cid-version=1`
+func (c *Creator) SetKuboCompat(enabled bool) {
+	c.kuboCompat = enabled
+	c.cdcMin, c.cdcAvg = 0, 0
+	if enabled {
+		c.chunker = NewChunkerWithSize(KuboChunkSize)
+	} else {
+		c.chunker = NewChunker()
+	}
+}
+
+// SetChunkSize changes the chunk size (MinChunkSize to MaxChunkSize).
+// Incremental backups only reuse blocks of a previous backup with the same
+// chunk size.
+func (c *Creator) SetChunkSize(size int) {
+	c.chunker = NewChunkerWithSize(size)
+	c.cdcMin, c.cdcAvg = 0, 0
+}
+
+// SetCDC switches to content-defined chunking with FastCDC, so unchanged
+// regions of modified files keep their blocks even if data before them was
+// inserted or removed. The sizes must pass ValidateCDCSizes.
+func (c *Creator) SetCDC(minSize, avgSize, maxSize int) {
+	c.chunker = NewCDCChunker(minSize, avgSize, maxSize)
+	c.cdcMin, c.cdcAvg = minSize, avgSize
+}
+
+// SetCompression compresses blocks with zstd at the given level instead of
+// LZ4. Check the codec and level with ValidateCompression.
+func (c *Creator) SetCompression(codec string, level int) {
+	c.compression, c.level = codec, level
+}
+
+// SetBlockHeader writes unencrypted blocks with a header, see
+// Chunker.SetBlockHeader. Encrypted blocks keep their codec and size inside
+// the ciphertext, and stay as they were so they still deduplicate.
+func (c *Creator) SetBlockHeader(on bool) {
+	c.blockHeader = on
+}
+
+// SetAdaptiveConcurrency tunes the number of blocks uploaded in parallel
+// between min and max from upload latency and errors, instead of using a
+// fixed number
+func (c *Creator) SetAdaptiveConcurrency(minConcurrency, maxConcurrency int) {
+	c.minConcurrency = minConcurrency
+	c.maxConcurrency = maxConcurrency
+}
+
+// SetMemoryBudget bounds the chunk data read ahead and waiting for upload
+func (c *Creator) SetMemoryBudget(budget *MemoryBudget) {
+	c.budget = budget
+}
+
+// SetReaders chunks large files with n readers at once, see
+// Chunker.SetReaders. It has no effect on content-defined chunking, which
+// has to read a file in order.
+func (c *Creator) SetReaders(n int) {
+	c.readers = n
+}
+
+// SetQueueDepth sets how many chunks can wait for the upload workers
+// before chunking waits for them (default DefaultQueueDepth). Chunks in the
+// queue count against the memory budget.
+func (c *Creator) SetQueueDepth(n int) {
+	c.queueDepth = n
+}
+
+// SetDelta makes changed files refer to the blocks they share with their
+// version in the previous backup without asking the server, so a file that
+// only changed in places, like an appended log or a VM image, costs checks
+// and uploads for the changed chunks only. Works best with SetCDC, which
+// keeps chunk boundaries after inserts.
+func (c *Creator) SetDelta(enabled bool) {
+	c.delta = enabled
+}
+
+// SetFollowSymlinks backs up the files and directories symlinks point to
+// instead of the links, see Scanner.SetFollowSymlinks
+func (c *Creator) SetFollowSymlinks(follow bool) {
+	c.followSymlinks = follow
+}
+
+// SetOneFileSystem keeps the backup on the file system of its root, see
+// Scanner.SetOneFileSystem
+func (c *Creator) SetOneFileSystem(one bool) {
+	c.oneFileSystem = one
+}
+
+// SetRescan reads and chunks every file instead of reusing the blocks of
+// unchanged files from the previous backup, e.g. after suspected bit rot.
+// Blocks the server already has are still not uploaded again.
+func (c *Creator) SetRescan(rescan bool) {
+	c.rescan = rescan
+}
+
+// SetChangeDetection sets how files are found unchanged since the previous
+// backup (default ChangeMtime). Check the mode with ParseChangeDetection.
+func (c *Creator) SetChangeDetection(mode ChangeDetection) {
+	c.changes = mode
+}
+
+// SetExcludes skips paths matching these .gitignore-style patterns, in
+// addition to .gitignore and .ibignore files
+func (c *Creator) SetExcludes(patterns []string) {
+	c.excludes = patterns
+}
+
+// SetExcludeIfPresent skips directories containing a file with one of
+// these names, see Scanner.ExcludeIfPresent
+func (c *Creator) SetExcludeIfPresent(names []string) {
+	c.markers = names
+}
+
+// SetExcludeCaches skips directories tagged with CACHEDIR.TAG, see
+// Scanner.SetExcludeCaches
+func (c *Creator) SetExcludeCaches(exclude bool) {
+	c.skipCaches = exclude
+}
+
+// SetOnError sets what happens to files and directories that can't be
+// read, OnErrorSkip (the default) or OnErrorFail
+func (c *Creator) SetOnError(policy string) {
+	c.onError = policy
+}
+
+// SetIncludes keeps paths matching these .gitignore-style patterns even if
+// excluded or ignored, see Scanner.Include
+func (c *Creator) SetIncludes(patterns []string) {
+	c.includes = patterns
+}
+
+// SetXattrs backs up the selected extended attributes and ACLs
+func (c *Creator) SetXattrs(opts XattrOptions) {
+	c.xattrs = opts
+}
+
+// SetFilter applies ordered include/exclude rules on top of the ignore
+// patterns, see FilterRules
+func (c *Creator) SetFilter(f *FilterRules) {
+	c.filter = f
+}
+
+// SetCheckpoint records the progress of Create in a file at path, so an
+// interrupted backup can be resumed by a later Create with resume set.
+// Resuming reuses the files and blocks the interrupted backup got done
+// instead of reading and checking them again. Remove the checkpoint with
+// RemoveCheckpoint once the manifest is uploaded.
+func (c *Creator) SetCheckpoint(path string, resume bool) {
+	c.checkpointPath = path
+	c.resume = resume
+}
+
+// RemoveCheckpoint removes the checkpoint of a finished backup
+func (c *Creator) RemoveCheckpoint() error {
+	if c.checkpointPath == "" {
+		return nil
+	}
+	if err := os.Remove(c.checkpointPath); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// SetKey enables client-side encryption of blocks. Use SealManifest on the
+// result before uploading it.
+func (c *Creator) SetKey(key *encryption.Key) {
+	c.key = key
+}
+
 // Progress tracks backup creation progress
 type Progress struct {
 	TotalFiles     int64
 	ProcessedFiles int64
 	SkippedFiles   int64 // Files unchanged from previous backup
-	ErrorFiles     int64 // Files skipped due to errors (permission denied, etc)
+	ErrorFiles     int64 // Files and directories skipped due to errors (permission denied, etc), see SetOnError
 	TotalBytes     int64
 	UploadedBytes  int64
 	SkippedBytes   int64 // Bytes from blocks that already existed
 	BlocksUploaded int64
 	BlocksSkipped  int64 // Blocks that already existed on server
+	Concurrency    int64 // Current limit of blocks uploaded in parallel
 	CurrentFile    atomic.Value
 	StartTime      time.Time
 }
 
 // Create creates a backup of the given path with the specified tags
 func (c *Creator) Create(ctx context.Context, rootPath string, tags map[string]string, prevManifest *Manifest) (*Manifest, error) {
-	// Build index of previous manifest for incremental backup
-	var prevIndex map[string]*Entry
-	if prevManifest != nil {
-		prevIndex = prevManifest.BuildEntryIndex()
-	}
-
 	// Create new manifest
 	absPath, err := filepath.Abs(rootPath)
 	if err != nil {
 		return nil, err
 	}
 	manifest := NewManifest(tags, absPath)
+	if c.chunker.ChunkSize() != ChunkSize {
+		manifest.ChunkSize = int64(c.chunker.ChunkSize())
+	}
+	manifest.KuboCompat = c.kuboCompat
+	if c.cdcAvg != 0 {
+		manifest.Chunking = ChunkingFastCDC
+		manifest.ChunkMin = int64(c.cdcMin)
+		manifest.ChunkAvg = int64(c.cdcAvg)
+	}
+	c.chunker.SetBudget(c.budget)
+	c.chunker.SetReaders(c.readers)
+	c.chunker.SetBlockHeader(c.blockHeader && c.key == nil)
+	if c.compression != "" {
+		if err := c.chunker.SetCompression(c.compression, c.level); err != nil {
+			return nil, err
+		}
+	}
+
+	// Pick up where an interrupted backup left off, and record this one so
+	// it can be resumed in turn
+	c.checkpoint, c.resumed = nil, resumeState{}
+	if c.checkpointPath != "" {
+		var interrupted *Manifest
+		if c.resume {
+			var state *resumeState
+			interrupted, state, err = loadCheckpoint(c.checkpointPath, manifest, c.key)
+			if err != nil {
+				return nil, err
+			}
+			if interrupted != nil {
+				// The server may have pruned blocks no manifest refers to yet
+				gone, err := state.verify(ctx, c.uploader)
+				if err != nil {
+					return nil, fmt.Errorf("checking the blocks of the interrupted backup: %w", err)
+				}
+				if gone > 0 {
+					fmt.Printf("%d blocks of the interrupted backup are no longer on the server and will be uploaded again\n", gone)
+				}
+				manifest.ID, manifest.CreatedAt = interrupted.ID, interrupted.CreatedAt
+				c.resumed = *state
+				fmt.Printf("Resuming backup %s: %d files and %d blocks done\n", manifest.ID, len(state.entries), len(state.blocks))
+			} else {
+				fmt.Println("No checkpoint to resume, starting from scratch")
+			}
+		}
+		c.checkpoint, err = createCheckpoint(c.checkpointPath, manifest, c.key, interrupted != nil)
+		if err != nil {
+			logger.Warn("could not create checkpoint, the backup can't be resumed", "error", err)
+		}
+		defer c.checkpoint.close()
+	}
+
+	// Build index of previous manifest for incremental backup. Blocks can only
+	// be reused if the previous backup was chunked the same way.
+	var prevIndex map[string]*Entry
+	if prevManifest != nil && !c.rescan && prevManifest.SameChunking(manifest) {
+		prevIndex = prevManifest.BuildEntryIndex()
+	}
 
 	// Initialize progress tracking
 	progress := &Progress{
 		StartTime: time.Now(),
 	}
 	progress.CurrentFile.Store("")
+	c.progress = progress
 
 	// Start progress reporter
 	progressCtx, cancelProgress := context.WithCancel(ctx)
@@ -76,141 +355,156 @@ func (c *Creator) Create(ctx context.Context, rootPath string, tags map[string]s
 		c.reportProgress(progressCtx, progress)
 	}()
 
-	// Scan directory
-	fmt.Println("Scanning directory...")
-	scanner := NewScanner(rootPath)
-	scanResults := scanner.Scan()
-
-	// Collect all entries first
-	var entries []Entry
-	for result := range scanResults {
-		if result.Error != nil {
-			fmt.Printf("Warning: scan error: %v\n", result.Error)
-			continue
-		}
-		entries = append(entries, result.Entry)
-		if result.Entry.Type == FileTypeFile {
-			atomic.AddInt64(&progress.TotalFiles, 1)
-			atomic.AddInt64(&progress.TotalBytes, result.Entry.Size)
-		}
-	}
-
-	fmt.Printf("Found %d files (%s total)\n", progress.TotalFiles, formatBytes(progress.TotalBytes))
-
-	// Process files concurrently
-	var wg sync.WaitGroup
-	sem := make(chan struct{}, c.concurrency)
+	// Scan, compare, upload and collect in a pipeline, so besides the
+	// manifest only the files in flight are held in memory
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
 	var firstErr error
 	var errOnce sync.Once
+	fail := func(err error) {
+		errOnce.Do(func() {
+			firstErr = err
+			cancel()
+		})
+	}
 
-	for i := range entries {
-		entry := &entries[i]
+	// Where the uploader can, the block checks of all workers go out in
+	// batches
+	c.exists = c.uploader.BlockExists
+	c.skipped = nil
+	if checker, ok := c.uploader.(BlockBatchChecker); ok {
+		c.exists = newExistsBatcher(ctx, checker).exists
+	}
 
-		if entry.Type != FileTypeFile {
-			// Add directories and symlinks directly
-			manifest.AddEntry(*entry)
-			continue
+	sem := newLimiter(c.concurrency)
+	workers := c.concurrency
+	if c.maxConcurrency > 0 {
+		sem = newAdaptiveLimiter(c.minConcurrency, c.maxConcurrency)
+		workers = c.maxConcurrency
+	}
+	atomic.StoreInt64(&progress.Concurrency, int64(sem.current()))
+
+	// Entries for the manifest, in the order they are done
+	done := make(chan Entry, max(workers, 1))
+	written := make(chan struct{})
+	go func() {
+		defer close(written)
+		for entry := range done {
+			manifest.AddEntry(entry)
 		}
+	}()
 
-		// Check if file changed since last backup
-		if prevIndex != nil {
-			if prevEntry, ok := prevIndex[entry.Path]; ok {
-				if prevEntry.Mtime == entry.Mtime && prevEntry.Size == entry.Size {
-					// File unchanged, reuse blocks from previous manifest
-					entry.Blocks = prevEntry.Blocks
-					// Don't add to manifest here - the final loop will add all files
-					atomic.AddInt64(&progress.ProcessedFiles, 1)
-					atomic.AddInt64(&progress.SkippedFiles, 1)
-					atomic.AddInt64(&progress.SkippedBytes, entry.Size)
-					continue
+	// Blocks of changed files, checked and uploaded by the upload workers,
+	// at most sem's limit at once. The queue between them and the chunking
+	// workers lets reading and uploading go on at the same time, and holds
+	// the chunking workers back when uploads can't keep up.
+	queueDepth := c.queueDepth
+	if queueDepth <= 0 {
+		queueDepth = DefaultQueueDepth
+	}
+	queue := make(chan blockJob, queueDepth)
+	var uploads sync.WaitGroup
+	for range max(workers, 1) {
+		uploads.Add(1)
+		go func() {
+			defer uploads.Done()
+			for job := range queue {
+				if err := c.uploadBlock(ctx, job, sem, progress, done); err != nil {
+					fail(err)
 				}
 			}
-		}
+		}()
+	}
 
-		// Process file
+	// Changed files, chunked by one worker per CPU
+	jobs := make(chan fileJob)
+	var wg sync.WaitGroup
+	for range max(runtime.NumCPU(), 2) {
 		wg.Add(1)
-		go func(e *Entry) {
+		go func() {
 			defer wg.Done()
-
-			sem <- struct{}{}
-			defer func() { <-sem }()
-
-			// Check for context cancellation or previous error
-			select {
-			case <-ctx.Done():
-				errOnce.Do(func() { firstErr = ctx.Err() })
-				return
-			default:
+			for job := range jobs {
+				if err := c.chunkFile(ctx, rootPath, job, queue, progress, done); err != nil {
+					fail(err)
+				}
 			}
+		}()
+	}
 
-			if firstErr != nil {
-				return
+	fmt.Println("Scanning directory...")
+	scanner := NewScanner(rootPath)
+	scanner.Exclude(c.excludes...)
+	scanner.ExcludeIfPresent(c.markers...)
+	scanner.SetExcludeCaches(c.skipCaches)
+	scanner.Include(c.includes...)
+	scanner.SetFilter(c.filter)
+	scanner.SetXattrs(c.xattrs)
+	scanner.SetFollowSymlinks(c.followSymlinks)
+	scanner.SetOneFileSystem(c.oneFileSystem)
+	scanner.SetChangeDetection(c.changes)
+
+scan:
+	for result := range scanner.Scan(ctx) {
+		if result.Error != nil {
+			path := result.Path
+			if path == "" {
+				path = "."
 			}
-
-			progress.CurrentFile.Store(e.Path)
-
-			fullPath := filepath.Join(rootPath, e.Path)
-			chunks := c.chunker.ChunkFile(fullPath)
-
-			var blocks []string
-			var fileUploadedBytes int64
-			var fileSkippedBytes int64
-			var fileError error
-
-			for chunk := range chunks {
-				if chunk.Error != nil {
-					// Check if this is a permission error - skip file instead of failing
-					if os.IsPermission(chunk.Error) {
-						fileError = chunk.Error
-						// Drain remaining chunks
-						for range chunks {
-						}
-						break
-					}
-					errOnce.Do(func() { firstErr = fmt.Errorf("chunking %s: %w", e.Path, chunk.Error) })
-					return
-				}
-
-				// Check if block exists on server
-				exists, err := c.uploader.BlockExists(ctx, chunk.CID)
-				if err != nil {
-					errOnce.Do(func() { firstErr = fmt.Errorf("checking block %s: %w", chunk.CID[:12], err) })
-					return
-				}
-
-				if !exists {
-					// Upload the block
-					if err := c.uploader.UploadBlock(ctx, chunk.CID, chunk.Data, chunk.OriginalSize); err != nil {
-						errOnce.Do(func() { firstErr = fmt.Errorf("uploading block %s: %w", chunk.CID[:12], err) })
-						return
-					}
-					atomic.AddInt64(&progress.BlocksUploaded, 1)
-					fileUploadedBytes += int64(len(chunk.Data))
-				} else {
-					atomic.AddInt64(&progress.BlocksSkipped, 1)
-					fileSkippedBytes += chunk.OriginalSize
-				}
-
-				blocks = append(blocks, chunk.CID)
+			if err := c.skip(path, result.Error, progress); err != nil {
+				fail(err)
+				break scan
 			}
+			continue
+		}
+		entry := result.Entry
 
-			// Handle files that couldn't be read
-			if fileError != nil {
-				fmt.Printf("Warning: skipping %s: %v\n", e.Path, fileError)
-				atomic.AddInt64(&progress.ErrorFiles, 1)
-				atomic.AddInt64(&progress.ProcessedFiles, 1)
-				e.Blocks = nil // Mark as unreadable
-				return
+		if entry.Type != FileTypeFile {
+			// Add directories and symlinks directly
+			done <- entry
+			continue
+		}
+		atomic.AddInt64(&progress.TotalFiles, 1)
+		atomic.AddInt64(&progress.TotalBytes, entry.Size)
+
+		// Check if file changed since last backup, or was uploaded by the
+		// interrupted backup being resumed
+		prevEntry, ok := c.resumed.entries[entry.Path]
+		if !ok {
+			prevEntry, ok = prevIndex[entry.Path]
+		}
+		if ok && c.changes.unchanged(prevEntry, &entry) {
+			// File unchanged, reuse blocks from previous manifest
+			entry.Blocks = prevEntry.Blocks
+			entry.BlockSizes = prevEntry.BlockSizes
+			atomic.AddInt64(&progress.ProcessedFiles, 1)
+			atomic.AddInt64(&progress.SkippedFiles, 1)
+			atomic.AddInt64(&progress.SkippedBytes, entry.Size)
+			if entry.Blocks != nil {
+				done <- entry
 			}
+			continue
+		}
 
-			e.Blocks = blocks
-			atomic.AddInt64(&progress.ProcessedFiles, 1)
-			atomic.AddInt64(&progress.UploadedBytes, fileUploadedBytes)
-			atomic.AddInt64(&progress.SkippedBytes, fileSkippedBytes)
-		}(entry)
+		job := fileJob{entry: entry}
+		if ok && (c.delta || c.changes == ChangeChecksum) {
+			job.prev = prevEntry
+		}
+		select {
+		case jobs <- job:
+		case <-ctx.Done():
+			break scan
+		}
+	}
+	close(jobs)
+	if ctx.Err() == nil {
+		fmt.Printf("Found %d files (%s total)\n", atomic.LoadInt64(&progress.TotalFiles), formatBytes(atomic.LoadInt64(&progress.TotalBytes)))
 	}
 
 	wg.Wait()
+	close(queue)
+	uploads.Wait()
+	close(done)
+	<-written
 
 	// Stop progress reporter
 	cancelProgress()
@@ -219,18 +513,198 @@ func (c *Creator) Create(ctx context.Context, rootPath string, tags map[string]s
 	// Print final progress
 	c.printFinalProgress(progress)
 
+	if firstErr == nil && ctx.Err() != nil {
+		firstErr = ctx.Err()
+	}
 	if firstErr != nil {
 		return nil, firstErr
 	}
 
-	// Add all file entries to manifest (skip files that had errors)
-	for _, entry := range entries {
-		if entry.Type == FileTypeFile && entry.Blocks != nil {
-			manifest.AddEntry(entry)
+	// Files finish out of order, sort so the manifest doesn't depend on timing
+	sort.Slice(manifest.Entries, func(i, j int) bool {
+		return manifest.Entries[i].Path < manifest.Entries[j].Path
+	})
+	sort.Slice(c.skipped, func(i, j int) bool {
+		return c.skipped[i].Path < c.skipped[j].Path
+	})
+	manifest.Errors = c.skipped
+
+	return manifest, nil
+}
+
+// fileJob is a changed file for the chunking workers
+type fileJob struct {
+	entry Entry
+	prev  *Entry // Previous version in delta mode, see SetDelta
+}
+
+// fileUpload is a changed file whose blocks are being uploaded
+type fileUpload struct {
+	entry Entry
+
+	// Blocks not uploaded yet, plus one until the file is chunked. Whoever
+	// takes it to zero finishes the file.
+	pending    int64
+	unreadable atomic.Bool // Nothing of it goes into the manifest
+}
+
+// blockJob is a chunk of a changed file, for the upload workers
+type blockJob struct {
+	file  *fileUpload
+	chunk ChunkResult // Sealed if the backup is encrypted
+}
+
+// chunkFile chunks a changed file and queues its chunks for upload. Files
+// that can't be read are left out or end the backup, see SetOnError; other
+// errors end the backup.
+func (c *Creator) chunkFile(ctx context.Context, rootPath string, job fileJob, queue chan<- blockJob, progress *Progress, done chan<- Entry) error {
+	e := job.entry
+	// Check for context cancellation or previous error
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	progress.CurrentFile.Store(e.Path)
+
+	f := &fileUpload{entry: e, pending: 1}
+	defer c.finishFile(f, progress, done)
+
+	// Blocks of the previous version are on the server
+	var stored map[string]bool
+	if job.prev != nil {
+		stored = make(map[string]bool, len(job.prev.Blocks))
+		for _, cid := range job.prev.Blocks {
+			stored[cid] = true
 		}
 	}
 
-	return manifest, nil
+	fullPath := filepath.Join(rootPath, e.Path)
+	chunks := c.chunker.ChunkFile(ctx, fullPath)
+
+	// Return the memory budget of any chunks left unread, so other files
+	// can go on
+	defer func() {
+		for rest := range chunks {
+			c.chunker.Release(rest)
+		}
+	}()
+
+	for chunk := range chunks {
+		if chunk.Error != nil {
+			f.unreadable.Store(true)
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			return c.skip(e.Path, chunk.Error, progress)
+		}
+
+		if c.cdcAvg != 0 {
+			f.entry.BlockSizes = append(f.entry.BlockSizes, chunk.OriginalSize)
+		}
+		if c.key != nil {
+			sealed, err := sealChunk(c.key, chunk)
+			if err != nil {
+				c.chunker.Release(chunk)
+				f.unreadable.Store(true)
+				return fmt.Errorf("encrypting %s: %w", e.Path, err)
+			}
+			sealed.held = chunk.held
+			chunk = sealed
+		}
+		f.entry.Blocks = append(f.entry.Blocks, chunk.CID)
+
+		if stored[chunk.CID] {
+			atomic.AddInt64(&progress.BlocksSkipped, 1)
+			atomic.AddInt64(&progress.SkippedBytes, chunk.OriginalSize)
+			c.chunker.Release(chunk)
+			continue
+		}
+		atomic.AddInt64(&f.pending, 1)
+		select {
+		case queue <- blockJob{file: f, chunk: chunk}:
+		case <-ctx.Done():
+			atomic.AddInt64(&f.pending, -1)
+			c.chunker.Release(chunk)
+			f.unreadable.Store(true)
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// skip leaves path out of the backup because it couldn't be read, or
+// returns the error that fails the backup if the error policy says so
+func (c *Creator) skip(path string, err error, progress *Progress) error {
+	if c.onError == OnErrorFail {
+		return fmt.Errorf("reading %s: %w", path, err)
+	}
+	logger.Warn("skipping", "path", path, "error", err)
+	atomic.AddInt64(&progress.ErrorFiles, 1)
+
+	c.skippedMu.Lock()
+	defer c.skippedMu.Unlock()
+	c.skipped = append(c.skipped, EntryError{Path: path, Error: err.Error()})
+	return nil
+}
+
+// uploadBlock uploads the block of job unless the server has it already
+func (c *Creator) uploadBlock(ctx context.Context, job blockJob, sem *limiter, progress *Progress, done chan<- Entry) error {
+	chunk := job.chunk
+	defer c.finishFile(job.file, progress, done)
+	defer c.chunker.Release(chunk)
+
+	if err := ctx.Err(); err != nil {
+		job.file.unreadable.Store(true)
+		return err
+	}
+
+	// Check if block exists on server, unless the interrupted backup being
+	// resumed already did
+	exists := c.resumed.blocks[chunk.CID]
+	if !exists {
+		var err error
+		exists, err = c.exists(ctx, chunk.CID)
+		if err != nil {
+			job.file.unreadable.Store(true)
+			return fmt.Errorf("checking block %s: %w", chunk.CID[:12], err)
+		}
+	}
+
+	if !exists {
+		sem.acquire()
+		start := time.Now()
+		err := c.uploader.UploadBlock(ctx, chunk.CID, chunk.Data, chunk.OriginalSize, chunk.Compression)
+		sem.observe(len(chunk.Data), time.Since(start), err)
+		sem.release()
+		atomic.StoreInt64(&progress.Concurrency, int64(sem.current()))
+		if err != nil {
+			job.file.unreadable.Store(true)
+			return fmt.Errorf("uploading block %s: %w", chunk.CID[:12], err)
+		}
+		atomic.AddInt64(&progress.BlocksUploaded, 1)
+		atomic.AddInt64(&progress.UploadedBytes, int64(len(chunk.Data)))
+	} else {
+		atomic.AddInt64(&progress.BlocksSkipped, 1)
+		atomic.AddInt64(&progress.SkippedBytes, chunk.OriginalSize)
+	}
+	if !c.resumed.blocks[chunk.CID] {
+		c.checkpoint.record(checkpointRecord{Block: chunk.CID})
+	}
+	return nil
+}
+
+// finishFile counts one of f's pending blocks, or its chunking, as done.
+// The last one adds f to the manifest, unless it couldn't be read.
+func (c *Creator) finishFile(f *fileUpload, progress *Progress, done chan<- Entry) {
+	if atomic.AddInt64(&f.pending, -1) != 0 {
+		return
+	}
+	atomic.AddInt64(&progress.ProcessedFiles, 1)
+	if f.unreadable.Load() || f.entry.Blocks == nil {
+		return
+	}
+	c.checkpoint.record(checkpointRecord{Entry: &f.entry})
+	done <- f.entry
 }
 
 func (c *Creator) reportProgress(ctx context.Context, p *Progress) {
@@ -276,7 +750,7 @@ func (c *Creator) reportProgress(ctx context.Context, p *Progress) {
 				fmt.Printf("  Skipped files: %d (permission denied or unreadable)\n", errorFiles)
 			}
 			if speed > 0 {
-				fmt.Printf("  Speed: %s/s\n", formatBytes(int64(speed)))
+				fmt.Printf("  Speed: %s/s (%d parallel)\n", formatBytes(int64(speed)), atomic.LoadInt64(&p.Concurrency))
 			}
 			if currentFile != "" {
 				displayPath := currentFile
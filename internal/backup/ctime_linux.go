@@ -0,0 +1,18 @@
+package backup
+
+import (
+	"io/fs"
+	"syscall"
+)
+
+// haveChangeTime is whether changeTime works on this platform
+const haveChangeTime = true
+
+// changeTime returns the time info's inode last changed, in nanoseconds
+func changeTime(info fs.FileInfo) (int64, bool) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, false
+	}
+	return st.Ctim.Nano(), true
+}
@@ -0,0 +1,13 @@
+//go:build !linux && !darwin
+
+package backup
+
+import "io/fs"
+
+// haveChangeTime is whether changeTime works on this platform
+const haveChangeTime = false
+
+// changeTime reports no change time, this platform isn't supported
+func changeTime(info fs.FileInfo) (int64, bool) {
+	return 0, false
+}
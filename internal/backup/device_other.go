@@ -0,0 +1,18 @@
+//go:build !unix
+
+package backup
+
+import "io/fs"
+
+// haveInode is whether fileInode works on this platform
+const haveInode = false
+
+// fileDevice reports no device, this platform isn't supported
+func fileDevice(info fs.FileInfo) (uint64, bool) {
+	return 0, false
+}
+
+// fileInode reports no inode, this platform isn't supported
+func fileInode(info fs.FileInfo) (uint64, bool) {
+	return 0, false
+}
@@ -0,0 +1,29 @@
+//go:build unix
+
+package backup
+
+import (
+	"io/fs"
+	"syscall"
+)
+
+// haveInode is whether fileInode works on this platform
+const haveInode = true
+
+// fileDevice returns the device of the file system info is on
+func fileDevice(info fs.FileInfo) (uint64, bool) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, false
+	}
+	return uint64(st.Dev), true
+}
+
+// fileInode returns the inode number of info
+func fileInode(info fs.FileInfo) (uint64, bool) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, false
+	}
+	return uint64(st.Ino), true
+}
@@ -0,0 +1,72 @@
+package backup
+
+import (
+	"slices"
+	"sort"
+)
+
+// Kinds of EntryChange
+const (
+	ChangeAdded    = "added"
+	ChangeRemoved  = "removed"
+	ChangeModified = "modified"
+)
+
+// EntryChange is an entry that differs between two manifests
+type EntryChange struct {
+	Path    string   `json:"path"`
+	Change  string   `json:"change"` // ChangeAdded, ChangeRemoved or ChangeModified
+	Type    FileType `json:"type"`   // Of the newer entry, unless removed
+	OldSize int64    `json:"old_size"`
+	NewSize int64    `json:"new_size"`
+}
+
+// DiffManifests returns the entries added, removed and modified from old
+// to new, by path. Files and symlinks are modified if their content, type,
+// size or mode changed; directories are only added or removed, as their
+// mtime changes with every file in them.
+func DiffManifests(old, new *Manifest) []EntryChange {
+	oldIndex := old.BuildEntryIndex()
+	newIndex := new.BuildEntryIndex()
+	sameChunking := old.SameChunking(new)
+
+	var changes []EntryChange
+	for path, e := range newIndex {
+		prev, ok := oldIndex[path]
+		if !ok {
+			changes = append(changes, EntryChange{Path: path, Change: ChangeAdded, Type: e.Type, NewSize: e.Size})
+			continue
+		}
+		if entryModified(prev, e, sameChunking) {
+			changes = append(changes, EntryChange{Path: path, Change: ChangeModified, Type: e.Type, OldSize: prev.Size, NewSize: e.Size})
+		}
+	}
+	for path, e := range oldIndex {
+		if _, ok := newIndex[path]; !ok {
+			changes = append(changes, EntryChange{Path: path, Change: ChangeRemoved, Type: e.Type, OldSize: e.Size})
+		}
+	}
+
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].Path < changes[j].Path
+	})
+	return changes
+}
+
+func entryModified(old, new *Entry, sameChunking bool) bool {
+	if old.Type != new.Type {
+		return true
+	}
+	if new.Type == FileTypeDir {
+		return false
+	}
+	if old.Size != new.Size || old.Mode != new.Mode || old.LinkTarget != new.LinkTarget {
+		return true
+	}
+	// Blocks of differently chunked backups differ even for the same
+	// content, so fall back to the mtime the incremental backup goes by
+	if !sameChunking {
+		return old.Mtime != new.Mtime
+	}
+	return !slices.Equal(old.Blocks, new.Blocks)
+}
@@ -0,0 +1,108 @@
+package backup
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+
+	"github.com/johann/ib/internal/cid"
+	"github.com/johann/ib/internal/encryption"
+)
+
+// sealChunk encrypts a (possibly compressed) chunk. The CID is computed over
+// the ciphertext, so the server and IPFS peers can verify blocks without
+// being able to read them.
+func sealChunk(key *encryption.Key, chunk ChunkResult) (ChunkResult, error) {
+	flag := blockRaw
+	switch chunk.Compression {
+	case CompressionLZ4:
+		flag = blockLZ4
+	case CompressionZstd:
+		flag = blockZstd
+	}
+
+	payload := make([]byte, 5, 5+len(chunk.Data))
+	payload[0] = flag
+	binary.BigEndian.PutUint32(payload[1:5], uint32(chunk.OriginalSize))
+	payload = append(payload, chunk.Data...)
+
+	sealed := key.SealConvergent(payload)
+	sealedCID, err := cid.Generate(sealed)
+	if err != nil {
+		return ChunkResult{}, err
+	}
+
+	// The server stores ciphertext as-is, it can't be compressed further
+	return ChunkResult{
+		CID:          sealedCID,
+		Data:         sealed,
+		OriginalSize: int64(len(sealed)),
+	}, nil
+}
+
+// openBlock decrypts and decompresses a block from sealChunk
+func openBlock(key *encryption.Key, data []byte) ([]byte, error) {
+	payload, err := key.Open(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(payload) < 5 {
+		return nil, fmt.Errorf("invalid encrypted block")
+	}
+
+	originalSize := int64(binary.BigEndian.Uint32(payload[1:5]))
+	switch payload[0] {
+	case blockRaw:
+		return payload[5:], nil
+	case blockLZ4:
+		return Decompress(payload[5:], originalSize)
+	case blockZstd:
+		return DecompressBlock(CompressionZstd, payload[5:], originalSize)
+	default:
+		return nil, fmt.Errorf("unknown block encoding %d", payload[0])
+	}
+}
+
+// SealManifest encrypts a manifest for zero-knowledge storage. The returned
+// manifest only exposes the ID, tags, creation time and the block CIDs the
+// server needs for reference tracking; paths and file names stay encrypted.
+func SealManifest(key *encryption.Key, manifest *Manifest) (*Manifest, error) {
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		return nil, err
+	}
+	sealed, err := key.Seal(data)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Manifest{
+		ID:        manifest.ID,
+		Tags:      manifest.Tags,
+		CreatedAt: manifest.CreatedAt,
+		Encrypted: sealed,
+		CIDs:      manifest.BlockCIDs(),
+	}, nil
+}
+
+// OpenManifest decrypts a manifest from SealManifest. Plaintext manifests
+// are returned unchanged.
+func OpenManifest(key *encryption.Key, manifest *Manifest) (*Manifest, error) {
+	if manifest.Encrypted == nil {
+		return manifest, nil
+	}
+	if key == nil {
+		return nil, fmt.Errorf("manifest %s is encrypted, an encryption key is required", manifest.ID)
+	}
+
+	data, err := key.Open(manifest.Encrypted)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt manifest %s: %w", manifest.ID, err)
+	}
+
+	var opened Manifest
+	if err := json.Unmarshal(data, &opened); err != nil {
+		return nil, fmt.Errorf("failed to parse manifest %s: %w", manifest.ID, err)
+	}
+	return &opened, nil
+}
@@ -0,0 +1,103 @@
+package backup
+
+import (
+	"fmt"
+	"math/bits"
+)
+
+// Default FastCDC chunk sizes. The maximum stays at ChunkSize, so restores
+// and the IPFS gateway never see blocks larger than fixed-size chunking
+// produces.
+const (
+	DefaultCDCMinSize = 512 * 1024
+	DefaultCDCAvgSize = 2 * 1024 * 1024
+	DefaultCDCMaxSize = ChunkSize
+)
+
+// ChunkingFastCDC is the Manifest.Chunking value for content-defined chunks
+const ChunkingFastCDC = "fastcdc"
+
+// fastCDC finds content-defined chunk boundaries with FastCDC (Xia et al.,
+// 2016): a gear rolling hash over the data past the minimum size, with a
+// stricter mask before the average size and a looser one after it, so
+// chunk sizes cluster around the average. A boundary only depends on the
+// bytes before it, so inserting or removing data in a file only changes
+// the chunks around the edit.
+type fastCDC struct {
+	minSize, avgSize, maxSize int
+	maskS, maskL              uint64 // Before and after the average size
+}
+
+// gear maps each byte to a random 64-bit value. It must never change:
+// chunk boundaries, and with them the CIDs of every content-defined
+// backup, depend on it.
+var gear = func() (table [256]uint64) {
+	// splitmix64 with a fixed seed
+	state := uint64(0x6962206661737463) // "ib fastc"
+	for i := range table {
+		state += 0x9e3779b97f4a7c15
+		z := state
+		z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+		z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+		table[i] = z ^ (z >> 31)
+	}
+	return table
+}()
+
+// ValidateCDCSizes checks FastCDC chunk sizes: min <= avg <= max within
+// MinChunkSize and MaxChunkSize, with a power of two average
+func ValidateCDCSizes(minSize, avgSize, maxSize int) error {
+	if minSize < MinChunkSize || maxSize > MaxChunkSize {
+		return fmt.Errorf("chunk sizes must be between %d and %d bytes", MinChunkSize, MaxChunkSize)
+	}
+	if minSize > avgSize || avgSize > maxSize {
+		return fmt.Errorf("chunk sizes must satisfy min <= avg <= max")
+	}
+	if avgSize&(avgSize-1) != 0 {
+		return fmt.Errorf("average chunk size must be a power of two")
+	}
+	return nil
+}
+
+func newFastCDC(minSize, avgSize, maxSize int) *fastCDC {
+	// The hash's top bits depend on the last 64 bytes, the bottom ones only
+	// on the last few, so boundaries are tested on the top bits. One bit
+	// more or less than log2(avg) is the normalization level 1 of the paper.
+	b := bits.Len(uint(avgSize)) - 1
+	return &fastCDC{
+		minSize: minSize,
+		avgSize: avgSize,
+		maxSize: maxSize,
+		maskS:   ^uint64(0) << (64 - (b + 1)),
+		maskL:   ^uint64(0) << (64 - (b - 1)),
+	}
+}
+
+// cut returns the length of the first chunk of data. data must hold
+// maxSize bytes unless it is the end of the file.
+func (f *fastCDC) cut(data []byte) int {
+	n := len(data)
+	if n <= f.minSize {
+		return n
+	}
+	if n > f.maxSize {
+		n = f.maxSize
+	}
+	normal := min(f.avgSize, n)
+
+	var hash uint64
+	i := f.minSize
+	for ; i < normal; i++ {
+		hash = (hash << 1) + gear[data[i]]
+		if hash&f.maskS == 0 {
+			return i + 1
+		}
+	}
+	for ; i < n; i++ {
+		hash = (hash << 1) + gear[data[i]]
+		if hash&f.maskL == 0 {
+			return i + 1
+		}
+	}
+	return n
+}
@@ -0,0 +1,99 @@
+package backup
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// FilterRules are ordered include/exclude rules in the style of rsync's
+// filter files. Unlike .gitignore, where the last matching pattern wins,
+// the first matching rule decides, so policies like "everything under var
+// except its caches, except one cache" can be written top down:
+//
+//	include /var/cache/keep/
+//	include /var/cache/keep/**
+//	exclude /var/cache/**
+//
+// A rule is "+ pattern" or "include pattern", "- pattern" or "exclude
+// pattern". Patterns use the same syntax as .gitignore: a leading / anchors
+// to the backup root and a trailing / matches directories only. Blank lines
+// and lines starting with # are ignored. Excluding a directory skips
+// everything below it.
+type FilterRules struct {
+	rules []filterRule
+}
+
+type filterRule struct {
+	include bool
+	pattern ignorePattern
+}
+
+// LoadFilterFile reads filter rules from a file
+func LoadFilterFile(path string) (*FilterRules, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	f := &FilterRules{}
+	scanner := bufio.NewScanner(file)
+	for n := 1; scanner.Scan(); n++ {
+		if err := f.Add(scanner.Text()); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, n, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Add appends one rule
+func (f *FilterRules) Add(line string) error {
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, "#") {
+		return nil
+	}
+
+	action, pattern, ok := strings.Cut(line, " ")
+	pattern = strings.TrimSpace(pattern)
+	if !ok || pattern == "" {
+		return fmt.Errorf("invalid filter rule %q (expected \"+ pattern\" or \"- pattern\")", line)
+	}
+
+	var rule filterRule
+	switch action {
+	case "+", "include":
+		rule.include = true
+	case "-", "exclude":
+	default:
+		return fmt.Errorf("invalid filter rule %q (expected \"+ pattern\" or \"- pattern\")", line)
+	}
+
+	if strings.HasSuffix(pattern, "/") {
+		rule.pattern.dirOnly = true
+		pattern = strings.TrimSuffix(pattern, "/")
+	}
+	rule.pattern.pattern = pattern
+	f.rules = append(f.rules, rule)
+	return nil
+}
+
+// Match returns whether the first rule matching path includes it. matched
+// is false if no rule matches, leaving the decision to the ignore files.
+func (f *FilterRules) Match(path string, isDir bool) (include, matched bool) {
+	path = filepath.ToSlash(path)
+	for _, r := range f.rules {
+		if r.pattern.dirOnly && !isDir {
+			continue
+		}
+		if matchPattern(r.pattern.pattern, path) {
+			return r.include, true
+		}
+	}
+	return false, false
+}
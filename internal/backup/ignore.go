@@ -38,29 +38,35 @@ func (m *IgnoreMatcher) LoadFile(path string) error {
 
 	scanner := bufio.NewScanner(file)
 	for scanner.Scan() {
-		line := strings.TrimSpace(scanner.Text())
-		if line == "" || strings.HasPrefix(line, "#") {
-			continue
-		}
+		m.AddPattern(scanner.Text())
+	}
 
-		pattern := ignorePattern{pattern: line}
+	return scanner.Err()
+}
 
-		// Check for negation
-		if strings.HasPrefix(line, "!") {
-			pattern.negation = true
-			pattern.pattern = line[1:]
-		}
+// AddPattern adds one pattern in .gitignore syntax. Blank lines and
+// comments are ignored.
+func (m *IgnoreMatcher) AddPattern(line string) {
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, "#") {
+		return
+	}
 
-		// Check for directory-only match
-		if strings.HasSuffix(pattern.pattern, "/") {
-			pattern.dirOnly = true
-			pattern.pattern = strings.TrimSuffix(pattern.pattern, "/")
-		}
+	pattern := ignorePattern{pattern: line}
 
-		m.patterns = append(m.patterns, pattern)
+	// Check for negation
+	if strings.HasPrefix(line, "!") {
+		pattern.negation = true
+		pattern.pattern = line[1:]
 	}
 
-	return scanner.Err()
+	// Check for directory-only match
+	if strings.HasSuffix(pattern.pattern, "/") {
+		pattern.dirOnly = true
+		pattern.pattern = strings.TrimSuffix(pattern.pattern, "/")
+	}
+
+	m.patterns = append(m.patterns, pattern)
 }
 
 // Match checks if a path should be ignored
@@ -0,0 +1,123 @@
+package backup
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultMinConcurrency and DefaultMaxConcurrency bound the adaptive
+	// number of blocks uploaded in parallel
+	DefaultMinConcurrency = 2
+	DefaultMaxConcurrency = 64
+
+	// congestionFactor is how much slower than the best observed upload
+	// (per byte) an upload may be before the limit is cut
+	congestionFactor = 2.0
+
+	// uploadOverhead is added to each block's size when comparing uploads,
+	// so small blocks dominated by the request round trip compare fairly
+	uploadOverhead = 64 << 10
+)
+
+// limiter bounds the number of blocks uploaded in parallel. An adaptive
+// limiter adjusts the bound with AIMD: it grows by one after a full window
+// of fast uploads and shrinks by a third when uploads slow down (latency
+// is a sign of queueing or retries) or fail.
+type limiter struct {
+	mu       sync.Mutex
+	cond     *sync.Cond
+	limit    int
+	inFlight int
+
+	adaptive     bool
+	min, max     int
+	baseline     float64 // Lowest observed seconds per byte
+	successes    int     // Fast uploads since the last change
+	lastDecrease time.Time
+}
+
+func newLimiter(n int) *limiter {
+	l := &limiter{limit: max(n, 1)}
+	l.cond = sync.NewCond(&l.mu)
+	return l
+}
+
+func newAdaptiveLimiter(minLimit, maxLimit int) *limiter {
+	minLimit = max(minLimit, 1)
+	maxLimit = max(maxLimit, minLimit)
+	l := newLimiter(minLimit)
+	l.adaptive = true
+	l.min, l.max = minLimit, maxLimit
+	return l
+}
+
+func (l *limiter) acquire() {
+	l.mu.Lock()
+	for l.inFlight >= l.limit {
+		l.cond.Wait()
+	}
+	l.inFlight++
+	l.mu.Unlock()
+}
+
+func (l *limiter) release() {
+	l.mu.Lock()
+	l.inFlight--
+	l.mu.Unlock()
+	l.cond.Signal()
+}
+
+// current returns the current limit
+func (l *limiter) current() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.limit
+}
+
+// observe records an upload of size bytes that took latency, or failed
+func (l *limiter) observe(size int, latency time.Duration, err error) {
+	if !l.adaptive {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if err != nil {
+		l.decrease(latency)
+		return
+	}
+
+	cost := latency.Seconds() / float64(size+uploadOverhead)
+	if l.baseline == 0 || cost < l.baseline {
+		l.baseline = cost
+	} else {
+		// Forget the best case slowly, in case the network got worse for good
+		l.baseline *= 1.001
+	}
+
+	if cost > l.baseline*congestionFactor {
+		l.decrease(latency)
+		return
+	}
+
+	l.successes++
+	if l.successes >= l.limit && l.limit < l.max {
+		l.limit++
+		l.successes = 0
+		logger.Debug("upload concurrency increased", "limit", l.limit)
+		l.cond.Signal()
+	}
+}
+
+// decrease cuts the limit, at most once per round trip so the uploads
+// already in flight at the old limit don't cut it again
+func (l *limiter) decrease(latency time.Duration) {
+	l.successes = 0
+	if time.Since(l.lastDecrease) < latency || l.limit == l.min {
+		return
+	}
+	l.limit = max(l.limit*2/3, l.min)
+	l.lastDecrease = time.Now()
+	logger.Debug("upload concurrency decreased", "limit", l.limit)
+}
@@ -17,12 +17,27 @@ const (
 
 // Manifest represents a backup manifest
 type Manifest struct {
-	ID        string            `json:"id"`
-	Tags      map[string]string `json:"tags"`
-	CreatedAt time.Time         `json:"created_at"`
-	RootPath  string            `json:"root_path"`
-	RootCID   string            `json:"root_cid,omitempty"` // IPFS CID of the backup root directory
-	Entries   []Entry           `json:"entries"`
+	ID         string            `json:"id"`
+	Tags       map[string]string `json:"tags"`
+	CreatedAt  time.Time         `json:"created_at"`
+	RootPath   string            `json:"root_path"`
+	RootCID    string            `json:"root_cid,omitempty"`    // IPFS CID of the backup root directory
+	ChunkSize  int64             `json:"chunk_size,omitempty"`  // Chunk size used for file blocks (0 = ChunkSize), the maximum for FastCDC
+	KuboCompat bool              `json:"kubo_compat,omitempty"` // Chunked like `ipfs add --cid-version=1`
+	Chunking   string            `json:"chunking,omitempty"`    // ChunkingFastCDC, or empty for fixed-size chunks
+	ChunkMin   int64             `json:"chunk_min,omitempty"`   // FastCDC minimum and average chunk size
+	ChunkAvg   int64             `json:"chunk_avg,omitempty"`
+	Entries    []Entry           `json:"entries"`
+	Errors     []EntryError      `json:"errors,omitempty"` // Paths left out because they couldn't be read
+
+	// Zero-knowledge mode: the sealed manifest, plus the block CIDs in it
+	// so the server can track references without decrypting anything
+	Encrypted []byte   `json:"encrypted,omitempty"`
+	CIDs      []string `json:"cids,omitempty"`
+
+	// Ed25519 signature by the creating client (hex), see SignManifest
+	SignedBy  string `json:"signed_by,omitempty"`
+	Signature string `json:"signature,omitempty"`
 }
 
 // Entry represents a single file/directory/symlink in a manifest
@@ -34,7 +49,18 @@ type Entry struct {
 	Size       int64    `json:"size,omitempty"`        // Original size (files only)
 	CID        string   `json:"cid,omitempty"`         // IPFS CID of this entry (for multi-block files, this is the file node CID)
 	Blocks     []string `json:"blocks,omitempty"`      // Raw block CIDs (files only)
+	BlockSizes []int64  `json:"block_sizes,omitempty"` // Original size of each block, for content-defined chunks
 	LinkTarget string   `json:"link_target,omitempty"` // Symlink target (symlinks only)
+	Ctime      int64    `json:"ctime,omitempty"`       // Inode change time (nanoseconds), with ChangeCtime
+	Inode      uint64   `json:"inode,omitempty"`       // Inode number, with ChangeInode
+
+	Xattrs map[string][]byte `json:"xattrs,omitempty"` // Extended attributes, with POSIX ACLs as system.posix_acl_*
+}
+
+// EntryError is a path left out of a backup, and why
+type EntryError struct {
+	Path  string `json:"path"`
+	Error string `json:"error"`
 }
 
 // Block represents a content-addressed data block
@@ -56,6 +82,68 @@ func NewManifest(tags map[string]string, rootPath string) *Manifest {
 	}
 }
 
+// EffectiveChunkSize returns the chunk size used for the manifest's blocks
+func (m *Manifest) EffectiveChunkSize() int64 {
+	if m.ChunkSize > 0 {
+		return m.ChunkSize
+	}
+	return ChunkSize
+}
+
+// FileBlockSizes returns the original size of each block of a file entry,
+// or nil if they don't add up to the file's size
+func (m *Manifest) FileBlockSizes(e *Entry) []int64 {
+	sizes := e.BlockSizes
+	if len(sizes) != len(e.Blocks) {
+		// All chunks are full size except the last one
+		chunkSize := m.EffectiveChunkSize()
+		sizes = make([]int64, len(e.Blocks))
+		for i := range sizes {
+			sizes[i] = min(chunkSize, e.Size-int64(i)*chunkSize)
+		}
+	}
+
+	var total int64
+	for _, size := range sizes {
+		if size <= 0 {
+			return nil
+		}
+		total += size
+	}
+	if total != e.Size {
+		return nil
+	}
+	return sizes
+}
+
+// SameChunking reports whether two manifests split files the same way, so
+// blocks of one can be reused for unchanged files of the other
+func (m *Manifest) SameChunking(other *Manifest) bool {
+	return m.EffectiveChunkSize() == other.EffectiveChunkSize() && m.KuboCompat == other.KuboCompat &&
+		m.Chunking == other.Chunking && m.ChunkMin == other.ChunkMin && m.ChunkAvg == other.ChunkAvg
+}
+
+// BlockCIDs returns the unique block CIDs referenced by the manifest
+func (m *Manifest) BlockCIDs() []string {
+	seen := make(map[string]bool)
+	var cids []string
+	add := func(c string) {
+		if !seen[c] {
+			seen[c] = true
+			cids = append(cids, c)
+		}
+	}
+	for _, entry := range m.Entries {
+		for _, c := range entry.Blocks {
+			add(c)
+		}
+	}
+	for _, c := range m.CIDs {
+		add(c)
+	}
+	return cids
+}
+
 // AddEntry adds an entry to the manifest
 func (m *Manifest) AddEntry(entry Entry) {
 	m.Entries = append(m.Entries, entry)
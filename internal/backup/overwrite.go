@@ -0,0 +1,104 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"slices"
+)
+
+// Overwrite policies for entries that already exist where a backup is
+// restored
+const (
+	OverwriteAlways      = "always"       // Restore over them
+	OverwriteNever       = "never"        // Keep them
+	OverwriteIfNewer     = "if-newer"     // Restore over them if the backup's is newer
+	OverwriteIfDifferent = "if-different" // Restore over them unless their content is the backup's
+)
+
+// ValidateOverwrite checks an overwrite policy
+func ValidateOverwrite(policy string) error {
+	switch policy {
+	case OverwriteAlways, OverwriteNever, OverwriteIfNewer, OverwriteIfDifferent:
+		return nil
+	}
+	return fmt.Errorf("unknown overwrite policy %q (expected always, never, if-newer or if-different)", policy)
+}
+
+// keepExisting reports whether the file or symlink at fullPath stays as it
+// is instead of being restored from entry
+func (r *Restorer) keepExisting(ctx context.Context, entry *Entry, fullPath string, manifest *Manifest) (bool, error) {
+	if r.overwrite == "" || r.overwrite == OverwriteAlways {
+		return false, nil
+	}
+	info, err := os.Lstat(fullPath)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	switch r.overwrite {
+	case OverwriteNever:
+		return true, nil
+	case OverwriteIfNewer:
+		return info.ModTime().UnixNano() >= entry.Mtime, nil
+	}
+
+	// If different: symlinks by target, files by size and mtime, and if only
+	// the mtime differs by the blocks their content would be chunked into
+	if entry.Type == FileTypeSymlink {
+		if info.Mode()&os.ModeSymlink == 0 {
+			return false, nil
+		}
+		target, err := os.Readlink(fullPath)
+		return err == nil && target == entry.LinkTarget, nil
+	}
+	if !info.Mode().IsRegular() || info.Size() != entry.Size {
+		return false, nil
+	}
+	if info.ModTime().UnixNano() == entry.Mtime {
+		return true, nil
+	}
+	blocks, err := r.fileBlocks(ctx, fullPath, manifest)
+	if err != nil {
+		return false, nil // Can't tell, restore it
+	}
+	return slices.Equal(blocks, entry.Blocks), nil
+}
+
+// fileBlocks chunks a local file the way the manifest's backup did and
+// returns the CIDs of its blocks
+func (r *Restorer) fileBlocks(ctx context.Context, path string, manifest *Manifest) ([]string, error) {
+	var chunker *Chunker
+	if manifest.Chunking == ChunkingFastCDC {
+		chunker = NewCDCChunker(int(manifest.ChunkMin), int(manifest.ChunkAvg), int(manifest.EffectiveChunkSize()))
+	} else {
+		chunker = NewChunkerWithSize(int(manifest.EffectiveChunkSize()))
+	}
+	chunker.SetBudget(r.budget)
+
+	var blocks []string
+	var firstErr error
+	for chunk := range chunker.ChunkFile(ctx, path) {
+		chunker.Release(chunk)
+		if firstErr != nil {
+			continue
+		}
+		if chunk.Error != nil {
+			firstErr = chunk.Error
+			continue
+		}
+		if r.key != nil {
+			sealed, err := sealChunk(r.key, chunk)
+			if err != nil {
+				firstErr = err
+				continue
+			}
+			chunk = sealed
+		}
+		blocks = append(blocks, chunk.CID)
+	}
+	return blocks, firstErr
+}
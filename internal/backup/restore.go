@@ -3,10 +3,13 @@ package backup
 import (
 	"context"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
-	"sync"
+	"sync/atomic"
 	"time"
+
+	"github.com/johann/ib/internal/encryption"
 )
 
 // BlockFetcher is an interface for fetching blocks
@@ -18,6 +21,14 @@ type BlockFetcher interface {
 type Restorer struct {
 	fetcher     BlockFetcher
 	concurrency int
+	key         *encryption.Key
+	budget      *MemoryBudget
+	xattrs      XattrOptions
+	selection   *Selection
+	overwrite   string // Overwrite policy, OverwriteAlways if empty
+
+	progressJSON io.Writer        // See SetProgressJSON
+	progress     *RestoreProgress // Of the running Restore call
 }
 
 // NewRestorer creates a new restorer
@@ -28,6 +39,35 @@ func NewRestorer(fetcher BlockFetcher, concurrency int) *Restorer {
 	}
 }
 
+// SetKey enables decryption of blocks written by an encrypting Creator
+func (r *Restorer) SetKey(key *encryption.Key) {
+	r.key = key
+}
+
+// SetMemoryBudget bounds the downloaded blocks waiting to be written
+func (r *Restorer) SetMemoryBudget(budget *MemoryBudget) {
+	r.budget = budget
+}
+
+// SetXattrs restores the selected extended attributes and ACLs of the
+// backup. Attributes outside the user namespace may need root.
+func (r *Restorer) SetXattrs(opts XattrOptions) {
+	r.xattrs = opts
+}
+
+// SetSelection restores only the selected entries, so the blocks of other
+// files are never downloaded
+func (r *Restorer) SetSelection(s *Selection) {
+	r.selection = s
+}
+
+// SetOverwrite sets what happens to files and symlinks that already exist
+// in the output directory, see the Overwrite constants. Kept files are
+// neither downloaded nor touched.
+func (r *Restorer) SetOverwrite(policy string) {
+	r.overwrite = policy
+}
+
 // Restore restores a manifest to the given output path
 func (r *Restorer) Restore(ctx context.Context, manifest *Manifest, outputPath string) error {
 	// Create output directory
@@ -35,8 +75,34 @@ func (r *Restorer) Restore(ctx context.Context, manifest *Manifest, outputPath s
 		return fmt.Errorf("failed to create output directory: %w", err)
 	}
 
+	entries := r.selection.Filter(manifest.Entries)
+	if len(entries) == 0 && len(manifest.Entries) > 0 {
+		return fmt.Errorf("no entries match the selected paths")
+	}
+
+	progress := &RestoreProgress{StartTime: time.Now()}
+	progress.CurrentFile.Store("")
+	for _, entry := range entries {
+		if entry.Type == FileTypeFile {
+			progress.TotalFiles++
+			progress.TotalBytes += entry.Size
+		}
+	}
+	r.progress = progress
+
+	progressCtx, cancelProgress := context.WithCancel(ctx)
+	progressDone := make(chan struct{})
+	go func() {
+		defer close(progressDone)
+		r.reportProgress(progressCtx, progress)
+	}()
+	defer func() {
+		cancelProgress()
+		<-progressDone
+	}()
+
 	// First pass: create directories
-	for _, entry := range manifest.Entries {
+	for _, entry := range entries {
 		if entry.Type == FileTypeDir {
 			dirPath := filepath.Join(outputPath, entry.Path)
 			if err := os.MkdirAll(dirPath, os.FileMode(entry.Mode)); err != nil {
@@ -45,15 +111,46 @@ func (r *Restorer) Restore(ctx context.Context, manifest *Manifest, outputPath s
 		}
 	}
 
-	// Second pass: restore files and symlinks
-	for _, entry := range manifest.Entries {
+	// Second pass: restore files and symlinks the overwrite policy doesn't
+	// keep. Identical files are kept too but still get the backup's metadata.
+	kept := make(map[string]bool)
+	var keptFiles int
+	for _, entry := range entries {
 		fullPath := filepath.Join(outputPath, entry.Path)
 
+		if entry.Type == FileTypeFile {
+			progress.CurrentFile.Store(entry.Path)
+		}
+		if entry.Type == FileTypeFile || entry.Type == FileTypeSymlink {
+			keep, err := r.keepExisting(ctx, &entry, fullPath, manifest)
+			if err != nil {
+				return fmt.Errorf("failed to check existing %s: %w", entry.Path, err)
+			}
+			if keep {
+				keptFiles++
+				kept[entry.Path] = r.overwrite != OverwriteIfDifferent
+				if entry.Type == FileTypeFile {
+					atomic.AddInt64(&progress.KeptFiles, 1)
+					atomic.AddInt64(&progress.CompletedFiles, 1)
+					atomic.AddInt64(&progress.CompletedBytes, entry.Size)
+				}
+				continue
+			}
+			// Replace what is in the way instead of writing through symlinks
+			if info, err := os.Lstat(fullPath); err == nil && !info.IsDir() &&
+				(entry.Type == FileTypeSymlink || !info.Mode().IsRegular()) {
+				if err := os.Remove(fullPath); err != nil {
+					return fmt.Errorf("failed to replace %s: %w", entry.Path, err)
+				}
+			}
+		}
+
 		switch entry.Type {
 		case FileTypeFile:
-			if err := r.restoreFile(ctx, &entry, fullPath); err != nil {
+			if err := r.restoreFile(ctx, &entry, fullPath, manifest.EffectiveChunkSize()); err != nil {
 				return fmt.Errorf("failed to restore file %s: %w", entry.Path, err)
 			}
+			atomic.AddInt64(&progress.CompletedFiles, 1)
 
 		case FileTypeSymlink:
 			if err := os.Symlink(entry.LinkTarget, fullPath); err != nil {
@@ -62,13 +159,23 @@ func (r *Restorer) Restore(ctx context.Context, manifest *Manifest, outputPath s
 		}
 	}
 
-	// Third pass: restore permissions and timestamps
-	for _, entry := range manifest.Entries {
+	// Third pass: restore extended attributes, permissions and timestamps.
+	// ACLs go first, so the mode sets their mask as it was.
+	for _, entry := range entries {
 		fullPath := filepath.Join(outputPath, entry.Path)
+		if kept[entry.Path] {
+			continue
+		}
+
+		if len(entry.Xattrs) > 0 && r.xattrs.enabled() {
+			if err := writeXattrs(fullPath, entry.Xattrs, r.xattrs); err != nil {
+				logger.Warn("failed to set extended attributes", "path", entry.Path, "error", err)
+			}
+		}
 
 		if entry.Type != FileTypeSymlink {
 			if err := os.Chmod(fullPath, os.FileMode(entry.Mode)); err != nil {
-				fmt.Printf("Warning: failed to set permissions for %s: %v\n", entry.Path, err)
+				logger.Warn("failed to set permissions", "path", entry.Path, "error", err)
 			}
 		}
 
@@ -79,79 +186,121 @@ func (r *Restorer) Restore(ctx context.Context, manifest *Manifest, outputPath s
 			continue
 		}
 		if err := os.Chtimes(fullPath, mtime, mtime); err != nil {
-			fmt.Printf("Warning: failed to set mtime for %s: %v\n", entry.Path, err)
+			logger.Warn("failed to set mtime", "path", entry.Path, "error", err)
 		}
 	}
 
+	progress.CurrentFile.Store("")
+	st := progress.Status()
+	if r.progressJSON != nil {
+		st.Done = true
+		r.writeStatus(st)
+	} else {
+		fmt.Printf("\nRestored %d files (%s downloaded) in %s\n", st.Files-st.KeptFiles,
+			formatBytes(st.DownloadedBytes), (time.Duration(st.ElapsedMs) * time.Millisecond).Round(time.Second))
+	}
+	if keptFiles > 0 {
+		fmt.Printf("Kept %d existing files (--overwrite=%s)\n", keptFiles, r.overwrite)
+	}
 	return nil
 }
 
-func (r *Restorer) restoreFile(ctx context.Context, entry *Entry, outputPath string) error {
+func (r *Restorer) restoreFile(ctx context.Context, entry *Entry, outputPath string, chunkSize int64) error {
 	if len(entry.Blocks) == 0 {
 		// Empty file
 		return os.WriteFile(outputPath, nil, os.FileMode(entry.Mode))
 	}
 
-	// Download and assemble blocks concurrently
-	blocks := make([][]byte, len(entry.Blocks))
-	var mu sync.Mutex
-	var wg sync.WaitGroup
-	errChan := make(chan error, len(entry.Blocks))
-
-	sem := make(chan struct{}, r.concurrency)
+	file, err := os.OpenFile(outputPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, os.FileMode(entry.Mode))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
 
-	for i, cid := range entry.Blocks {
-		wg.Add(1)
-		go func(idx int, blockCID string) {
-			defer wg.Done()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
 
-			sem <- struct{}{}
-			defer func() { <-sem }()
+	// Download blocks concurrently and write them in order as they arrive.
+	// Budget is taken in block order, so the next block to write always
+	// has its share and writing can't stall behind later blocks.
+	type result struct {
+		data []byte
+		err  error
+	}
+	results := make([]chan result, len(entry.Blocks))
+	for i := range results {
+		results[i] = make(chan result, 1)
+	}
+	dispatched := make(chan int, 1)
 
-			data, err := r.fetcher.DownloadBlock(ctx, blockCID)
-			if err != nil {
-				errChan <- fmt.Errorf("failed to download block %s: %w", blockCID, err)
+	go func() {
+		sem := make(chan struct{}, r.concurrency)
+		n := 0
+		defer func() { dispatched <- n }()
+		for i, blockCID := range entry.Blocks {
+			if err := r.budget.Acquire(ctx, chunkSize); err != nil {
+				return
+			}
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+				r.budget.Release(chunkSize)
 				return
 			}
+			n++
+			go func(idx int, blockCID string) {
+				defer func() { <-sem }()
+				data, err := r.fetchBlock(ctx, blockCID)
+				results[idx] <- result{data: data, err: err}
+			}(i, blockCID)
+		}
+	}()
 
-			mu.Lock()
-			blocks[idx] = data
-			mu.Unlock()
-		}(i, cid)
+	written := 0
+	for ; written < len(results); written++ {
+		var res result
+		next := written + 1 // First result not received yet
+		select {
+		case res = <-results[written]:
+		case <-ctx.Done():
+			res.err = ctx.Err()
+			next = written
+		}
+		if next > written {
+			if res.err == nil {
+				_, res.err = file.Write(res.data)
+				atomic.AddInt64(&r.progress.DownloadedBytes, int64(len(res.data)))
+				atomic.AddInt64(&r.progress.CompletedBytes, int64(len(res.data)))
+			}
+			r.budget.Release(chunkSize)
+		}
+		if res.err != nil {
+			// Wait for the downloads in flight and return their budget
+			cancel()
+			n := <-dispatched
+			for i := next; i < n; i++ {
+				<-results[i]
+				r.budget.Release(chunkSize)
+			}
+			return res.err
+		}
 	}
+	<-dispatched
 
-	wg.Wait()
-	close(errChan)
-
-	// Check for errors
-	for err := range errChan {
-		return err
-	}
+	return nil
+}
 
-	// Create the file
-	file, err := os.OpenFile(outputPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, os.FileMode(entry.Mode))
+// fetchBlock downloads a block and decrypts it if needed
+func (r *Restorer) fetchBlock(ctx context.Context, blockCID string) ([]byte, error) {
+	data, err := r.fetcher.DownloadBlock(ctx, blockCID)
 	if err != nil {
-		return err
+		return nil, fmt.Errorf("failed to download block %s: %w", blockCID, err)
 	}
-	defer file.Close()
-
-	// Write blocks in order
-	// Note: blocks are compressed, we need to track original sizes
-	// For now, assume blocks are already decompressed by the client
-	for _, block := range blocks {
-		if _, err := file.Write(block); err != nil {
-			return err
+	if r.key != nil {
+		data, err = openBlock(r.key, data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt block %s: %w", blockCID, err)
 		}
 	}
-
-	return nil
-}
-
-// RestoreProgress represents progress information
-type RestoreProgress struct {
-	TotalFiles     int
-	CompletedFiles int
-	TotalBytes     int64
-	DownloadedBytes int64
-	CurrentFile    string
+	return data, nil
 }
@@ -0,0 +1,126 @@
+package backup
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+// RestoreProgress tracks restore progress
+type RestoreProgress struct {
+	TotalFiles      int64
+	CompletedFiles  int64 // Restored or kept
+	KeptFiles       int64 // Existing files kept by the overwrite policy
+	TotalBytes      int64
+	CompletedBytes  int64 // Of completed files and of blocks written so far
+	DownloadedBytes int64
+	CurrentFile     atomic.Value
+	StartTime       time.Time
+}
+
+// RestoreStatus is a snapshot of a restore's progress, as printed by
+// --json-progress
+type RestoreStatus struct {
+	ElapsedMs       int64  `json:"elapsed_ms"`
+	Files           int64  `json:"files"`
+	TotalFiles      int64  `json:"total_files"`
+	KeptFiles       int64  `json:"kept_files"`
+	Bytes           int64  `json:"bytes"`
+	TotalBytes      int64  `json:"total_bytes"`
+	DownloadedBytes int64  `json:"downloaded_bytes"`
+	CurrentFile     string `json:"current_file,omitempty"`
+	ETAMs           int64  `json:"eta_ms,omitempty"` // Zero until anything was downloaded
+	Done            bool   `json:"done,omitempty"`
+}
+
+// Status returns the current progress. The ETA assumes the rest downloads
+// at the average speed so far.
+func (p *RestoreProgress) Status() RestoreStatus {
+	elapsed := time.Since(p.StartTime)
+	st := RestoreStatus{
+		ElapsedMs:       elapsed.Milliseconds(),
+		Files:           atomic.LoadInt64(&p.CompletedFiles),
+		TotalFiles:      atomic.LoadInt64(&p.TotalFiles),
+		KeptFiles:       atomic.LoadInt64(&p.KeptFiles),
+		Bytes:           atomic.LoadInt64(&p.CompletedBytes),
+		TotalBytes:      atomic.LoadInt64(&p.TotalBytes),
+		DownloadedBytes: atomic.LoadInt64(&p.DownloadedBytes),
+	}
+	st.CurrentFile, _ = p.CurrentFile.Load().(string)
+	if st.DownloadedBytes > 0 && st.TotalBytes > st.Bytes {
+		speed := float64(st.DownloadedBytes) / elapsed.Seconds()
+		st.ETAMs = int64(float64(st.TotalBytes-st.Bytes) / speed * 1000)
+	}
+	return st
+}
+
+// Status returns the progress of the running or last Restore call
+func (r *Restorer) Status() RestoreStatus {
+	if r.progress == nil {
+		return RestoreStatus{}
+	}
+	return r.progress.Status()
+}
+
+// SetProgressJSON writes the progress to w as one RestoreStatus JSON object
+// per line every second, the last one with Done set, instead of printing it
+// every few seconds
+func (r *Restorer) SetProgressJSON(w io.Writer) {
+	r.progressJSON = w
+}
+
+func (r *Restorer) reportProgress(ctx context.Context, p *RestoreProgress) {
+	interval := 5 * time.Second
+	if r.progressJSON != nil {
+		interval = time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			st := p.Status()
+			if r.progressJSON != nil {
+				r.writeStatus(st)
+				continue
+			}
+
+			var pct float64
+			if st.TotalBytes > 0 {
+				pct = float64(st.Bytes) / float64(st.TotalBytes) * 100
+			}
+			fmt.Printf("\n[%s] Progress: %d/%d files, %s/%s (%.1f%%)\n",
+				(time.Duration(st.ElapsedMs) * time.Millisecond).Round(time.Second),
+				st.Files, st.TotalFiles, formatBytes(st.Bytes), formatBytes(st.TotalBytes), pct)
+			if st.ElapsedMs > 0 && st.DownloadedBytes > 0 {
+				speed := float64(st.DownloadedBytes) / (float64(st.ElapsedMs) / 1000)
+				fmt.Printf("  Downloaded: %s (%s/s), ETA %s\n", formatBytes(st.DownloadedBytes),
+					formatBytes(int64(speed)), (time.Duration(st.ETAMs) * time.Millisecond).Round(time.Second))
+			}
+			if st.KeptFiles > 0 {
+				fmt.Printf("  Kept files: %d (already up to date)\n", st.KeptFiles)
+			}
+			if st.CurrentFile != "" {
+				displayPath := st.CurrentFile
+				if len(displayPath) > 60 {
+					displayPath = "..." + displayPath[len(displayPath)-57:]
+				}
+				fmt.Printf("  Current: %s\n", displayPath)
+			}
+		}
+	}
+}
+
+func (r *Restorer) writeStatus(st RestoreStatus) {
+	data, err := json.Marshal(st)
+	if err != nil {
+		return
+	}
+	r.progressJSON.Write(append(data, '\n'))
+}
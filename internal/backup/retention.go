@@ -0,0 +1,125 @@
+package backup
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RetentionPolicy decides which backups of a name to keep, like restic's
+// forget: the Last newest, and the newest of each of the Daily last days,
+// Weekly last ISO weeks and Monthly last months that have a backup, in
+// local time. A backup kept by any rule is kept.
+type RetentionPolicy struct {
+	Last    int `json:"keep_last,omitempty"`
+	Daily   int `json:"keep_daily,omitempty"`
+	Weekly  int `json:"keep_weekly,omitempty"`
+	Monthly int `json:"keep_monthly,omitempty"`
+}
+
+// ParseRetentionPolicy parses a policy like "keep-last=7,keep-daily=14"
+func ParseRetentionPolicy(s string) (RetentionPolicy, error) {
+	var p RetentionPolicy
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(part, "=")
+		n, err := strconv.Atoi(value)
+		if !ok || err != nil || n < 0 {
+			return p, fmt.Errorf("invalid retention rule %q (expected keep-<last|daily|weekly|monthly>=<count>)", part)
+		}
+		switch key {
+		case "keep-last":
+			p.Last = n
+		case "keep-daily":
+			p.Daily = n
+		case "keep-weekly":
+			p.Weekly = n
+		case "keep-monthly":
+			p.Monthly = n
+		default:
+			return p, fmt.Errorf("invalid retention rule %q (expected keep-<last|daily|weekly|monthly>=<count>)", part)
+		}
+	}
+	return p, nil
+}
+
+// Empty reports whether the policy keeps everything
+func (p RetentionPolicy) Empty() bool {
+	return p.Last == 0 && p.Daily == 0 && p.Weekly == 0 && p.Monthly == 0
+}
+
+// Validate checks that no count is negative
+func (p RetentionPolicy) Validate() error {
+	if p.Last < 0 || p.Daily < 0 || p.Weekly < 0 || p.Monthly < 0 {
+		return fmt.Errorf("--keep-* counts can't be negative")
+	}
+	return nil
+}
+
+// String describes the policy, e.g. "last 3, daily 7"
+func (p RetentionPolicy) String() string {
+	var s string
+	add := func(name string, n int) {
+		if n == 0 {
+			return
+		}
+		if s != "" {
+			s += ", "
+		}
+		s += fmt.Sprintf("%s %d", name, n)
+	}
+	add("last", p.Last)
+	add("daily", p.Daily)
+	add("weekly", p.Weekly)
+	add("monthly", p.Monthly)
+	return s
+}
+
+// Keep reports for each creation time whether the policy keeps that backup
+func (p RetentionPolicy) Keep(created []time.Time) []bool {
+	keep := make([]bool, len(created))
+	if p.Empty() {
+		for i := range keep {
+			keep[i] = true
+		}
+		return keep
+	}
+
+	// Newest first
+	order := make([]int, len(created))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return created[order[a]].After(created[order[b]])
+	})
+
+	bucket := func(n int, key func(t time.Time) string) {
+		seen := make(map[string]bool)
+		for _, i := range order {
+			if len(seen) == n {
+				return
+			}
+			k := key(created[i].Local())
+			if !seen[k] {
+				seen[k] = true
+				keep[i] = true
+			}
+		}
+	}
+	for _, i := range order[:min(p.Last, len(order))] {
+		keep[i] = true
+	}
+	bucket(p.Daily, func(t time.Time) string { return t.Format("2006-01-02") })
+	bucket(p.Weekly, func(t time.Time) string {
+		year, week := t.ISOWeek()
+		return fmt.Sprintf("%d-%d", year, week)
+	})
+	bucket(p.Monthly, func(t time.Time) string { return t.Format("2006-01") })
+	return keep
+}
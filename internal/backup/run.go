@@ -0,0 +1,55 @@
+package backup
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// RunSummary is what the client reports to the server after a backup run,
+// successful or not
+type RunSummary struct {
+	ManifestID     string            `json:"manifest_id,omitempty"` // Empty if the run failed
+	Tags           map[string]string `json:"tags"`
+	StartedAt      time.Time         `json:"started_at"`
+	DurationMs     int64             `json:"duration_ms"`
+	TotalFiles     int64             `json:"total_files"`
+	UnchangedFiles int64             `json:"unchanged_files"`
+	ErrorFiles     int64             `json:"error_files"` // Unreadable files left out of the backup
+	TotalBytes     int64             `json:"total_bytes"`
+	UploadedBytes  int64             `json:"uploaded_bytes"`
+	DedupBytes     int64             `json:"dedup_bytes"` // Bytes of blocks the server already had
+	BlocksUploaded int64             `json:"blocks_uploaded"`
+	BlocksDeduped  int64             `json:"blocks_deduped"`
+	Error          string            `json:"error,omitempty"`
+}
+
+// DedupRatio is the share of the run's block bytes that didn't need uploading
+func (r *RunSummary) DedupRatio() float64 {
+	total := r.UploadedBytes + r.DedupBytes
+	if total == 0 {
+		return 0
+	}
+	return float64(r.DedupBytes) / float64(total)
+}
+
+// Summary returns the summary of the last Create call, or nil if there was
+// none. ManifestID and Error are left for the caller to fill in.
+func (c *Creator) Summary(tags map[string]string) *RunSummary {
+	p := c.progress
+	if p == nil {
+		return nil
+	}
+	return &RunSummary{
+		Tags:           tags,
+		StartedAt:      p.StartTime,
+		DurationMs:     time.Since(p.StartTime).Milliseconds(),
+		TotalFiles:     atomic.LoadInt64(&p.TotalFiles),
+		UnchangedFiles: atomic.LoadInt64(&p.SkippedFiles),
+		ErrorFiles:     atomic.LoadInt64(&p.ErrorFiles),
+		TotalBytes:     atomic.LoadInt64(&p.TotalBytes),
+		UploadedBytes:  atomic.LoadInt64(&p.UploadedBytes),
+		DedupBytes:     atomic.LoadInt64(&p.SkippedBytes),
+		BlocksUploaded: atomic.LoadInt64(&p.BlocksUploaded),
+		BlocksDeduped:  atomic.LoadInt64(&p.BlocksSkipped),
+	}
+}
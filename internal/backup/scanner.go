@@ -1,139 +1,351 @@
 package backup
 
 import (
+	"bytes"
+	"context"
+	"io"
 	"io/fs"
 	"os"
 	"path/filepath"
+	"strings"
 )
 
 // ScanResult represents a scanned file entry
 type ScanResult struct {
 	Entry Entry
 	Error error
+	Path  string // Of the entry Error is about, if known
 }
 
 // Scanner handles directory traversal with ignore file support
 type Scanner struct {
 	rootPath      string
 	ignoreMatcher *IgnoreMatcher
+	includes      *IgnoreMatcher // Matches are kept even if ignored
+	filter        *FilterRules
+	xattrs        XattrOptions
+	follow        bool // See SetFollowSymlinks
+	oneFS         bool // See SetOneFileSystem
+	rootDev       uint64
+	markers       []string // See ExcludeIfPresent
+	caches        bool     // See SetExcludeCaches
+	changes       ChangeDetection
 }
 
+// cacheDirSignature starts the CACHEDIR.TAG files of cache directories, see
+// https://bford.info/cachedir/
+const cacheDirSignature = "Signature: 8a477f597d28d172789f06886806bc55"
+
 // NewScanner creates a new scanner for the given root path
 func NewScanner(rootPath string) *Scanner {
 	return &Scanner{
 		rootPath:      rootPath,
 		ignoreMatcher: NewIgnoreMatcher(),
+		includes:      NewIgnoreMatcher(),
+	}
+}
+
+// Exclude adds .gitignore-style patterns to skip
+func (s *Scanner) Exclude(patterns ...string) {
+	for _, p := range patterns {
+		s.ignoreMatcher.AddPattern(p)
+	}
+}
+
+// Include adds .gitignore-style patterns to keep even if the exclude
+// patterns or ignore files would skip them. Like negated patterns in
+// .gitignore, they can't bring back paths inside a skipped directory.
+func (s *Scanner) Include(patterns ...string) {
+	for _, p := range patterns {
+		s.includes.AddPattern(p)
 	}
 }
 
-// Scan traverses the directory and streams results via channel
-func (s *Scanner) Scan() <-chan ScanResult {
+// SetFilter applies ordered include/exclude rules. A matching rule
+// overrides the ignore patterns.
+func (s *Scanner) SetFilter(f *FilterRules) {
+	s.filter = f
+}
+
+// SetXattrs captures the selected extended attributes and ACLs of entries
+func (s *Scanner) SetXattrs(opts XattrOptions) {
+	s.xattrs = opts
+}
+
+// SetFollowSymlinks backs up what symlinks point to instead of the links,
+// so trees that link into other volumes contain the data. A link to a
+// directory the walk is already inside of, which would loop, and a link
+// whose target is missing are kept as links.
+func (s *Scanner) SetFollowSymlinks(follow bool) {
+	s.follow = follow
+}
+
+// SetOneFileSystem keeps the walk on the file system of the root: mount
+// points are backed up as empty directories, like with tar
+// --one-file-system, so backing up / leaves out /proc, network mounts and
+// the backup target. It has no effect on platforms without device numbers.
+func (s *Scanner) SetOneFileSystem(one bool) {
+	s.oneFS = one
+}
+
+// ExcludeIfPresent skips directories that contain a file or directory with
+// one of these names, with everything in them
+func (s *Scanner) ExcludeIfPresent(names ...string) {
+	s.markers = append(s.markers, names...)
+}
+
+// SetExcludeCaches skips cache directories, marked by a CACHEDIR.TAG file
+// with the standard signature, with everything in them
+func (s *Scanner) SetExcludeCaches(exclude bool) {
+	s.caches = exclude
+}
+
+// SetChangeDetection records the fields of files that mode compares, see
+// ChangeDetection
+func (s *Scanner) SetChangeDetection(mode ChangeDetection) {
+	s.changes = mode
+}
+
+// Scan traverses the directory and streams results via channel.
+// Cancelling ctx stops the walk.
+func (s *Scanner) Scan(ctx context.Context) <-chan ScanResult {
 	results := make(chan ScanResult, 100)
 
 	go func() {
 		defer close(results)
 
+		send := func(r ScanResult) error {
+			select {
+			case results <- r:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+
 		// Load root-level ignore files
 		s.ignoreMatcher.LoadFile(filepath.Join(s.rootPath, ".gitignore"))
 		s.ignoreMatcher.LoadFile(filepath.Join(s.rootPath, ".ibignore"))
 
-		err := filepath.WalkDir(s.rootPath, func(path string, d fs.DirEntry, err error) error {
+		if s.oneFS {
+			info, err := os.Stat(s.rootPath)
 			if err != nil {
 				results <- ScanResult{Error: err}
-				return nil // Continue walking
+				return
 			}
+			s.rootDev, _ = fileDevice(info)
+		}
 
-			// Get relative path
-			relPath, err := filepath.Rel(s.rootPath, path)
-			if err != nil {
-				results <- ScanResult{Error: err}
-				return nil
+		err := s.walk(s.rootPath, "", send)
+		if err != nil && ctx.Err() == nil {
+			results <- ScanResult{Error: err}
+		}
+	}()
+
+	return results
+}
+
+// walk walks dir, whose entries are at prefix in the backup: the root, or
+// a followed symlink to a directory
+func (s *Scanner) walk(dir, prefix string, send func(ScanResult) error) error {
+	return filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		// Get relative path, normalized to forward slashes for consistent
+		// matching
+		relPath, relErr := filepath.Rel(dir, path)
+		if relErr != nil {
+			return send(ScanResult{Error: relErr})
+		}
+		relPath = filepath.ToSlash(relPath)
+		switch {
+		case relPath == ".":
+			relPath = prefix
+		case prefix != "":
+			relPath = prefix + "/" + relPath
+		}
+
+		if err != nil {
+			return send(ScanResult{Path: relPath, Error: err}) // Continue walking
+		}
+
+		// Skip the walked directory itself
+		if path == dir {
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return send(ScanResult{Path: relPath, Error: err})
+		}
+
+		followed := false
+		if s.follow && info.Mode()&os.ModeSymlink != 0 {
+			if target, err := os.Stat(path); err != nil {
+				logger.Warn("not following broken symlink", "path", relPath, "error", err)
+			} else if target.IsDir() && s.loops(relPath, target) {
+				logger.Warn("not following symlink to a directory it is in", "path", relPath)
+			} else {
+				info, followed = target, true
 			}
+		}
 
-			// Skip root directory itself
-			if relPath == "." {
-				return nil
+		isDir := info.IsDir()
+
+		// Check if ignored
+		ignored := s.ignoreMatcher.Match(relPath, isDir) && !s.includes.Match(relPath, isDir)
+		if s.filter != nil {
+			if include, ok := s.filter.Match(relPath, isDir); ok {
+				ignored = !include
+			}
+		}
+		if isDir && !ignored {
+			ignored = s.marked(path)
+		}
+		if ignored {
+			if isDir && !followed {
+				return filepath.SkipDir
 			}
+			return nil
+		}
 
-			// Normalize to forward slashes for consistent matching
-			relPath = filepath.ToSlash(relPath)
+		// Load nested ignore files for directories
+		if isDir {
+			gitignorePath := filepath.Join(path, ".gitignore")
+			ibignorePath := filepath.Join(path, ".ibignore")
+			s.ignoreMatcher.LoadFile(gitignorePath)
+			s.ignoreMatcher.LoadFile(ibignorePath)
+		}
 
-			info, err := d.Info()
-			if err != nil {
-				results <- ScanResult{Error: err}
-				return nil
+		// Determine file type
+		mode := info.Mode()
+		var entry Entry
+
+		switch {
+		case mode.IsDir():
+			entry = Entry{
+				Path:  relPath,
+				Type:  FileTypeDir,
+				Mode:  uint32(mode.Perm()),
+				Mtime: info.ModTime().UnixNano(),
 			}
 
-			isDir := d.IsDir()
+		case mode&os.ModeSymlink != 0:
+			// Handle symlink - store target, don't follow
+			target, err := os.Readlink(path)
+			if err != nil {
+				return send(ScanResult{Path: relPath, Error: err})
+			}
+			entry = Entry{
+				Path:       relPath,
+				Type:       FileTypeSymlink,
+				Mode:       uint32(mode.Perm()),
+				Mtime:      info.ModTime().UnixNano(),
+				LinkTarget: target,
+			}
 
-			// Check if ignored
-			if s.ignoreMatcher.Match(relPath, isDir) {
-				if isDir {
-					return filepath.SkipDir
-				}
-				return nil
+		case mode.IsRegular():
+			entry = Entry{
+				Path:  relPath,
+				Type:  FileTypeFile,
+				Mode:  uint32(mode.Perm()),
+				Mtime: info.ModTime().UnixNano(),
+				Size:  info.Size(),
+			}
+			switch s.changes {
+			case ChangeCtime:
+				entry.Ctime, _ = changeTime(info)
+			case ChangeInode:
+				entry.Inode, _ = fileInode(info)
 			}
 
-			// Load nested ignore files for directories
-			if isDir {
-				gitignorePath := filepath.Join(path, ".gitignore")
-				ibignorePath := filepath.Join(path, ".ibignore")
-				s.ignoreMatcher.LoadFile(gitignorePath)
-				s.ignoreMatcher.LoadFile(ibignorePath)
+		default:
+			// Skip special files (sockets, devices, pipes)
+			return nil
+		}
+
+		if s.xattrs.enabled() {
+			attrs, err := readXattrs(path, s.xattrs)
+			if err != nil {
+				logger.Warn("could not read extended attributes", "path", relPath, "error", err)
 			}
+			entry.Xattrs = attrs
+		}
+
+		if err := send(ScanResult{Entry: entry}); err != nil {
+			return err
+		}
 
-			// Determine file type
-			mode := info.Mode()
-			var entry Entry
-
-			switch {
-			case mode.IsDir():
-				entry = Entry{
-					Path:  relPath,
-					Type:  FileTypeDir,
-					Mode:  uint32(mode.Perm()),
-					Mtime: info.ModTime().UnixNano(),
-				}
-
-			case mode&os.ModeSymlink != 0:
-				// Handle symlink - store target, don't follow
-				target, err := os.Readlink(path)
-				if err != nil {
-					results <- ScanResult{Error: err}
-					return nil
-				}
-				entry = Entry{
-					Path:       relPath,
-					Type:       FileTypeSymlink,
-					Mode:       uint32(mode.Perm()),
-					Mtime:      info.ModTime().UnixNano(),
-					LinkTarget: target,
-				}
-
-			case mode.IsRegular():
-				entry = Entry{
-					Path:  relPath,
-					Type:  FileTypeFile,
-					Mode:  uint32(mode.Perm()),
-					Mtime: info.ModTime().UnixNano(),
-					Size:  info.Size(),
-				}
-
-			default:
-				// Skip special files (sockets, devices, pipes)
+		if isDir && s.otherFileSystem(info) {
+			if followed {
 				return nil
 			}
+			return filepath.SkipDir
+		}
 
-			results <- ScanResult{Entry: entry}
-			return nil
-		})
+		// WalkDir doesn't descend into symlinks, walk the target in its place
+		if followed && isDir {
+			target, err := filepath.EvalSymlinks(path)
+			if err != nil {
+				return send(ScanResult{Path: relPath, Error: err})
+			}
+			return s.walk(target, relPath, send)
+		}
+		return nil
+	})
+}
 
-		if err != nil {
-			results <- ScanResult{Error: err}
+// marked reports whether the directory at path is excluded by a marker
+// file or is a tagged cache directory
+func (s *Scanner) marked(path string) bool {
+	for _, name := range s.markers {
+		if _, err := os.Lstat(filepath.Join(path, name)); err == nil {
+			return true
 		}
-	}()
+	}
+	return s.caches && isCacheDir(path)
+}
 
-	return results
+// isCacheDir reports whether the directory at path has a CACHEDIR.TAG file
+// with the standard signature
+func isCacheDir(path string) bool {
+	f, err := os.Open(filepath.Join(path, "CACHEDIR.TAG"))
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	buf := make([]byte, len(cacheDirSignature))
+	if _, err := io.ReadFull(f, buf); err != nil {
+		return false
+	}
+	return bytes.Equal(buf, []byte(cacheDirSignature))
+}
+
+// otherFileSystem reports whether info is on another file system than the
+// root while the walk is kept on the root's
+func (s *Scanner) otherFileSystem(info fs.FileInfo) bool {
+	if !s.oneFS {
+		return false
+	}
+	dev, ok := fileDevice(info)
+	return ok && dev != s.rootDev
+}
+
+// loops reports whether dir, the target of the symlink at relPath, is the
+// root or a directory on the way to the link, so following it would loop
+// forever. Directories are compared by identity, as reached through any
+// links followed on the way.
+func (s *Scanner) loops(relPath string, dir fs.FileInfo) bool {
+	current := s.rootPath
+	parts := strings.Split(relPath, "/")
+	for i := 0; ; i++ {
+		if info, err := os.Stat(current); err == nil && os.SameFile(info, dir) {
+			return true
+		}
+		if i == len(parts)-1 {
+			return false
+		}
+		current = filepath.Join(current, parts[i])
+	}
 }
 
 // IsSpecialFile checks if a file mode represents a special file
@@ -0,0 +1,75 @@
+package backup
+
+import (
+	"path"
+	"strings"
+)
+
+// Selection picks the entries of a manifest to restore: those at or under
+// one of the paths, or matching one of the .gitignore-style patterns, plus
+// the directories above them
+type Selection struct {
+	paths    map[string]bool
+	patterns *IgnoreMatcher
+}
+
+// NewSelection creates a selection from paths relative to the backup root
+// and glob patterns. It returns nil, selecting everything, if both are
+// empty.
+func NewSelection(paths, globs []string) *Selection {
+	if len(paths) == 0 && len(globs) == 0 {
+		return nil
+	}
+	s := &Selection{paths: make(map[string]bool), patterns: NewIgnoreMatcher()}
+	for _, p := range paths {
+		p = path.Clean("/" + strings.ReplaceAll(p, "\\", "/"))
+		s.paths[strings.TrimPrefix(p, "/")] = true
+	}
+	for _, g := range globs {
+		s.patterns.AddPattern(g)
+	}
+	return s
+}
+
+// match reports whether an entry, or a directory above it, was selected
+func (s *Selection) match(p string, isDir bool) bool {
+	if s.paths[""] {
+		return true // The root
+	}
+	for {
+		if s.paths[p] || s.patterns.Match(p, isDir) {
+			return true
+		}
+		i := strings.LastIndex(p, "/")
+		if i < 0 {
+			return false
+		}
+		p, isDir = p[:i], true
+	}
+}
+
+// Filter returns the selected entries and the directories above them, in
+// manifest order
+func (s *Selection) Filter(entries []Entry) []Entry {
+	if s == nil {
+		return entries
+	}
+	keep := make(map[string]bool)
+	for _, e := range entries {
+		if !s.match(e.Path, e.Type == FileTypeDir) {
+			continue
+		}
+		keep[e.Path] = true
+		for dir := path.Dir(e.Path); dir != "." && !keep[dir]; dir = path.Dir(dir) {
+			keep[dir] = true
+		}
+	}
+
+	var selected []Entry
+	for _, e := range entries {
+		if keep[e.Path] {
+			selected = append(selected, e)
+		}
+	}
+	return selected
+}
@@ -0,0 +1,101 @@
+package backup
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// SignManifest signs the manifest with an Ed25519 key. The signature covers
+// everything the client produced; the CIDs the server fills in when building
+// the IPFS DAG are excluded.
+func SignManifest(priv ed25519.PrivateKey, manifest *Manifest) error {
+	manifest.SignedBy = hex.EncodeToString(priv.Public().(ed25519.PublicKey))
+	payload, err := signingPayload(manifest)
+	if err != nil {
+		return err
+	}
+	manifest.Signature = hex.EncodeToString(ed25519.Sign(priv, payload))
+	return nil
+}
+
+// VerifyManifest checks that the manifest is signed by one of the trusted
+// public keys (hex-encoded)
+func VerifyManifest(manifest *Manifest, trusted []string) error {
+	if manifest.Signature == "" {
+		return fmt.Errorf("manifest %s is not signed", manifest.ID)
+	}
+
+	trustedSigner := false
+	for _, k := range trusted {
+		if strings.EqualFold(k, manifest.SignedBy) {
+			trustedSigner = true
+			break
+		}
+	}
+	if !trustedSigner {
+		return fmt.Errorf("manifest %s is signed by untrusted key %s", manifest.ID, manifest.SignedBy)
+	}
+
+	pub, err := hex.DecodeString(manifest.SignedBy)
+	if err != nil || len(pub) != ed25519.PublicKeySize {
+		return fmt.Errorf("manifest %s has an invalid signer key", manifest.ID)
+	}
+	sig, err := hex.DecodeString(manifest.Signature)
+	if err != nil {
+		return fmt.Errorf("manifest %s has an invalid signature", manifest.ID)
+	}
+
+	payload, err := signingPayload(manifest)
+	if err != nil {
+		return err
+	}
+	if !ed25519.Verify(pub, payload, sig) {
+		return fmt.Errorf("manifest %s signature mismatch, it was modified after signing", manifest.ID)
+	}
+	return nil
+}
+
+// signingPayload is the manifest JSON without the signature and without
+// the server-assigned root and entry CIDs
+func signingPayload(manifest *Manifest) ([]byte, error) {
+	unsigned := *manifest
+	unsigned.Signature = ""
+	unsigned.RootCID = ""
+	unsigned.Entries = make([]Entry, len(manifest.Entries))
+	for i, entry := range manifest.Entries {
+		entry.CID = ""
+		unsigned.Entries[i] = entry
+	}
+	return json.Marshal(&unsigned)
+}
+
+// GenerateSigningKey writes a new Ed25519 private key (hex-encoded seed) to
+// path and returns its public key
+func GenerateSigningKey(path string) (string, error) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate signing key: %w", err)
+	}
+	if err := os.WriteFile(path, []byte(hex.EncodeToString(priv.Seed())+"\n"), 0600); err != nil {
+		return "", fmt.Errorf("failed to write signing key: %w", err)
+	}
+	return hex.EncodeToString(pub), nil
+}
+
+// LoadSigningKey reads an Ed25519 private key from GenerateSigningKey
+func LoadSigningKey(path string) (ed25519.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read signing key: %w", err)
+	}
+	seed, err := hex.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil || len(seed) != ed25519.SeedSize {
+		return nil, fmt.Errorf("invalid signing key file %s", path)
+	}
+	return ed25519.NewKeyFromSeed(seed), nil
+}
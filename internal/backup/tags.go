@@ -0,0 +1,51 @@
+package backup
+
+import (
+	"os"
+	"os/user"
+	"runtime"
+	"strings"
+)
+
+// Reserved tags the client adds to every manifest, see HostTags
+const (
+	TagHost    = "host"
+	TagOS      = "os"
+	TagArch    = "arch"
+	TagUser    = "user"
+	TagVersion = "ib_version"
+)
+
+// HostTagKeys lists the reserved tags. The server indexes them so fleets
+// can be filtered by machine without scanning every manifest.
+var HostTagKeys = []string{TagHost, TagOS, TagArch, TagUser, TagVersion}
+
+// HostTags describes the machine, user and client version creating a
+// backup. Values that can't be determined are left out.
+func HostTags(version string) map[string]string {
+	tags := map[string]string{
+		TagOS:   runtime.GOOS,
+		TagArch: runtime.GOARCH,
+	}
+	if host, err := os.Hostname(); err == nil && host != "" {
+		tags[TagHost] = host
+	}
+	if u, err := user.Current(); err == nil && u.Username != "" {
+		tags[TagUser] = u.Username
+	} else if name := os.Getenv("USER"); name != "" {
+		tags[TagUser] = name
+	} else if name := os.Getenv("USERNAME"); name != "" {
+		tags[TagUser] = name
+	}
+	if version != "" {
+		tags[TagVersion] = version
+	}
+	return tags
+}
+
+// InNamespace reports whether a backup name belongs to a namespace: the
+// name itself or names below it like "<namespace>/db". Every name belongs
+// to the empty namespace.
+func InNamespace(name, namespace string) bool {
+	return namespace == "" || name == namespace || strings.HasPrefix(name, namespace+"/")
+}
@@ -0,0 +1,29 @@
+package backup
+
+import "strings"
+
+// Linux keeps POSIX ACLs in these extended attributes, so they are backed
+// up and restored like any other, but only with --acls
+const (
+	xattrACLAccess  = "system.posix_acl_access"
+	xattrACLDefault = "system.posix_acl_default"
+)
+
+// XattrOptions selects the extended attributes to back up or restore
+type XattrOptions struct {
+	Xattrs bool // All but the POSIX ACLs
+	ACLs   bool // POSIX ACLs
+}
+
+func (o XattrOptions) enabled() bool {
+	return o.Xattrs || o.ACLs
+}
+
+// wants reports whether the attribute name is selected
+func (o XattrOptions) wants(name string) bool {
+	if name == xattrACLAccess || name == xattrACLDefault {
+		return o.ACLs
+	}
+	// Other system.* attributes are views of file state, like NFSv4 ACLs
+	return o.Xattrs && !strings.HasPrefix(name, "system.")
+}
@@ -0,0 +1,21 @@
+//go:build !linux && !darwin
+
+package backup
+
+import "fmt"
+
+// readXattrs returns no attributes, this platform isn't supported
+func readXattrs(path string, opts XattrOptions) (map[string][]byte, error) {
+	return nil, nil
+}
+
+// writeXattrs fails if there are attributes to restore, this platform
+// isn't supported
+func writeXattrs(path string, attrs map[string][]byte, opts XattrOptions) error {
+	for name := range attrs {
+		if opts.wants(name) {
+			return fmt.Errorf("extended attributes aren't supported on this platform")
+		}
+	}
+	return nil
+}
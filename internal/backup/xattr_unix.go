@@ -0,0 +1,103 @@
+//go:build linux || darwin
+
+package backup
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+
+	"golang.org/x/sys/unix"
+)
+
+// readXattrs returns the selected extended attributes of path, without
+// following symlinks. File systems without them have none.
+func readXattrs(path string, opts XattrOptions) (map[string][]byte, error) {
+	names, err := xattrList(path)
+	if err != nil {
+		if errors.Is(err, unix.ENOTSUP) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var attrs map[string][]byte
+	for _, name := range names {
+		if !opts.wants(name) {
+			continue
+		}
+		value, err := xattrGet(path, name)
+		if errors.Is(err, unix.ENODATA) {
+			continue // Removed meanwhile
+		}
+		if err != nil {
+			return nil, err
+		}
+		if attrs == nil {
+			attrs = make(map[string][]byte)
+		}
+		attrs[name] = value
+	}
+	return attrs, nil
+}
+
+// writeXattrs sets the selected attributes on path, without following
+// symlinks
+func writeXattrs(path string, attrs map[string][]byte, opts XattrOptions) error {
+	var errs []error
+	for name, value := range attrs {
+		if !opts.wants(name) {
+			continue
+		}
+		if err := unix.Lsetxattr(path, name, value, 0); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// xattrList lists the attribute names of path, retrying if they grow
+// between sizing and reading the list
+func xattrList(path string) ([]string, error) {
+	for {
+		size, err := unix.Llistxattr(path, nil)
+		if err != nil || size == 0 {
+			return nil, err
+		}
+		buf := make([]byte, size)
+		n, err := unix.Llistxattr(path, buf)
+		if errors.Is(err, unix.ERANGE) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		var names []string
+		for _, name := range bytes.Split(buf[:n], []byte{0}) {
+			if len(name) > 0 {
+				names = append(names, string(name))
+			}
+		}
+		return names, nil
+	}
+}
+
+// xattrGet reads one attribute of path, retrying if it grows between sizing
+// and reading it
+func xattrGet(path, name string) ([]byte, error) {
+	for {
+		size, err := unix.Lgetxattr(path, name, nil)
+		if err != nil {
+			return nil, err
+		}
+		buf := make([]byte, size)
+		n, err := unix.Lgetxattr(path, name, buf)
+		if errors.Is(err, unix.ERANGE) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return buf[:n], nil
+	}
+}
@@ -4,29 +4,54 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"math/rand"
+	"net"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
 
+	"github.com/johann/ib/internal/auth"
 	"github.com/johann/ib/internal/backup"
 	"github.com/johann/ib/internal/config"
+	"github.com/johann/ib/internal/logging"
+	"github.com/johann/ib/internal/tracing"
+	"github.com/johann/ib/internal/version"
+	"github.com/klauspost/compress/zstd"
 )
 
 const (
-	maxRetries     = 5
 	baseRetryDelay = 1 * time.Second
 	maxRetryDelay  = 30 * time.Second
 )
 
+var logger = logging.For("client")
+
 // Client is an HTTP client for the backup server
 type Client struct {
 	baseURL    string
 	token      string
+	signingKey []byte // Set to sign requests instead of sending the token
+	tokenID    string
 	httpClient *http.Client
+	retries    int // Of block requests after transient failures
+
+	infoOnce sync.Once
+	info     *version.Info
+	infoErr  error
+}
+
+// Overrides replaces the config's request timeout and retries where set,
+// for command line flags
+var Overrides struct {
+	Timeout time.Duration
+	Retries int // -1 for none
 }
 
 // New creates a new client from config
@@ -35,77 +60,146 @@ func New(cfg *config.ClientConfig) (*Client, error) {
 		return nil, fmt.Errorf("server URL not configured. Run 'ib login <server-url>'")
 	}
 
-	return &Client{
+	timeout := cfg.RequestTimeout()
+	if Overrides.Timeout > 0 {
+		timeout = Overrides.Timeout
+	}
+	retries := cfg.MaxRetries()
+	if Overrides.Retries != 0 {
+		retries = max(Overrides.Retries, 0)
+	}
+
+	c := &Client{
 		baseURL: cfg.ServerURL,
 		token:   cfg.Token,
 		httpClient: &http.Client{
-			Timeout: 5 * time.Minute,
+			Timeout:   timeout,
+			Transport: &rotationNotifier{base: http.DefaultTransport},
 		},
-	}, nil
+		retries: retries,
+	}
+
+	if cfg.SignRequests {
+		id, ok := auth.ParseNamedToken(cfg.Token)
+		if !ok {
+			return nil, fmt.Errorf("request signing requires a named token (ib_...)")
+		}
+		c.tokenID = id
+		c.signingKey = auth.SigningKey(cfg.Token)
+	}
+	return c, nil
 }
 
-// BlockExists checks if a block exists on the server
-func (c *Client) BlockExists(ctx context.Context, cid string) (bool, error) {
-	var lastErr error
+// rotationNotifier warns once when the server reports that the token in
+// use has been rotated out and is only valid for a grace period
+type rotationNotifier struct {
+	base http.RoundTripper
+	once sync.Once
+}
 
-	for attempt := 0; attempt < maxRetries; attempt++ {
-		if attempt > 0 {
-			delay := retryDelay(attempt - 1)
-			select {
-			case <-ctx.Done():
-				return false, ctx.Err()
-			case <-time.After(delay):
-			}
+func (t *rotationNotifier) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.base.RoundTrip(req)
+	if err == nil {
+		if expires := resp.Header.Get("X-IB-Token-Expires"); expires != "" {
+			t.once.Do(func() {
+				logger.Warn("the server token was rotated; run 'ib login' with the new token", "expires", expires)
+			})
 		}
+	}
+	return resp, err
+}
 
+// BlockExists checks if a block exists on the server
+func (c *Client) BlockExists(ctx context.Context, cid string) (bool, error) {
+	var exists bool
+	err := c.retry(ctx, "block exists", cid, func() error {
 		req, err := c.newRequest(ctx, "POST", fmt.Sprintf("/api/blocks/%s/exists", cid), nil)
 		if err != nil {
-			return false, err
+			return err
 		}
-
 		resp, err := c.httpClient.Do(req)
 		if err != nil {
-			if isRetryableError(err) {
-				lastErr = err
-				continue
-			}
-			return false, err
+			return transient(err)
 		}
 		defer resp.Body.Close()
 
-		if resp.StatusCode == http.StatusOK {
-			return true, nil
-		}
-		if resp.StatusCode == http.StatusNotFound {
-			return false, nil
-		}
-		if isRetryableStatus(resp.StatusCode) {
-			lastErr = fmt.Errorf("server returned %d", resp.StatusCode)
-			continue
+		switch {
+		case resp.StatusCode == http.StatusOK:
+			exists = true
+			return nil
+		case resp.StatusCode == http.StatusNotFound:
+			exists = false
+			return nil
+		case isRetryableStatus(resp.StatusCode):
+			return transientError{fmt.Errorf("server returned %d", resp.StatusCode)}
 		}
+		return fmt.Errorf("unexpected status: %d", resp.StatusCode)
+	})
+	return exists, err
+}
 
-		return false, fmt.Errorf("unexpected status: %d", resp.StatusCode)
+// MissingBlocks returns the blocks of cids the server doesn't have, in one
+// request where the server supports it and one per block otherwise
+func (c *Client) MissingBlocks(ctx context.Context, cids []string) ([]string, error) {
+	if !c.Supports(ctx, version.BlockBatch) {
+		var missing []string
+		for _, cid := range cids {
+			exists, err := c.BlockExists(ctx, cid)
+			if err != nil {
+				return nil, err
+			}
+			if !exists {
+				missing = append(missing, cid)
+			}
+		}
+		return missing, nil
 	}
 
-	return false, fmt.Errorf("max retries exceeded: %w", lastErr)
-}
-
-// UploadBlock uploads a block to the server
-func (c *Client) UploadBlock(ctx context.Context, cid string, data []byte, originalSize int64) error {
-	var lastErr error
+	body, err := json.Marshal(cids)
+	if err != nil {
+		return nil, err
+	}
+	var result struct {
+		Missing []string `json:"missing"`
+	}
+	err = c.retry(ctx, "block exists", fmt.Sprintf("%d blocks", len(cids)), func() error {
+		req, err := c.newRequest(ctx, "POST", "/api/blocks/exists", body)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := c.httpClient.Do(req)
+		if err != nil {
+			return transient(err)
+		}
+		defer resp.Body.Close()
 
-	for attempt := 0; attempt < maxRetries; attempt++ {
-		if attempt > 0 {
-			delay := retryDelay(attempt - 1)
-			fmt.Printf("  Retrying upload (attempt %d/%d) after %v...\n", attempt+1, maxRetries, delay.Round(time.Millisecond))
-			select {
-			case <-ctx.Done():
-				return ctx.Err()
-			case <-time.After(delay):
+		if resp.StatusCode != http.StatusOK {
+			err := fmt.Errorf("unexpected status: %d", resp.StatusCode)
+			if isRetryableStatus(resp.StatusCode) {
+				return transientError{err}
 			}
+			return err
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+			return transientError{err}
 		}
+		return nil
+	})
+	return result.Missing, err
+}
 
-		req, err := c.newRequest(ctx, "POST", "/api/blocks", bytes.NewReader(data))
+// UploadBlock uploads a block to the server. compression is the codec of
+// data, empty if it is stored raw, backup.CompressionHeader if it starts
+// with a header.
+func (c *Client) UploadBlock(ctx context.Context, cid string, data []byte, originalSize int64, compression string) error {
+	if len(data) >= resumableUploadSize {
+		if info, err := c.ServerInfo(ctx); err == nil && info.Has(version.ResumableUpload) {
+			return c.uploadBlockResumable(ctx, cid, data, originalSize, compression)
+		}
+	}
+	return c.retry(ctx, "upload", cid, func() error {
+		req, err := c.newRequest(ctx, "POST", "/api/blocks", data)
 		if err != nil {
 			return err
 		}
@@ -113,68 +207,214 @@ func (c *Client) UploadBlock(ctx context.Context, cid string, data []byte, origi
 		req.Header.Set("Content-Type", "application/octet-stream")
 		req.Header.Set("X-Block-CID", cid)
 		req.Header.Set("X-Original-Size", fmt.Sprintf("%d", originalSize))
+		if compression != "" {
+			req.Header.Set("X-Compression", compression)
+		}
 
 		resp, err := c.httpClient.Do(req)
 		if err != nil {
-			if isRetryableError(err) {
-				lastErr = err
-				continue
-			}
-			return err
+			return transient(err)
 		}
 		defer resp.Body.Close()
 
 		if resp.StatusCode == http.StatusOK || resp.StatusCode == http.StatusCreated {
 			return nil
 		}
-
+		body, _ := io.ReadAll(resp.Body)
 		if isRetryableStatus(resp.StatusCode) {
-			body, _ := io.ReadAll(resp.Body)
-			lastErr = fmt.Errorf("server returned %d: %s", resp.StatusCode, string(body))
-			continue
+			return transientError{fmt.Errorf("server returned %d: %s", resp.StatusCode, string(body))}
+		}
+		return fmt.Errorf("upload failed: %d - %s", resp.StatusCode, string(body))
+	})
+}
+
+// resumableUploadSize is the size from which a block upload interrupted
+// halfway continues where it stopped instead of starting over
+const resumableUploadSize = 1024 * 1024
+
+// uploadBlockResumable uploads a block to /api/blocks/:cid/upload. Retries
+// ask the server how much of it arrived and send only the rest.
+func (c *Client) uploadBlockResumable(ctx context.Context, cid string, data []byte, originalSize int64, compression string) error {
+	path := fmt.Sprintf("/api/blocks/%s/upload", cid)
+	var offset int64
+	first := true
+	return c.retry(ctx, "upload", cid, func() error {
+		if !first {
+			var err error
+			if offset, err = c.blockUploadOffset(ctx, path); err != nil {
+				return err
+			}
+			if offset > int64(len(data)) {
+				offset = 0
+			}
+		}
+		first = false
+
+		req, err := c.newRequest(ctx, "PATCH", path, data[offset:])
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/octet-stream")
+		req.Header.Set("X-Upload-Offset", strconv.FormatInt(offset, 10))
+		req.Header.Set("X-Upload-Length", strconv.Itoa(len(data)))
+		req.Header.Set("X-Original-Size", strconv.FormatInt(originalSize, 10))
+		if compression != "" {
+			req.Header.Set("X-Compression", compression)
 		}
 
+		resp, err := c.httpClient.Do(req)
+		if err != nil {
+			return transient(err)
+		}
+		defer resp.Body.Close()
+
 		body, _ := io.ReadAll(resp.Body)
+		switch {
+		case resp.StatusCode == http.StatusCreated:
+			return nil
+		case resp.StatusCode == http.StatusConflict || isRetryableStatus(resp.StatusCode):
+			// Out of step with the server, the next attempt asks it
+			return transientError{fmt.Errorf("server returned %d: %s", resp.StatusCode, string(body))}
+		}
 		return fmt.Errorf("upload failed: %d - %s", resp.StatusCode, string(body))
+	})
+}
+
+// blockUploadOffset asks the server how much of a block upload it has, 0
+// if it has none
+func (c *Client) blockUploadOffset(ctx context.Context, path string) (int64, error) {
+	req, err := c.newRequest(ctx, "GET", path, nil)
+	if err != nil {
+		return 0, err
 	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return 0, transient(err)
+	}
+	defer resp.Body.Close()
 
-	return fmt.Errorf("max retries exceeded: %w", lastErr)
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return 0, nil
+	case isRetryableStatus(resp.StatusCode):
+		return 0, transientError{fmt.Errorf("server returned %d", resp.StatusCode)}
+	case resp.StatusCode != http.StatusOK:
+		return 0, fmt.Errorf("failed to get upload offset: %d", resp.StatusCode)
+	}
+	var up struct {
+		Offset int64 `json:"offset"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&up); err != nil {
+		return 0, transientError{err}
+	}
+	return up.Offset, nil
 }
 
-// DownloadBlock downloads a block from the server
+// DownloadBlock downloads a block from the server and returns its original
+// data, checked against its checksum if it has a header. Servers that
+// don't send the block's compression return it as stored.
 func (c *Client) DownloadBlock(ctx context.Context, cid string) ([]byte, error) {
-	req, err := c.newRequest(ctx, "GET", fmt.Sprintf("/api/blocks/%s", cid), nil)
+	var data []byte
+	var codec, size string
+	err := c.retry(ctx, "download", cid, func() error {
+		req, err := c.newRequest(ctx, "GET", fmt.Sprintf("/api/blocks/%s", cid), nil)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Accept", backup.BlockMediaType)
+		resp, err := c.httpClient.Do(req)
+		if err != nil {
+			return transient(err)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			err := fmt.Errorf("download failed: %d", resp.StatusCode)
+			if isRetryableStatus(resp.StatusCode) {
+				return transientError{err}
+			}
+			return err
+		}
+		// A connection lost halfway through the body is worth another try
+		if data, err = io.ReadAll(resp.Body); err != nil {
+			return transientError{err}
+		}
+		codec, size = resp.Header.Get("X-Compression"), resp.Header.Get("X-Original-Size")
+		return nil
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	resp, err := c.httpClient.Do(req)
-	if err != nil {
-		return nil, err
+	switch codec {
+	case "":
+		return data, nil
+	case backup.CompressionHeader:
+		return backup.DecodeBlock(data)
 	}
-	defer resp.Body.Close()
+	originalSize, err := strconv.ParseInt(size, 10, 64)
+	if err != nil || originalSize < 0 || originalSize > backup.MaxChunkSize {
+		return nil, fmt.Errorf("invalid original size of block %s", cid)
+	}
+	return backup.DecompressBlock(codec, data, originalSize)
+}
 
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("download failed: %d", resp.StatusCode)
+// transientError is a failure of an attempt that may succeed if retried
+type transientError struct {
+	err error
+}
+
+func (e transientError) Error() string { return e.err.Error() }
+func (e transientError) Unwrap() error { return e.err }
+
+// transient marks err as transient if it is a network error worth retrying
+func transient(err error) error {
+	if isRetryableError(err) {
+		return transientError{err}
 	}
+	return err
+}
 
-	return io.ReadAll(resp.Body)
+// retry runs attempt until it succeeds or fails with an error that isn't a
+// transientError, up to c.retries more times, waiting with exponential
+// backoff and jitter in between
+func (c *Client) retry(ctx context.Context, op, cid string, attempt func() error) error {
+	var lastErr error
+	for i := 0; i <= c.retries; i++ {
+		if i > 0 {
+			delay := retryDelay(i - 1)
+			logger.Info("retrying "+op, "cid", cid, "attempt", i+1, "max_attempts", c.retries+1, "delay", delay.Round(time.Millisecond), "error", lastErr)
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(delay):
+			}
+		}
+
+		err := attempt()
+		var t transientError
+		if !errors.As(err, &t) {
+			return err
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		lastErr = t.err
+	}
+	if c.retries == 0 {
+		return lastErr
+	}
+	return fmt.Errorf("max retries exceeded: %w", lastErr)
 }
 
 // GetLatestManifest retrieves the latest manifest matching the given tags
 func (c *Client) GetLatestManifest(ctx context.Context, tags map[string]string) (*backup.Manifest, error) {
-	u, err := url.Parse(c.baseURL + "/api/manifests/latest")
-	if err != nil {
-		return nil, err
-	}
-
-	q := u.Query()
+	q := url.Values{}
 	for k, v := range tags {
 		q.Set("tag."+k, v)
 	}
-	u.RawQuery = q.Encode()
 
-	req, err := http.NewRequestWithContext(ctx, "GET", u.String(), nil)
+	req, err := c.newRequest(ctx, "GET", "/api/manifests/latest?"+q.Encode(), nil)
 	if err != nil {
 		return nil, err
 	}
@@ -226,6 +466,39 @@ func (c *Client) GetManifest(ctx context.Context, id string) (*backup.Manifest,
 	return &manifest, nil
 }
 
+// DiffManifests asks the server for the entries changed from manifest
+// oldID to newID. Returns nil without an error if the server can't compare
+// them because they are encrypted.
+func (c *Client) DiffManifests(ctx context.Context, oldID, newID string) ([]backup.EntryChange, error) {
+	req, err := c.newRequest(ctx, "GET", fmt.Sprintf("/api/manifests/%s/diff/%s", oldID, newID), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusBadRequest:
+		return nil, nil
+	default:
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("failed to compare manifests: %d - %s", resp.StatusCode, string(body))
+	}
+
+	var diff struct {
+		Changes []backup.EntryChange `json:"changes"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&diff); err != nil {
+		return nil, err
+	}
+	return diff.Changes, nil
+}
+
 // UploadManifest uploads a manifest to the server
 func (c *Client) UploadManifest(ctx context.Context, manifest *backup.Manifest) error {
 	data, err := json.Marshal(manifest)
@@ -233,12 +506,27 @@ func (c *Client) UploadManifest(ctx context.Context, manifest *backup.Manifest)
 		return err
 	}
 
-	req, err := c.newRequest(ctx, "POST", "/api/manifests", bytes.NewReader(data))
+	// Manifests of many files shrink to a fraction. Responses come back
+	// gzipped without asking, net/http decodes them.
+	encoding := ""
+	if info, err := c.ServerInfo(ctx); err == nil && info.Has(version.Compression) {
+		enc, err := zstd.NewWriter(nil)
+		if err != nil {
+			return err
+		}
+		data = enc.EncodeAll(data, nil)
+		encoding = "zstd"
+	}
+
+	req, err := c.newRequest(ctx, "POST", "/api/manifests", data)
 	if err != nil {
 		return err
 	}
 
 	req.Header.Set("Content-Type", "application/json")
+	if encoding != "" {
+		req.Header.Set("Content-Encoding", encoding)
+	}
 
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
@@ -254,20 +542,285 @@ func (c *Client) UploadManifest(ctx context.Context, manifest *backup.Manifest)
 	return nil
 }
 
-// ListManifests lists available manifests
-func (c *Client) ListManifests(ctx context.Context, tags map[string]string) ([]ManifestInfo, error) {
-	u, err := url.Parse(c.baseURL + "/api/manifests")
+// ReportRun sends a backup run summary to the server
+func (c *Client) ReportRun(ctx context.Context, summary *backup.RunSummary) error {
+	data, err := json.Marshal(summary)
 	if err != nil {
+		return err
+	}
+
+	req, err := c.newRequest(ctx, "POST", "/api/runs", data)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("run report failed: %d - %s", resp.StatusCode, string(body))
+	}
+
+	return nil
+}
+
+// ServerInfo returns the server's version and features, fetched once, and
+// warns if the server speaks a different API. Servers older than GET
+// /api/version get an Info with API 0 and no features.
+func (c *Client) ServerInfo(ctx context.Context) (*version.Info, error) {
+	c.infoOnce.Do(func() {
+		c.info, c.infoErr = c.fetchServerInfo(ctx)
+		switch {
+		case c.infoErr != nil:
+			logger.Warn("could not get the server version", "error", c.infoErr)
+		case c.info.API == 0:
+			logger.Warn("the server doesn't report its version, it is probably older than this client; some commands may fail")
+		case c.info.API != version.API:
+			logger.Warn("the server speaks a different API version, update the older side", "server", c.info.API, "client", version.API, "server_version", c.info.Version)
+		}
+	})
+	return c.info, c.infoErr
+}
+
+func (c *Client) fetchServerInfo(ctx context.Context) (*version.Info, error) {
+	req, err := c.newRequest(ctx, "GET", "/api/version", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return &version.Info{Version: "unknown"}, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to get server version: %d", resp.StatusCode)
+	}
+
+	var info version.Info
+	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		return nil, err
+	}
+	return &info, nil
+}
+
+// Supports reports whether the server announced a feature. Servers that
+// don't report their features are given the benefit of the doubt, their
+// answer to the request itself says more than a guess.
+func (c *Client) Supports(ctx context.Context, feature string) bool {
+	info, err := c.ServerInfo(ctx)
+	if err != nil || info.API == 0 {
+		return true
+	}
+	return info.Has(feature)
+}
+
+// ListRuns lists up to limit backup runs matching the given tags, newest
+// first
+func (c *Client) ListRuns(ctx context.Context, tags map[string]string, limit int) ([]BackupRun, error) {
+	q := url.Values{}
+	for k, v := range tags {
+		q.Set("tag."+k, v)
+	}
+	q.Set("limit", strconv.Itoa(limit))
+
+	req, err := c.newRequest(ctx, "GET", "/api/runs?"+q.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to list runs: %d", resp.StatusCode)
+	}
+
+	var runs []BackupRun
+	if err := json.NewDecoder(resp.Body).Decode(&runs); err != nil {
+		return nil, err
+	}
+	return runs, nil
+}
+
+// DedupReport shows how a manifest's blocks are shared with other manifests
+type DedupReport struct {
+	ManifestID      string         `json:"manifest_id"`
+	Blocks          int64          `json:"blocks"`
+	Bytes           int64          `json:"bytes"`
+	OriginalBytes   int64          `json:"original_bytes"`
+	ExclusiveBlocks int64          `json:"exclusive_blocks"`
+	ExclusiveBytes  int64          `json:"exclusive_bytes"` // Freed if the manifest is deleted
+	Shared          []SharedBlocks `json:"shared"`          // Most shared bytes first
+}
+
+// SharedBlocks is what another manifest has in common with the reported one
+type SharedBlocks struct {
+	ManifestID string            `json:"manifest_id"`
+	Tags       map[string]string `json:"tags"`
+	CreatedAt  time.Time         `json:"created_at"`
+	Blocks     int64             `json:"blocks"`
+	Bytes      int64             `json:"bytes"`
+}
+
+// ManifestDedup fetches the dedup report of a manifest
+func (c *Client) ManifestDedup(ctx context.Context, id string) (*DedupReport, error) {
+	req, err := c.newRequest(ctx, "GET", fmt.Sprintf("/api/manifests/%s/dedup", id), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("manifest not found: %s", id)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to get dedup report: %d", resp.StatusCode)
+	}
+
+	var report DedupReport
+	if err := json.NewDecoder(resp.Body).Decode(&report); err != nil {
 		return nil, err
 	}
+	return &report, nil
+}
+
+// Admin sends a JSON request to an admin endpoint and decodes the JSON
+// response into out (if not nil)
+func (c *Client) Admin(ctx context.Context, method, path string, in, out any) error {
+	var data []byte
+	if in != nil {
+		var err error
+		if data, err = json.Marshal(in); err != nil {
+			return err
+		}
+	}
 
-	q := u.Query()
+	req, err := c.newRequest(ctx, method, path, data)
+	if err != nil {
+		return err
+	}
+	if data != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		var apiErr struct {
+			Error string `json:"error"`
+		}
+		if json.Unmarshal(body, &apiErr) == nil && apiErr.Error != "" {
+			return fmt.Errorf("%s %s failed: %d - %s", method, path, resp.StatusCode, apiErr.Error)
+		}
+		return fmt.Errorf("%s %s failed: %d - %s", method, path, resp.StatusCode, string(body))
+	}
+	if out == nil {
+		return nil
+	}
+	return json.Unmarshal(body, out)
+}
+
+// CLIChecksums fetches the checksums of the client binaries the server
+// embeds, and their signature. sig is nil if the binaries are not signed.
+func (c *Client) CLIChecksums(ctx context.Context) (sums, sig []byte, err error) {
+	if sums, err = c.getCLIFile(ctx, "/cli/checksums"); err != nil {
+		return nil, nil, err
+	}
+	if sums == nil {
+		return nil, nil, fmt.Errorf("server does not publish client checksums")
+	}
+	if sig, err = c.getCLIFile(ctx, "/cli/checksums.sig"); err != nil {
+		return nil, nil, err
+	}
+	return sums, sig, nil
+}
+
+// DownloadCLI streams the client binary for a platform to w
+func (c *Client) DownloadCLI(ctx context.Context, goos, goarch string, w io.Writer) error {
+	req, err := c.newRequest(ctx, "GET", fmt.Sprintf("/cli/%s/%s", goos, goarch), nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to download client for %s/%s: %d", goos, goarch, resp.StatusCode)
+	}
+	_, err = io.Copy(w, resp.Body)
+	return err
+}
+
+// getCLIFile returns the body of a small /cli file, or nil if not found
+func (c *Client) getCLIFile(ctx context.Context, path string) ([]byte, error) {
+	req, err := c.newRequest(ctx, "GET", path, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to get %s: %d", path, resp.StatusCode)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// ListManifests lists available manifests, newest first
+func (c *Client) ListManifests(ctx context.Context, tags map[string]string) ([]ManifestInfo, error) {
+	return c.ListRecentManifests(ctx, tags, 0)
+}
+
+// ListRecentManifests lists the newest limit manifests, or all of them if
+// limit is 0
+func (c *Client) ListRecentManifests(ctx context.Context, tags map[string]string, limit int) ([]ManifestInfo, error) {
+	q := url.Values{}
 	for k, v := range tags {
 		q.Set("tag."+k, v)
 	}
-	u.RawQuery = q.Encode()
+	if limit > 0 {
+		q.Set("limit", strconv.Itoa(limit))
+	}
 
-	req, err := http.NewRequestWithContext(ctx, "GET", u.String(), nil)
+	req, err := c.newRequest(ctx, "GET", "/api/manifests?"+q.Encode(), nil)
 	if err != nil {
 		return nil, err
 	}
@@ -287,16 +840,31 @@ func (c *Client) ListManifests(ctx context.Context, tags map[string]string) ([]M
 		return nil, err
 	}
 
+	// Servers before paging return all of them
+	if limit > 0 && len(manifests) > limit {
+		manifests = manifests[:limit]
+	}
 	return manifests, nil
 }
 
-func (c *Client) newRequest(ctx context.Context, method, path string, body io.Reader) (*http.Request, error) {
-	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, body)
+func (c *Client) newRequest(ctx context.Context, method, path string, body []byte) (*http.Request, error) {
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, reader)
 	if err != nil {
 		return nil, err
 	}
 
-	if c.token != "" {
+	tracing.Inject(ctx, req.Header)
+
+	switch {
+	case c.signingKey != nil:
+		ts := time.Now().Unix()
+		sig := auth.SignRequest(c.signingKey, method, req.URL.RequestURI(), ts, body)
+		req.Header.Set("Authorization", auth.SignatureHeader(c.tokenID, ts, sig))
+	case c.token != "":
 		req.Header.Set("Authorization", "Bearer "+c.token)
 	}
 
@@ -308,6 +876,15 @@ func isRetryableError(err error) bool {
 	if err == nil {
 		return false
 	}
+	// Timeouts of the request, but not a canceled backup
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	if errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
 	errStr := err.Error()
 	// HTTP/2 rate limiting errors
 	if strings.Contains(errStr, "ENHANCE_YOUR_CALM") ||
@@ -345,3 +922,11 @@ type ManifestInfo struct {
 	Tags      map[string]string `json:"tags"`
 	CreatedAt time.Time         `json:"created_at"`
 }
+
+// BackupRun is a run summary as stored by the server
+type BackupRun struct {
+	backup.RunSummary
+	ID         int64     `json:"id"`
+	ReportedBy string    `json:"reported_by"`
+	ReportedAt time.Time `json:"reported_at"`
+}
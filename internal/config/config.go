@@ -2,14 +2,21 @@ package config
 
 import (
 	"encoding/json"
+	"fmt"
 	"os"
 	"path/filepath"
 	"strconv"
+	"strings"
 	"sync"
+	"time"
+
+	"github.com/johann/ib/internal/auth"
+	"github.com/johann/ib/internal/secrets"
 )
 
 var (
 	configDir  string
+	configErr  error
 	configOnce sync.Once
 )
 
@@ -17,15 +24,147 @@ var (
 type ClientConfig struct {
 	ServerURL string `json:"server_url"`
 	Token     string `json:"token,omitempty"`
+
+	// Sign each request with a key derived from the token instead of
+	// sending the token (requires a token created with --signed)
+	SignRequests bool `json:"sign_requests,omitempty"`
+
+	// Encrypt backups client-side with the key in this file (zero-knowledge mode)
+	EncryptionKeyFile string `json:"encryption_key_file,omitempty"`
+
+	// Sign manifests with this Ed25519 key, and only restore manifests
+	// signed by one of the trusted public keys (hex)
+	SigningKeyFile string   `json:"signing_key_file,omitempty"`
+	TrustedKeys    []string `json:"trusted_keys,omitempty"`
+
+	// Only self-update to client binaries signed by this Ed25519 public
+	// key (hex). Default: the key the client was built with.
+	ReleaseKey string `json:"release_key,omitempty"`
+
+	// Don't add the host, os, arch, user and ib_version tags to backups
+	NoHostTags bool `json:"no_host_tags,omitempty"`
+
+	// Timeout of each request to the server (default 300 seconds), and how
+	// often block checks, uploads and downloads are retried after network
+	// errors, timeouts and overloaded servers (default 5, -1 for never)
+	TimeoutSeconds int `json:"timeout_seconds,omitempty"`
+	Retries        int `json:"retries,omitempty"`
+
+	// Named backups for `ib backup run <name>`
+	Backups map[string]BackupDefinition `json:"backups,omitempty"`
+}
+
+// BackupDefinition holds the settings of a named backup. Zero values fall
+// back to the same defaults as `ib backup create`.
+type BackupDefinition struct {
+	Path        string            `json:"path"`
+	Tags        map[string]string `json:"tags,omitempty"` // name defaults to the definition's name
+	Excludes    []string          `json:"excludes,omitempty"`
+	Markers     []string          `json:"exclude_if_present,omitempty"` // See 'ib backup create --exclude-if-present'
+	SkipCaches  bool              `json:"exclude_caches,omitempty"`     // Directories with a CACHEDIR.TAG
+	Includes    []string          `json:"includes,omitempty"`           // Kept even if excluded or ignored
+	FilterFile  string            `json:"filter_file,omitempty"`        // Ordered +/- rules, see 'ib backup create --filter-file'
+	FollowLinks bool              `json:"follow_symlinks,omitempty"`    // See 'ib backup create --follow-symlinks'
+	OneFS       bool              `json:"one_file_system,omitempty"`    // See 'ib backup create --one-file-system'
+	Changes     string            `json:"change_detection,omitempty"`   // mtime (default), ctime, inode or checksum
+	OnError     string            `json:"on_error,omitempty"`           // skip (default) or fail, for unreadable files
+	Concurrency int               `json:"concurrency,omitempty"`
+	QueueDepth  int               `json:"queue_depth,omitempty"` // Chunks waiting for upload, see 'ib backup create --queue-depth'
+	ChunkSize   int               `json:"chunk_size,omitempty"`
+	CDC         bool              `json:"cdc,omitempty"` // Content-defined chunks, sizes default to 512KiB, 2MiB and 8MiB
+	ChunkMin    int               `json:"chunk_min,omitempty"`
+	ChunkAvg    int               `json:"chunk_avg,omitempty"`
+	ChunkMax    int               `json:"chunk_max,omitempty"`
+	KuboCompat  bool              `json:"kubo_compat,omitempty"`
+	Delta       bool              `json:"delta,omitempty"`       // See 'ib backup create --delta'
+	Xattrs      bool              `json:"xattrs,omitempty"`      // Extended attributes, without ACLs
+	ACLs        bool              `json:"acls,omitempty"`        // POSIX ACLs
+	Compression string            `json:"compression,omitempty"` // lz4 (default) or zstd
+	Level       int               `json:"compression_level,omitempty"`
+	Readers     int               `json:"readers,omitempty"`  // Per large file, see 'ib backup create --readers'
+	KeyFile     string            `json:"key_file,omitempty"` // Default: encryption_key_file
+	PingURL     string            `json:"ping_url,omitempty"`
+	PreHook     string            `json:"pre_hook,omitempty"` // Shell commands, see 'ib backup create --pre-hook'
+	PostHook    string            `json:"post_hook,omitempty"`
+	KeepLast    int               `json:"keep_last,omitempty"` // Retention after each run, see 'ib backup create --keep-last'
+	KeepDaily   int               `json:"keep_daily,omitempty"`
+	KeepWeekly  int               `json:"keep_weekly,omitempty"`
+	KeepMonthly int               `json:"keep_monthly,omitempty"`
+	Schedule    string            `json:"schedule,omitempty"` // Cron expression for 'ib daemon'
 }
 
 // ServerConfig holds server-side configuration
 type ServerConfig struct {
-	Token         string `json:"token,omitempty"`
+	// Token is the plaintext token, from IB_TOKEN or a legacy config file.
+	// SaveServer only ever writes TokenHash.
+	Token     string `json:"token,omitempty"`
+	TokenHash string `json:"token_hash,omitempty"`
+
+	// Rotated-out token, still accepted until PreviousTokenExpires (unix seconds)
+	PreviousTokenHash    string `json:"previous_token_hash,omitempty"`
+	PreviousTokenExpires int64  `json:"previous_token_expires,omitempty"`
+
 	DBPath        string `json:"db_path"`
 	ListenAddr    string `json:"listen_addr"`
 	RetentionDays int    `json:"retention_days"`
 
+	// Retention policies per name tag, like "keep-last=7,keep-daily=14,
+	// keep-weekly=8,keep-monthly=12"; "*" applies to all other names.
+	// Names with a policy are pruned by it instead of RetentionDays.
+	// Policies set through the API take precedence.
+	RetentionPolicies map[string]string `json:"retention_policies,omitempty"`
+
+	// HTTPS: serve TLSCert/TLSKey (PEM files, reread when they change), or
+	// get certificates for ACMEDomains from Let's Encrypt. ACME needs the
+	// domains to reach the server on port 443, and port 80 is used for
+	// HTTP challenges and redirects if it can be bound.
+	TLSCert      string   `json:"tls_cert,omitempty"`
+	TLSKey       string   `json:"tls_key,omitempty"`
+	ACMEDomains  []string `json:"acme_domains,omitempty"`
+	ACMEEmail    string   `json:"acme_email,omitempty"`     // Contact for expiry notices
+	ACMECacheDir string   `json:"acme_cache_dir,omitempty"` // Default: acme in the config directory
+
+	// Days deleted manifests stay in the trash before their blocks can be
+	// collected (default 7, negative deletes right away)
+	TrashDays int `json:"trash_days,omitempty"`
+
+	// Logging: debug, info, warn or error; text or json output
+	LogLevel  string `json:"log_level,omitempty"`
+	LogFormat string `json:"log_format,omitempty"`
+
+	// Log to this file instead of stderr, rotated at LogMaxSizeMB (default
+	// 100) and/or daily. LogMaxBackups rotated files are kept (default 10,
+	// negative keeps all), none older than LogMaxAgeDays if set.
+	LogFile        string `json:"log_file,omitempty"`
+	LogMaxSizeMB   int    `json:"log_max_size_mb,omitempty"`
+	LogRotateDaily bool   `json:"log_rotate_daily,omitempty"`
+	LogMaxBackups  int    `json:"log_max_backups,omitempty"`
+	LogMaxAgeDays  int    `json:"log_max_age_days,omitempty"`
+
+	// OpenTelemetry: OTLP/HTTP collector URL (empty disables tracing) and
+	// the fraction of new traces to sample (default 1)
+	OTLPEndpoint     string  `json:"otlp_endpoint,omitempty"`
+	TraceSampleRatio float64 `json:"trace_sample_ratio,omitempty"`
+
+	// Report panics and errors to a Sentry-compatible DSN (empty disables)
+	ErrorReportDSN string `json:"error_report_dsn,omitempty"`
+
+	// Require a read or admin token to list and download backups
+	PrivateReads bool `json:"private_reads,omitempty"`
+
+	// CIDR allowlists per route group (empty allows all). Forwarding headers
+	// are only trusted from TrustedProxies.
+	WriteAllowlist   []string `json:"write_allowlist,omitempty"`
+	AdminAllowlist   []string `json:"admin_allowlist,omitempty"`
+	MetricsAllowlist []string `json:"metrics_allowlist,omitempty"`
+	TrustedProxies   []string `json:"trusted_proxies,omitempty"`
+
+	// Failed auth blocks double from AuthBlockSeconds up to
+	// AuthMaxBlockSeconds; AuthBanAfter failures ban until cleared (0 = never)
+	AuthBlockSeconds    int `json:"auth_block_seconds,omitempty"`
+	AuthMaxBlockSeconds int `json:"auth_max_block_seconds,omitempty"`
+	AuthBanAfter        int `json:"auth_ban_after,omitempty"`
+
 	// S3 configuration
 	S3Endpoint  string `json:"s3_endpoint"`
 	S3Bucket    string `json:"s3_bucket"`
@@ -33,35 +172,104 @@ type ServerConfig struct {
 	S3SecretKey string `json:"s3_secret_key"`
 	S3Region    string `json:"s3_region"`
 
+	// Replication: mirror manifests from a primary ib-server over bitswap
+	ReplicaOf       string `json:"replica_of,omitempty"`       // Primary server HTTP URL
+	ReplicaPeer     string `json:"replica_peer,omitempty"`     // Primary IPFS multiaddr including /p2p/<peer-id>
+	ReplicaToken    string `json:"replica_token,omitempty"`    // Token for the primary API, if reads require one
+	ReplicaInterval int    `json:"replica_interval,omitempty"` // Poll interval in seconds (default 300)
+
 	// IPFS configuration
-	IPFSEnabled     bool     `json:"ipfs_enabled"`
-	IPFSListenAddrs []string `json:"ipfs_listen_addrs,omitempty"`
-	IPFSGatewayAddr string   `json:"ipfs_gateway_addr,omitempty"`
-	IPFSPublicIP    string   `json:"ipfs_public_ip,omitempty"` // Public IP for DHT announcements
+	IPFSEnabled          bool     `json:"ipfs_enabled"`
+	IPFSListenAddrs      []string `json:"ipfs_listen_addrs,omitempty"`
+	IPFSGatewayAddr      string   `json:"ipfs_gateway_addr,omitempty"`
+	IPFSGatewayMount     bool     `json:"ipfs_gateway_mount,omitempty"`     // Serve gateway at /ipfs/ on the main HTTP server
+	IPFSGatewayTrustless bool     `json:"ipfs_gateway_trustless,omitempty"` // Only serve raw block and CAR responses
+	IPFSPublicIP         string   `json:"ipfs_public_ip,omitempty"`         // Public IP for DHT announcements
+	IPFSMDNS             bool     `json:"ipfs_mdns,omitempty"`              // Discover peers on the local network
+
+	// Circuit relay v2: relays to reserve slots on when behind NAT (default: bootstrap peers)
+	IPFSStaticRelays        []string `json:"ipfs_static_relays,omitempty"`
+	IPFSDisableAutoRelay    bool     `json:"ipfs_disable_autorelay,omitempty"`
+	IPFSDisableRelayService bool     `json:"ipfs_disable_relay_service,omitempty"`
+
+	// Directories with more entries are HAMT-sharded (0 = default, negative disables)
+	IPFSShardThreshold int `json:"ipfs_shard_threshold,omitempty"`
+
+	// DHT providing: roots, recursive or all, at IPFSProvideRate provides/sec
+	IPFSProvideStrategy string `json:"ipfs_provide_strategy,omitempty"`
+	IPFSProvideRate     int    `json:"ipfs_provide_rate,omitempty"`
+
+	// IPNI: announce stored content to network indexers such as cid.contact
+	IPNIIndexers     []string `json:"ipni_indexers,omitempty"`      // Indexer announce URLs
+	IPNIListenAddr   string   `json:"ipni_listen_addr,omitempty"`   // Advertisement HTTP publisher address
+	IPNIAnnounceAddr string   `json:"ipni_announce_addr,omitempty"` // Public multiaddr of the publisher
+
+	// Notification channels for backup events, and how long each name tag
+	// may go without a new manifest before a missed backup is reported
+	// (durations like "26h"; "*" applies to all other names)
+	Notifications     []NotifyChannel   `json:"notifications,omitempty"`
+	MissedBackupAfter map[string]string `json:"missed_backup_after,omitempty"`
+
+	// Storage quota per namespace (sizes like "500GiB"), for tokens
+	// created with a namespace. New blocks count against the namespace
+	// that uploads them first.
+	NamespaceQuotas map[string]string `json:"namespace_quotas,omitempty"`
+}
+
+// NotifyChannel is one notification destination. Secrets (URLs with
+// tokens, SMTP password, bot token) may be vault:, awssm: or sops: refs.
+type NotifyChannel struct {
+	Type string `json:"type"` // smtp, slack, discord, ntfy or telegram
+
+	// Webhook URL for slack and discord, topic URL for ntfy
+	URL string `json:"url,omitempty"`
+
+	// smtp
+	SMTPHost     string   `json:"smtp_host,omitempty"`
+	SMTPPort     int      `json:"smtp_port,omitempty"` // Default 587
+	SMTPUser     string   `json:"smtp_user,omitempty"`
+	SMTPPassword string   `json:"smtp_password,omitempty"`
+	From         string   `json:"from,omitempty"`
+	To           []string `json:"to,omitempty"`
+
+	// telegram
+	BotToken string `json:"bot_token,omitempty"`
+	ChatID   string `json:"chat_id,omitempty"`
+
+	// Routing: events (manifest_created, backup_failed, missed_backup,
+	// verify_failed, prune) and name tags to send; empty means all
+	Events []string `json:"events,omitempty"`
+	Names  []string `json:"names,omitempty"`
 }
 
-// Dir returns the configuration directory path
+// Dir returns the configuration directory path: IB_CONFIG_DIR, or ib in
+// the user config directory
 func Dir() (string, error) {
-	var err error
 	configOnce.Do(func() {
-		configDir, err = os.UserConfigDir()
-		if err != nil {
-			return
+		if v := os.Getenv("IB_CONFIG_DIR"); v != "" {
+			configDir = v
+		} else {
+			dir, err := os.UserConfigDir()
+			if err != nil {
+				configErr = err
+				return
+			}
+			configDir = filepath.Join(dir, "ib")
 		}
-		configDir = filepath.Join(configDir, "ib")
-		err = os.MkdirAll(configDir, 0700)
+		// Best effort: env-only deployments may have no writable home, and
+		// the save functions create it when they need it
+		os.MkdirAll(configDir, 0700)
 	})
-	return configDir, err
+	return configDir, configErr
 }
 
 // LoadClient loads the client configuration
 func LoadClient() (*ClientConfig, error) {
-	dir, err := Dir()
+	path, err := ClientPath()
 	if err != nil {
 		return nil, err
 	}
 
-	path := filepath.Join(dir, "config.json")
 	data, err := os.ReadFile(path)
 	if os.IsNotExist(err) {
 		return &ClientConfig{}, nil
@@ -71,7 +279,7 @@ func LoadClient() (*ClientConfig, error) {
 	}
 
 	var cfg ClientConfig
-	if err := json.Unmarshal(data, &cfg); err != nil {
+	if err := decodeFile(path, data, &cfg); err != nil {
 		return nil, err
 	}
 	return &cfg, nil
@@ -79,58 +287,143 @@ func LoadClient() (*ClientConfig, error) {
 
 // SaveClient saves the client configuration
 func SaveClient(cfg *ClientConfig) error {
-	dir, err := Dir()
+	path, err := ClientPath()
 	if err != nil {
 		return err
 	}
 
-	data, err := json.MarshalIndent(cfg, "", "  ")
+	data, err := encodeFile(path, cfg)
 	if err != nil {
 		return err
 	}
-
-	path := filepath.Join(dir, "config.json")
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
 	return os.WriteFile(path, data, 0600)
 }
 
-// LoadServer loads the server configuration
-// Environment variables take precedence over config file
-func LoadServer() (*ServerConfig, error) {
-	dir, err := Dir()
-	if err != nil {
-		return nil, err
+// RequestTimeout returns the timeout of each request to the server
+func (c *ClientConfig) RequestTimeout() time.Duration {
+	if c.TimeoutSeconds > 0 {
+		return time.Duration(c.TimeoutSeconds) * time.Second
 	}
+	return 5 * time.Minute
+}
 
-	path := filepath.Join(dir, "server.json")
-	data, err := os.ReadFile(path)
+// MaxRetries returns how often failed block requests are retried
+func (c *ClientConfig) MaxRetries() int {
+	switch {
+	case c.Retries < 0:
+		return 0
+	case c.Retries > 0:
+		return c.Retries
+	}
+	return 5
+}
 
-	var cfg *ServerConfig
-	if os.IsNotExist(err) {
-		cfg = DefaultServerConfig()
-	} else if err != nil {
+// LoadServer loads the server configuration
+// Environment variables take precedence over config file. Without a config
+// file (or config directory) the defaults and environment are used alone.
+func LoadServer() (*ServerConfig, error) {
+	cfg, err := readServerFile()
+	if err != nil {
 		return nil, err
-	} else {
-		cfg = &ServerConfig{}
-		if err := json.Unmarshal(data, cfg); err != nil {
-			return nil, err
-		}
 	}
 
 	// Environment variables override config file
 	if v := os.Getenv("IB_TOKEN"); v != "" {
 		cfg.Token = v
 	}
+	if v := os.Getenv("IB_TOKEN_HASH"); v != "" {
+		cfg.TokenHash = v
+	}
+	if v := os.Getenv("IB_PREVIOUS_TOKEN_HASH"); v != "" {
+		cfg.PreviousTokenHash = v
+	}
+	if v := os.Getenv("IB_PREVIOUS_TOKEN_EXPIRES"); v != "" {
+		if n, err := strconv.ParseInt(v, 10, 64); err == nil {
+			cfg.PreviousTokenExpires = n
+		}
+	}
 	if v := os.Getenv("IB_DB_PATH"); v != "" {
 		cfg.DBPath = v
 	}
 	if v := os.Getenv("IB_LISTEN_ADDR"); v != "" {
 		cfg.ListenAddr = v
 	}
+	if v := os.Getenv("IB_TLS_CERT"); v != "" {
+		cfg.TLSCert = v
+	}
+	if v := os.Getenv("IB_TLS_KEY"); v != "" {
+		cfg.TLSKey = v
+	}
+	if v := os.Getenv("IB_ACME_DOMAINS"); v != "" {
+		cfg.ACMEDomains = strings.Split(v, ",")
+	}
+	if v := os.Getenv("IB_ACME_EMAIL"); v != "" {
+		cfg.ACMEEmail = v
+	}
+	if v := os.Getenv("IB_ACME_CACHE_DIR"); v != "" {
+		cfg.ACMECacheDir = v
+	}
 	if v := os.Getenv("IB_RETENTION_DAYS"); v != "" {
 		if days, err := strconv.Atoi(v); err == nil {
 			cfg.RetentionDays = days
 		}
 	}
+	if v := os.Getenv("IB_RETENTION_POLICIES"); v != "" {
+		cfg.RetentionPolicies = make(map[string]string)
+		for _, entry := range strings.Split(v, ";") {
+			name, policy, ok := strings.Cut(entry, ":")
+			if !ok {
+				return nil, fmt.Errorf("invalid IB_RETENTION_POLICIES entry %q (expected name:keep-last=7,keep-daily=14,...)", entry)
+			}
+			cfg.RetentionPolicies[strings.TrimSpace(name)] = strings.TrimSpace(policy)
+		}
+	}
+	if v := os.Getenv("IB_TRASH_DAYS"); v != "" {
+		if days, err := strconv.Atoi(v); err == nil {
+			cfg.TrashDays = days
+		}
+	}
+	if v := os.Getenv("IB_LOG_LEVEL"); v != "" {
+		cfg.LogLevel = v
+	}
+	if v := os.Getenv("IB_LOG_FORMAT"); v != "" {
+		cfg.LogFormat = v
+	}
+	if v := os.Getenv("IB_LOG_FILE"); v != "" {
+		cfg.LogFile = v
+	}
+	if v := os.Getenv("IB_LOG_MAX_SIZE_MB"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			cfg.LogMaxSizeMB = n
+		}
+	}
+	if v := os.Getenv("IB_LOG_ROTATE_DAILY"); v != "" {
+		cfg.LogRotateDaily = v == "true" || v == "1"
+	}
+	if v := os.Getenv("IB_LOG_MAX_BACKUPS"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			cfg.LogMaxBackups = n
+		}
+	}
+	if v := os.Getenv("IB_LOG_MAX_AGE_DAYS"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			cfg.LogMaxAgeDays = n
+		}
+	}
+	if v := os.Getenv("IB_OTLP_ENDPOINT"); v != "" {
+		cfg.OTLPEndpoint = v
+	}
+	if v := os.Getenv("IB_TRACE_SAMPLE_RATIO"); v != "" {
+		if ratio, err := strconv.ParseFloat(v, 64); err == nil {
+			cfg.TraceSampleRatio = ratio
+		}
+	}
+	if v := os.Getenv("IB_ERROR_REPORT_DSN"); v != "" {
+		cfg.ErrorReportDSN = v
+	}
 	if v := os.Getenv("IB_S3_ENDPOINT"); v != "" {
 		cfg.S3Endpoint = v
 	}
@@ -146,42 +439,271 @@ func LoadServer() (*ServerConfig, error) {
 	if v := os.Getenv("IB_S3_REGION"); v != "" {
 		cfg.S3Region = v
 	}
+	if v := os.Getenv("IB_PRIVATE_READS"); v != "" {
+		cfg.PrivateReads = v == "true" || v == "1"
+	}
+	if v := os.Getenv("IB_WRITE_ALLOWLIST"); v != "" {
+		cfg.WriteAllowlist = strings.Split(v, ",")
+	}
+	if v := os.Getenv("IB_ADMIN_ALLOWLIST"); v != "" {
+		cfg.AdminAllowlist = strings.Split(v, ",")
+	}
+	if v := os.Getenv("IB_METRICS_ALLOWLIST"); v != "" {
+		cfg.MetricsAllowlist = strings.Split(v, ",")
+	}
+	if v := os.Getenv("IB_TRUSTED_PROXIES"); v != "" {
+		cfg.TrustedProxies = strings.Split(v, ",")
+	}
+	if v := os.Getenv("IB_AUTH_BLOCK_SECONDS"); v != "" {
+		if secs, err := strconv.Atoi(v); err == nil {
+			cfg.AuthBlockSeconds = secs
+		}
+	}
+	if v := os.Getenv("IB_AUTH_MAX_BLOCK_SECONDS"); v != "" {
+		if secs, err := strconv.Atoi(v); err == nil {
+			cfg.AuthMaxBlockSeconds = secs
+		}
+	}
+	if v := os.Getenv("IB_AUTH_BAN_AFTER"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			cfg.AuthBanAfter = n
+		}
+	}
+	if v := os.Getenv("IB_REPLICA_OF"); v != "" {
+		cfg.ReplicaOf = v
+	}
+	if v := os.Getenv("IB_REPLICA_PEER"); v != "" {
+		cfg.ReplicaPeer = v
+	}
+	if v := os.Getenv("IB_REPLICA_TOKEN"); v != "" {
+		cfg.ReplicaToken = v
+	}
+	if v := os.Getenv("IB_REPLICA_INTERVAL"); v != "" {
+		if secs, err := strconv.Atoi(v); err == nil {
+			cfg.ReplicaInterval = secs
+		}
+	}
 	if v := os.Getenv("IB_IPFS_ENABLED"); v != "" {
 		cfg.IPFSEnabled = v == "true" || v == "1"
 	}
+	if v := os.Getenv("IB_IPFS_LISTEN_ADDRS"); v != "" {
+		cfg.IPFSListenAddrs = strings.Split(v, ",")
+	}
 	if v := os.Getenv("IB_IPFS_GATEWAY_ADDR"); v != "" {
 		cfg.IPFSGatewayAddr = v
 	}
+	if v := os.Getenv("IB_IPFS_GATEWAY_MOUNT"); v != "" {
+		cfg.IPFSGatewayMount = v == "true" || v == "1"
+	}
+	if v := os.Getenv("IB_IPFS_GATEWAY_TRUSTLESS"); v != "" {
+		cfg.IPFSGatewayTrustless = v == "true" || v == "1"
+	}
 	if v := os.Getenv("IB_IPFS_PUBLIC_IP"); v != "" {
 		cfg.IPFSPublicIP = v
 	}
+	if v := os.Getenv("IB_IPFS_STATIC_RELAYS"); v != "" {
+		cfg.IPFSStaticRelays = strings.Split(v, ",")
+	}
+	if v := os.Getenv("IB_IPFS_DISABLE_AUTORELAY"); v != "" {
+		cfg.IPFSDisableAutoRelay = v == "true" || v == "1"
+	}
+	if v := os.Getenv("IB_IPFS_DISABLE_RELAY_SERVICE"); v != "" {
+		cfg.IPFSDisableRelayService = v == "true" || v == "1"
+	}
+	if v := os.Getenv("IB_IPFS_MDNS"); v != "" {
+		cfg.IPFSMDNS = v == "true" || v == "1"
+	}
+	if v := os.Getenv("IB_IPFS_SHARD_THRESHOLD"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			cfg.IPFSShardThreshold = n
+		}
+	}
+	if v := os.Getenv("IB_IPFS_PROVIDE_STRATEGY"); v != "" {
+		cfg.IPFSProvideStrategy = v
+	}
+	if v := os.Getenv("IB_IPFS_PROVIDE_RATE"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			cfg.IPFSProvideRate = n
+		}
+	}
+	if v := os.Getenv("IB_IPNI_INDEXERS"); v != "" {
+		cfg.IPNIIndexers = strings.Split(v, ",")
+	}
+	if v := os.Getenv("IB_IPNI_LISTEN_ADDR"); v != "" {
+		cfg.IPNIListenAddr = v
+	}
+	if v := os.Getenv("IB_IPNI_ANNOUNCE_ADDR"); v != "" {
+		cfg.IPNIAnnounceAddr = v
+	}
+	if v := os.Getenv("IB_NOTIFICATIONS"); v != "" {
+		var channels []NotifyChannel
+		if err := json.Unmarshal([]byte(v), &channels); err != nil {
+			return nil, fmt.Errorf("invalid IB_NOTIFICATIONS (expected a JSON array of channels): %w", err)
+		}
+		cfg.Notifications = channels
+	}
+	if v := os.Getenv("IB_MISSED_BACKUP_AFTER"); v != "" {
+		cfg.MissedBackupAfter = make(map[string]string)
+		for _, pair := range strings.Split(v, ",") {
+			name, after, ok := strings.Cut(pair, "=")
+			if !ok {
+				return nil, fmt.Errorf("invalid IB_MISSED_BACKUP_AFTER entry %q (expected name=duration)", pair)
+			}
+			cfg.MissedBackupAfter[strings.TrimSpace(name)] = strings.TrimSpace(after)
+		}
+	}
+	if v := os.Getenv("IB_NAMESPACE_QUOTAS"); v != "" {
+		cfg.NamespaceQuotas = make(map[string]string)
+		for _, pair := range strings.Split(v, ",") {
+			namespace, quota, ok := strings.Cut(pair, "=")
+			if !ok {
+				return nil, fmt.Errorf("invalid IB_NAMESPACE_QUOTAS entry %q (expected namespace=size)", pair)
+			}
+			cfg.NamespaceQuotas[strings.TrimSpace(namespace)] = strings.TrimSpace(quota)
+		}
+	}
 
 	return cfg, nil
 }
 
+// readServerFile reads the server config file, falling back to the
+// defaults when there is none
+func readServerFile() (*ServerConfig, error) {
+	if _, err := Dir(); err != nil {
+		return DefaultServerConfig(), nil
+	}
+	path, err := ServerPath()
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return DefaultServerConfig(), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := &ServerConfig{}
+	if err := decodeFile(path, data, cfg); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
 // SaveServer saves the server configuration
 func SaveServer(cfg *ServerConfig) error {
-	dir, err := Dir()
+	path, err := ServerPath()
 	if err != nil {
 		return err
 	}
 
-	data, err := json.MarshalIndent(cfg, "", "  ")
+	// Never write the plaintext token; env tokens aren't persisted at all.
+	// References to a secret store are kept as they are.
+	out := *cfg
+	if !secrets.IsRef(out.Token) {
+		if out.Token != "" && out.Token != os.Getenv("IB_TOKEN") {
+			hash, err := auth.HashToken(out.Token)
+			if err != nil {
+				return err
+			}
+			out.TokenHash = hash
+		}
+		out.Token = ""
+	}
+
+	data, err := encodeFile(path, &out)
 	if err != nil {
 		return err
 	}
-
-	path := filepath.Join(dir, "server.json")
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
 	return os.WriteFile(path, data, 0600)
 }
 
 // DefaultServerConfig returns default server configuration
 func DefaultServerConfig() *ServerConfig {
-	dir, _ := Dir()
+	dbPath := ""
+	if dir, err := Dir(); err == nil {
+		dbPath = filepath.Join(dir, "ib.db")
+	}
 	return &ServerConfig{
-		DBPath:        filepath.Join(dir, "ib.db"),
+		DBPath:        dbPath,
 		ListenAddr:    ":8080",
 		RetentionDays: 90,
 		S3Region:      "us-east-1",
 	}
 }
+
+// AuthBlockDuration returns the block after a first failed auth attempt
+func (c *ServerConfig) AuthBlockDuration() time.Duration {
+	if c.AuthBlockSeconds > 0 {
+		return time.Duration(c.AuthBlockSeconds) * time.Second
+	}
+	return 15 * time.Second
+}
+
+// AuthMaxBlockDuration returns the cap for escalating auth blocks
+func (c *ServerConfig) AuthMaxBlockDuration() time.Duration {
+	if c.AuthMaxBlockSeconds > 0 {
+		return time.Duration(c.AuthMaxBlockSeconds) * time.Second
+	}
+	return 24 * time.Hour
+}
+
+// TrashPeriod returns how long deleted manifests can be undeleted, zero if
+// they are deleted right away
+func (c *ServerConfig) TrashPeriod() time.Duration {
+	switch {
+	case c.TrashDays < 0:
+		return 0
+	case c.TrashDays > 0:
+		return time.Duration(c.TrashDays) * 24 * time.Hour
+	}
+	return 7 * 24 * time.Hour
+}
+
+// LogRotation returns the log file rotation settings with defaults applied
+func (c *ServerConfig) LogRotation() (maxSize int64, maxBackups int, maxAge time.Duration) {
+	maxSize = 100 << 20
+	if c.LogMaxSizeMB > 0 {
+		maxSize = int64(c.LogMaxSizeMB) << 20
+	}
+	maxBackups = 10
+	if c.LogMaxBackups != 0 {
+		maxBackups = max(c.LogMaxBackups, 0)
+	}
+	return maxSize, maxBackups, time.Duration(c.LogMaxAgeDays) * 24 * time.Hour
+}
+
+// ParseSize parses a byte count with an optional K, M, G or T suffix
+// (powers of 1024, with or without "iB"/"B")
+func ParseSize(s string) (int64, error) {
+	s = strings.TrimSpace(strings.ToUpper(s))
+	s = strings.TrimSuffix(strings.TrimSuffix(s, "B"), "I")
+
+	shift := 0
+	if s != "" {
+		switch s[len(s)-1] {
+		case 'K':
+			shift = 10
+		case 'M':
+			shift = 20
+		case 'G':
+			shift = 30
+		case 'T':
+			shift = 40
+		}
+	}
+	if shift > 0 {
+		s = s[:len(s)-1]
+	}
+
+	n, err := strconv.ParseInt(strings.TrimSpace(s), 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("expected a size like 512MiB")
+	}
+	return n << shift, nil
+}
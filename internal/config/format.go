@@ -0,0 +1,176 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+
+	"github.com/pelletier/go-toml/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// Config file names, in order of precedence. Only one may exist.
+var (
+	serverFiles = []string{"server.json", "server.yaml", "server.yml", "server.toml"}
+	clientFiles = []string{"config.json", "client.yaml", "client.yml", "client.toml"}
+)
+
+// ServerPath returns the server config file in use, or server.json if
+// there is none yet
+func ServerPath() (string, error) {
+	return findFile(serverFiles)
+}
+
+// ClientPath returns the client config file in use, or config.json if
+// there is none yet
+func ClientPath() (string, error) {
+	return findFile(clientFiles)
+}
+
+func findFile(names []string) (string, error) {
+	dir, err := Dir()
+	if err != nil {
+		return "", err
+	}
+
+	var found []string
+	for _, name := range names {
+		path := filepath.Join(dir, name)
+		if _, err := os.Stat(path); err == nil {
+			found = append(found, path)
+		}
+	}
+	switch len(found) {
+	case 0:
+		return filepath.Join(dir, names[0]), nil
+	case 1:
+		return found[0], nil
+	default:
+		return "", fmt.Errorf("several config files found, keep only one: %s", strings.Join(found, ", "))
+	}
+}
+
+// decodeFile parses a JSON, YAML or TOML config file into v. All formats
+// use the JSON key names, and unknown keys are errors.
+func decodeFile(path string, data []byte, v any) error {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		var m map[string]any
+		if err := yaml.Unmarshal(data, &m); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		data, _ = json.Marshal(m)
+	case ".toml":
+		var m map[string]any
+		if err := toml.Unmarshal(data, &m); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		data, _ = json.Marshal(m)
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return fmt.Errorf("%s: %s", path, describeDecodeError(err, v))
+	}
+	return nil
+}
+
+// encodeFile serializes v in the format of path
+func encodeFile(path string, v any) ([]byte, error) {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	ext := strings.ToLower(filepath.Ext(path))
+	if ext == ".json" {
+		return data, nil
+	}
+	var m map[string]any
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, err
+	}
+	if ext == ".toml" {
+		return toml.Marshal(m)
+	}
+	return yaml.Marshal(m)
+}
+
+// describeDecodeError turns JSON decoding errors into messages that name
+// the offending key
+func describeDecodeError(err error, v any) string {
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) {
+		return fmt.Sprintf("%s must be a %s, not a %s", typeErr.Field, typeErr.Type, typeErr.Value)
+	}
+
+	if name, ok := strings.CutPrefix(err.Error(), "json: unknown field "); ok {
+		name = strings.Trim(name, `"`)
+		msg := fmt.Sprintf("unknown key %q", name)
+		if suggestion := closestKey(name, jsonKeys(reflect.TypeOf(v))); suggestion != "" {
+			msg += fmt.Sprintf(" (did you mean %q?)", suggestion)
+		}
+		return msg
+	}
+	return err.Error()
+}
+
+// jsonKeys returns the JSON key names of a struct type and the structs it
+// contains
+func jsonKeys(t reflect.Type) []string {
+	for t.Kind() == reflect.Pointer || t.Kind() == reflect.Slice || t.Kind() == reflect.Map {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return nil
+	}
+
+	var keys []string
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "" || name == "-" {
+			continue
+		}
+		keys = append(keys, name)
+		keys = append(keys, jsonKeys(f.Type)...)
+	}
+	return keys
+}
+
+// closestKey returns the known key nearest to name, if it's a likely typo
+func closestKey(name string, keys []string) string {
+	best, bestDist := "", len(name)/3+2
+	for _, k := range keys {
+		if d := editDistance(name, k); d < bestDist {
+			best, bestDist = k, d
+		}
+	}
+	return best
+}
+
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
@@ -0,0 +1,102 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/johann/ib/internal/backup"
+)
+
+// Validate checks the server configuration for values that would only fail
+// later at runtime. All problems are returned together.
+func (c *ServerConfig) Validate() error {
+	var errs []error
+	add := func(format string, args ...any) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+
+	if c.S3Bucket == "" {
+		add("s3_bucket is required")
+	}
+	if c.DBPath == "" {
+		add("db_path is required (set IB_DB_PATH)")
+	}
+	if c.RetentionDays < 0 {
+		add("retention_days must not be negative")
+	}
+	for name, v := range c.RetentionPolicies {
+		if _, err := backup.ParseRetentionPolicy(v); err != nil {
+			add("retention_policies[%q]: %v", name, err)
+		}
+	}
+
+	if (c.TLSCert == "") != (c.TLSKey == "") {
+		add("tls_cert and tls_key must be set together")
+	}
+	if c.TLSCert != "" && len(c.ACMEDomains) > 0 {
+		add("tls_cert and acme_domains are mutually exclusive")
+	}
+
+	switch strings.ToLower(c.LogLevel) {
+	case "", "debug", "info", "warn", "error":
+	default:
+		add("invalid log_level %q (expected debug, info, warn or error)", c.LogLevel)
+	}
+	switch strings.ToLower(c.LogFormat) {
+	case "", "text", "json":
+	default:
+		add("invalid log_format %q (expected text or json)", c.LogFormat)
+	}
+	if c.TraceSampleRatio < 0 || c.TraceSampleRatio > 1 {
+		add("trace_sample_ratio must be between 0 and 1")
+	}
+
+	lists := map[string][]string{
+		"write_allowlist":   c.WriteAllowlist,
+		"admin_allowlist":   c.AdminAllowlist,
+		"metrics_allowlist": c.MetricsAllowlist,
+		"trusted_proxies":   c.TrustedProxies,
+	}
+	for key, entries := range lists {
+		for _, entry := range entries {
+			if net.ParseIP(entry) != nil {
+				continue
+			}
+			if _, _, err := net.ParseCIDR(entry); err != nil {
+				add("%s: invalid IP or CIDR %q", key, entry)
+			}
+		}
+	}
+
+	switch c.IPFSProvideStrategy {
+	case "", "roots", "recursive", "all":
+	default:
+		add("invalid ipfs_provide_strategy %q (expected roots, recursive or all)", c.IPFSProvideStrategy)
+	}
+	if c.ReplicaOf != "" && (!c.IPFSEnabled || c.ReplicaPeer == "") {
+		add("replica_of requires ipfs_enabled and replica_peer")
+	}
+
+	for i, ch := range c.Notifications {
+		switch ch.Type {
+		case "smtp", "slack", "discord", "ntfy", "telegram":
+		default:
+			add("notifications[%d]: unknown type %q (expected smtp, slack, discord, ntfy or telegram)", i, ch.Type)
+		}
+	}
+	for name, v := range c.MissedBackupAfter {
+		if d, err := time.ParseDuration(v); err != nil || d <= 0 {
+			add("missed_backup_after[%q]: invalid duration %q", name, v)
+		}
+	}
+	for namespace, v := range c.NamespaceQuotas {
+		if _, err := ParseSize(v); err != nil {
+			add("namespace_quotas[%q]: invalid size %q", namespace, v)
+		}
+	}
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,169 @@
+package cron
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule is a parsed cron expression with the five standard fields:
+// minute, hour, day of month, month and day of week
+type Schedule struct {
+	minute, hour, dom, month, dow uint64 // Bit i set if value i matches
+
+	// The day fields are *. As in Vixie cron, a day matches either day
+	// field if both are restricted.
+	domAny, dowAny bool
+}
+
+var macros = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+var (
+	monthNames = []string{"jan", "feb", "mar", "apr", "may", "jun", "jul", "aug", "sep", "oct", "nov", "dec"}
+	dayNames   = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+)
+
+// Parse parses a cron expression like "30 2 * * 1-5" or one of the macros
+// @hourly, @daily, @weekly, @monthly and @yearly. Fields take *, numbers,
+// ranges, lists and steps like */15; months and days of the week can be
+// named (jan, mon). Day of week 7 is Sunday, like 0.
+func Parse(expr string) (*Schedule, error) {
+	if m, ok := macros[strings.ToLower(strings.TrimSpace(expr))]; ok {
+		expr = m
+	}
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("invalid cron expression %q: expected 5 fields, got %d", expr, len(fields))
+	}
+
+	s := &Schedule{}
+	var err error
+	if s.minute, err = parseField(fields[0], 0, 59, nil); err != nil {
+		return nil, fmt.Errorf("invalid minute in %q: %w", expr, err)
+	}
+	if s.hour, err = parseField(fields[1], 0, 23, nil); err != nil {
+		return nil, fmt.Errorf("invalid hour in %q: %w", expr, err)
+	}
+	if s.dom, err = parseField(fields[2], 1, 31, nil); err != nil {
+		return nil, fmt.Errorf("invalid day of month in %q: %w", expr, err)
+	}
+	if s.month, err = parseField(fields[3], 1, 12, monthNames); err != nil {
+		return nil, fmt.Errorf("invalid month in %q: %w", expr, err)
+	}
+	if s.dow, err = parseField(fields[4], 0, 7, dayNames); err != nil {
+		return nil, fmt.Errorf("invalid day of week in %q: %w", expr, err)
+	}
+	if s.dow&(1<<7) != 0 {
+		s.dow |= 1
+	}
+	s.domAny = strings.HasPrefix(fields[2], "*")
+	s.dowAny = strings.HasPrefix(fields[4], "*")
+	return s, nil
+}
+
+// parseField parses a comma-separated list of values, ranges and steps
+// between min and max. names, if given, are the names of min, min+1, ...
+func parseField(field string, min, max int, names []string) (uint64, error) {
+	var bits uint64
+	for part := range strings.SplitSeq(field, ",") {
+		rng, stepStr, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			var err error
+			step, err = strconv.Atoi(stepStr)
+			if err != nil || step < 1 {
+				return 0, fmt.Errorf("invalid step %q", stepStr)
+			}
+		}
+
+		lo, hi := min, max
+		if rng != "*" {
+			loStr, hiStr, isRange := strings.Cut(rng, "-")
+			var err error
+			if lo, err = parseValue(loStr, min, max, names); err != nil {
+				return 0, err
+			}
+			hi = lo
+			if isRange {
+				if hi, err = parseValue(hiStr, min, max, names); err != nil {
+					return 0, err
+				}
+				if hi < lo {
+					return 0, fmt.Errorf("invalid range %q", rng)
+				}
+			} else if hasStep {
+				// 5/15 means 5-max/15
+				hi = max
+			}
+		}
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << v
+		}
+	}
+	return bits, nil
+}
+
+func parseValue(s string, min, max int, names []string) (int, error) {
+	for i, name := range names {
+		if strings.EqualFold(s, name) {
+			return min + i, nil
+		}
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value %q", s)
+	}
+	if v < min || v > max {
+		return 0, fmt.Errorf("%d out of range %d-%d", v, min, max)
+	}
+	return v, nil
+}
+
+// Next returns the first time after t that matches the schedule, in t's
+// location, or the zero time if there is none within five years (like
+// "0 0 30 2 *").
+func (s *Schedule) Next(t time.Time) time.Time {
+	loc := t.Location()
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.AddDate(5, 0, 0)
+
+	for t.Before(limit) {
+		if s.month&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, loc)
+			continue
+		}
+		if !s.dayMatches(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc)
+			continue
+		}
+		if s.hour&(1<<uint(t.Hour())) == 0 {
+			// Adding, not setting the hour, so DST changes can't loop
+			t = t.Add(time.Duration(60-t.Minute()) * time.Minute)
+			continue
+		}
+		if s.minute&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
+
+func (s *Schedule) dayMatches(t time.Time) bool {
+	dom := s.dom&(1<<uint(t.Day())) != 0
+	dow := s.dow&(1<<uint(t.Weekday())) != 0
+	if s.domAny || s.dowAny {
+		return dom && dow
+	}
+	return dom || dow
+}
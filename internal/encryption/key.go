@@ -0,0 +1,98 @@
+package encryption
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"fmt"
+)
+
+const (
+	// KeySize is the size of a master key in bytes
+	KeySize = 32
+
+	// version prefixes every sealed value so the format can change later
+	version byte = 1
+)
+
+// Key encrypts backup data with AES-256-GCM. The encryption and nonce keys
+// are derived from the master key, which never touches the server.
+type Key struct {
+	aead     cipher.AEAD
+	nonceKey []byte
+}
+
+// NewKey creates a key from a 32-byte master key
+func NewKey(master []byte) (*Key, error) {
+	if len(master) != KeySize {
+		return nil, fmt.Errorf("invalid key size %d, expected %d", len(master), KeySize)
+	}
+
+	block, err := aes.NewCipher(derive(master, "ib encryption"))
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Key{aead: aead, nonceKey: derive(master, "ib nonce")}, nil
+}
+
+// LoadKeyFile loads a raw or passphrase-protected key file. passphrase is
+// only called for protected files.
+func LoadKeyFile(path string, passphrase func() (string, error)) (*Key, error) {
+	master, err := LoadMasterKey(path, passphrase)
+	if err != nil {
+		return nil, err
+	}
+	return NewKey(master)
+}
+
+// Seal encrypts plaintext with a random nonce
+func (k *Key) Seal(plaintext []byte) ([]byte, error) {
+	nonce := make([]byte, k.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	return k.seal(nonce, plaintext), nil
+}
+
+// SealConvergent encrypts plaintext with a nonce derived from the plaintext,
+// so equal blocks encrypt to equal ciphertexts and still deduplicate. This
+// reveals which blocks are identical, but nothing about their contents.
+func (k *Key) SealConvergent(plaintext []byte) []byte {
+	nonce := derive(k.nonceKey, string(plaintext))[:k.aead.NonceSize()]
+	return k.seal(nonce, plaintext)
+}
+
+// Open decrypts a value from Seal or SealConvergent
+func (k *Key) Open(sealed []byte) ([]byte, error) {
+	nonceSize := k.aead.NonceSize()
+	if len(sealed) < 1+nonceSize || sealed[0] != version {
+		return nil, fmt.Errorf("not an encrypted value")
+	}
+	nonce := sealed[1 : 1+nonceSize]
+	plaintext, err := k.aead.Open(nil, nonce, sealed[1+nonceSize:], []byte{version})
+	if err != nil {
+		return nil, fmt.Errorf("decryption failed (wrong key?)")
+	}
+	return plaintext, nil
+}
+
+// seal returns version || nonce || ciphertext
+func (k *Key) seal(nonce, plaintext []byte) []byte {
+	out := make([]byte, 0, 1+len(nonce)+len(plaintext)+k.aead.Overhead())
+	out = append(out, version)
+	out = append(out, nonce...)
+	return k.aead.Seal(out, nonce, plaintext, []byte{version})
+}
+
+func derive(key []byte, label string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(label))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,189 @@
+package encryption
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// argon2id parameters for new key slots (RFC 9106 second recommendation)
+const (
+	argonTime    = 3
+	argonMemory  = 64 * 1024 // KiB
+	argonThreads = 4
+)
+
+// KeyFile holds the master key wrapped by one or more passphrase slots, so
+// each team member can unlock the same backups with their own passphrase
+type KeyFile struct {
+	Version int       `json:"version"`
+	Slots   []KeySlot `json:"slots"`
+}
+
+// KeySlot is the master key encrypted with a key derived from a passphrase
+type KeySlot struct {
+	Name    string `json:"name"`
+	Salt    string `json:"salt"`
+	Time    uint32 `json:"time"`
+	Memory  uint32 `json:"memory"`
+	Threads uint8  `json:"threads"`
+	Wrapped string `json:"wrapped"`
+}
+
+// GenerateMasterKey returns a random master key
+func GenerateMasterKey() ([]byte, error) {
+	master := make([]byte, KeySize)
+	if _, err := rand.Read(master); err != nil {
+		return nil, fmt.Errorf("failed to generate key: %w", err)
+	}
+	return master, nil
+}
+
+// WriteRawKeyFile writes an unprotected, hex-encoded master key
+func WriteRawKeyFile(path string, master []byte) error {
+	return os.WriteFile(path, []byte(hex.EncodeToString(master)+"\n"), 0600)
+}
+
+// ReadKeyFile reads a passphrase-protected key file
+func ReadKeyFile(path string) (*KeyFile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key file: %w", err)
+	}
+	if !isKeyFile(data) {
+		return nil, fmt.Errorf("%s is a raw key file without passphrase slots", path)
+	}
+
+	var f KeyFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("invalid key file %s: %w", path, err)
+	}
+	return &f, nil
+}
+
+// Save writes the key file
+func (f *KeyFile) Save(path string) error {
+	data, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0600)
+}
+
+// AddSlot wraps master with a key derived from passphrase
+func (f *KeyFile) AddSlot(master []byte, name, passphrase string) error {
+	if passphrase == "" {
+		return fmt.Errorf("passphrase must not be empty")
+	}
+	for _, slot := range f.Slots {
+		if slot.Name == name {
+			return fmt.Errorf("key slot %q already exists", name)
+		}
+	}
+
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return fmt.Errorf("failed to generate salt: %w", err)
+	}
+
+	slot := KeySlot{
+		Name:    name,
+		Salt:    hex.EncodeToString(salt),
+		Time:    argonTime,
+		Memory:  argonMemory,
+		Threads: argonThreads,
+	}
+	kek, err := slot.key(passphrase)
+	if err != nil {
+		return err
+	}
+	wrapped, err := kek.Seal(master)
+	if err != nil {
+		return err
+	}
+	slot.Wrapped = hex.EncodeToString(wrapped)
+
+	f.Version = 1
+	f.Slots = append(f.Slots, slot)
+	return nil
+}
+
+// RemoveSlot deletes a slot. The last slot can't be removed.
+func (f *KeyFile) RemoveSlot(name string) error {
+	for i, slot := range f.Slots {
+		if slot.Name != name {
+			continue
+		}
+		if len(f.Slots) == 1 {
+			return fmt.Errorf("can't remove the last key slot")
+		}
+		f.Slots = append(f.Slots[:i], f.Slots[i+1:]...)
+		return nil
+	}
+	return fmt.Errorf("key slot %q not found", name)
+}
+
+// Unlock returns the master key from the first slot matching passphrase
+func (f *KeyFile) Unlock(passphrase string) ([]byte, error) {
+	for _, slot := range f.Slots {
+		kek, err := slot.key(passphrase)
+		if err != nil {
+			return nil, err
+		}
+		wrapped, err := hex.DecodeString(slot.Wrapped)
+		if err != nil {
+			return nil, fmt.Errorf("invalid key slot %q: %w", slot.Name, err)
+		}
+		if master, err := kek.Open(wrapped); err == nil {
+			return master, nil
+		}
+	}
+	return nil, fmt.Errorf("wrong passphrase")
+}
+
+// key derives the slot's key-encryption key with argon2id
+func (s *KeySlot) key(passphrase string) (*Key, error) {
+	salt, err := hex.DecodeString(s.Salt)
+	if err != nil {
+		return nil, fmt.Errorf("invalid key slot %q: %w", s.Name, err)
+	}
+	return NewKey(argon2.IDKey([]byte(passphrase), salt, s.Time, s.Memory, s.Threads, KeySize))
+}
+
+// LoadMasterKey reads the master key from a raw or passphrase-protected key
+// file. passphrase is only called for protected files.
+func LoadMasterKey(path string, passphrase func() (string, error)) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key file: %w", err)
+	}
+
+	if !isKeyFile(data) {
+		master, err := hex.DecodeString(strings.TrimSpace(string(data)))
+		if err != nil {
+			return nil, fmt.Errorf("invalid key file %s: %w", path, err)
+		}
+		return master, nil
+	}
+
+	f, err := ReadKeyFile(path)
+	if err != nil {
+		return nil, err
+	}
+	pass, err := passphrase()
+	if err != nil {
+		return nil, err
+	}
+	return f.Unlock(pass)
+}
+
+// isKeyFile reports whether data is a JSON key file rather than a raw key
+func isKeyFile(data []byte) bool {
+	return bytes.HasPrefix(bytes.TrimSpace(data), []byte("{"))
+}
@@ -0,0 +1,225 @@
+package errreport
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/johann/ib/internal/logging"
+)
+
+var logger = logging.For("errreport")
+
+// reporter sends events to a Sentry-compatible store endpoint
+type reporter struct {
+	endpoint string // https://host/api/<project>/store/
+	key      string
+	service  string
+	hostname string
+	client   *http.Client
+	wg       sync.WaitGroup
+}
+
+var current *reporter
+
+// Setup enables error reporting to a Sentry-compatible DSN of the form
+// https://<key>@host/<project>. With an empty DSN reporting stays disabled.
+func Setup(dsn, service string) error {
+	if dsn == "" {
+		return nil
+	}
+
+	u, err := url.Parse(dsn)
+	if err != nil || u.User == nil || u.Host == "" {
+		return fmt.Errorf("invalid error report DSN")
+	}
+	project := strings.Trim(u.Path, "/")
+	if project == "" {
+		return fmt.Errorf("invalid error report DSN: missing project ID")
+	}
+	prefix := ""
+	if i := strings.LastIndex(project, "/"); i >= 0 {
+		prefix, project = "/"+project[:i], project[i+1:]
+	}
+
+	hostname, _ := os.Hostname()
+	current = &reporter{
+		endpoint: fmt.Sprintf("%s://%s%s/api/%s/store/", u.Scheme, u.Host, prefix, project),
+		key:      u.User.Username(),
+		service:  service,
+		hostname: hostname,
+		client:   &http.Client{Timeout: 10 * time.Second},
+	}
+	return nil
+}
+
+// Enabled reports whether Setup was given a DSN
+func Enabled() bool {
+	return current != nil
+}
+
+// Error reports a failed operation (e.g. "backup create") in the
+// background. Events are grouped by op.
+func Error(op string, err error) {
+	if current == nil || err == nil {
+		return
+	}
+	current.send("error", op, err.Error(), callers(3), nil)
+}
+
+// Panic reports a recovered panic in the background. Call it from the
+// deferred function so the stack still shows where the panic happened.
+func Panic(v any) {
+	if current == nil {
+		return
+	}
+	current.send("fatal", "panic", fmt.Sprint(v), callers(3), nil)
+}
+
+// LogRecord reports an error-level log record; pass it to
+// logging.SetErrorHook
+func LogRecord(component string, r slog.Record) {
+	if current == nil {
+		return
+	}
+	var b strings.Builder
+	b.WriteString(r.Message)
+	r.Attrs(func(a slog.Attr) bool {
+		fmt.Fprintf(&b, " %s=%v", a.Key, a.Value)
+		return true
+	})
+	var stack []frame
+	if r.PC != 0 {
+		f, _ := runtime.CallersFrames([]uintptr{r.PC}).Next()
+		stack = []frame{toFrame(f)}
+	}
+	current.send("error", r.Message, b.String(), stack, map[string]string{"component": component})
+}
+
+// Flush waits up to timeout for pending reports to be sent
+func Flush(timeout time.Duration) {
+	if current == nil {
+		return
+	}
+	done := make(chan struct{})
+	go func() {
+		current.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(timeout):
+	}
+}
+
+type frame struct {
+	Function string `json:"function"`
+	Module   string `json:"module,omitempty"`
+	Filename string `json:"filename"`
+	Lineno   int    `json:"lineno"`
+}
+
+// callers returns the stack above skip, outermost first as Sentry expects
+func callers(skip int) []frame {
+	pcs := make([]uintptr, 64)
+	n := runtime.Callers(skip, pcs)
+	frames := runtime.CallersFrames(pcs[:n])
+
+	var stack []frame
+	for {
+		f, more := frames.Next()
+		stack = append([]frame{toFrame(f)}, stack...)
+		if !more {
+			break
+		}
+	}
+	return stack
+}
+
+// toFrame keeps only the file base name so build paths don't leak
+func toFrame(f runtime.Frame) frame {
+	fn := f.Function
+	module := ""
+	if i := strings.LastIndex(fn, "/"); i >= 0 {
+		if j := strings.Index(fn[i:], "."); j >= 0 {
+			module, fn = fn[:i+j], fn[i+j+1:]
+		}
+	} else if j := strings.Index(fn, "."); j >= 0 {
+		module, fn = fn[:j], fn[j+1:]
+	}
+	file := f.File
+	if i := strings.LastIndex(file, "/"); i >= 0 {
+		file = file[i+1:]
+	}
+	return frame{Function: fn, Module: module, Filename: file, Lineno: f.Line}
+}
+
+func (r *reporter) send(level, typ, message string, stack []frame, tags map[string]string) {
+	id := make([]byte, 16)
+	rand.Read(id)
+
+	allTags := map[string]string{"service": r.service}
+	for k, v := range tags {
+		allTags[k] = v
+	}
+
+	event := map[string]any{
+		"event_id":    hex.EncodeToString(id),
+		"timestamp":   time.Now().UTC().Format(time.RFC3339),
+		"level":       level,
+		"platform":    "go",
+		"logger":      r.service,
+		"server_name": r.hostname,
+		"tags":        allTags,
+		"contexts": map[string]any{
+			"runtime": map[string]string{"name": "go", "version": runtime.Version()},
+			"os":      map[string]string{"name": runtime.GOOS},
+		},
+		"exception": map[string]any{
+			"values": []map[string]any{{
+				"type":       typ,
+				"value":      Scrub(message),
+				"stacktrace": map[string]any{"frames": stack},
+			}},
+		},
+	}
+	body, err := json.Marshal(event)
+	if err != nil {
+		return
+	}
+
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		req, err := http.NewRequestWithContext(ctx, "POST", r.endpoint, bytes.NewReader(body))
+		if err != nil {
+			return
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Sentry-Auth", fmt.Sprintf("Sentry sentry_version=7, sentry_client=ib/1.0, sentry_key=%s", r.key))
+
+		resp, err := r.client.Do(req)
+		if err != nil {
+			logger.Debug("failed to send error report", "error", err)
+			return
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 300 {
+			logger.Debug("error report rejected", "status", resp.StatusCode)
+		}
+	}()
+}
@@ -0,0 +1,31 @@
+package errreport
+
+import "regexp"
+
+// scrubbers replace secrets and file paths in reported messages. Order
+// matters: tokens inside paths or URLs are caught before the path is.
+var scrubbers = []struct {
+	re   *regexp.Regexp
+	repl string
+}{
+	// Named tokens (ib_<id>_<secret>) and bearer/signed Authorization values
+	{regexp.MustCompile(`ib_[0-9a-f]{16}_[0-9a-f]{64}`), "[token]"},
+	{regexp.MustCompile(`(?i)(bearer|IB-HMAC)\s+\S+`), "$1 [redacted]"},
+	// Legacy tokens, signing keys and signatures are long hex strings
+	{regexp.MustCompile(`\b[0-9a-fA-F]{40,}\b`), "[hex]"},
+	// Secrets in query strings and key=value pairs
+	{regexp.MustCompile(`(?i)\b(token|sig|secret|password|key|otp|link)=[^\s&"',]+`), "$1=[redacted]"},
+	// Credentials in URLs
+	{regexp.MustCompile(`://[^/\s:@]+:[^/\s@]+@`), "://[redacted]@"},
+	// Absolute Unix and Windows paths, but not the // of a URL
+	{regexp.MustCompile(`(^|[\s"'(=:])/[^/\s"'):,][^\s"'):,]*`), "$1[path]"},
+	{regexp.MustCompile(`\b[A-Za-z]:\\[^\s"'):,]*`), "[path]"},
+}
+
+// Scrub removes tokens, keys and file paths from s
+func Scrub(s string) string {
+	for _, sc := range scrubbers {
+		s = sc.re.ReplaceAllString(s, sc.repl)
+	}
+	return s
+}
@@ -11,12 +11,19 @@ import (
 
 // StorageBackend interface for the blockstore to access storage
 type StorageBackend interface {
-	GetBlock(ctx context.Context, cid string) ([]byte, error)
+	GetBlock(ctx context.Context, cid string) ([]byte, error) // Original, decompressed data
 	GetNode(ctx context.Context, cid string) ([]byte, error)
 	BlockExists(ctx context.Context, cid string) (bool, error)
 	NodeExists(ctx context.Context, cid string) (bool, error)
 }
 
+// BlockWriter is implemented by storage backends that accept blocks fetched
+// from other peers (used for replication)
+type BlockWriter interface {
+	SaveBlock(ctx context.Context, cid string, data []byte, originalSize int64, compression string) error
+	SaveNode(ctx context.Context, cid string, data []byte) error
+}
+
 // Blockstore implements the IPFS blockstore interface backed by our storage
 type Blockstore struct {
 	storage StorageBackend
@@ -31,8 +38,8 @@ func NewBlockstore(storage StorageBackend) *Blockstore {
 func (bs *Blockstore) Has(ctx context.Context, c cid.Cid) (bool, error) {
 	cidStr := c.String()
 
-	// Check if it's a dag-pb node first
-	if c.Type() == cid.DagProtobuf {
+	// Check if it's a node (dag-pb, or the dag-json metadata node) first
+	if c.Type() != cid.Raw {
 		exists, err := bs.storage.NodeExists(ctx, cidStr)
 		if err == nil && exists {
 			return true, nil
@@ -47,27 +54,20 @@ func (bs *Blockstore) Has(ctx context.Context, c cid.Cid) (bool, error) {
 func (bs *Blockstore) Get(ctx context.Context, c cid.Cid) (blocks.Block, error) {
 	cidStr := c.String()
 
-	// Check if it's a dag-pb node first
-	if c.Type() == cid.DagProtobuf {
+	// Check if it's a node (dag-pb, or the dag-json metadata node) first
+	if c.Type() != cid.Raw {
 		data, err := bs.storage.GetNode(ctx, cidStr)
 		if err == nil {
 			return blocks.NewBlockWithCid(data, c)
 		}
 	}
 
-	// Get raw data block and decompress
-	compressedData, err := bs.storage.GetBlock(ctx, cidStr)
+	// Get raw data block, decompressed by the storage
+	data, err := bs.storage.GetBlock(ctx, cidStr)
 	if err != nil {
 		return nil, err
 	}
 
-	// Decompress the block
-	data, err := backup.Decompress(compressedData, backup.ChunkSize)
-	if err != nil {
-		// Might not be compressed
-		data = compressedData
-	}
-
 	return blocks.NewBlockWithCid(data, c)
 }
 
@@ -80,14 +80,41 @@ func (bs *Blockstore) GetSize(ctx context.Context, c cid.Cid) (int, error) {
 	return len(block.RawData()), nil
 }
 
-// Put is not supported - blocks are added via the backup process
+// Put stores a block received over bitswap. Only blocks we asked for are
+// delivered here, so this is only reached while replicating.
 func (bs *Blockstore) Put(ctx context.Context, block blocks.Block) error {
-	return errors.New("blockstore is read-only")
+	writer, ok := bs.storage.(BlockWriter)
+	if !ok {
+		return errors.New("blockstore is read-only")
+	}
+
+	c := block.Cid()
+	data := block.RawData()
+
+	if c.Type() != cid.Raw {
+		return writer.SaveNode(ctx, c.String(), data)
+	}
+
+	// Raw blocks are stored LZ4 compressed behind a header, like uploaded
+	// blocks
+	stored := make([]byte, backup.BlockHeaderSize+len(data))
+	codec := backup.CompressionLZ4
+	n, err := backup.CompressBlock(data, stored[backup.BlockHeaderSize:])
+	if err != nil || n == 0 || n >= len(data) {
+		codec, n = "", copy(stored[backup.BlockHeaderSize:], data)
+	}
+	backup.PutBlockHeader(stored, codec, data)
+	return writer.SaveBlock(ctx, c.String(), stored[:backup.BlockHeaderSize+n], int64(len(data)), backup.CompressionHeader)
 }
 
-// PutMany is not supported
+// PutMany stores multiple blocks
 func (bs *Blockstore) PutMany(ctx context.Context, blocks []blocks.Block) error {
-	return errors.New("blockstore is read-only")
+	for _, block := range blocks {
+		if err := bs.Put(ctx, block); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 // DeleteBlock is not supported
@@ -2,6 +2,7 @@ package ipfsnode
 
 import (
 	"crypto/sha256"
+	"sort"
 
 	"github.com/ipfs/go-cid"
 	mh "github.com/multiformats/go-multihash"
@@ -26,6 +27,10 @@ const (
 	unixfsTypeDirectory = 1
 )
 
+// KuboLinksPerNode is the maximum number of links per file node in kubo's
+// balanced DAG layout (boxo helpers.DefaultLinksPerBlock)
+const KuboLinksPerNode = 174
+
 // BuildFileNode creates a UnixFS file node for a multi-block file.
 // For single-block files, just use the raw block CID directly (returns nil).
 func BuildFileNode(blockCIDs []string, blockSizes []uint64, totalSize uint64) (*DAGNode, error) {
@@ -69,9 +74,13 @@ func BuildDirNode(entries []DirEntry) (*DAGNode, error) {
 	// Build UnixFS Data field for directory
 	unixfsData := encodeUnixFSDirectory()
 
-	// Build dag-pb node with links
-	links := make([]pbLink, len(entries))
-	for i, entry := range entries {
+	// Build dag-pb node with links, sorted by name like go-merkledag
+	sorted := make([]DirEntry, len(entries))
+	copy(sorted, entries)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Name < sorted[j].Name })
+
+	links := make([]pbLink, len(sorted))
+	for i, entry := range sorted {
 		links[i] = pbLink{
 			Hash:  entry.Cid.Bytes(),
 			Name:  entry.Name,
@@ -88,11 +97,75 @@ func BuildDirNode(entries []DirEntry) (*DAGNode, error) {
 	}, nil
 }
 
+// BuildBalancedFileNodes creates a UnixFS file DAG using kubo's balanced
+// layout: leaves are grouped into nodes of at most KuboLinksPerNode links,
+// level by level, until a single root remains. Returns all intermediate nodes
+// with the root last, or nil for single-block files (the leaf is the file).
+func BuildBalancedFileNodes(blockCIDs []string, blockSizes []uint64) ([]*DAGNode, error) {
+	if len(blockCIDs) <= 1 {
+		return nil, nil
+	}
+
+	type levelItem struct {
+		cid      cid.Cid
+		fileSize uint64 // Bytes of file content below this item
+		tsize    uint64 // Cumulative serialized DAG size below this item
+	}
+
+	level := make([]levelItem, len(blockCIDs))
+	for i, cidStr := range blockCIDs {
+		c, err := cid.Decode(cidStr)
+		if err != nil {
+			return nil, err
+		}
+		level[i] = levelItem{cid: c, fileSize: blockSizes[i], tsize: blockSizes[i]}
+	}
+
+	var nodes []*DAGNode
+	for len(level) > 1 {
+		var next []levelItem
+		for start := 0; start < len(level); start += KuboLinksPerNode {
+			end := start + KuboLinksPerNode
+			if end > len(level) {
+				end = len(level)
+			}
+			children := level[start:end]
+
+			links := make([]pbLink, len(children))
+			sizes := make([]uint64, len(children))
+			var fileSize, tsize uint64
+			for i, child := range children {
+				links[i] = pbLink{
+					Hash:    child.cid.Bytes(),
+					Tsize:   child.tsize,
+					NameSet: true,
+				}
+				sizes[i] = child.fileSize
+				fileSize += child.fileSize
+				tsize += child.tsize
+			}
+
+			pbData := encodePBNode(links, encodeUnixFSFile(fileSize, sizes))
+			node := &DAGNode{Cid: computeDagPBCid(pbData), Data: pbData}
+			nodes = append(nodes, node)
+			next = append(next, levelItem{
+				cid:      node.Cid,
+				fileSize: fileSize,
+				tsize:    tsize + uint64(len(pbData)),
+			})
+		}
+		level = next
+	}
+
+	return nodes, nil
+}
+
 // pbLink represents a link in a dag-pb node
 type pbLink struct {
-	Hash  []byte
-	Name  string
-	Tsize uint64
+	Hash    []byte
+	Name    string
+	Tsize   uint64
+	NameSet bool // Encode Name even when empty, as go-merkledag does
 }
 
 // encodeUnixFSFile encodes UnixFS file data
@@ -175,7 +248,7 @@ func encodePBLink(link pbLink) []byte {
 	}
 
 	// Name (field 2)
-	if link.Name != "" {
+	if link.Name != "" || link.NameSet {
 		buf = append(buf, 0x12) // field 2, wire type 2
 		buf = appendVarint(buf, uint64(len(link.Name)))
 		buf = append(buf, link.Name...)
@@ -0,0 +1,136 @@
+package ipfsnode
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/ipfs/boxo/bitswap"
+	bsnet "github.com/ipfs/boxo/bitswap/network"
+	"github.com/ipfs/boxo/blockstore"
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	"github.com/libp2p/go-libp2p"
+	dht "github.com/libp2p/go-libp2p-kad-dht"
+	routinghelpers "github.com/libp2p/go-libp2p-routing-helpers"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/routing"
+	"github.com/multiformats/go-multiaddr"
+)
+
+// FetcherConfig configures a client-side block fetcher
+type FetcherConfig struct {
+	Peers  []string // Peer multiaddrs including /p2p/<peer-id> (servers or replicas)
+	UseDHT bool     // Find providers via the DHT in addition to the given peers
+}
+
+// Fetcher is a lightweight libp2p host that retrieves blocks over bitswap.
+// It does not listen or serve blocks; received blocks are kept in memory
+// only until they are handed to the caller.
+type Fetcher struct {
+	host       host.Host
+	dht        *dht.IpfsDHT
+	blockstore blockstore.Blockstore
+	bswap      *bitswap.Bitswap
+}
+
+// NewFetcher creates a fetcher and connects to the configured peers
+func NewFetcher(ctx context.Context, cfg *FetcherConfig) (*Fetcher, error) {
+	if len(cfg.Peers) == 0 && !cfg.UseDHT {
+		return nil, fmt.Errorf("no peers configured and DHT disabled")
+	}
+
+	var peers []peer.AddrInfo
+	for _, addrStr := range cfg.Peers {
+		addr, err := multiaddr.NewMultiaddr(addrStr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid peer address %s: %w", addrStr, err)
+		}
+		peerInfo, err := peer.AddrInfoFromP2pAddr(addr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid peer address %s: %w", addrStr, err)
+		}
+		peers = append(peers, *peerInfo)
+	}
+
+	h, err := libp2p.New(libp2p.NoListenAddrs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create libp2p host: %w", err)
+	}
+
+	f := &Fetcher{
+		host:       h,
+		blockstore: blockstore.NewBlockstore(dssync.MutexWrap(datastore.NewMapDatastore())),
+	}
+
+	// Without the DHT, bitswap only asks directly connected peers
+	var router routing.ContentRouting = routinghelpers.Null{}
+	if cfg.UseDHT {
+		f.dht, err = dht.New(ctx, h, dht.Mode(dht.ModeClient), dht.BootstrapPeers(dht.GetDefaultBootstrapPeerAddrInfos()...))
+		if err != nil {
+			f.Close()
+			return nil, fmt.Errorf("failed to create DHT: %w", err)
+		}
+		if err := f.dht.Bootstrap(ctx); err != nil {
+			f.Close()
+			return nil, fmt.Errorf("failed to bootstrap DHT: %w", err)
+		}
+		router = f.dht
+	}
+
+	f.bswap = bitswap.New(ctx, bsnet.NewFromIpfsHost(h, router), f.blockstore)
+
+	// Connect to the given peers; a single reachable peer is enough
+	connected := 0
+	for _, pi := range peers {
+		connectCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+		err := h.Connect(connectCtx, pi)
+		cancel()
+		if err != nil {
+			logger.Warn("failed to connect to peer", "peer", pi.ID, "error", err)
+			continue
+		}
+		connected++
+	}
+	if len(peers) > 0 && connected == 0 && !cfg.UseDHT {
+		f.Close()
+		return nil, fmt.Errorf("could not connect to any peer")
+	}
+
+	return f, nil
+}
+
+// DownloadBlock fetches a block over bitswap and returns its data.
+// Implements backup.BlockFetcher.
+func (f *Fetcher) DownloadBlock(ctx context.Context, cidStr string) ([]byte, error) {
+	c, err := cid.Decode(cidStr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid CID %s: %w", cidStr, err)
+	}
+
+	block, err := f.bswap.GetBlock(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+
+	// Blocks are written straight to disk by the restorer, don't keep them
+	f.blockstore.DeleteBlock(ctx, c)
+
+	return block.RawData(), nil
+}
+
+// Close shuts down the fetcher
+func (f *Fetcher) Close() error {
+	if f.bswap != nil {
+		f.bswap.Close()
+	}
+	if f.dht != nil {
+		f.dht.Close()
+	}
+	if f.host != nil {
+		f.host.Close()
+	}
+	return nil
+}
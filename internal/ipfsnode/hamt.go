@@ -0,0 +1,140 @@
+package ipfsnode
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"sort"
+
+	"github.com/spaolacci/murmur3"
+)
+
+// HAMT sharding parameters (UnixFS spec defaults, as used by kubo)
+const (
+	hamtFanout          = 256
+	hamtHashType        = 0x22 // murmur3-x64-64
+	unixfsTypeHAMTShard = 5
+
+	// DefaultShardThreshold is the number of directory entries above which
+	// directories are emitted as HAMT shards
+	DefaultShardThreshold = 2000
+)
+
+// shardEntry is a directory entry with its precomputed name hash
+type shardEntry struct {
+	entry DirEntry
+	hash  []byte
+}
+
+// BuildShardedDirNode creates a HAMT-sharded UnixFS directory. Sub-shards are
+// saved via the NodeSaver; the returned root node is left to the caller.
+func BuildShardedDirNode(ctx context.Context, entries []DirEntry, saver NodeSaver) (*DAGNode, error) {
+	shardEntries := make([]shardEntry, len(entries))
+	for i, entry := range entries {
+		shardEntries[i] = shardEntry{entry: entry, hash: hamtHash(entry.Name)}
+	}
+
+	node, _, err := buildShard(ctx, shardEntries, 0, saver)
+	return node, err
+}
+
+// buildShard builds one shard level, consuming one byte of the name hash
+// (log2 of the 256 fanout) per level. Returns the node and its content size.
+func buildShard(ctx context.Context, entries []shardEntry, depth int, saver NodeSaver) (*DAGNode, uint64, error) {
+	buckets := make(map[int][]shardEntry)
+	for _, e := range entries {
+		if depth >= len(e.hash) {
+			return nil, 0, fmt.Errorf("HAMT hash exhausted for %q", e.entry.Name)
+		}
+		idx := int(e.hash[depth])
+		buckets[idx] = append(buckets[idx], e)
+	}
+
+	indexes := make([]int, 0, len(buckets))
+	for idx := range buckets {
+		indexes = append(indexes, idx)
+	}
+	sort.Ints(indexes)
+
+	bitfield := make([]byte, hamtFanout/8)
+	links := make([]pbLink, 0, len(indexes))
+	var totalSize uint64
+
+	for _, idx := range indexes {
+		bucket := buckets[idx]
+		prefix := fmt.Sprintf("%02X", idx)
+		bitfield[len(bitfield)-1-idx/8] |= 1 << uint(idx%8)
+
+		if len(bucket) == 1 {
+			// Single entry in this slot - link to it directly
+			e := bucket[0].entry
+			links = append(links, pbLink{
+				Hash:  e.Cid.Bytes(),
+				Name:  prefix + e.Name,
+				Tsize: e.Size,
+			})
+			totalSize += e.Size
+			continue
+		}
+
+		// Collision - push the entries down into a sub-shard
+		child, childSize, err := buildShard(ctx, bucket, depth+1, saver)
+		if err != nil {
+			return nil, 0, err
+		}
+		if err := saver.SaveNode(ctx, child.Cid.String(), child.Data); err != nil {
+			return nil, 0, err
+		}
+		links = append(links, pbLink{
+			Hash:  child.Cid.Bytes(),
+			Name:  prefix,
+			Tsize: childSize,
+		})
+		totalSize += childSize
+	}
+
+	// Leading zero bytes are dropped, matching go-bitfield's Bytes()
+	for len(bitfield) > 0 && bitfield[0] == 0 {
+		bitfield = bitfield[1:]
+	}
+
+	pbData := encodePBNode(links, encodeUnixFSHAMTShard(bitfield))
+	return &DAGNode{
+		Cid:  computeDagPBCid(pbData),
+		Data: pbData,
+	}, totalSize, nil
+}
+
+// hamtHash returns the 64-bit murmur3 hash of a name, big-endian
+func hamtHash(name string) []byte {
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, murmur3.Sum64([]byte(name)))
+	return buf
+}
+
+// encodeUnixFSHAMTShard encodes UnixFS HAMT shard data
+func encodeUnixFSHAMTShard(bitfield []byte) []byte {
+	// UnixFS protobuf:
+	// message Data {
+	//   required DataType Type = 1;
+	//   optional bytes Data = 2;     // bitfield of occupied slots
+	//   optional uint64 hashType = 5;
+	//   optional uint64 fanout = 6;
+	// }
+	var buf []byte
+
+	buf = append(buf, 0x08) // field 1, wire type 0 (varint)
+	buf = appendVarint(buf, unixfsTypeHAMTShard)
+
+	buf = append(buf, 0x12) // field 2, wire type 2 (length-delimited)
+	buf = appendVarint(buf, uint64(len(bitfield)))
+	buf = append(buf, bitfield...)
+
+	buf = append(buf, 0x28) // field 5, wire type 0 (varint)
+	buf = appendVarint(buf, hamtHashType)
+
+	buf = append(buf, 0x30) // field 6, wire type 0 (varint)
+	buf = appendVarint(buf, hamtFanout)
+
+	return buf
+}
@@ -0,0 +1,86 @@
+package ipfsnode
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	dssync "github.com/ipfs/go-datastore/sync"
+	"github.com/ipni/go-libipni/metadata"
+	provider "github.com/ipni/index-provider"
+	"github.com/ipni/index-provider/engine"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/multiformats/go-multihash"
+)
+
+// IPNIConfig configures announcements to IPNI indexers
+type IPNIConfig struct {
+	IndexerURLs  []string // Indexer announce endpoints (e.g. https://cid.contact)
+	ListenAddr   string   // HTTP address serving the advertisement chain (e.g. ":3104")
+	AnnounceAddr string   // Public multiaddr of ListenAddr, e.g. /dns4/ib.example.com/tcp/3104/http
+}
+
+// startIPNI starts the index provider engine. Each manifest root is one
+// advertisement, keyed by the root CID, listing every multihash in its DAG.
+// The advertisement chain is kept in memory, so it is rebuilt on restart.
+func (n *Node) startIPNI(cfg *IPNIConfig) error {
+	opts := []engine.Option{
+		engine.WithHost(n.host),
+		engine.WithDatastore(dssync.MutexWrap(datastore.NewMapDatastore())),
+		engine.WithPublisherKind(engine.HttpPublisher),
+		engine.WithHttpPublisherListenAddr(cfg.ListenAddr),
+		engine.WithDirectAnnounce(cfg.IndexerURLs...),
+	}
+	if cfg.AnnounceAddr != "" {
+		opts = append(opts, engine.WithHttpPublisherAnnounceAddr(cfg.AnnounceAddr))
+	}
+
+	// Retrieval is over bitswap from this node's announced addresses
+	var retrievalAddrs []string
+	for _, addr := range n.host.Addrs() {
+		retrievalAddrs = append(retrievalAddrs, addr.String())
+	}
+	opts = append(opts, engine.WithRetrievalAddrs(retrievalAddrs...))
+
+	eng, err := engine.New(opts...)
+	if err != nil {
+		return err
+	}
+
+	eng.RegisterMultihashLister(func(ctx context.Context, _ peer.ID, contextID []byte) (provider.MultihashIterator, error) {
+		root, err := cid.Cast(contextID)
+		if err != nil {
+			return nil, fmt.Errorf("invalid context ID: %w", err)
+		}
+
+		var mhs []multihash.Multihash
+		err = n.walkDAG(ctx, root, func(c cid.Cid) error {
+			mhs = append(mhs, c.Hash())
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		return provider.SliceMultihashIterator(mhs), nil
+	})
+
+	if err := eng.Start(n.ctx); err != nil {
+		return err
+	}
+	n.ipni = eng
+	return nil
+}
+
+// AnnounceIPNI publishes an advertisement for the DAG under root.
+// No-op unless IPNI announcements are configured.
+func (n *Node) AnnounceIPNI(ctx context.Context, root cid.Cid) error {
+	if n.ipni == nil {
+		return nil
+	}
+	_, err := n.ipni.NotifyPut(ctx, nil, root.Bytes(), metadata.Default.New(metadata.Bitswap{}))
+	if err == provider.ErrAlreadyAdvertised {
+		return nil
+	}
+	return err
+}
@@ -9,8 +9,13 @@ import (
 
 	"github.com/ipfs/go-cid"
 	"github.com/johann/ib/internal/backup"
+	"github.com/johann/ib/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
+var tracer = tracing.Tracer("ipfs")
+
 // NodeCollector wraps a NodeSaver to collect saved node CIDs
 type NodeCollector struct {
 	saver    NodeSaver
@@ -57,11 +62,29 @@ type dirNode struct {
 	files    map[string]*backup.Entry
 }
 
+// DAGOptions controls how manifest DAGs are built
+type DAGOptions struct {
+	// ShardThreshold is the number of entries above which a directory is
+	// emitted as a HAMT shard (0 disables sharding)
+	ShardThreshold int
+}
+
+// DefaultDAGOptions returns the default DAG building options
+func DefaultDAGOptions() DAGOptions {
+	return DAGOptions{ShardThreshold: DefaultShardThreshold}
+}
+
 // BuildManifestDAG builds the UnixFS DAG structure for a manifest.
 // It creates file nodes for multi-block files and directory nodes,
 // saving them via the NodeSaver and updating the manifest with CIDs.
 // Returns the root CID.
-func BuildManifestDAG(ctx context.Context, manifest *backup.Manifest, saver NodeSaver) (cid.Cid, error) {
+func BuildManifestDAG(ctx context.Context, manifest *backup.Manifest, saver NodeSaver, opts DAGOptions) (root cid.Cid, err error) {
+	ctx, span := tracer.Start(ctx, "ipfs.BuildManifestDAG", trace.WithAttributes(
+		attribute.String("manifest.id", manifest.ID),
+		attribute.Int("manifest.entries", len(manifest.Entries)),
+	))
+	defer func() { tracing.End(span, err) }()
+
 	// Step 1: Process all file entries - create file nodes for multi-block files
 	for i := range manifest.Entries {
 		entry := &manifest.Entries[i]
@@ -78,19 +101,37 @@ func BuildManifestDAG(ctx context.Context, manifest *backup.Manifest, saver Node
 			entry.CID = entry.Blocks[0]
 		} else {
 			// Multi-block file - create a file node
+			chunkSize := uint64(manifest.EffectiveChunkSize())
 			blockSizes := make([]uint64, len(entry.Blocks))
 			for j := range blockSizes {
-				// We store 8MB chunks, but the last one might be smaller
-				// For simplicity, use ChunkSize for all but estimate from total size
-				if j < len(entry.Blocks)-1 {
-					blockSizes[j] = uint64(backup.ChunkSize)
+				if len(entry.BlockSizes) == len(entry.Blocks) {
+					// Content-defined chunks record their sizes
+					blockSizes[j] = uint64(entry.BlockSizes[j])
+				} else if j < len(entry.Blocks)-1 {
+					// All chunks are full size except the last one
+					blockSizes[j] = chunkSize
 				} else {
 					// Last block
-					remaining := uint64(entry.Size) - uint64(j)*uint64(backup.ChunkSize)
+					remaining := uint64(entry.Size) - uint64(j)*chunkSize
 					blockSizes[j] = remaining
 				}
 			}
 
+			if manifest.KuboCompat {
+				// Balanced multi-level DAG, identical to `ipfs add --cid-version=1`
+				fileNodes, err := BuildBalancedFileNodes(entry.Blocks, blockSizes)
+				if err != nil {
+					return cid.Undef, err
+				}
+				for _, node := range fileNodes {
+					if err := saver.SaveNode(ctx, node.Cid.String(), node.Data); err != nil {
+						return cid.Undef, err
+					}
+				}
+				entry.CID = fileNodes[len(fileNodes)-1].Cid.String()
+				continue
+			}
+
 			fileNode, err := BuildFileNode(entry.Blocks, blockSizes, uint64(entry.Size))
 			if err != nil {
 				return cid.Undef, err
@@ -106,8 +147,26 @@ func BuildManifestDAG(ctx context.Context, manifest *backup.Manifest, saver Node
 		}
 	}
 
-	// Step 2: Build directory tree from entries
-	rootCID, err := buildDirectoryTree(ctx, manifest.Entries, saver)
+	// Step 2: Build the metadata node linked from the root. Skipped in kubo
+	// compatible mode, where the root must match `ipfs add` exactly.
+	var rootExtras []DirEntry
+	if !manifest.KuboCompat {
+		metaNode, err := BuildMetadataNode(manifest)
+		if err != nil {
+			return cid.Undef, err
+		}
+		if err := saver.SaveNode(ctx, metaNode.Cid.String(), metaNode.Data); err != nil {
+			return cid.Undef, err
+		}
+		rootExtras = append(rootExtras, DirEntry{
+			Name: MetadataLinkName,
+			Cid:  metaNode.Cid,
+			Size: uint64(len(metaNode.Data)),
+		})
+	}
+
+	// Step 3: Build directory tree from entries
+	rootCID, err := buildDirectoryTree(ctx, manifest.Entries, saver, opts, rootExtras)
 	if err != nil {
 		return cid.Undef, err
 	}
@@ -118,8 +177,9 @@ func BuildManifestDAG(ctx context.Context, manifest *backup.Manifest, saver Node
 	return rootCID, nil
 }
 
-// buildDirectoryTree builds the directory node hierarchy from entries
-func buildDirectoryTree(ctx context.Context, entries []backup.Entry, saver NodeSaver) (cid.Cid, error) {
+// buildDirectoryTree builds the directory node hierarchy from entries.
+// rootExtras are added to the root directory as-is.
+func buildDirectoryTree(ctx context.Context, entries []backup.Entry, saver NodeSaver, opts DAGOptions, rootExtras []DirEntry) (cid.Cid, error) {
 	root := &dirNode{
 		children: make(map[string]*dirNode),
 		files:    make(map[string]*backup.Entry),
@@ -167,10 +227,10 @@ func buildDirectoryTree(ctx context.Context, entries []backup.Entry, saver NodeS
 	}
 
 	// Recursively build directory nodes bottom-up
-	return buildDirNodeRecursive(ctx, root, saver)
+	return buildDirNodeRecursive(ctx, root, saver, opts, rootExtras)
 }
 
-func buildDirNodeRecursive(ctx context.Context, dir *dirNode, saver NodeSaver) (cid.Cid, error) {
+func buildDirNodeRecursive(ctx context.Context, dir *dirNode, saver NodeSaver, opts DAGOptions, extras []DirEntry) (cid.Cid, error) {
 	var dirEntries []DirEntry
 
 	// Process child directories first
@@ -182,7 +242,7 @@ func buildDirNodeRecursive(ctx context.Context, dir *dirNode, saver NodeSaver) (
 
 	for _, name := range childNames {
 		child := dir.children[name]
-		childCID, err := buildDirNodeRecursive(ctx, child, saver)
+		childCID, err := buildDirNodeRecursive(ctx, child, saver, opts, nil)
 		if err != nil {
 			return cid.Undef, err
 		}
@@ -222,8 +282,16 @@ func buildDirNodeRecursive(ctx context.Context, dir *dirNode, saver NodeSaver) (
 		})
 	}
 
-	// Build directory node
-	node, err := BuildDirNode(dirEntries)
+	dirEntries = append(dirEntries, extras...)
+
+	// Build directory node, sharded if it has too many entries for one block
+	var node *DAGNode
+	var err error
+	if opts.ShardThreshold > 0 && len(dirEntries) > opts.ShardThreshold {
+		node, err = BuildShardedDirNode(ctx, dirEntries, saver)
+	} else {
+		node, err = BuildDirNode(dirEntries)
+	}
 	if err != nil {
 		return cid.Undef, err
 	}
@@ -0,0 +1,47 @@
+package ipfsnode
+
+import (
+	"context"
+	"time"
+
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/p2p/discovery/mdns"
+)
+
+// mdnsNotifee connects to peers discovered on the local network
+type mdnsNotifee struct {
+	host host.Host
+	ctx  context.Context
+}
+
+// HandlePeerFound is called by the mDNS service for each discovered peer
+func (m *mdnsNotifee) HandlePeerFound(pi peer.AddrInfo) {
+	if pi.ID == m.host.ID() {
+		return
+	}
+
+	go func() {
+		ctx, cancel := context.WithTimeout(m.ctx, 30*time.Second)
+		defer cancel()
+		if err := m.host.Connect(ctx, pi); err != nil {
+			logger.Warn("failed to connect to mDNS peer", "peer", pi.ID, "error", err)
+			return
+		}
+		logger.Info("connected to local peer via mDNS", "peer", pi.ID)
+	}()
+}
+
+// startMDNS starts local network peer discovery. Uses the default libp2p
+// service name so kubo nodes on the same LAN are discovered as well.
+func (n *Node) startMDNS() error {
+	service := mdns.NewMdnsService(n.host, mdns.ServiceName, &mdnsNotifee{
+		host: n.host,
+		ctx:  n.ctx,
+	})
+	if err := service.Start(); err != nil {
+		return err
+	}
+	n.mdns = service
+	return nil
+}
@@ -0,0 +1,68 @@
+package ipfsnode
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/johann/ib/internal/backup"
+	mh "github.com/multiformats/go-multihash"
+)
+
+// MetadataLinkName is the root directory entry linking to the backup's
+// dag-json metadata node
+const MetadataLinkName = ".ib-backup.json"
+
+// BuildMetadataNode creates a dag-json node describing the backup, so the
+// root CID is identifiable without the ib API. The manifest hash is the
+// sha256 of the manifest JSON with root_cid left empty.
+func BuildMetadataNode(manifest *backup.Manifest) (*DAGNode, error) {
+	unrooted := *manifest
+	unrooted.RootCID = ""
+	manifestJSON, err := json.Marshal(&unrooted)
+	if err != nil {
+		return nil, err
+	}
+	manifestHash := sha256.Sum256(manifestJSON)
+
+	var totalSize int64
+	for _, entry := range manifest.Entries {
+		totalSize += entry.Size
+	}
+
+	tags := make(map[string]any, len(manifest.Tags))
+	for k, v := range manifest.Tags {
+		tags[k] = v
+	}
+
+	// Maps marshal with sorted keys, as dag-json requires
+	meta := map[string]any{
+		"type":          "ib-backup",
+		"id":            manifest.ID,
+		"tags":          tags,
+		"created_at":    manifest.CreatedAt.UTC().Format(time.RFC3339),
+		"root_path":     manifest.RootPath,
+		"entry_count":   len(manifest.Entries),
+		"total_size":    totalSize,
+		"manifest_hash": "sha256:" + hex.EncodeToString(manifestHash[:]),
+	}
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(meta); err != nil {
+		return nil, err
+	}
+	data := bytes.TrimSuffix(buf.Bytes(), []byte("\n"))
+
+	hash := sha256.Sum256(data)
+	multihash, _ := mh.Encode(hash[:], mh.SHA2_256)
+
+	return &DAGNode{
+		Cid:  cid.NewCidV1(cid.DagJSON, multihash),
+		Data: data,
+	}, nil
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"net/http"
+	"sync"
 	"time"
 
 	"github.com/ipfs/boxo/bitswap"
@@ -13,15 +14,20 @@ import (
 	"github.com/ipfs/boxo/ipld/merkledag"
 	"github.com/ipfs/go-cid"
 	format "github.com/ipfs/go-ipld-format"
+	"github.com/ipni/index-provider/engine"
+	"github.com/johann/ib/internal/logging"
 	"github.com/libp2p/go-libp2p"
 	dht "github.com/libp2p/go-libp2p-kad-dht"
 	"github.com/libp2p/go-libp2p/core/host"
 	"github.com/libp2p/go-libp2p/core/peer"
 	"github.com/libp2p/go-libp2p/core/routing"
+	"github.com/libp2p/go-libp2p/p2p/discovery/mdns"
 	"github.com/libp2p/go-libp2p/p2p/net/connmgr"
 	"github.com/multiformats/go-multiaddr"
 )
 
+var logger = logging.For("ipfs")
+
 // Node represents an embedded IPFS node
 type Node struct {
 	host       host.Host
@@ -30,10 +36,18 @@ type Node struct {
 	bswap      *bitswap.Bitswap
 	dagService format.DAGService
 	gateway    *http.Server
+	gwHandler  http.Handler
+	mdns       mdns.Service
+	ipni       *engine.Engine
 
 	// Root CIDs to advertise
 	rootCIDs []cid.Cid
 
+	// Background providing of non-root CIDs
+	provideStrategy string
+	provideQueue    chan cid.Cid
+	reprovideMu     sync.Mutex
+
 	// For periodic re-advertising
 	ctx    context.Context
 	cancel context.CancelFunc
@@ -41,10 +55,22 @@ type Node struct {
 
 // Config for the IPFS node
 type Config struct {
-	ListenAddrs    []string // libp2p listen addresses
-	AnnounceAddrs  []string // Addresses to announce to the network (public IPs)
-	GatewayAddr    string   // HTTP gateway address (e.g., ":8080")
-	BootstrapPeers []string // Bootstrap peer addresses
+	ListenAddrs      []string // libp2p listen addresses
+	AnnounceAddrs    []string // Addresses to announce to the network (public IPs)
+	GatewayAddr      string   // HTTP gateway address (e.g., ":8080"), empty to skip the listener
+	GatewayMount     bool     // Build the gateway handler for mounting on another HTTP server
+	GatewayTrustless bool     // Only serve verifiable raw block and CAR responses
+	BootstrapPeers   []string // Bootstrap peer addresses
+	MDNSEnabled      bool     // Discover peers on the local network via mDNS
+
+	StaticRelays []string // Circuit relay v2 servers (multiaddrs with /p2p/) used by AutoRelay
+	AutoRelay    bool     // Reserve relay slots when not publicly reachable
+	RelayService bool     // Act as a circuit relay v2 server for other peers
+
+	ProvideStrategy string // roots, recursive or all (default roots)
+	ProvideRate     int    // DHT provides per second for the background queue
+
+	IPNI *IPNIConfig // Announce content to IPNI indexers, nil to disable
 }
 
 // DefaultConfig returns a default configuration
@@ -54,7 +80,11 @@ func DefaultConfig() *Config {
 			"/ip4/0.0.0.0/tcp/4001",
 			"/ip4/0.0.0.0/udp/4001/quic-v1",
 		},
-		GatewayAddr: ":8080",
+		GatewayAddr:     ":8080",
+		AutoRelay:       true,
+		RelayService:    true,
+		ProvideStrategy: ProvideRoots,
+		ProvideRate:     DefaultProvideRate,
 		BootstrapPeers: []string{
 			// IPFS/libp2p official bootstrap nodes
 			"/dnsaddr/bootstrap.libp2p.io/p2p/QmNnooDu7bfjPFoTZYxMNLWUQJyrVwtbZg5gBMjTezGAJN",
@@ -90,6 +120,16 @@ func NewNode(ctx context.Context, storage StorageBackend, cfg *Config) (*Node, e
 		cfg = DefaultConfig()
 	}
 
+	if cfg.ProvideStrategy == "" {
+		cfg.ProvideStrategy = ProvideRoots
+	}
+	if !validProvideStrategy(cfg.ProvideStrategy) {
+		return nil, fmt.Errorf("invalid provide strategy %q (expected roots, recursive or all)", cfg.ProvideStrategy)
+	}
+	if cfg.ProvideRate <= 0 {
+		cfg.ProvideRate = DefaultProvideRate
+	}
+
 	// Create blockstore
 	blockstore := NewBlockstore(storage)
 
@@ -119,17 +159,36 @@ func NewNode(ctx context.Context, storage StorageBackend, cfg *Config) (*Node, e
 		return nil, fmt.Errorf("failed to create connection manager: %w", err)
 	}
 
+	// Parse static relays; without any, fall back to the bootstrap peers
+	relays := dht.GetDefaultBootstrapPeerAddrInfos()
+	if len(cfg.StaticRelays) > 0 {
+		relays = nil
+		for _, addr := range cfg.StaticRelays {
+			ma, err := multiaddr.NewMultiaddr(addr)
+			if err != nil {
+				return nil, fmt.Errorf("invalid static relay %s: %w", addr, err)
+			}
+			peerInfo, err := peer.AddrInfoFromP2pAddr(ma)
+			if err != nil {
+				return nil, fmt.Errorf("invalid static relay %s: %w", addr, err)
+			}
+			relays = append(relays, *peerInfo)
+		}
+	}
+
 	// Build libp2p options
 	opts := []libp2p.Option{
 		libp2p.ListenAddrs(listenAddrs...),
 		libp2p.ConnectionManager(connMgr),
-		libp2p.NATPortMap(),                    // Enable UPnP/NAT-PMP
-		libp2p.EnableNATService(),              // Help others with NAT detection
-		libp2p.EnableHolePunching(),            // Enable hole punching for NAT traversal
-		libp2p.EnableRelayService(),            // Act as relay for others
-		libp2p.EnableAutoRelayWithStaticRelays( // Use relays if needed
-			dht.GetDefaultBootstrapPeerAddrInfos(),
-		),
+		libp2p.NATPortMap(),         // Enable UPnP/NAT-PMP
+		libp2p.EnableNATService(),   // Help others with NAT detection
+		libp2p.EnableHolePunching(), // Enable hole punching for NAT traversal
+	}
+	if cfg.RelayService {
+		opts = append(opts, libp2p.EnableRelayService()) // Act as relay for others
+	}
+	if cfg.AutoRelay {
+		opts = append(opts, libp2p.EnableAutoRelayWithStaticRelays(relays)) // Use relays if needed
 	}
 
 	// Add announce addresses if configured (for servers with public IPs)
@@ -192,19 +251,49 @@ func NewNode(ctx context.Context, storage StorageBackend, cfg *Config) (*Node, e
 		dagService: dagService,
 		ctx:        nodeCtx,
 		cancel:     cancel,
+
+		provideStrategy: cfg.ProvideStrategy,
+		provideQueue:    make(chan cid.Cid, 1024),
 	}
 
-	// Start HTTP gateway if configured
+	// Create gateway handler if a standalone or mounted gateway is wanted
+	if cfg.GatewayAddr != "" || cfg.GatewayMount {
+		if err := node.setupGateway(cfg.GatewayTrustless); err != nil {
+			node.Close()
+			return nil, fmt.Errorf("failed to create gateway: %w", err)
+		}
+	}
+
+	// Start standalone HTTP gateway if configured
 	if cfg.GatewayAddr != "" {
-		if err := node.startGateway(cfg.GatewayAddr); err != nil {
+		node.startGateway(cfg.GatewayAddr)
+	}
+
+	// Start local network discovery if enabled
+	if cfg.MDNSEnabled {
+		if err := node.startMDNS(); err != nil {
 			node.Close()
-			return nil, fmt.Errorf("failed to start gateway: %w", err)
+			return nil, fmt.Errorf("failed to start mDNS discovery: %w", err)
+		}
+	}
+
+	// Start IPNI index provider if configured
+	if cfg.IPNI != nil {
+		if err := node.startIPNI(cfg.IPNI); err != nil {
+			node.Close()
+			return nil, fmt.Errorf("failed to start IPNI provider: %w", err)
 		}
 	}
 
 	// Start periodic re-advertiser (DHT provider records expire)
 	go node.periodicAdvertise()
 
+	// Start rate-limited provider for strategies beyond roots
+	if cfg.ProvideStrategy != ProvideRoots {
+		go node.runProvider(cfg.ProvideRate)
+		go node.reprovideLoop()
+	}
+
 	return node, nil
 }
 
@@ -219,14 +308,17 @@ func (n *Node) periodicAdvertise() {
 			return
 		case <-ticker.C:
 			if len(n.rootCIDs) > 0 {
-				fmt.Printf("Re-advertising %d root CIDs to DHT...\n", len(n.rootCIDs))
+				logger.Info("re-advertising root CIDs to DHT", "count", len(n.rootCIDs))
 				n.AdvertiseRoots(n.ctx)
 			}
 		}
 	}
 }
 
-func (n *Node) startGateway(addr string) error {
+// setupGateway creates the gateway handler. Trustless responses
+// (?format=raw, ?format=car or the matching Accept headers) are always
+// served; deserialized UnixFS responses unless trustlessOnly is set.
+func (n *Node) setupGateway(trustlessOnly bool) error {
 	// Create gateway backend
 	backend, err := gateway.NewBlocksBackend(
 		blockservice.New(n.blockstore, n.bswap),
@@ -237,12 +329,17 @@ func (n *Node) startGateway(addr string) error {
 	}
 
 	// Create gateway handler
-	gwHandler := gateway.NewHandler(gateway.Config{
-		DeserializedResponses: true,
+	n.gwHandler = gateway.NewHandler(gateway.Config{
+		DeserializedResponses: !trustlessOnly,
 	}, backend)
 
+	return nil
+}
+
+func (n *Node) startGateway(addr string) {
 	mux := http.NewServeMux()
-	mux.Handle("/ipfs/", gwHandler)
+	mux.Handle("/ipfs", n.gwHandler)
+	mux.Handle("/ipfs/", n.gwHandler)
 
 	n.gateway = &http.Server{
 		Addr:    addr,
@@ -250,7 +347,13 @@ func (n *Node) startGateway(addr string) error {
 	}
 
 	go n.gateway.ListenAndServe()
-	return nil
+}
+
+// GatewayHandler returns the HTTP handler serving /ipfs/ paths, so it can be
+// mounted on an existing HTTP server instead of a separate port.
+// Returns nil unless the node was configured with GatewayAddr or GatewayMount.
+func (n *Node) GatewayHandler() http.Handler {
+	return n.gwHandler
 }
 
 // AddRootCID adds a CID to be advertised to the DHT
@@ -261,27 +364,82 @@ func (n *Node) AddRootCID(c cid.Cid) {
 // AdvertiseRoots advertises all root CIDs to the DHT
 func (n *Node) AdvertiseRoots(ctx context.Context) error {
 	// Wait for DHT to be ready (need peers to advertise to)
-	fmt.Printf("Waiting for DHT peers before advertising...\n")
+	logger.Debug("waiting for DHT peers before advertising")
 	for i := 0; i < 30; i++ {
 		if n.dht.RoutingTable().Size() > 0 {
 			break
 		}
 		time.Sleep(time.Second)
 	}
-	fmt.Printf("DHT routing table has %d peers\n", n.dht.RoutingTable().Size())
+	logger.Debug("DHT routing table ready", "peers", n.dht.RoutingTable().Size())
 
 	for _, c := range n.rootCIDs {
-		fmt.Printf("Advertising CID to DHT: %s\n", c)
 		if err := n.dht.Provide(ctx, c, true); err != nil {
-			fmt.Printf("Warning: failed to provide %s: %v\n", c, err)
+			logger.Warn("failed to provide CID", "cid", c, "error", err)
 			// Continue with other CIDs
 		} else {
-			fmt.Printf("Successfully advertised: %s\n", c)
+			logger.Debug("advertised CID to DHT", "cid", c)
 		}
 	}
 	return nil
 }
 
+// Connect connects to a peer given its full multiaddr (including /p2p/<peer-id>)
+func (n *Node) Connect(ctx context.Context, addr string) error {
+	ma, err := multiaddr.NewMultiaddr(addr)
+	if err != nil {
+		return fmt.Errorf("invalid peer address %s: %w", addr, err)
+	}
+	peerInfo, err := peer.AddrInfoFromP2pAddr(ma)
+	if err != nil {
+		return fmt.Errorf("invalid peer address %s: %w", addr, err)
+	}
+	return n.host.Connect(ctx, *peerInfo)
+}
+
+// FetchBlock retrieves a block over bitswap. Received blocks are written to
+// the blockstore, so they are stored locally afterwards.
+func (n *Node) FetchBlock(ctx context.Context, c cid.Cid) error {
+	_, err := n.bswap.GetBlock(ctx, c)
+	return err
+}
+
+// Status describes the runtime state of the node
+type Status struct {
+	PeerID          string   `json:"peer_id"`
+	Addrs           []string `json:"addrs"`
+	ConnectedPeers  int      `json:"connected_peers"`
+	DHTPeers        int      `json:"dht_peers"`
+	RootCIDs        []string `json:"root_cids"`
+	GatewayAddr     string   `json:"gateway_addr,omitempty"`
+	GatewayMounted  bool     `json:"gateway_mounted"`
+	ProvideStrategy string   `json:"provide_strategy"`
+}
+
+// Status returns a snapshot of the node's peers, advertised roots and gateway
+func (n *Node) Status() *Status {
+	status := &Status{
+		PeerID:          n.host.ID().String(),
+		Addrs:           make([]string, 0),
+		ConnectedPeers:  len(n.host.Network().Peers()),
+		DHTPeers:        n.dht.RoutingTable().Size(),
+		RootCIDs:        make([]string, 0, len(n.rootCIDs)),
+		ProvideStrategy: n.provideStrategy,
+	}
+	for _, addr := range n.host.Addrs() {
+		status.Addrs = append(status.Addrs, addr.String())
+	}
+	for _, c := range n.rootCIDs {
+		status.RootCIDs = append(status.RootCIDs, c.String())
+	}
+	if n.gateway != nil {
+		status.GatewayAddr = n.gateway.Addr
+	} else if n.gwHandler != nil {
+		status.GatewayMounted = true
+	}
+	return status
+}
+
 // PeerID returns the node's peer ID
 func (n *Node) PeerID() peer.ID {
 	return n.host.ID()
@@ -297,6 +455,12 @@ func (n *Node) Close() error {
 	if n.cancel != nil {
 		n.cancel()
 	}
+	if n.mdns != nil {
+		n.mdns.Close()
+	}
+	if n.ipni != nil {
+		n.ipni.Shutdown()
+	}
 	if n.gateway != nil {
 		n.gateway.Close()
 	}
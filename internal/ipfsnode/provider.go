@@ -0,0 +1,194 @@
+package ipfsnode
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/ipfs/go-cid"
+)
+
+// Provide strategies
+const (
+	ProvideRoots     = "roots"     // Only manifest root CIDs
+	ProvideRecursive = "recursive" // Roots plus every node and block reachable from them
+	ProvideAll       = "all"       // Every stored node and block
+
+	// DefaultProvideRate is the default number of DHT provides per second
+	DefaultProvideRate = 10
+
+	// reprovideInterval is how often the full set is re-provided (records expire after 48h)
+	reprovideInterval = 12 * time.Hour
+
+	// provideWorkers bounds the number of in-flight DHT provides
+	provideWorkers = 16
+)
+
+// CIDLister is implemented by storage backends that can enumerate their
+// contents (needed for the "all" provide strategy)
+type CIDLister interface {
+	ListCIDs(ctx context.Context) ([]string, error)
+}
+
+// validProvideStrategy reports whether s is a known provide strategy
+func validProvideStrategy(s string) bool {
+	switch s {
+	case ProvideRoots, ProvideRecursive, ProvideAll:
+		return true
+	}
+	return false
+}
+
+// runProvider drains the provide queue at the configured rate
+func (n *Node) runProvider(rate int) {
+	ticker := time.NewTicker(time.Second / time.Duration(rate))
+	defer ticker.Stop()
+
+	sem := make(chan struct{}, provideWorkers)
+	for {
+		select {
+		case <-n.ctx.Done():
+			return
+		case c := <-n.provideQueue:
+			select {
+			case <-n.ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			sem <- struct{}{}
+			go func() {
+				defer func() { <-sem }()
+				if err := n.dht.Provide(n.ctx, c, true); err != nil && n.ctx.Err() == nil {
+					logger.Warn("failed to provide CID", "cid", c, "error", err)
+				}
+			}()
+		}
+	}
+}
+
+// enqueueProvide queues a CID for the background provider
+func (n *Node) enqueueProvide(ctx context.Context, c cid.Cid) error {
+	select {
+	case n.provideQueue <- c:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-n.ctx.Done():
+		return n.ctx.Err()
+	}
+}
+
+// ProvideDAG queues every node and block below root for providing.
+// No-op with the roots strategy; the root itself is provided by AdvertiseRoots.
+func (n *Node) ProvideDAG(ctx context.Context, root cid.Cid) error {
+	if n.provideStrategy == ProvideRoots {
+		return nil
+	}
+	return n.walkDAG(ctx, root, func(c cid.Cid) error {
+		if c.Equals(root) {
+			return nil
+		}
+		return n.enqueueProvide(ctx, c)
+	})
+}
+
+// Reprovide queues the full set of CIDs selected by the provide strategy.
+// Roots are provided separately by AdvertiseRoots. Concurrent calls are
+// dropped while a reprovide is already running.
+func (n *Node) Reprovide(ctx context.Context) error {
+	if n.provideStrategy == ProvideRoots {
+		return nil
+	}
+	if !n.reprovideMu.TryLock() {
+		return nil
+	}
+	defer n.reprovideMu.Unlock()
+
+	var queued int
+	switch n.provideStrategy {
+	case ProvideRecursive:
+		roots := append([]cid.Cid(nil), n.rootCIDs...)
+		for _, root := range roots {
+			if err := n.ProvideDAG(ctx, root); err != nil {
+				return err
+			}
+			queued++
+		}
+		logger.Info("queued DAGs for providing", "roots", queued)
+
+	case ProvideAll:
+		lister, ok := n.blockstore.storage.(CIDLister)
+		if !ok {
+			return fmt.Errorf("storage cannot list CIDs")
+		}
+		cids, err := lister.ListCIDs(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to list CIDs: %w", err)
+		}
+		for _, cidStr := range cids {
+			c, err := cid.Decode(cidStr)
+			if err != nil {
+				continue
+			}
+			if err := n.enqueueProvide(ctx, c); err != nil {
+				return err
+			}
+			queued++
+		}
+		logger.Info("queued CIDs for providing", "count", queued)
+	}
+
+	return nil
+}
+
+// walkDAG calls fn for every CID reachable from root, including root
+func (n *Node) walkDAG(ctx context.Context, root cid.Cid, fn func(cid.Cid) error) error {
+	visited := make(map[cid.Cid]bool)
+	queue := []cid.Cid{root}
+
+	for len(queue) > 0 {
+		c := queue[0]
+		queue = queue[1:]
+		if visited[c] {
+			continue
+		}
+		visited[c] = true
+
+		if err := fn(c); err != nil {
+			return err
+		}
+
+		if c.Type() != cid.DagProtobuf {
+			continue
+		}
+		data, err := n.blockstore.storage.GetNode(ctx, c.String())
+		if err != nil {
+			return err
+		}
+		links, err := decodePBLinks(data)
+		if err != nil {
+			return fmt.Errorf("failed to decode node %s: %w", c, err)
+		}
+		queue = append(queue, links...)
+	}
+
+	return nil
+}
+
+// reprovideLoop periodically re-queues the full provide set
+func (n *Node) reprovideLoop() {
+	ticker := time.NewTicker(reprovideInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-n.ctx.Done():
+			return
+		case <-ticker.C:
+			if err := n.Reprovide(n.ctx); err != nil && n.ctx.Err() == nil {
+				logger.Warn("reprovide failed", "error", err)
+			}
+		}
+	}
+}
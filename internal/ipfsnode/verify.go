@@ -0,0 +1,166 @@
+package ipfsnode
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/ipfs/go-cid"
+)
+
+// VerifyResult reports the outcome of a DAG completeness check
+type VerifyResult struct {
+	RootCID string   `json:"root_cid"`
+	Checked int      `json:"checked"`
+	Missing []string `json:"missing"`
+}
+
+// Complete returns true if every node and block in the DAG is present
+func (r *VerifyResult) Complete() bool {
+	return len(r.Missing) == 0
+}
+
+// VerifyDAG walks the UnixFS DAG from root and checks that every referenced
+// dag-pb node and raw block is present in local storage
+func VerifyDAG(ctx context.Context, root cid.Cid, storage StorageBackend) (*VerifyResult, error) {
+	result := &VerifyResult{
+		RootCID: root.String(),
+		Missing: make([]string, 0),
+	}
+
+	visited := make(map[cid.Cid]bool)
+	queue := []cid.Cid{root}
+
+	for len(queue) > 0 {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		c := queue[0]
+		queue = queue[1:]
+		if visited[c] {
+			continue
+		}
+		visited[c] = true
+		result.Checked++
+
+		cidStr := c.String()
+		switch c.Type() {
+		case cid.DagProtobuf:
+			exists, err := storage.NodeExists(ctx, cidStr)
+			if err != nil {
+				return nil, err
+			}
+			if !exists {
+				result.Missing = append(result.Missing, cidStr)
+				continue
+			}
+
+			data, err := storage.GetNode(ctx, cidStr)
+			if err != nil {
+				return nil, err
+			}
+			links, err := decodePBLinks(data)
+			if err != nil {
+				return nil, fmt.Errorf("failed to decode node %s: %w", cidStr, err)
+			}
+			queue = append(queue, links...)
+
+		case cid.DagJSON:
+			// Backup metadata node, has no links
+			exists, err := storage.NodeExists(ctx, cidStr)
+			if err != nil {
+				return nil, err
+			}
+			if !exists {
+				result.Missing = append(result.Missing, cidStr)
+			}
+
+		default:
+			exists, err := storage.BlockExists(ctx, cidStr)
+			if err != nil {
+				return nil, err
+			}
+			if !exists {
+				result.Missing = append(result.Missing, cidStr)
+			}
+		}
+	}
+
+	return result, nil
+}
+
+// decodePBLinks extracts the link CIDs from an encoded dag-pb node
+func decodePBLinks(data []byte) ([]cid.Cid, error) {
+	var links []cid.Cid
+
+	err := walkProtobuf(data, func(field int, value []byte) error {
+		// PBNode.Links = 2
+		if field != 2 {
+			return nil
+		}
+		return walkProtobuf(value, func(field int, value []byte) error {
+			// PBLink.Hash = 1
+			if field != 1 {
+				return nil
+			}
+			c, err := cid.Cast(value)
+			if err != nil {
+				return err
+			}
+			links = append(links, c)
+			return nil
+		})
+	})
+
+	return links, err
+}
+
+// walkProtobuf calls fn for every length-delimited field in a protobuf
+// message, skipping varint fields
+func walkProtobuf(data []byte, fn func(field int, value []byte) error) error {
+	for len(data) > 0 {
+		key, n := readVarint(data)
+		if n == 0 {
+			return errors.New("truncated field key")
+		}
+		data = data[n:]
+
+		field := int(key >> 3)
+		switch key & 0x7 {
+		case 0: // varint
+			_, n := readVarint(data)
+			if n == 0 {
+				return errors.New("truncated varint")
+			}
+			data = data[n:]
+
+		case 2: // length-delimited
+			length, n := readVarint(data)
+			if n == 0 || uint64(len(data)-n) < length {
+				return errors.New("truncated length-delimited field")
+			}
+			value := data[n : n+int(length)]
+			data = data[n+int(length):]
+			if err := fn(field, value); err != nil {
+				return err
+			}
+
+		default:
+			return fmt.Errorf("unsupported wire type %d", key&0x7)
+		}
+	}
+	return nil
+}
+
+// readVarint decodes a varint, returning the value and bytes consumed (0 on error)
+func readVarint(data []byte) (uint64, int) {
+	var v uint64
+	for i := 0; i < len(data) && i < 10; i++ {
+		v |= uint64(data[i]&0x7f) << (7 * uint(i))
+		if data[i] < 0x80 {
+			return v, i + 1
+		}
+	}
+	return 0, 0
+}
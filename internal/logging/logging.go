@@ -0,0 +1,101 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+)
+
+// level is shared by all loggers and can be changed at runtime
+var level = new(slog.LevelVar)
+
+// errorHook, if set, also receives every error-level record
+var errorHook func(component string, r slog.Record)
+
+// SetErrorHook passes error-level records of component loggers to hook as
+// well, e.g. for error reporting. Set it before logging starts.
+func SetErrorHook(hook func(component string, r slog.Record)) {
+	errorHook = hook
+}
+
+// Setup installs the default logger. format is "text" or "json".
+func Setup(levelName, format string, w io.Writer) error {
+	if err := SetLevel(levelName); err != nil {
+		return err
+	}
+
+	opts := &slog.HandlerOptions{Level: level}
+	var h slog.Handler
+	switch strings.ToLower(format) {
+	case "", "text":
+		h = slog.NewTextHandler(w, opts)
+	case "json":
+		h = slog.NewJSONHandler(w, opts)
+	default:
+		return fmt.Errorf("invalid log format %q (expected text or json)", format)
+	}
+	slog.SetDefault(slog.New(h))
+	return nil
+}
+
+// SetLevel changes the log level of all loggers. An empty name means info.
+func SetLevel(name string) error {
+	if name == "" {
+		name = "info"
+	}
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(name)); err != nil {
+		return fmt.Errorf("invalid log level %q (expected debug, info, warn or error)", name)
+	}
+	level.Set(l)
+	return nil
+}
+
+// Level returns the current log level name
+func Level() string {
+	return strings.ToLower(level.Level().String())
+}
+
+// For returns a logger tagged with a component. It can be created at
+// package init; records go to whatever default logger Setup installed.
+func For(component string) *slog.Logger {
+	return slog.New(&lazyHandler{component: component, wrap: func(h slog.Handler) slog.Handler {
+		return h.WithAttrs([]slog.Attr{slog.String("component", component)})
+	}})
+}
+
+// lazyHandler resolves slog's default handler on every record, so loggers
+// created before Setup still follow its output and format
+type lazyHandler struct {
+	component string
+	wrap      func(slog.Handler) slog.Handler
+}
+
+func (h *lazyHandler) handler() slog.Handler {
+	return h.wrap(slog.Default().Handler())
+}
+
+func (h *lazyHandler) Enabled(ctx context.Context, l slog.Level) bool {
+	return l >= level.Level()
+}
+
+func (h *lazyHandler) Handle(ctx context.Context, r slog.Record) error {
+	if r.Level >= slog.LevelError && errorHook != nil {
+		errorHook(h.component, r.Clone())
+	}
+	return h.handler().Handle(ctx, r)
+}
+
+func (h *lazyHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &lazyHandler{component: h.component, wrap: func(base slog.Handler) slog.Handler {
+		return h.wrap(base).WithAttrs(attrs)
+	}}
+}
+
+func (h *lazyHandler) WithGroup(name string) slog.Handler {
+	return &lazyHandler{component: h.component, wrap: func(base slog.Handler) slog.Handler {
+		return h.wrap(base).WithGroup(name)
+	}}
+}
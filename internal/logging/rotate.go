@@ -0,0 +1,154 @@
+package logging
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// backupTimeFormat names rotated files: <path>.<time>
+const backupTimeFormat = "2006-01-02T15-04-05"
+
+// RotatingFile is a log file that is rotated when it grows past MaxSize
+// or, with Daily, when the day changes. Rotated files beyond MaxBackups or
+// older than MaxAge are removed (0 keeps them).
+type RotatingFile struct {
+	Path       string
+	MaxSize    int64 // Bytes, 0 = no size limit
+	Daily      bool
+	MaxBackups int
+	MaxAge     time.Duration
+
+	mu     sync.Mutex
+	file   *os.File
+	size   int64
+	opened time.Time
+}
+
+// Open opens (or creates) the log file for appending
+func (r *RotatingFile) Open() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.openLocked()
+}
+
+func (r *RotatingFile) openLocked() error {
+	if err := os.MkdirAll(filepath.Dir(r.Path), 0755); err != nil {
+		return fmt.Errorf("failed to create log directory: %w", err)
+	}
+	f, err := os.OpenFile(r.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0640)
+	if err != nil {
+		return fmt.Errorf("failed to open log file: %w", err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	r.file = f
+	r.size = info.Size()
+	r.opened = info.ModTime()
+	if r.size == 0 {
+		r.opened = time.Now()
+	}
+	return nil
+}
+
+// Write appends p, rotating first if p would overflow the file or the day
+// has changed
+func (r *RotatingFile) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.file == nil {
+		if err := r.openLocked(); err != nil {
+			return 0, err
+		}
+	}
+
+	now := time.Now()
+	tooBig := r.MaxSize > 0 && r.size > 0 && r.size+int64(len(p)) > r.MaxSize
+	newDay := r.Daily && r.size > 0 && !sameDay(r.opened, now)
+	if tooBig || newDay {
+		if err := r.rotateLocked(now); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := r.file.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+// Close closes the current file
+func (r *RotatingFile) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.file == nil {
+		return nil
+	}
+	err := r.file.Close()
+	r.file = nil
+	return err
+}
+
+func (r *RotatingFile) rotateLocked(now time.Time) error {
+	if err := r.file.Close(); err != nil {
+		return err
+	}
+	r.file = nil
+
+	backup := r.Path + "." + now.Format(backupTimeFormat)
+	if err := os.Rename(r.Path, backup); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to rotate log file: %w", err)
+	}
+	if err := r.openLocked(); err != nil {
+		return err
+	}
+	r.opened = now
+
+	// Cleanup must not hold up logging
+	go r.removeOld(now)
+	return nil
+}
+
+// removeOld deletes rotated files beyond MaxBackups or older than MaxAge
+func (r *RotatingFile) removeOld(now time.Time) {
+	if r.MaxBackups <= 0 && r.MaxAge <= 0 {
+		return
+	}
+	matches, err := filepath.Glob(r.Path + ".*")
+	if err != nil {
+		return
+	}
+
+	type rotated struct {
+		path string
+		t    time.Time
+	}
+	var files []rotated
+	for _, m := range matches {
+		t, err := time.ParseInLocation(backupTimeFormat, strings.TrimPrefix(m, r.Path+"."), time.Local)
+		if err != nil {
+			continue // Not one of ours
+		}
+		files = append(files, rotated{m, t})
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].t.After(files[j].t) })
+
+	for i, f := range files {
+		if (r.MaxBackups > 0 && i >= r.MaxBackups) || (r.MaxAge > 0 && now.Sub(f.t) > r.MaxAge) {
+			os.Remove(f.path)
+		}
+	}
+}
+
+func sameDay(a, b time.Time) bool {
+	ay, am, ad := a.Date()
+	by, bm, bd := b.Date()
+	return ay == by && am == bm && ad == bd
+}
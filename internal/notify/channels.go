@@ -0,0 +1,149 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/smtp"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/johann/ib/internal/config"
+	"github.com/johann/ib/internal/secrets"
+)
+
+var httpClient = &http.Client{Timeout: 30 * time.Second}
+
+// resolve returns a channel setting, looking it up if it's a secret ref
+func resolve(ctx context.Context, value string) (string, error) {
+	if !secrets.IsRef(value) {
+		return value, nil
+	}
+	v, _, err := secrets.Resolve(ctx, value)
+	return v, err
+}
+
+// post sends a request body and fails on non-2xx responses
+func post(ctx context.Context, target, contentType string, body []byte, header http.Header) error {
+	req, err := http.NewRequestWithContext(ctx, "POST", target, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	for k, v := range header {
+		req.Header[k] = v
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%d - %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+// webhookSender posts to Slack and Discord incoming webhooks and ntfy topics
+type webhookSender struct {
+	ch config.NotifyChannel
+}
+
+func (w *webhookSender) send(ctx context.Context, ev Event) error {
+	target, err := resolve(ctx, w.ch.URL)
+	if err != nil {
+		return err
+	}
+
+	switch w.ch.Type {
+	case "ntfy":
+		header := http.Header{}
+		header.Set("Title", ev.Title)
+		header.Set("Tags", ev.Type)
+		if ev.Failure {
+			header.Set("Priority", "high")
+		}
+		return post(ctx, target, "text/plain", []byte(ev.Message), header)
+	case "discord":
+		body, _ := json.Marshal(map[string]string{"content": "**" + ev.Title + "**\n" + ev.Message})
+		return post(ctx, target, "application/json", body, nil)
+	default:
+		body, _ := json.Marshal(map[string]string{"text": "*" + ev.Title + "*\n" + ev.Message})
+		return post(ctx, target, "application/json", body, nil)
+	}
+}
+
+// telegramSender uses the Bot API sendMessage method
+type telegramSender struct {
+	ch config.NotifyChannel
+}
+
+func (t *telegramSender) send(ctx context.Context, ev Event) error {
+	token, err := resolve(ctx, t.ch.BotToken)
+	if err != nil {
+		return err
+	}
+	body, _ := json.Marshal(map[string]string{
+		"chat_id": t.ch.ChatID,
+		"text":    ev.Title + "\n\n" + ev.Message,
+	})
+	target := "https://api.telegram.org/bot" + url.PathEscape(token) + "/sendMessage"
+	if err := post(ctx, target, "application/json", body, nil); err != nil {
+		// Don't let the bot token end up in logs via the URL
+		return fmt.Errorf("telegram: %s", strings.ReplaceAll(err.Error(), token, "[token]"))
+	}
+	return nil
+}
+
+// smtpSender sends mail with STARTTLS when the server offers it
+type smtpSender struct {
+	ch config.NotifyChannel
+}
+
+func (m *smtpSender) send(ctx context.Context, ev Event) error {
+	password, err := resolve(ctx, m.ch.SMTPPassword)
+	if err != nil {
+		return err
+	}
+
+	port := m.ch.SMTPPort
+	if port == 0 {
+		port = 587
+	}
+	addr := net.JoinHostPort(m.ch.SMTPHost, strconv.Itoa(port))
+
+	var auth smtp.Auth
+	if m.ch.SMTPUser != "" {
+		auth = smtp.PlainAuth("", m.ch.SMTPUser, password, m.ch.SMTPHost)
+	}
+
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", m.ch.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(m.ch.To, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", ev.Title)
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	msg.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	msg.WriteString(strings.ReplaceAll(ev.Message, "\n", "\r\n"))
+	msg.WriteString("\r\n")
+
+	// net/smtp has no context support; give up with the context instead
+	done := make(chan error, 1)
+	go func() {
+		done <- smtp.SendMail(addr, auth, m.ch.From, m.ch.To, msg.Bytes())
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
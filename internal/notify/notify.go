@@ -0,0 +1,120 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/johann/ib/internal/config"
+	"github.com/johann/ib/internal/logging"
+)
+
+var logger = logging.For("notify")
+
+// Event types
+const (
+	ManifestCreated = "manifest_created"
+	BackupFailed    = "backup_failed"
+	MissedBackup    = "missed_backup"
+	VerifyFailed    = "verify_failed"
+	Prune           = "prune"
+)
+
+// Event is something worth telling people about
+type Event struct {
+	Type    string
+	Name    string // Backup name tag, empty for server-wide events
+	Title   string
+	Message string
+	Failure bool // Sent with higher priority where the channel supports it
+}
+
+// sender delivers an event over one channel type
+type sender interface {
+	send(ctx context.Context, ev Event) error
+}
+
+type route struct {
+	ch     config.NotifyChannel
+	sender sender
+}
+
+// Dispatcher sends events to the channels whose routing rules match
+type Dispatcher struct {
+	routes []route
+}
+
+// New creates a dispatcher for the configured channels
+func New(channels []config.NotifyChannel) (*Dispatcher, error) {
+	d := &Dispatcher{}
+	for i, ch := range channels {
+		var snd sender
+		switch ch.Type {
+		case "smtp":
+			if ch.SMTPHost == "" || ch.From == "" || len(ch.To) == 0 {
+				return nil, fmt.Errorf("notification %d: smtp needs smtp_host, from and to", i)
+			}
+			snd = &smtpSender{ch: ch}
+		case "slack", "discord", "ntfy":
+			if ch.URL == "" {
+				return nil, fmt.Errorf("notification %d: %s needs url", i, ch.Type)
+			}
+			snd = &webhookSender{ch: ch}
+		case "telegram":
+			if ch.BotToken == "" || ch.ChatID == "" {
+				return nil, fmt.Errorf("notification %d: telegram needs bot_token and chat_id", i)
+			}
+			snd = &telegramSender{ch: ch}
+		default:
+			return nil, fmt.Errorf("notification %d: unknown type %q (expected smtp, slack, discord, ntfy or telegram)", i, ch.Type)
+		}
+		for _, e := range ch.Events {
+			if !validEvent(e) {
+				return nil, fmt.Errorf("notification %d: unknown event %q", i, e)
+			}
+		}
+		d.routes = append(d.routes, route{ch: ch, sender: snd})
+	}
+	return d, nil
+}
+
+func validEvent(e string) bool {
+	switch e {
+	case ManifestCreated, BackupFailed, MissedBackup, VerifyFailed, Prune:
+		return true
+	}
+	return false
+}
+
+// matches reports whether the channel's routing rules accept ev.
+// Server-wide events go to every channel that takes the event type.
+func (r *route) matches(ev Event) bool {
+	if len(r.ch.Events) > 0 && !slices.Contains(r.ch.Events, ev.Type) {
+		return false
+	}
+	if len(r.ch.Names) > 0 && ev.Name != "" && !slices.Contains(r.ch.Names, ev.Name) {
+		return false
+	}
+	return true
+}
+
+// Notify sends ev to all matching channels in the background. Delivery
+// failures are logged.
+func (d *Dispatcher) Notify(ev Event) {
+	if d == nil {
+		return
+	}
+	for _, r := range d.routes {
+		if !r.matches(ev) {
+			continue
+		}
+		go func(r route) {
+			ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+			defer cancel()
+			if err := r.sender.send(ctx, ev); err != nil {
+				logger.Warn("failed to send notification", "channel", r.ch.Type, "event", ev.Type, "error", err)
+			}
+		}(r)
+	}
+}
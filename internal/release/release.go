@@ -0,0 +1,101 @@
+// Package release creates and verifies the checksums and signature of the
+// client binaries the server embeds.
+package release
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+)
+
+const (
+	// ChecksumsFile lists the SHA-256 of every client binary in sha256sum format
+	ChecksumsFile = "SHA256SUMS"
+	// SignatureFile is the hex-encoded Ed25519 signature of ChecksumsFile
+	SignatureFile = "SHA256SUMS.sig"
+)
+
+// BinaryName returns the file name of the client binary for a platform
+func BinaryName(goos, goarch string) string {
+	name := "ib-" + goos + "-" + goarch
+	if goos == "windows" {
+		name += ".exe"
+	}
+	return name
+}
+
+// Checksums hashes every client binary in dir and returns the contents of
+// ChecksumsFile. The checksums and signature files themselves are skipped.
+func Checksums(fsys fs.FS, dir string) ([]byte, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasPrefix(e.Name(), "ib-") {
+			continue
+		}
+		names = append(names, e.Name())
+	}
+	sort.Strings(names)
+
+	var buf bytes.Buffer
+	for _, name := range names {
+		f, err := fsys.Open(path.Join(dir, name))
+		if err != nil {
+			return nil, err
+		}
+		h := sha256.New()
+		_, err = io.Copy(h, f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to hash %s: %w", name, err)
+		}
+		fmt.Fprintf(&buf, "%s  %s\n", hex.EncodeToString(h.Sum(nil)), name)
+	}
+	return buf.Bytes(), nil
+}
+
+// Lookup returns the hex SHA-256 of name in checksums
+func Lookup(checksums []byte, name string) (string, error) {
+	scanner := bufio.NewScanner(bytes.NewReader(checksums))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 && strings.TrimPrefix(fields[1], "*") == name {
+			return fields[0], nil
+		}
+	}
+	return "", fmt.Errorf("no checksum for %s", name)
+}
+
+// Sign returns the contents of SignatureFile for checksums
+func Sign(priv ed25519.PrivateKey, checksums []byte) []byte {
+	return []byte(hex.EncodeToString(ed25519.Sign(priv, checksums)) + "\n")
+}
+
+// Verify checks that sig is a signature of checksums by the public key
+// (hex-encoded)
+func Verify(publicKey string, checksums, sig []byte) error {
+	pub, err := hex.DecodeString(strings.TrimSpace(publicKey))
+	if err != nil || len(pub) != ed25519.PublicKeySize {
+		return fmt.Errorf("invalid release public key %q", publicKey)
+	}
+	s, err := hex.DecodeString(strings.TrimSpace(string(sig)))
+	if err != nil {
+		return fmt.Errorf("invalid checksums signature")
+	}
+	if !ed25519.Verify(pub, checksums, s) {
+		return fmt.Errorf("checksums signature mismatch, they were not signed by the release key")
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+package secrets
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	v4 "github.com/aws/aws-sdk-go-v2/aws/signer/v4"
+	"github.com/aws/aws-sdk-go-v2/config"
+)
+
+// readAWSSM reads a secret from AWS Secrets Manager with the default AWS
+// credential chain. With a field, the secret string is decoded as JSON.
+func readAWSSM(ctx context.Context, ref string) (string, error) {
+	id, field := splitRef(ref)
+
+	awsCfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to load AWS config: %w", err)
+	}
+	if awsCfg.Region == "" {
+		return "", fmt.Errorf("AWS region is not set")
+	}
+	creds, err := awsCfg.Credentials.Retrieve(ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to get AWS credentials: %w", err)
+	}
+
+	body, err := json.Marshal(map[string]string{"SecretId": id})
+	if err != nil {
+		return "", err
+	}
+	url := fmt.Sprintf("https://secretsmanager.%s.amazonaws.com/", awsCfg.Region)
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "secretsmanager.GetSecretValue")
+
+	sum := sha256.Sum256(body)
+	if err := v4.NewSigner().SignHTTP(ctx, creds, req, hex.EncodeToString(sum[:]), "secretsmanager", awsCfg.Region, time.Now()); err != nil {
+		return "", fmt.Errorf("failed to sign request: %w", err)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return "", fmt.Errorf("secrets manager returned %s: %s", resp.Status, msg)
+	}
+
+	var result struct {
+		SecretString string `json:"SecretString"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", fmt.Errorf("invalid secrets manager response: %w", err)
+	}
+	if field == "" {
+		return result.SecretString, nil
+	}
+
+	var data map[string]interface{}
+	if err := json.Unmarshal([]byte(result.SecretString), &data); err != nil {
+		return "", fmt.Errorf("secret %s is not JSON: %w", id, err)
+	}
+	return lookupField(data, field)
+}
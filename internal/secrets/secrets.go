@@ -0,0 +1,104 @@
+package secrets
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultTTL is how long a secret without a lease is cached before it is
+// fetched again, so rotations in the secret store are picked up
+const DefaultTTL = 5 * time.Minute
+
+// Reference prefixes for secrets kept outside the config file
+const (
+	prefixVault = "vault:" // vault:<path>#<field>
+	prefixAWSSM = "awssm:" // awssm:<secret-id>[#<field>]
+	prefixSOPS  = "sops:"  // sops:<file>#<field>
+)
+
+type cached struct {
+	value   string
+	expires time.Time
+}
+
+var (
+	cacheMu sync.Mutex
+	cache   = make(map[string]cached)
+)
+
+// IsRef reports whether value refers to an external secret
+func IsRef(value string) bool {
+	return strings.HasPrefix(value, prefixVault) ||
+		strings.HasPrefix(value, prefixAWSSM) ||
+		strings.HasPrefix(value, prefixSOPS)
+}
+
+// Resolve returns the secret a config value refers to, and when it should
+// be fetched again. Plain values are returned unchanged and never expire.
+func Resolve(ctx context.Context, value string) (string, time.Time, error) {
+	if !IsRef(value) {
+		return value, time.Time{}, nil
+	}
+
+	cacheMu.Lock()
+	c, ok := cache[value]
+	cacheMu.Unlock()
+	if ok && time.Now().Before(c.expires) {
+		return c.value, c.expires, nil
+	}
+
+	var secret string
+	var ttl time.Duration
+	var err error
+	switch {
+	case strings.HasPrefix(value, prefixVault):
+		secret, ttl, err = readVault(ctx, strings.TrimPrefix(value, prefixVault))
+	case strings.HasPrefix(value, prefixAWSSM):
+		secret, err = readAWSSM(ctx, strings.TrimPrefix(value, prefixAWSSM))
+	case strings.HasPrefix(value, prefixSOPS):
+		secret, err = readSOPS(ctx, strings.TrimPrefix(value, prefixSOPS))
+	}
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("failed to resolve secret %s: %w", value, err)
+	}
+
+	if ttl <= 0 || ttl > DefaultTTL {
+		ttl = DefaultTTL
+	}
+	expires := time.Now().Add(ttl)
+
+	cacheMu.Lock()
+	cache[value] = cached{value: secret, expires: expires}
+	cacheMu.Unlock()
+	return secret, expires, nil
+}
+
+// splitRef splits "<location>#<field>" into its parts
+func splitRef(ref string) (location, field string) {
+	location, field, _ = strings.Cut(ref, "#")
+	return location, field
+}
+
+// lookupField returns a string field from decoded JSON. Dots address
+// nested objects.
+func lookupField(data map[string]interface{}, field string) (string, error) {
+	parts := strings.Split(field, ".")
+	var cur interface{} = data
+	for _, part := range parts {
+		obj, ok := cur.(map[string]interface{})
+		if !ok {
+			return "", fmt.Errorf("field %q not found", field)
+		}
+		if cur, ok = obj[part]; !ok {
+			return "", fmt.Errorf("field %q not found", field)
+		}
+	}
+	s, ok := cur.(string)
+	if !ok {
+		return "", fmt.Errorf("field %q is not a string", field)
+	}
+	return s, nil
+}
@@ -0,0 +1,33 @@
+package secrets
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// readSOPS decrypts a SOPS file with the sops binary and reads a field.
+// sops finds its keys (age, PGP, KMS, Vault transit) the usual way.
+func readSOPS(ctx context.Context, ref string) (string, error) {
+	path, field := splitRef(ref)
+	if field == "" {
+		return "", fmt.Errorf("missing #field")
+	}
+
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "sops", "--decrypt", "--output-type", "json", path)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("sops failed: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	var data map[string]interface{}
+	if err := json.Unmarshal(out, &data); err != nil {
+		return "", fmt.Errorf("invalid sops output: %w", err)
+	}
+	return lookupField(data, field)
+}
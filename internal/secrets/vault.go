@@ -0,0 +1,68 @@
+package secrets
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// readVault reads a field from a HashiCorp Vault secret using VAULT_ADDR
+// and VAULT_TOKEN. Both KV v1 and v2 engines are supported.
+func readVault(ctx context.Context, ref string) (string, time.Duration, error) {
+	path, field := splitRef(ref)
+	if field == "" {
+		return "", 0, fmt.Errorf("missing #field")
+	}
+
+	addr := os.Getenv("VAULT_ADDR")
+	token := os.Getenv("VAULT_TOKEN")
+	if addr == "" || token == "" {
+		return "", 0, fmt.Errorf("VAULT_ADDR and VAULT_TOKEN must be set")
+	}
+
+	url := strings.TrimSuffix(addr, "/") + "/v1/" + strings.TrimPrefix(path, "/")
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return "", 0, err
+	}
+	req.Header.Set("X-Vault-Token", token)
+	if ns := os.Getenv("VAULT_NAMESPACE"); ns != "" {
+		req.Header.Set("X-Vault-Namespace", ns)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", 0, fmt.Errorf("vault returned %s", resp.Status)
+	}
+
+	var result struct {
+		LeaseDuration int                    `json:"lease_duration"`
+		Data          map[string]interface{} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", 0, fmt.Errorf("invalid vault response: %w", err)
+	}
+
+	// KV v2 nests the secret under data.data
+	data := result.Data
+	if inner, ok := data["data"].(map[string]interface{}); ok {
+		if _, isMeta := data["metadata"]; isMeta {
+			data = inner
+		}
+	}
+
+	value, err := lookupField(data, field)
+	if err != nil {
+		return "", 0, err
+	}
+	return value, time.Duration(result.LeaseDuration) * time.Second, nil
+}
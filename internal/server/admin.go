@@ -0,0 +1,166 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// handleAdminStats summarizes what the server stores
+func (s *Server) handleAdminStats(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	stats, err := s.storage.Stats(ctx)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	names, err := s.storage.BackupNames(ctx)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	backups := make([]gin.H, 0, len(names))
+	for _, n := range names {
+		backups = append(backups, gin.H{
+			"name":      n.Name,
+			"manifests": n.Manifests,
+			"latest":    n.Latest.UTC().Format(time.RFC3339),
+		})
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"blocks":         stats.Blocks,
+		"bytes":          stats.Bytes,
+		"manifests":      stats.Manifests,
+		"trashed":        stats.Trashed,
+		"backups":        backups,
+		"retention_days": s.settings().retentionDays,
+		"trash_days":     int(s.settings().trashPeriod.Hours() / 24),
+		"ipfs_running":   s.ipfs() != nil,
+		"started_at":     s.started.UTC().Format(time.RFC3339),
+	})
+}
+
+// handleAdminPrune runs the retention prune now instead of waiting for the
+// daily run. An optional cutoff (RFC 3339) or older_than (duration) prunes
+// manifests created before it instead of applying the retention period,
+// and dry_run only reports what would be removed.
+func (s *Server) handleAdminPrune(c *gin.Context) {
+	var req struct {
+		Cutoff    string `json:"cutoff"`
+		OlderThan string `json:"older_than"`
+		DryRun    bool   `json:"dry_run"`
+	}
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request: " + err.Error()})
+			return
+		}
+	}
+
+	opts := pruneOptions{dryRun: req.DryRun}
+	switch {
+	case req.Cutoff != "" && req.OlderThan != "":
+		c.JSON(http.StatusBadRequest, gin.H{"error": "cutoff and older_than are mutually exclusive"})
+		return
+	case req.Cutoff != "":
+		cutoff, err := time.Parse(time.RFC3339, req.Cutoff)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid cutoff: " + req.Cutoff})
+			return
+		}
+		opts.cutoff = cutoff
+	case req.OlderThan != "":
+		d, err := time.ParseDuration(req.OlderThan)
+		if err != nil || d <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid older_than: " + req.OlderThan})
+			return
+		}
+		opts.cutoff = time.Now().Add(-d)
+	}
+
+	logger.Info("prune requested", "token", c.GetString("token_name"), "cutoff", opts.cutoff, "dry_run", opts.dryRun)
+	result, errs := s.prune(opts)
+	status := http.StatusOK
+	if len(errs) > 0 {
+		status = http.StatusInternalServerError
+	}
+	c.JSON(status, gin.H{"result": result, "errors": errs})
+}
+
+// handleAdminGC removes unreferenced blocks and nodes
+func (s *Server) handleAdminGC(c *gin.Context) {
+	logger.Info("garbage collection requested", "token", c.GetString("token_name"))
+	ctx := c.Request.Context()
+	start := time.Now()
+
+	result, err := s.storage.CollectGarbage(ctx)
+	s.metrics.gcDuration.Set(time.Since(start).Seconds())
+	s.metrics.gcBlocks.Add(float64(result.Blocks))
+	s.metrics.gcBytes.Add(float64(result.Bytes))
+	s.metrics.gcS3Failures.Add(float64(result.S3DeleteErrors))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error(), "result": result})
+		return
+	}
+	s.refreshMetrics(ctx)
+	c.JSON(http.StatusOK, gin.H{"result": result})
+}
+
+// handleAdminScrub verifies the DAG of every manifest, or of those matching
+// the tag query parameters
+func (s *Server) handleAdminScrub(c *gin.Context) {
+	ctx := c.Request.Context()
+	logger.Info("scrub requested", "token", c.GetString("token_name"))
+
+	tags := make(map[string]string)
+	for k, v := range c.Request.URL.Query() {
+		if len(v) > 0 {
+			tags[k] = v[0]
+		}
+	}
+	infos, err := s.storage.ListManifests(ctx, tags)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	var checked, skipped int
+	incomplete := make([]gin.H, 0)
+	failed := make([]gin.H, 0)
+	for _, info := range infos {
+		manifest, err := s.loadManifest(ctx, info.ID)
+		if err != nil {
+			if strings.Contains(err.Error(), "not found") {
+				continue // Pruned meanwhile
+			}
+			failed = append(failed, gin.H{"id": info.ID, "error": err.Error()})
+			continue
+		}
+		if manifest.RootCID == "" {
+			skipped++
+			continue
+		}
+
+		result, err := s.verifyManifest(ctx, manifest)
+		if err != nil {
+			failed = append(failed, gin.H{"id": info.ID, "error": err.Error()})
+			continue
+		}
+		checked++
+		if !result.Complete() {
+			incomplete = append(incomplete, gin.H{"id": info.ID, "missing": len(result.Missing), "checked": result.Checked})
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"checked":    checked,
+		"skipped":    skipped,
+		"incomplete": incomplete,
+		"errors":     failed,
+	})
+}
@@ -0,0 +1,167 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/johann/ib/internal/config"
+)
+
+// allowlists restrict route groups to known networks. An empty list allows
+// every address. The lists can be replaced at runtime with update.
+type allowlists struct {
+	mu      sync.RWMutex
+	write   []*net.IPNet
+	admin   []*net.IPNet
+	metrics []*net.IPNet
+
+	// Reverse proxies whose forwarding headers are believed; for everyone
+	// else the connection's address is used, so headers can't be spoofed
+	proxies []*net.IPNet
+}
+
+func newAllowlists(cfg *config.ServerConfig) (*allowlists, error) {
+	var a allowlists
+	var err error
+	if a.write, err = parseCIDRs(cfg.WriteAllowlist); err != nil {
+		return nil, fmt.Errorf("invalid write allowlist: %w", err)
+	}
+	if a.admin, err = parseCIDRs(cfg.AdminAllowlist); err != nil {
+		return nil, fmt.Errorf("invalid admin allowlist: %w", err)
+	}
+	if a.metrics, err = parseCIDRs(cfg.MetricsAllowlist); err != nil {
+		return nil, fmt.Errorf("invalid metrics allowlist: %w", err)
+	}
+	if a.proxies, err = parseCIDRs(cfg.TrustedProxies); err != nil {
+		return nil, fmt.Errorf("invalid trusted proxies: %w", err)
+	}
+	return &a, nil
+}
+
+// update replaces the lists with those of b
+func (a *allowlists) update(b *allowlists) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.write, a.admin, a.metrics, a.proxies = b.write, b.admin, b.metrics, b.proxies
+}
+
+// networks returns the list for a route group
+func (a *allowlists) networks(group string) []*net.IPNet {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	switch group {
+	case "write":
+		return a.write
+	case "admin":
+		return a.admin
+	case "metrics":
+		return a.metrics
+	}
+	return nil
+}
+
+// parseCIDRs parses CIDRs; plain IPs are taken as single-address networks
+func parseCIDRs(entries []string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid address %q", entry)
+			}
+			bits := 128
+			if ip.To4() != nil {
+				ip = ip.To4()
+				bits = 32
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+func containsIP(nets []*net.IPNet, ipStr string) bool {
+	ip := net.ParseIP(ipStr)
+	if ip == nil {
+		return false
+	}
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// remoteIP returns the host part of a RemoteAddr
+func remoteIP(remoteAddr string) string {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		return remoteAddr
+	}
+	return host
+}
+
+// clientIP returns the client address for allowlist checks and failed
+// authentication tracking. Forwarding headers are only honored from
+// trusted proxies, so clients can't choose the address they are blocked
+// under.
+func (a *allowlists) clientIP(c *gin.Context) string {
+	a.mu.RLock()
+	proxies := a.proxies
+	a.mu.RUnlock()
+
+	ip := remoteIP(c.Request.RemoteAddr)
+	if containsIP(proxies, ip) {
+		return GetRealIP(c)
+	}
+	return ip
+}
+
+// allowNetworks rejects requests from outside the group's list. No-op for
+// an empty list.
+func (a *allowlists) allowNetworks(group string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		nets := a.networks(group)
+		if len(nets) == 0 {
+			c.Next()
+			return
+		}
+		ip := a.clientIP(c)
+		if !containsIP(nets, ip) {
+			authLogger.Warn("access denied", "ip", ip, "group", group, "path", c.Request.URL.Path, "reason", "not in allowlist")
+			c.JSON(http.StatusForbidden, gin.H{"error": "access denied from this address"})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// wrapMetrics applies the metrics allowlist to the metrics handler. The
+// metrics port is not meant to sit behind a proxy, so only the connection's
+// address counts.
+func (a *allowlists) wrapMetrics(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		nets := a.networks("metrics")
+		if len(nets) > 0 && !containsIP(nets, remoteIP(r.RemoteAddr)) {
+			http.Error(w, "access denied from this address", http.StatusForbidden)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
@@ -6,31 +6,73 @@ import (
 	"compress/gzip"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/ipfs/go-cid"
 	"github.com/johann/ib/internal/backup"
 	"github.com/johann/ib/internal/ipfsnode"
+	"github.com/johann/ib/internal/notify"
+	"github.com/johann/ib/internal/storage"
 )
 
+// handleListManifests lists manifests matching the tag.* query parameters,
+// newest first unless sort and order say otherwise. Without limit all of
+// them are returned; X-Total-Count has the number of matches either way.
 func (s *Server) handleListManifests(c *gin.Context) {
-	tags := extractTags(c)
+	q := storage.ManifestQuery{
+		Tags:      extractTags(c),
+		Namespace: c.GetString("token_namespace"),
+		Sort:      c.DefaultQuery("sort", storage.ManifestSortCreated),
+	}
+	if q.Sort != storage.ManifestSortCreated && q.Sort != storage.ManifestSortName {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "sort must be created_at or name"})
+		return
+	}
+	order := c.DefaultQuery("order", "desc")
+	if order != "asc" && order != "desc" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "order must be asc or desc"})
+		return
+	}
+	q.Desc = order == "desc"
+	offset, err := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if err != nil || offset < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid offset"})
+		return
+	}
+	q.Offset = offset
+	if v := c.Query("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 1 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid limit"})
+			return
+		}
+		q.Limit = min(limit, maxManifestsLimit)
+	}
 
-	manifests, err := s.storage.ListManifests(c.Request.Context(), tags)
+	manifests, total, err := s.storage.QueryManifests(c.Request.Context(), q)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	if manifests == nil {
+		manifests = []storage.ManifestInfo{}
+	}
 
+	c.Header("X-Total-Count", strconv.Itoa(total))
 	c.JSON(http.StatusOK, manifests)
 }
 
+// maxManifestsLimit is the largest page GET /api/manifests returns
+const maxManifestsLimit = 1000
+
 func (s *Server) handleGetManifest(c *gin.Context) {
 	id := c.Param("id")
 
@@ -43,6 +85,9 @@ func (s *Server) handleGetManifest(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	if s.notModified(c, id, true) {
+		return
+	}
 
 	// Decompress manifest data
 	decompressed, err := backup.Decompress(data, int64(len(data)*10)) // Estimate
@@ -57,13 +102,59 @@ func (s *Server) handleGetManifest(c *gin.Context) {
 		return
 	}
 
-	c.JSON(http.StatusOK, manifest)
+	// Without entry parameters the whole manifest is returned, as before
+	_, paged := c.GetQuery("entries_page")
+	_, limited := c.GetQuery("entries_limit")
+	if c.Query("entries") != "false" && !paged && !limited {
+		c.JSON(http.StatusOK, manifest)
+		return
+	}
+
+	page, err := strconv.Atoi(c.DefaultQuery("entries_page", "1"))
+	if err != nil || page < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid entries_page"})
+		return
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("entries_limit", strconv.Itoa(defaultEntriesLimit)))
+	if err != nil || limit < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid entries_limit"})
+		return
+	}
+	limit = min(limit, maxEntriesLimit)
+
+	total := len(manifest.Entries)
+	if c.Query("entries") == "false" {
+		manifest.Entries = []backup.Entry{}
+	} else {
+		start := min((page-1)*limit, total)
+		manifest.Entries = manifest.Entries[start:min(start+limit, total)]
+	}
+	c.JSON(http.StatusOK, manifestPage{
+		Manifest:     &manifest,
+		EntriesTotal: total,
+		EntriesPage:  page,
+		EntriesLimit: limit,
+	})
+}
+
+const (
+	defaultEntriesLimit = 1000
+	maxEntriesLimit     = 10000
+)
+
+// manifestPage is a manifest with one page of its entries, or none with
+// ?entries=false
+type manifestPage struct {
+	*backup.Manifest
+	EntriesTotal int `json:"entries_total"`
+	EntriesPage  int `json:"entries_page"`
+	EntriesLimit int `json:"entries_limit"`
 }
 
 func (s *Server) handleGetLatestManifest(c *gin.Context) {
 	tags := extractTags(c)
 
-	data, err := s.storage.GetLatestManifest(c.Request.Context(), tags)
+	data, err := s.storage.GetLatestManifest(c.Request.Context(), tags, c.GetString("token_namespace"))
 	if err != nil {
 		if strings.Contains(err.Error(), "not found") || strings.Contains(err.Error(), "no manifests") {
 			c.JSON(http.StatusNotFound, gin.H{"error": "no matching manifest found"})
@@ -91,18 +182,52 @@ func (s *Server) handleGetLatestManifest(c *gin.Context) {
 func (s *Server) handleCreateManifest(c *gin.Context) {
 	var manifest backup.Manifest
 	if err := c.ShouldBindJSON(&manifest); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("manifest is larger than %d bytes", tooLarge.Limit)})
+			return
+		}
 		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid manifest JSON"})
 		return
 	}
+	if !checkNamespaceName(c, manifest.Tags["name"]) {
+		return
+	}
 
-	ctx := c.Request.Context()
+	if err := s.storeManifest(c.Request.Context(), &manifest); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	name := manifest.Tags["name"]
+	s.events.publish(serverEvent{Type: eventManifestCreated, Name: name, Data: storage.ManifestInfo{
+		ID:        manifest.ID,
+		Tags:      manifest.Tags,
+		CreatedAt: manifest.CreatedAt,
+	}})
+	s.settings().notifier.Notify(notify.Event{
+		Type:    notify.ManifestCreated,
+		Name:    name,
+		Title:   fmt.Sprintf("Backup %s completed", name),
+		Message: fmt.Sprintf("Manifest %s of %s was stored.", manifest.ID, name),
+	})
+
+	c.JSON(http.StatusCreated, gin.H{"id": manifest.ID, "root_cid": manifest.RootCID})
+}
+
+// storeManifest builds the manifest's IPFS DAG, saves it with its block and
+// node references, and advertises the root CID if IPFS is enabled
+func (s *Server) storeManifest(ctx context.Context, manifest *backup.Manifest) error {
+	// Encrypted manifests are stored opaquely, without a DAG
+	if manifest.Encrypted != nil {
+		return s.storeEncryptedManifest(ctx, manifest)
+	}
 
 	// Build IPFS DAG structure and collect node CIDs
 	nodeCollector := ipfsnode.NewNodeCollector(s.storage)
-	rootCID, err := ipfsnode.BuildManifestDAG(ctx, &manifest, nodeCollector)
+	rootCID, err := ipfsnode.BuildManifestDAG(ctx, manifest, nodeCollector, s.dagOptions())
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("failed to build DAG: %v", err)})
-		return
+		return fmt.Errorf("failed to build DAG: %w", err)
 	}
 
 	// Update manifest with root CID (BuildManifestDAG already does this, but be explicit)
@@ -111,8 +236,7 @@ func (s *Server) handleCreateManifest(c *gin.Context) {
 	// Serialize and compress manifest (after DAG building so it includes CIDs)
 	data, err := json.Marshal(manifest)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to serialize manifest"})
-		return
+		return fmt.Errorf("failed to serialize manifest: %w", err)
 	}
 
 	// Compress the manifest data
@@ -120,46 +244,174 @@ func (s *Server) handleCreateManifest(c *gin.Context) {
 
 	// Save manifest with node references
 	nodeCIDs := nodeCollector.NodeCIDs()
-	if err := s.storage.SaveManifest(ctx, &manifest, compressed, nodeCIDs); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
+	if err := s.storage.SaveManifest(ctx, manifest, compressed, nodeCIDs); err != nil {
+		return err
 	}
 
 	s.metrics.manifestsTotal.Inc()
+	s.refreshBackupMetrics(ctx)
 
 	// Advertise root CID to DHT if IPFS is enabled
-	if s.ipfsNode != nil && manifest.RootCID != "" {
+	if node := s.ipfs(); node != nil && manifest.RootCID != "" {
 		if rootCIDParsed, err := cid.Decode(manifest.RootCID); err == nil {
-			s.ipfsNode.AddRootCID(rootCIDParsed)
+			node.AddRootCID(rootCIDParsed)
 			// Advertise in background to not block the response
 			go func() {
-				if err := s.ipfsNode.AdvertiseRoots(context.Background()); err != nil {
-					fmt.Printf("Warning: failed to advertise root CID: %v\n", err)
+				if err := node.AdvertiseRoots(context.Background()); err != nil {
+					logger.Warn("failed to advertise root CID", "cid", rootCIDParsed, "error", err)
+				}
+				if err := node.ProvideDAG(context.Background(), rootCIDParsed); err != nil {
+					logger.Warn("failed to queue DAG for providing", "cid", rootCIDParsed, "error", err)
+				}
+				if err := node.AnnounceIPNI(context.Background(), rootCIDParsed); err != nil {
+					logger.Warn("failed to announce root CID to IPNI", "cid", rootCIDParsed, "error", err)
 				}
 			}()
 		}
 	}
 
-	c.JSON(http.StatusCreated, gin.H{"id": manifest.ID, "root_cid": manifest.RootCID})
+	return nil
 }
 
+// storeEncryptedManifest saves a zero-knowledge manifest. Only its ID, tags,
+// creation time and block CIDs are visible to the server.
+func (s *Server) storeEncryptedManifest(ctx context.Context, manifest *backup.Manifest) error {
+	if len(manifest.Entries) > 0 || manifest.RootPath != "" || manifest.RootCID != "" {
+		return fmt.Errorf("encrypted manifest must not contain plaintext entries")
+	}
+
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		return fmt.Errorf("failed to serialize manifest: %w", err)
+	}
+
+	if err := s.storage.SaveManifest(ctx, manifest, compressData(data), nil); err != nil {
+		return err
+	}
+
+	s.metrics.manifestsTotal.Inc()
+	s.refreshBackupMetrics(ctx)
+	return nil
+}
+
+// dagOptions returns the DAG building options from the server config
+func (s *Server) dagOptions() ipfsnode.DAGOptions {
+	opts := ipfsnode.DefaultDAGOptions()
+	if s.config.IPFSShardThreshold < 0 {
+		opts.ShardThreshold = 0
+	} else if s.config.IPFSShardThreshold > 0 {
+		opts.ShardThreshold = s.config.IPFSShardThreshold
+	}
+	return opts
+}
+
+// handleDeleteManifest moves a manifest to the trash, or deletes it right
+// away if the trash is disabled. Its blocks stay until the trash period is
+// over and the next prune runs.
 func (s *Server) handleDeleteManifest(c *gin.Context) {
 	id := c.Param("id")
+	ctx := c.Request.Context()
 
-	if err := s.storage.DeleteManifest(c.Request.Context(), id); err != nil {
+	// Looked up before the manifest is gone, for the event
+	name, _ := s.storage.ManifestName(ctx, id)
+
+	period := s.settings().trashPeriod
+	var err error
+	if period > 0 {
+		err = s.storage.TrashManifest(ctx, id)
+	} else {
+		err = s.storage.DeleteManifest(ctx, id)
+	}
+	if err != nil {
+		if strings.Contains(err.Error(), "not found") {
+			c.JSON(http.StatusNotFound, gin.H{"error": "manifest not found"})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	s.trees.remove(id)
+	s.events.publish(serverEvent{Type: eventManifestDeleted, Name: name, Data: gin.H{"id": id}})
 
-	s.metrics.manifestsTotal.Dec()
+	s.refreshMetrics(ctx)
 
+	if period > 0 {
+		logger.Info("manifest moved to trash", "manifest", id, "token", c.GetString("token_name"))
+		c.JSON(http.StatusOK, gin.H{"deleted": id, "trashed_until": time.Now().Add(period).UTC()})
+		return
+	}
 	c.JSON(http.StatusOK, gin.H{"deleted": id})
 }
 
+func (s *Server) handleVerifyDAG(c *gin.Context) {
+	ctx := c.Request.Context()
+
+	manifest, err := s.loadManifest(ctx, c.Param("id"))
+	if err != nil {
+		if strings.Contains(err.Error(), "not found") {
+			c.JSON(http.StatusNotFound, gin.H{"error": "manifest not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if manifest.RootCID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "manifest has no root CID"})
+		return
+	}
+
+	result, err := s.verifyManifest(ctx, manifest)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"id":       manifest.ID,
+		"root_cid": result.RootCID,
+		"checked":  result.Checked,
+		"missing":  result.Missing,
+		"complete": result.Complete(),
+	})
+}
+
+// verifyManifest checks that a manifest's whole DAG is stored, recording
+// the result in metrics and notifying about incomplete DAGs
+func (s *Server) verifyManifest(ctx context.Context, manifest *backup.Manifest) (*ipfsnode.VerifyResult, error) {
+	rootCID, err := cid.Decode(manifest.RootCID)
+	if err != nil {
+		s.metrics.dagVerifications.WithLabelValues("error").Inc()
+		return nil, fmt.Errorf("invalid root CID: %w", err)
+	}
+
+	result, err := ipfsnode.VerifyDAG(ctx, rootCID, s.storage)
+	if err != nil {
+		s.metrics.dagVerifications.WithLabelValues("error").Inc()
+		return nil, err
+	}
+	if result.Complete() {
+		s.metrics.dagVerifications.WithLabelValues("complete").Inc()
+		return result, nil
+	}
+
+	s.metrics.dagVerifications.WithLabelValues("incomplete").Inc()
+	s.metrics.dagMissing.Add(float64(len(result.Missing)))
+	name := manifest.Tags["name"]
+	s.settings().notifier.Notify(notify.Event{
+		Type:    notify.VerifyFailed,
+		Name:    name,
+		Title:   fmt.Sprintf("Verification of %s failed", name),
+		Message: fmt.Sprintf("Manifest %s is missing %d of %d blocks and nodes.", manifest.ID, len(result.Missing), result.Checked),
+		Failure: true,
+	})
+	return result, nil
+}
+
 func (s *Server) handleGetBlock(c *gin.Context) {
 	cid := c.Param("cid")
 
-	data, err := s.storage.GetBlock(c.Request.Context(), cid)
+	body, info, err := s.storage.GetBlockReader(c.Request.Context(), cid)
 	if err != nil {
 		if strings.Contains(err.Error(), "not found") {
 			c.JSON(http.StatusNotFound, gin.H{"error": "block not found"})
@@ -168,10 +420,44 @@ func (s *Server) handleGetBlock(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
+	defer body.Close()
 
-	s.metrics.bandwidthDownload.Add(float64(len(data)))
+	// Clients that don't accept blocks with a header get them decoded
+	decode := info.Compression == backup.CompressionHeader && c.GetHeader("Accept") != backup.BlockMediaType
+	c.Header("Vary", "Accept")
+	key := cid
+	if !decode {
+		key += "-stored"
+	}
+	if s.notModified(c, key, false) {
+		return
+	}
+	if decode {
+		c.Header("Content-Type", "application/octet-stream")
+		c.Header("Content-Length", strconv.FormatInt(info.OriginalSize, 10))
+		n, err := decodeBlock(c.Writer, body, info)
+		if err != nil {
+			logger.Warn("failed to send block", "cid", cid, "error", err)
+		}
+		s.metrics.bandwidthDownload.Add(float64(n))
+		return
+	}
 
-	c.Data(http.StatusOK, "application/octet-stream", data)
+	// Blocks are sent as stored, with what the client needs to decompress
+	// them
+	var headers map[string]string
+	if info.Size != info.OriginalSize || info.Compression == backup.CompressionHeader {
+		codec := info.Compression
+		if codec == "" {
+			codec = backup.CompressionLZ4
+		}
+		headers = map[string]string{
+			"X-Compression":   codec,
+			"X-Original-Size": strconv.FormatInt(info.OriginalSize, 10),
+		}
+	}
+	c.DataFromReader(http.StatusOK, info.Size, "application/octet-stream", body, headers)
+	s.metrics.bandwidthDownload.Add(float64(info.Size))
 }
 
 func (s *Server) handleBlockExists(c *gin.Context) {
@@ -190,6 +476,30 @@ func (s *Server) handleBlockExists(c *gin.Context) {
 	}
 }
 
+// maxBlockBatch is the most CIDs POST /api/blocks/exists checks at once
+const maxBlockBatch = 10000
+
+// handleMissingBlocks takes a JSON array of CIDs and returns the ones the
+// server doesn't have, saving incremental backups a round trip per block
+func (s *Server) handleMissingBlocks(c *gin.Context) {
+	var cids []string
+	if err := c.ShouldBindJSON(&cids); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "expected a JSON array of CIDs"})
+		return
+	}
+	if len(cids) > maxBlockBatch {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("at most %d CIDs per request", maxBlockBatch)})
+		return
+	}
+
+	missing, err := s.storage.MissingBlocks(c.Request.Context(), cids)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"missing": missing})
+}
+
 func (s *Server) handleUploadBlock(c *gin.Context) {
 	cid := c.GetHeader("X-Block-CID")
 	if cid == "" {
@@ -200,13 +510,43 @@ func (s *Server) handleUploadBlock(c *gin.Context) {
 	originalSizeStr := c.GetHeader("X-Original-Size")
 	originalSize, _ := strconv.ParseInt(originalSizeStr, 10, 64)
 
+	// Blocks without a codec are LZ4 compressed or raw, as before codecs
+	compression := c.GetHeader("X-Compression")
+	switch compression {
+	case "", backup.CompressionLZ4, backup.CompressionZstd, backup.CompressionHeader:
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "unsupported X-Compression " + compression})
+		return
+	}
+
 	data, err := io.ReadAll(c.Request.Body)
 	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "failed to read body"})
 		return
 	}
+	// Blocks with a header are checked against their checksum on every read
+	if compression == backup.CompressionHeader {
+		h, _, err := backup.ParseBlockHeader(data)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		originalSize = h.OriginalSize
+	}
 
-	if err := s.storage.SaveBlock(c.Request.Context(), cid, data, originalSize); err != nil {
+	// Blocks are deduplicated by CID, across namespaces too, so data stored
+	// under a wrong CID would be restored for every backup using that CID
+	if err := verifyBlockCID(cid, data, originalSize, compression); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	namespace := c.GetString("token_namespace")
+	if err := s.storage.SaveNamespaceBlock(c.Request.Context(), namespace, s.namespaceQuota(namespace), cid, data, originalSize, compression); err != nil {
+		if errors.Is(err, storage.ErrQuotaExceeded) {
+			c.JSON(http.StatusInsufficientStorage, gin.H{"error": err.Error()})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
@@ -214,6 +554,7 @@ func (s *Server) handleUploadBlock(c *gin.Context) {
 	s.metrics.blocksTotal.Inc()
 	s.metrics.storageBytes.Add(float64(len(data)))
 	s.metrics.bandwidthUpload.Add(float64(len(data)))
+	s.events.recordUpload(c.GetString("token_name"), namespace, 1, int64(len(data)))
 
 	c.JSON(http.StatusCreated, gin.H{"cid": cid})
 }
@@ -249,6 +590,12 @@ func (s *Server) handleDownload(c *gin.Context) {
 		return
 	}
 
+	// The server can't read encrypted backups, only the client can restore them
+	if manifest.Encrypted != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "manifest is encrypted, restore it with the ib client"})
+		return
+	}
+
 	// Set headers for download
 	filename := manifestID
 	if format == "zip" {
@@ -293,6 +640,12 @@ func (s *Server) handleDownloadFile(c *gin.Context) {
 		return
 	}
 
+	// The server can't read encrypted backups, only the client can restore them
+	if manifest.Encrypted != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "manifest is encrypted, restore it with the ib client"})
+		return
+	}
+
 	// Find the entry
 	var targetEntry *backup.Entry
 	for i := range manifest.Entries {
@@ -316,10 +669,19 @@ func (s *Server) handleDownloadFile(c *gin.Context) {
 	filename := filepath.Base(filePath)
 	c.Header("Content-Type", "application/octet-stream")
 	c.Header("Content-Disposition", "attachment; filename="+filename)
-	c.Header("Content-Length", strconv.FormatInt(targetEntry.Size, 10))
 
-	// Stream the file blocks
+	// Range requests (resumed downloads, seeking in videos) start at the
+	// block the range is in
 	ctx := c.Request.Context()
+	if sizes := manifest.FileBlockSizes(targetEntry); sizes != nil {
+		r := s.newFileReader(ctx, targetEntry.Blocks, sizes)
+		http.ServeContent(c.Writer, c.Request, filename, time.Unix(0, targetEntry.Mtime), r)
+		s.metrics.bandwidthDownload.Add(float64(r.read))
+		return
+	}
+
+	// Block sizes unknown, stream the whole file
+	c.Header("Content-Length", strconv.FormatInt(targetEntry.Size, 10))
 	for _, cid := range targetEntry.Blocks {
 		select {
 		case <-ctx.Done():
@@ -327,17 +689,7 @@ func (s *Server) handleDownloadFile(c *gin.Context) {
 		default:
 		}
 
-		blockData, err := s.storage.GetBlock(ctx, cid)
-		if err != nil {
-			continue
-		}
-
-		decompressed, err := backup.Decompress(blockData, backup.ChunkSize)
-		if err != nil {
-			c.Writer.Write(blockData)
-		} else {
-			c.Writer.Write(decompressed)
-		}
+		s.writeBlock(ctx, c.Writer, cid)
 	}
 
 	s.metrics.bandwidthDownload.Add(float64(targetEntry.Size))
@@ -377,6 +729,12 @@ func (s *Server) handleDownloadFolder(c *gin.Context) {
 		return
 	}
 
+	// The server can't read encrypted backups, only the client can restore them
+	if manifest.Encrypted != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "manifest is encrypted, restore it with the ib client"})
+		return
+	}
+
 	// Filter entries to only include those in the folder
 	var filteredEntries []backup.Entry
 	folderPrefix := folderPath + "/"
@@ -421,26 +779,6 @@ func (s *Server) handleDownloadFolder(c *gin.Context) {
 	}
 }
 
-func (s *Server) handleCLIDownload(c *gin.Context) {
-	osName := c.Param("os")
-	arch := c.Param("arch")
-
-	filename := "ib-" + osName + "-" + arch
-	if osName == "windows" {
-		filename += ".exe"
-	}
-
-	// Try to serve from embedded files
-	data, err := clientBinaries.ReadFile("dist/clients/" + filename)
-	if err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "binary not found for " + osName + "/" + arch})
-		return
-	}
-
-	c.Header("Content-Disposition", "attachment; filename="+filename)
-	c.Data(http.StatusOK, "application/octet-stream", data)
-}
-
 func (s *Server) handleStaticFiles(c *gin.Context) {
 	path := c.Request.URL.Path
 	if path == "/" {
@@ -484,6 +822,25 @@ func extractTags(c *gin.Context) map[string]string {
 	return tags
 }
 
+// loadManifest reads, decompresses and parses a stored manifest
+func (s *Server) loadManifest(ctx context.Context, id string) (*backup.Manifest, error) {
+	data, err := s.storage.GetManifest(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	decompressed, err := backup.Decompress(data, int64(len(data)*10))
+	if err != nil {
+		decompressed = data
+	}
+
+	var manifest backup.Manifest
+	if err := json.Unmarshal(decompressed, &manifest); err != nil {
+		return nil, fmt.Errorf("failed to parse manifest: %w", err)
+	}
+	return &manifest, nil
+}
+
 func compressData(data []byte) []byte {
 	// Use LZ4 compression
 	compressed := make([]byte, len(data))
@@ -547,17 +904,7 @@ func (s *Server) streamTarGz(c *gin.Context, manifest *backup.Manifest, stripPre
 
 			// Stream blocks directly to tar writer
 			for _, cid := range entry.Blocks {
-				blockData, err := s.storage.GetBlock(ctx, cid)
-				if err != nil {
-					continue
-				}
-				// Decompress and write directly
-				decompressed, err := backup.Decompress(blockData, backup.ChunkSize)
-				if err != nil {
-					tw.Write(blockData)
-				} else {
-					tw.Write(decompressed)
-				}
+				s.writeBlock(ctx, tw, cid)
 			}
 		}
 	}
@@ -611,17 +958,7 @@ func (s *Server) streamZip(c *gin.Context, manifest *backup.Manifest, stripPrefi
 
 			// Stream blocks directly to zip writer
 			for _, cid := range entry.Blocks {
-				blockData, err := s.storage.GetBlock(ctx, cid)
-				if err != nil {
-					continue
-				}
-				// Decompress and write directly
-				decompressed, err := backup.Decompress(blockData, backup.ChunkSize)
-				if err != nil {
-					w.Write(blockData)
-				} else {
-					w.Write(decompressed)
-				}
+				s.writeBlock(ctx, w, cid)
 			}
 		}
 	}
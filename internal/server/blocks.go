@@ -0,0 +1,140 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+
+	"github.com/johann/ib/internal/backup"
+	"github.com/johann/ib/internal/storage"
+)
+
+// blockBuffers holds buffers for decompressing blocks, so each concurrent
+// download needs at most two chunk-sized buffers however large it is
+var blockBuffers = sync.Pool{New: func() any { return new([]byte) }}
+
+func getBlockBuffer(n int64) *[]byte {
+	buf := blockBuffers.Get().(*[]byte)
+	if int64(cap(*buf)) < n {
+		*buf = make([]byte, n)
+	}
+	*buf = (*buf)[:n]
+	return buf
+}
+
+// writeBlock writes the original data of a block to w
+func (s *Server) writeBlock(ctx context.Context, w io.Writer, cid string) (int64, error) {
+	body, info, err := s.storage.GetBlockReader(ctx, cid)
+	if err != nil {
+		return 0, err
+	}
+	defer body.Close()
+	return decodeBlock(w, body, info)
+}
+
+// decodeBlock writes the original data of a stored block read from body
+// to w. Uncompressed blocks without a header are streamed; others are read
+// into a pooled buffer and decoded whole, blocks with a header checked
+// against their checksum.
+func decodeBlock(w io.Writer, body io.Reader, info *storage.BlockInfo) (int64, error) {
+	if info.Size == info.OriginalSize && info.Compression != backup.CompressionHeader {
+		return io.Copy(w, body)
+	}
+
+	in := getBlockBuffer(info.Size)
+	defer blockBuffers.Put(in)
+	if _, err := io.ReadFull(body, *in); err != nil {
+		return 0, err
+	}
+
+	out := getBlockBuffer(info.OriginalSize)
+	defer blockBuffers.Put(out)
+	if info.Compression == backup.CompressionHeader {
+		n, err := backup.DecodeBlockInto(*in, *out)
+		if err != nil {
+			return 0, err
+		}
+		written, err := w.Write((*out)[:n])
+		return int64(written), err
+	}
+	n, err := backup.DecompressBlockInto(info.Compression, *in, *out)
+	if err != nil && info.Compression == "" {
+		// Stored as is
+		written, err := w.Write(*in)
+		return int64(written), err
+	}
+	written, err := w.Write((*out)[:n])
+	return int64(written), err
+}
+
+// fileReader reads a file of a manifest as an io.ReadSeeker, decoding only
+// the block the offset is in, so http.ServeContent can answer range
+// requests without sending the blocks before them
+type fileReader struct {
+	ctx     context.Context
+	server  *Server
+	blocks  []string
+	offsets []int64 // Start of each block in the file
+	size    int64
+	pos     int64
+	read    int64 // Bytes read, for the bandwidth metric
+
+	block int // Index of the block in buf, -1 for none
+	buf   bytes.Buffer
+}
+
+// newFileReader returns a reader for a file with the given blocks and
+// their original sizes, see backup.Manifest.FileBlockSizes
+func (s *Server) newFileReader(ctx context.Context, blocks []string, sizes []int64) *fileReader {
+	r := &fileReader{ctx: ctx, server: s, blocks: blocks, offsets: make([]int64, len(sizes)), block: -1}
+	for i, size := range sizes {
+		r.offsets[i] = r.size
+		r.size += size
+	}
+	return r
+}
+
+func (r *fileReader) Read(p []byte) (int, error) {
+	if r.pos >= r.size {
+		return 0, io.EOF
+	}
+	i := sort.Search(len(r.offsets), func(i int) bool { return r.offsets[i] > r.pos }) - 1
+	if i != r.block {
+		r.block = -1
+		r.buf.Reset()
+		if _, err := r.server.writeBlock(r.ctx, &r.buf, r.blocks[i]); err != nil {
+			return 0, err
+		}
+		r.block = i
+	}
+
+	data := r.buf.Bytes()
+	start := r.pos - r.offsets[i]
+	if start >= int64(len(data)) {
+		return 0, fmt.Errorf("block %s is shorter than recorded", r.blocks[i])
+	}
+	n := copy(p, data[start:])
+	r.pos += int64(n)
+	r.read += int64(n)
+	return n, nil
+}
+
+func (r *fileReader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += r.pos
+	case io.SeekEnd:
+		offset += r.size
+	default:
+		return 0, fmt.Errorf("invalid whence %d", whence)
+	}
+	if offset < 0 {
+		return 0, fmt.Errorf("negative position %d", offset)
+	}
+	r.pos = offset
+	return offset, nil
+}
@@ -0,0 +1,57 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// immutableMaxAge is how long clients and proxies may keep blocks and
+// manifests, which never change once stored
+const immutableMaxAge = "max-age=31536000, immutable"
+
+// notModified sets a strong ETag made of key, and of the query for
+// responses that depend on it, plus caching headers for a response that
+// never changes. It answers 304 and returns true if the client has it
+// already.
+func (s *Server) notModified(c *gin.Context, key string, withQuery bool) bool {
+	tag := key
+	if withQuery && c.Request.URL.RawQuery != "" {
+		sum := sha256.Sum256([]byte(c.Request.URL.RawQuery))
+		tag += "-" + hex.EncodeToString(sum[:8])
+	}
+	// The compressed representation is a different one
+	if w, ok := c.Writer.(*compressWriter); ok {
+		tag += "-" + w.encoding
+	}
+	etag := `"` + tag + `"`
+
+	c.Header("ETag", etag)
+	if s.config.PrivateReads {
+		c.Header("Cache-Control", "private, "+immutableMaxAge)
+	} else {
+		c.Header("Cache-Control", "public, "+immutableMaxAge)
+	}
+
+	if etagMatches(c.GetHeader("If-None-Match"), etag) {
+		c.Status(http.StatusNotModified)
+		c.Writer.WriteHeaderNow()
+		return true
+	}
+	return false
+}
+
+// etagMatches reports whether an If-None-Match header lists etag, by weak
+// comparison as RFC 9110 asks for
+func etagMatches(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,80 @@
+package server
+
+import (
+	"io"
+	"net/http"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/johann/ib/internal/release"
+)
+
+// clientDir is where the client binaries are embedded
+const clientDir = "dist/clients"
+
+var (
+	checksumsOnce sync.Once
+	checksums     []byte
+	checksumsErr  error
+)
+
+// clientChecksums returns the embedded checksums file, or hashes the
+// embedded binaries once for builds that didn't generate one
+func clientChecksums() ([]byte, error) {
+	checksumsOnce.Do(func() {
+		checksums, checksumsErr = clientBinaries.ReadFile(path.Join(clientDir, release.ChecksumsFile))
+		if checksumsErr != nil {
+			checksums, checksumsErr = release.Checksums(clientBinaries, clientDir)
+		}
+	})
+	return checksums, checksumsErr
+}
+
+// handleCLIDownload streams a client binary. Range requests let
+// interrupted downloads resume.
+func (s *Server) handleCLIDownload(c *gin.Context) {
+	osName := c.Param("os")
+	arch := c.Param("arch")
+	filename := release.BinaryName(osName, arch)
+
+	f, err := clientBinaries.Open(path.Join(clientDir, filename))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "binary not found for " + osName + "/" + arch})
+		return
+	}
+	defer f.Close()
+
+	if sums, err := clientChecksums(); err == nil {
+		if sum, err := release.Lookup(sums, filename); err == nil {
+			c.Header("X-Checksum-Sha256", sum)
+		}
+	}
+	c.Header("Content-Disposition", "attachment; filename="+filename)
+	c.Header("Content-Type", "application/octet-stream")
+	// Embedded files have no modification time
+	http.ServeContent(c.Writer, c.Request, filename, time.Time{}, f.(io.ReadSeeker))
+}
+
+// handleCLIChecksums serves the SHA-256 of every client binary in
+// sha256sum format
+func (s *Server) handleCLIChecksums(c *gin.Context) {
+	sums, err := clientChecksums()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.Data(http.StatusOK, "text/plain; charset=utf-8", sums)
+}
+
+// handleCLISignature serves the detached signature of the checksums, if
+// the client binaries were signed at build time
+func (s *Server) handleCLISignature(c *gin.Context) {
+	sig, err := clientBinaries.ReadFile(path.Join(clientDir, release.SignatureFile))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "client binaries are not signed"})
+		return
+	}
+	c.Data(http.StatusOK, "text/plain; charset=utf-8", sig)
+}
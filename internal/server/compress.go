@@ -0,0 +1,152 @@
+package server
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Encoders are reused, a zstd encoder in particular is expensive to create
+var (
+	gzipWriters = sync.Pool{New: func() any { return gzip.NewWriter(nil) }}
+	zstdWriters = sync.Pool{New: func() any {
+		w, _ := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedDefault))
+		return w
+	}}
+)
+
+// compressResponses encodes responses with zstd or gzip if the client
+// accepts them, for the JSON endpoints: manifests and listings shrink to a
+// fraction, blocks and archives are compressed already
+func compressResponses() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Header("Vary", "Accept-Encoding")
+		encoding := negotiateEncoding(c.GetHeader("Accept-Encoding"))
+		if encoding == "" {
+			c.Next()
+			return
+		}
+
+		w := &compressWriter{ResponseWriter: c.Writer, encoding: encoding}
+		c.Writer = w
+		defer w.close()
+		c.Next()
+	}
+}
+
+// negotiateEncoding picks zstd or gzip from an Accept-Encoding header,
+// preferring zstd, or "" if the client accepts neither
+func negotiateEncoding(header string) string {
+	accepted := make(map[string]bool)
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			q, _ = strconv.ParseFloat(v, 64)
+		}
+		accepted[strings.ToLower(strings.TrimSpace(name))] = q > 0
+	}
+	switch {
+	case accepted["zstd"]:
+		return "zstd"
+	case accepted["gzip"]:
+		return "gzip"
+	}
+	return ""
+}
+
+// compressWriter encodes what is written to it. The encoder is only set
+// up by the first write, so empty responses stay as they are.
+type compressWriter struct {
+	gin.ResponseWriter
+	encoding string
+	encoder  io.WriteCloser
+}
+
+func (w *compressWriter) Write(data []byte) (int, error) {
+	if w.encoder == nil {
+		h := w.ResponseWriter.Header()
+		h.Set("Content-Encoding", w.encoding)
+		h.Del("Content-Length")
+		switch w.encoding {
+		case "zstd":
+			enc := zstdWriters.Get().(*zstd.Encoder)
+			enc.Reset(w.ResponseWriter)
+			w.encoder = enc
+		default:
+			enc := gzipWriters.Get().(*gzip.Writer)
+			enc.Reset(w.ResponseWriter)
+			w.encoder = enc
+		}
+	}
+	return w.encoder.Write(data)
+}
+
+func (w *compressWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+// close flushes the encoder and returns it to its pool
+func (w *compressWriter) close() {
+	if w.encoder == nil {
+		return
+	}
+	if err := w.encoder.Close(); err != nil {
+		logger.Debug("failed to finish compressed response", "error", err)
+	}
+	switch enc := w.encoder.(type) {
+	case *zstd.Encoder:
+		enc.Reset(nil)
+		zstdWriters.Put(enc)
+	case *gzip.Writer:
+		gzipWriters.Put(enc)
+	}
+	w.encoder = nil
+}
+
+// maxManifestBody is the largest manifest upload taken once decompressed,
+// so a small compressed body can't inflate without bound
+const maxManifestBody = 1 << 30
+
+// decompressRequests decodes request bodies sent with Content-Encoding
+// gzip or zstd, to at most limit bytes. Reading past it fails with an
+// *http.MaxBytesError, which handlers answer with 413. Must run after
+// authMiddleware, signed requests are signed over the body as sent.
+func decompressRequests(limit int64) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		switch encoding := strings.ToLower(c.GetHeader("Content-Encoding")); encoding {
+		case "", "identity":
+		case "gzip":
+			r, err := gzip.NewReader(c.Request.Body)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid gzip body"})
+				c.Abort()
+				return
+			}
+			defer r.Close()
+			c.Request.Body = http.MaxBytesReader(c.Writer, io.NopCloser(r), limit)
+		case "zstd":
+			r, err := zstd.NewReader(c.Request.Body)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid zstd body"})
+				c.Abort()
+				return
+			}
+			defer r.Close()
+			c.Request.Body = http.MaxBytesReader(c.Writer, io.NopCloser(r), limit)
+		default:
+			c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": "unsupported Content-Encoding " + encoding})
+			c.Abort()
+			return
+		}
+		c.Request.Header.Del("Content-Encoding")
+		c.Request.ContentLength = -1
+		c.Next()
+	}
+}
@@ -0,0 +1,23 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// handleManifestDedup reports which other manifests share a manifest's
+// blocks and how much deleting it would free
+func (s *Server) handleManifestDedup(c *gin.Context) {
+	report, err := s.storage.ManifestDedup(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		if strings.Contains(err.Error(), "not found") {
+			c.JSON(http.StatusNotFound, gin.H{"error": "manifest not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, report)
+}
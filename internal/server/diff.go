@@ -0,0 +1,88 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/johann/ib/internal/backup"
+)
+
+// manifestDiff is the response of GET /api/manifests/:id/diff/:other
+type manifestDiff struct {
+	Old        string               `json:"old"`
+	New        string               `json:"new"`
+	Added      int                  `json:"added"`
+	Removed    int                  `json:"removed"`
+	Modified   int                  `json:"modified"`
+	SizeChange int64                `json:"size_change"`
+	Total      int                  `json:"total"` // Changes, of which Changes is a page with offset and limit
+	Changes    []backup.EntryChange `json:"changes"`
+}
+
+// handleManifestDiff returns the entries added, removed and modified from
+// manifest :id to manifest :other, so clients don't have to download both
+func (s *Server) handleManifestDiff(c *gin.Context) {
+	offset, err := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if err != nil || offset < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid offset"})
+		return
+	}
+	limit := 0
+	if v := c.Query("limit"); v != "" {
+		if limit, err = strconv.Atoi(v); err != nil || limit < 1 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid limit"})
+			return
+		}
+	}
+
+	ctx := c.Request.Context()
+	var manifests [2]*backup.Manifest
+	for i, id := range []string{c.Param("id"), c.Param("other")} {
+		m, err := s.loadManifest(ctx, id)
+		if err != nil {
+			if strings.Contains(err.Error(), "not found") {
+				c.JSON(http.StatusNotFound, gin.H{"error": "manifest not found: " + id})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		// The server can't read encrypted backups, only the client can
+		// compare them
+		if m.Encrypted != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "manifest " + id + " is encrypted, compare it with the ib client"})
+			return
+		}
+		manifests[i] = m
+	}
+	if s.notModified(c, manifests[0].ID+"-diff-"+manifests[1].ID, true) {
+		return
+	}
+
+	changes := backup.DiffManifests(manifests[0], manifests[1])
+	diff := manifestDiff{Old: manifests[0].ID, New: manifests[1].ID, Total: len(changes)}
+	for _, ch := range changes {
+		switch ch.Change {
+		case backup.ChangeAdded:
+			diff.Added++
+		case backup.ChangeRemoved:
+			diff.Removed++
+		case backup.ChangeModified:
+			diff.Modified++
+		}
+		diff.SizeChange += ch.NewSize - ch.OldSize
+	}
+
+	start := min(offset, len(changes))
+	end := len(changes)
+	if limit > 0 {
+		end = min(start+limit, end)
+	}
+	diff.Changes = changes[start:end]
+	if diff.Changes == nil {
+		diff.Changes = []backup.EntryChange{}
+	}
+	c.JSON(http.StatusOK, diff)
+}
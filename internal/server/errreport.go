@@ -0,0 +1,65 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/johann/ib/internal/errreport"
+)
+
+// recoverPanics logs and reports handler panics, then answers with a 500
+func recoverPanics() gin.HandlerFunc {
+	return gin.CustomRecovery(func(c *gin.Context, v any) {
+		errreport.Panic(v)
+		c.AbortWithStatus(http.StatusInternalServerError)
+	})
+}
+
+// errorCapture keeps the start of 5xx response bodies
+type errorCapture struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *errorCapture) Write(b []byte) (int, error) {
+	if w.Status() >= 500 && w.body.Len() < 1024 {
+		w.body.Write(b)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// reportServerErrors reports 5xx responses, mostly storage and S3 failures,
+// with the error message the handler returned
+func reportServerErrors() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !errreport.Enabled() {
+			c.Next()
+			return
+		}
+
+		w := &errorCapture{ResponseWriter: c.Writer}
+		c.Writer = w
+		c.Next()
+
+		status := w.Status()
+		if status < 500 {
+			return
+		}
+		msg := fmt.Sprintf("HTTP %d", status)
+		var resp struct {
+			Error string `json:"error"`
+		}
+		if json.Unmarshal(w.body.Bytes(), &resp) == nil && resp.Error != "" {
+			msg = resp.Error
+		}
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		errreport.Error(c.Request.Method+" "+route, errors.New(msg))
+	}
+}
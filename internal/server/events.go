@@ -0,0 +1,181 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/johann/ib/internal/backup"
+)
+
+const (
+	// maxEventStreamsPerClient caps the open event streams of a token
+	// from one IP, so one client can't take the server's
+	maxEventStreamsPerClient = 8
+
+	// eventHeartbeat keeps idle streams from being closed by proxies
+	eventHeartbeat = 30 * time.Second
+
+	// uploadEventInterval is how often upload activity is reported
+	uploadEventInterval = time.Second
+)
+
+// Types of server-sent events
+const (
+	eventManifestCreated = "manifest_created"
+	eventManifestDeleted = "manifest_deleted"
+	eventUpload          = "upload"
+	eventPrune           = "prune"
+)
+
+// serverEvent is one event for GET /api/events subscribers
+type serverEvent struct {
+	Type string
+	Name string // Backup name or token namespace it concerns, empty for server-wide events
+	Data any
+}
+
+// uploadActivity is the data of an upload event: what a token uploaded
+// since the last one
+type uploadActivity struct {
+	Token     string `json:"token"`
+	Namespace string `json:"namespace,omitempty"`
+	Blocks    int    `json:"blocks"` // Blocks completed
+	Bytes     int64  `json:"bytes"`  // Bytes received, including partial resumable uploads
+}
+
+// eventHub fans events out to subscribers. Slow subscribers miss events
+// rather than holding up requests.
+type eventHub struct {
+	mu      sync.Mutex
+	subs    map[chan serverEvent]string // Client of each stream
+	clients map[string]int              // Open streams per client
+	uploads map[string]*uploadActivity  // By token, until the next report
+}
+
+func newEventHub() *eventHub {
+	return &eventHub{
+		subs:    make(map[chan serverEvent]string),
+		clients: make(map[string]int),
+		uploads: make(map[string]*uploadActivity),
+	}
+}
+
+// subscribe returns a channel receiving events, or nil if the client has
+// too many streams open
+func (h *eventHub) subscribe(client string) chan serverEvent {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.clients[client] >= maxEventStreamsPerClient {
+		return nil
+	}
+	ch := make(chan serverEvent, 64)
+	h.subs[ch] = client
+	h.clients[client]++
+	return ch
+}
+
+func (h *eventHub) unsubscribe(ch chan serverEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	client := h.subs[ch]
+	delete(h.subs, ch)
+	if h.clients[client]--; h.clients[client] <= 0 {
+		delete(h.clients, client)
+	}
+}
+
+// publish sends an event to all subscribers with room for it
+func (h *eventHub) publish(ev serverEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for ch := range h.subs {
+		select {
+		case ch <- ev:
+		default:
+		}
+	}
+}
+
+// recordUpload adds to a token's upload activity for the next report
+func (h *eventHub) recordUpload(token, namespace string, blocks int, bytes int64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if len(h.subs) == 0 {
+		return
+	}
+	a := h.uploads[token]
+	if a == nil {
+		a = &uploadActivity{Token: token, Namespace: namespace}
+		h.uploads[token] = a
+	}
+	a.Blocks += blocks
+	a.Bytes += bytes
+}
+
+// runUploadReporter publishes the upload activity of each token once per
+// interval, so busy clients don't cause an event per block
+func (h *eventHub) runUploadReporter() {
+	ticker := time.NewTicker(uploadEventInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		h.mu.Lock()
+		uploads := h.uploads
+		h.uploads = make(map[string]*uploadActivity)
+		h.mu.Unlock()
+
+		for _, a := range uploads {
+			h.publish(serverEvent{Type: eventUpload, Name: a.Namespace, Data: a})
+		}
+	}
+}
+
+// handleEvents streams server-sent events: manifests created and deleted,
+// upload activity and prune results. Tokens limited to a namespace only
+// get the events of their namespace. Must run after authMiddleware, the
+// events name tokens and namespaces.
+func (s *Server) handleEvents(c *gin.Context) {
+	ch := s.events.subscribe(c.GetString("token_name") + "@" + s.allowlists.clientIP(c))
+	if ch == nil {
+		c.JSON(http.StatusTooManyRequests, gin.H{"error": "too many open event streams"})
+		return
+	}
+	defer s.events.unsubscribe(ch)
+	namespace := c.GetString("token_namespace")
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no") // Don't let nginx buffer the stream
+	c.Status(http.StatusOK)
+	c.Writer.Flush()
+
+	heartbeat := time.NewTicker(eventHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-c.Request.Context().Done():
+			return
+		case <-heartbeat.C:
+			fmt.Fprint(c.Writer, ": heartbeat\n\n")
+		case ev := <-ch:
+			if namespace != "" && !backup.InNamespace(ev.Name, namespace) {
+				continue
+			}
+			data, err := json.Marshal(ev.Data)
+			if err != nil {
+				logger.Warn("failed to encode event", "type", ev.Type, "error", err)
+				continue
+			}
+			fmt.Fprintf(c.Writer, "event: %s\ndata: %s\n\n", ev.Type, data)
+		}
+		c.Writer.Flush()
+	}
+}
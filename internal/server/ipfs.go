@@ -0,0 +1,219 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ipfs/go-cid"
+	"github.com/johann/ib/internal/backup"
+	"github.com/johann/ib/internal/ipfsnode"
+)
+
+// ipfs returns the running IPFS node, or nil if it is stopped
+func (s *Server) ipfs() *ipfsnode.Node {
+	s.ipfsMu.RLock()
+	defer s.ipfsMu.RUnlock()
+	return s.ipfsNode
+}
+
+// startIPFS starts the embedded IPFS node from the server configuration
+func (s *Server) startIPFS() error {
+	s.ipfsMu.Lock()
+	defer s.ipfsMu.Unlock()
+
+	if s.ipfsNode != nil {
+		return fmt.Errorf("IPFS node already running")
+	}
+	cfg := s.config
+
+	ipfsCfg := ipfsnode.DefaultConfig()
+	if len(cfg.IPFSListenAddrs) > 0 {
+		ipfsCfg.ListenAddrs = cfg.IPFSListenAddrs
+	}
+	// Gateway mounted on the main server replaces the separate listener
+	if cfg.IPFSGatewayMount {
+		ipfsCfg.GatewayAddr = ""
+		ipfsCfg.GatewayMount = true
+	} else if cfg.IPFSGatewayAddr != "" {
+		ipfsCfg.GatewayAddr = cfg.IPFSGatewayAddr
+	}
+	ipfsCfg.GatewayTrustless = cfg.IPFSGatewayTrustless
+	ipfsCfg.MDNSEnabled = cfg.IPFSMDNS
+	ipfsCfg.StaticRelays = cfg.IPFSStaticRelays
+	ipfsCfg.AutoRelay = !cfg.IPFSDisableAutoRelay
+	ipfsCfg.RelayService = !cfg.IPFSDisableRelayService
+	if cfg.IPFSProvideStrategy != "" {
+		ipfsCfg.ProvideStrategy = cfg.IPFSProvideStrategy
+	}
+	if cfg.IPFSProvideRate > 0 {
+		ipfsCfg.ProvideRate = cfg.IPFSProvideRate
+	}
+	if len(cfg.IPNIIndexers) > 0 {
+		listenAddr := cfg.IPNIListenAddr
+		if listenAddr == "" {
+			listenAddr = ":3104"
+		}
+		ipfsCfg.IPNI = &ipfsnode.IPNIConfig{
+			IndexerURLs:  cfg.IPNIIndexers,
+			ListenAddr:   listenAddr,
+			AnnounceAddr: cfg.IPNIAnnounceAddr,
+		}
+	}
+	// Set public IP for DHT announcements
+	if cfg.IPFSPublicIP != "" {
+		ipfsCfg.AnnounceAddrs = []string{
+			fmt.Sprintf("/ip4/%s/tcp/4001", cfg.IPFSPublicIP),
+			fmt.Sprintf("/ip4/%s/udp/4001/quic-v1", cfg.IPFSPublicIP),
+		}
+	}
+
+	ipfsNode, err := ipfsnode.NewNode(context.Background(), s.storage, ipfsCfg)
+	if err != nil {
+		return fmt.Errorf("failed to start IPFS node: %w", err)
+	}
+	s.ipfsNode = ipfsNode
+	peerID := ipfsNode.PeerID()
+	logger.Info("IPFS node started", "peer_id", peerID)
+	for _, addr := range ipfsNode.Addrs() {
+		logger.Info("IPFS listening", "addr", fmt.Sprintf("%s/p2p/%s", addr, peerID))
+	}
+	if cfg.IPFSPublicIP != "" {
+		logger.Info("IPFS announcing", "addr", fmt.Sprintf("/ip4/%s/tcp/4001/p2p/%s", cfg.IPFSPublicIP, peerID))
+		logger.Info("IPFS announcing", "addr", fmt.Sprintf("/ip4/%s/udp/4001/quic-v1/p2p/%s", cfg.IPFSPublicIP, peerID))
+	}
+	if cfg.IPFSMDNS {
+		logger.Info("mDNS local discovery enabled")
+	}
+	if ipfsCfg.IPNI != nil {
+		logger.Info("IPNI announcements enabled", "indexers", cfg.IPNIIndexers, "publisher", ipfsCfg.IPNI.ListenAddr)
+	}
+	if cfg.IPFSGatewayMount {
+		logger.Info("IPFS gateway", "url", fmt.Sprintf("http://localhost%s/ipfs/<cid>", cfg.ListenAddr))
+	} else if cfg.IPFSGatewayAddr != "" {
+		logger.Info("IPFS gateway", "url", fmt.Sprintf("http://localhost%s/ipfs/<cid>", cfg.IPFSGatewayAddr))
+	}
+	// Only the mounted gateway checks tokens
+	if cfg.PrivateReads {
+		logger.Warn("private_reads doesn't cover IPFS: peers and a gateway on its own port can still fetch blocks")
+	}
+
+	return nil
+}
+
+// stopIPFS shuts down the embedded IPFS node if it is running
+func (s *Server) stopIPFS() error {
+	s.ipfsMu.Lock()
+	defer s.ipfsMu.Unlock()
+
+	if s.ipfsNode == nil {
+		return nil
+	}
+	err := s.ipfsNode.Close()
+	s.ipfsNode = nil
+	return err
+}
+
+// serveGateway forwards mounted /ipfs requests to the running node's gateway
+func (s *Server) serveGateway(w http.ResponseWriter, r *http.Request) {
+	node := s.ipfs()
+	if node == nil || node.GatewayHandler() == nil {
+		http.Error(w, "IPFS node is not running", http.StatusServiceUnavailable)
+		return
+	}
+	node.GatewayHandler().ServeHTTP(w, r)
+}
+
+// loadExistingRootCIDs loads root CIDs from existing manifests and advertises them
+func (s *Server) loadExistingRootCIDs(node *ipfsnode.Node) {
+	ctx := context.Background()
+
+	manifests, err := s.storage.ListManifests(ctx, nil)
+	if err != nil {
+		logger.Warn("failed to list manifests for IPFS", "error", err)
+		return
+	}
+
+	var loaded int
+	for _, info := range manifests {
+		data, err := s.storage.GetManifest(ctx, info.ID)
+		if err != nil {
+			continue
+		}
+
+		// Decompress
+		decompressed, err := backup.Decompress(data, int64(len(data)*10))
+		if err != nil {
+			decompressed = data
+		}
+
+		var manifest struct {
+			RootCID string `json:"root_cid"`
+		}
+		if err := json.Unmarshal(decompressed, &manifest); err != nil {
+			continue
+		}
+
+		if manifest.RootCID != "" {
+			if c, err := cid.Decode(manifest.RootCID); err == nil {
+				node.AddRootCID(c)
+				if err := node.AnnounceIPNI(ctx, c); err != nil {
+					logger.Warn("failed to announce to IPNI", "cid", c, "error", err)
+				}
+				loaded++
+			}
+		}
+	}
+
+	if loaded > 0 {
+		logger.Info("loaded root CIDs for IPFS", "count", loaded)
+		if err := node.AdvertiseRoots(ctx); err != nil {
+			logger.Warn("failed to advertise root CIDs", "error", err)
+		}
+		// Queue the rest of the DAGs if the provide strategy asks for it
+		if err := node.Reprovide(ctx); err != nil {
+			logger.Warn("failed to queue CIDs for providing", "error", err)
+		}
+	}
+}
+
+// handleIPFSStatus reports the state of the embedded IPFS node
+func (s *Server) handleIPFSStatus(c *gin.Context) {
+	node := s.ipfs()
+	if node == nil {
+		c.JSON(http.StatusOK, gin.H{"running": false})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"running": true,
+		"node":    node.Status(),
+	})
+}
+
+// handleIPFSStart starts the embedded IPFS node at runtime
+func (s *Server) handleIPFSStart(c *gin.Context) {
+	if err := s.startIPFS(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	node := s.ipfs()
+	go s.loadExistingRootCIDs(node)
+
+	c.JSON(http.StatusOK, gin.H{
+		"running": true,
+		"node":    node.Status(),
+	})
+}
+
+// handleIPFSStop stops the embedded IPFS node at runtime
+func (s *Server) handleIPFSStop(c *gin.Context) {
+	if err := s.stopIPFS(); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"running": false})
+}
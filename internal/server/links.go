@@ -0,0 +1,180 @@
+package server
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/johann/ib/internal/storage"
+)
+
+// Download link lifetimes
+const (
+	defaultLinkExpiry = time.Hour
+	maxLinkExpiry     = 7 * 24 * time.Hour
+)
+
+// linkKeySetting is the settings key holding the download link HMAC key
+const linkKeySetting = "download_link_key"
+
+// loadLinkKey returns the key download links are signed with, creating it
+// on first start so links survive restarts
+func loadLinkKey(store *storage.Storage) ([]byte, error) {
+	ctx := context.Background()
+	value, err := store.GetSetting(ctx, linkKeySetting)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load download link key: %w", err)
+	}
+	if value != "" {
+		return hex.DecodeString(value)
+	}
+
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, fmt.Errorf("failed to generate download link key: %w", err)
+	}
+	if err := store.SetSetting(ctx, linkKeySetting, hex.EncodeToString(key)); err != nil {
+		return nil, fmt.Errorf("failed to save download link key: %w", err)
+	}
+	return key, nil
+}
+
+// signLink returns the signature binding a link ID to a path and expiry
+func (s *Server) signLink(id, path string, expires int64) string {
+	mac := hmac.New(sha256.New, s.linkKey)
+	fmt.Fprintf(mac, "%s\n%s\n%d", id, path, expires)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// handleCreateDownloadLink issues a signed URL for a download endpoint that
+// works without a token until it expires or runs out of downloads
+func (s *Server) handleCreateDownloadLink(c *gin.Context) {
+	var req struct {
+		Path         string `json:"path"`
+		ExpiresIn    int    `json:"expires_in"` // Seconds
+		MaxDownloads int    `json:"max_downloads"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+		return
+	}
+
+	u, err := url.Parse(req.Path)
+	if err != nil || !strings.HasPrefix(u.Path, "/api/download/") {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "path must be an /api/download/ URL"})
+		return
+	}
+	// Links are only handed out for the token's own namespace
+	if namespace := c.GetString("token_namespace"); namespace != "" {
+		id, _, _ := strings.Cut(strings.TrimPrefix(u.Path, "/api/download/"), "/")
+		id = downloadManifestID(id)
+		ok, err := s.manifestInNamespace(c, id, namespace)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if !ok {
+			c.JSON(http.StatusNotFound, gin.H{"error": "manifest not found: " + id})
+			return
+		}
+	}
+	if req.MaxDownloads < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "max_downloads must not be negative"})
+		return
+	}
+
+	expiry := defaultLinkExpiry
+	if req.ExpiresIn > 0 {
+		expiry = time.Duration(req.ExpiresIn) * time.Second
+	}
+	if expiry > maxLinkExpiry {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("expires_in must be at most %d seconds", int(maxLinkExpiry.Seconds()))})
+		return
+	}
+
+	idBytes := make([]byte, 16)
+	if _, err := rand.Read(idBytes); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	now := time.Now()
+	link := &storage.DownloadLink{
+		ID:           hex.EncodeToString(idBytes),
+		Path:         u.Path,
+		ExpiresAt:    now.Add(expiry),
+		MaxDownloads: req.MaxDownloads,
+		CreatedBy:    c.GetString("token_name"),
+		CreatedAt:    now,
+	}
+	if err := s.storage.CreateDownloadLink(c.Request.Context(), link); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	expires := link.ExpiresAt.Unix()
+	q := url.Values{}
+	q.Set("link", link.ID)
+	q.Set("expires", strconv.FormatInt(expires, 10))
+	q.Set("sig", s.signLink(link.ID, link.Path, expires))
+
+	c.JSON(http.StatusCreated, gin.H{
+		"url":           (&url.URL{Path: link.Path}).EscapedPath() + "?" + q.Encode(),
+		"expires_at":    link.ExpiresAt.UTC().Format(time.RFC3339),
+		"max_downloads": link.MaxDownloads,
+	})
+}
+
+// signedLink accepts download requests carrying a valid link signature.
+// Requests without one fall through to the normal read authentication.
+func (s *Server) signedLink() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		sig := c.Query("sig")
+		if sig == "" {
+			c.Next()
+			return
+		}
+
+		id := c.Query("link")
+		expires, err := strconv.ParseInt(c.Query("expires"), 10, 64)
+		valid := err == nil && time.Now().Unix() < expires &&
+			hmac.Equal([]byte(sig), []byte(s.signLink(id, c.Request.URL.Path, expires)))
+		if valid {
+			valid, err = s.storage.UseDownloadLink(c.Request.Context(), id, time.Now())
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				c.Abort()
+				return
+			}
+		}
+		if !valid {
+			c.JSON(http.StatusForbidden, gin.H{"error": "download link is invalid, expired or used up"})
+			c.Abort()
+			return
+		}
+
+		c.Set("signed_link", true)
+		c.Set("token_name", "link:"+id)
+		c.Next()
+	}
+}
+
+// unlessSignedLink skips h for requests already admitted by signedLink
+func unlessSignedLink(h gin.HandlerFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.GetBool("signed_link") {
+			c.Next()
+			return
+		}
+		h(c)
+	}
+}
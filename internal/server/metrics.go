@@ -1,6 +1,12 @@
 package server
 
 import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/johann/ib/internal/backup"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
 )
@@ -9,9 +15,39 @@ import (
 type Metrics struct {
 	blocksTotal       prometheus.Gauge
 	manifestsTotal    prometheus.Gauge
+	manifestsTrashed  prometheus.Gauge
 	storageBytes      prometheus.Gauge
 	bandwidthUpload   prometheus.Counter
 	bandwidthDownload prometheus.Counter
+
+	// Per-route HTTP metrics
+	requestsTotal    *prometheus.CounterVec
+	requestDuration  *prometheus.HistogramVec
+	requestsInFlight prometheus.Gauge
+
+	// Client-reported backup runs
+	backupRunsTotal        *prometheus.CounterVec
+	backupRunDuration      prometheus.Histogram
+	backupRunUploadedBytes prometheus.Counter
+	backupRunDedupBytes    prometheus.Counter
+	backupRunErrorFiles    prometheus.Counter
+
+	// Background maintenance
+	pruneLastRun     prometheus.Gauge
+	pruneLastSuccess prometheus.Gauge
+	pruneFailures    prometheus.Counter
+	prunedManifests  prometheus.Counter
+	gcDuration       prometheus.Gauge
+	gcBlocks         prometheus.Counter
+	gcBytes          prometheus.Counter
+	gcS3Failures     prometheus.Counter
+	dagVerifications *prometheus.CounterVec
+	dagMissing       prometheus.Counter
+	configReloads    *prometheus.CounterVec
+
+	// Per name tag
+	lastBackup      *prometheus.GaugeVec
+	backupSnapshots *prometheus.GaugeVec
 }
 
 // NewMetrics creates and registers all metrics
@@ -25,6 +61,10 @@ func NewMetrics() *Metrics {
 			Name: "ib_manifests_total",
 			Help: "Total number of manifests stored",
 		}),
+		manifestsTrashed: promauto.NewGauge(prometheus.GaugeOpts{
+			Name: "ib_manifests_trashed",
+			Help: "Deleted manifests still in the trash",
+		}),
 		storageBytes: promauto.NewGauge(prometheus.GaugeOpts{
 			Name: "ib_storage_bytes",
 			Help: "Total storage used in bytes",
@@ -37,5 +77,171 @@ func NewMetrics() *Metrics {
 			Name: "ib_bandwidth_download_bytes_total",
 			Help: "Total bytes downloaded",
 		}),
+		requestsTotal: promauto.NewCounterVec(prometheus.CounterOpts{
+			Name: "ib_http_requests_total",
+			Help: "HTTP requests by route, method and status code",
+		}, []string{"route", "method", "code"}),
+		requestDuration: promauto.NewHistogramVec(prometheus.HistogramOpts{
+			Name: "ib_http_request_duration_seconds",
+			Help: "HTTP request latency by route and method",
+			// Block uploads and downloads of large archives take far longer
+			// than the default buckets cover
+			Buckets: []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60, 300},
+		}, []string{"route", "method"}),
+		requestsInFlight: promauto.NewGauge(prometheus.GaugeOpts{
+			Name: "ib_http_requests_in_flight",
+			Help: "HTTP requests currently being served",
+		}),
+		backupRunsTotal: promauto.NewCounterVec(prometheus.CounterOpts{
+			Name: "ib_backup_runs_total",
+			Help: "Backup runs reported by clients, by result (success or failed)",
+		}, []string{"result"}),
+		backupRunDuration: promauto.NewHistogram(prometheus.HistogramOpts{
+			Name:    "ib_backup_run_duration_seconds",
+			Help:    "Duration of client-reported backup runs",
+			Buckets: []float64{1, 10, 30, 60, 300, 600, 1800, 3600, 3 * 3600, 6 * 3600, 12 * 3600, 24 * 3600},
+		}),
+		backupRunUploadedBytes: promauto.NewCounter(prometheus.CounterOpts{
+			Name: "ib_backup_run_uploaded_bytes_total",
+			Help: "Bytes uploaded by client-reported backup runs",
+		}),
+		backupRunDedupBytes: promauto.NewCounter(prometheus.CounterOpts{
+			Name: "ib_backup_run_dedup_bytes_total",
+			Help: "Bytes client-reported backup runs didn't upload because the server had them",
+		}),
+		backupRunErrorFiles: promauto.NewCounter(prometheus.CounterOpts{
+			Name: "ib_backup_run_error_files_total",
+			Help: "Unreadable files left out of client-reported backup runs",
+		}),
+		pruneLastRun: promauto.NewGauge(prometheus.GaugeOpts{
+			Name: "ib_prune_last_run_timestamp_seconds",
+			Help: "Unix time the retention prune last ran",
+		}),
+		pruneLastSuccess: promauto.NewGauge(prometheus.GaugeOpts{
+			Name: "ib_prune_last_success_timestamp_seconds",
+			Help: "Unix time the retention prune last completed without errors",
+		}),
+		pruneFailures: promauto.NewCounter(prometheus.CounterOpts{
+			Name: "ib_prune_failures_total",
+			Help: "Retention prune runs that failed",
+		}),
+		prunedManifests: promauto.NewCounter(prometheus.CounterOpts{
+			Name: "ib_pruned_manifests_total",
+			Help: "Manifests removed by the retention prune",
+		}),
+		gcDuration: promauto.NewGauge(prometheus.GaugeOpts{
+			Name: "ib_gc_duration_seconds",
+			Help: "Duration of the last prune and orphaned block collection",
+		}),
+		gcBlocks: promauto.NewCounter(prometheus.CounterOpts{
+			Name: "ib_gc_blocks_reclaimed_total",
+			Help: "Orphaned blocks removed by garbage collection",
+		}),
+		gcBytes: promauto.NewCounter(prometheus.CounterOpts{
+			Name: "ib_gc_bytes_reclaimed_total",
+			Help: "Stored bytes of orphaned blocks removed by garbage collection",
+		}),
+		gcS3Failures: promauto.NewCounter(prometheus.CounterOpts{
+			Name: "ib_gc_s3_delete_failures_total",
+			Help: "Orphaned S3 objects garbage collection failed to delete",
+		}),
+		dagVerifications: promauto.NewCounterVec(prometheus.CounterOpts{
+			Name: "ib_dag_verifications_total",
+			Help: "Manifest DAG verifications by result (complete, incomplete or error)",
+		}, []string{"result"}),
+		dagMissing: promauto.NewCounter(prometheus.CounterOpts{
+			Name: "ib_dag_verify_missing_total",
+			Help: "Missing blocks and nodes found by DAG verification",
+		}),
+		configReloads: promauto.NewCounterVec(prometheus.CounterOpts{
+			Name: "ib_config_reloads_total",
+			Help: "Configuration reloads by result (success or error)",
+		}, []string{"result"}),
+		lastBackup: promauto.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "ib_last_backup_timestamp",
+			Help: "Unix time of the newest manifest per name tag",
+		}, []string{"name"}),
+		backupSnapshots: promauto.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "ib_backup_snapshots",
+			Help: "Stored manifests per name tag",
+		}, []string{"name"}),
+	}
+}
+
+// observeRun records a client-reported backup run
+func (m *Metrics) observeRun(r *backup.RunSummary) {
+	result := "success"
+	if r.Error != "" {
+		result = "failed"
+	}
+	m.backupRunsTotal.WithLabelValues(result).Inc()
+	m.backupRunDuration.Observe(float64(r.DurationMs) / 1000)
+	m.backupRunUploadedBytes.Add(float64(r.UploadedBytes))
+	m.backupRunDedupBytes.Add(float64(r.DedupBytes))
+	m.backupRunErrorFiles.Add(float64(r.ErrorFiles))
+}
+
+// instrument records request counts, latency and in-flight requests. Routes
+// are labeled with their pattern (e.g. /api/manifests/:id) so label
+// cardinality stays bounded; unmatched paths share one label.
+func (m *Metrics) instrument() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		m.requestsInFlight.Inc()
+		defer m.requestsInFlight.Dec()
+
+		c.Next()
+
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		m.requestsTotal.WithLabelValues(route, c.Request.Method, strconv.Itoa(c.Writer.Status())).Inc()
+		m.requestDuration.WithLabelValues(route, c.Request.Method).Observe(time.Since(start).Seconds())
+	}
+}
+
+// metricsRefreshInterval is how often gauges are recounted from the
+// database, correcting drift from the per-request deltas
+const metricsRefreshInterval = 5 * time.Minute
+
+// refreshMetrics sets the storage gauges from the database
+func (s *Server) refreshMetrics(ctx context.Context) {
+	st, err := s.storage.Stats(ctx)
+	if err != nil {
+		logger.Warn("failed to refresh metrics", "error", err)
+		return
+	}
+	s.metrics.blocksTotal.Set(float64(st.Blocks))
+	s.metrics.storageBytes.Set(float64(st.Bytes))
+	s.metrics.manifestsTotal.Set(float64(st.Manifests))
+	s.metrics.manifestsTrashed.Set(float64(st.Trashed))
+	s.refreshBackupMetrics(ctx)
+}
+
+// refreshBackupMetrics sets the per-name freshness and snapshot gauges.
+// Names whose manifests are all gone are dropped.
+func (s *Server) refreshBackupMetrics(ctx context.Context) {
+	names, err := s.storage.BackupNames(ctx)
+	if err != nil {
+		logger.Warn("failed to refresh backup metrics", "error", err)
+		return
+	}
+	s.metrics.lastBackup.Reset()
+	s.metrics.backupSnapshots.Reset()
+	for _, n := range names {
+		s.metrics.lastBackup.WithLabelValues(n.Name).Set(float64(n.Latest.Unix()))
+		s.metrics.backupSnapshots.WithLabelValues(n.Name).Set(float64(n.Manifests))
+	}
+}
+
+// runMetricsRefresher keeps the storage gauges in line with the database
+func (s *Server) runMetricsRefresher() {
+	ticker := time.NewTicker(metricsRefreshInterval)
+	defer ticker.Stop()
+
+	s.refreshMetrics(context.Background())
+	for range ticker.C {
+		s.refreshMetrics(context.Background())
 	}
 }
@@ -0,0 +1,105 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/johann/ib/internal/backup"
+	"github.com/johann/ib/internal/config"
+)
+
+// parseNamespaceQuotas parses namespace_quotas into bytes per namespace
+func parseNamespaceQuotas(m map[string]string) (map[string]int64, error) {
+	result := make(map[string]int64, len(m))
+	for namespace, v := range m {
+		quota, err := config.ParseSize(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid namespace_quotas for %q: %q", namespace, v)
+		}
+		result[namespace] = quota
+	}
+	return result, nil
+}
+
+// namespaceQuota returns the storage quota of a namespace, 0 for none
+func (s *Server) namespaceQuota(namespace string) int64 {
+	if namespace == "" {
+		return 0
+	}
+	return s.settings().quotas[namespace]
+}
+
+// requireNamespace answers 404 for manifests outside the namespace of the
+// request's token, on routes with a manifest :id, :other or :manifest_id,
+// as if they didn't exist. Must run after authMiddleware; requests without
+// a token aren't limited.
+func (s *Server) requireNamespace() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		namespace := c.GetString("token_namespace")
+		if namespace == "" {
+			c.Next()
+			return
+		}
+
+		for _, id := range manifestParams(c) {
+			ok, err := s.manifestInNamespace(c, id, namespace)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				c.Abort()
+				return
+			}
+			if !ok {
+				c.JSON(http.StatusNotFound, gin.H{"error": "manifest not found: " + id})
+				c.Abort()
+				return
+			}
+		}
+		c.Next()
+	}
+}
+
+// manifestParams returns the manifest IDs in a request's route parameters
+func manifestParams(c *gin.Context) []string {
+	var ids []string
+	for _, key := range []string{"id", "other"} {
+		if id := c.Param(key); id != "" {
+			ids = append(ids, id)
+		}
+	}
+	if id := c.Param("manifest_id"); id != "" {
+		ids = append(ids, downloadManifestID(id))
+	}
+	return ids
+}
+
+// downloadManifestID strips the archive extension from the manifest ID of
+// a download path
+func downloadManifestID(id string) string {
+	return strings.TrimSuffix(strings.TrimSuffix(id, ".zip"), ".tar.gz")
+}
+
+// manifestInNamespace reports whether manifest id exists and its name tag
+// belongs to namespace
+func (s *Server) manifestInNamespace(c *gin.Context, id, namespace string) (bool, error) {
+	name, err := s.storage.ManifestName(c.Request.Context(), id)
+	if err != nil {
+		if strings.Contains(err.Error(), "not found") {
+			return false, nil
+		}
+		return false, err
+	}
+	return backup.InNamespace(name, namespace), nil
+}
+
+// checkNamespaceName rejects writes of backups named outside the namespace
+// of the request's token, returning false if it did
+func checkNamespaceName(c *gin.Context, name string) bool {
+	namespace := c.GetString("token_namespace")
+	if backup.InNamespace(name, namespace) {
+		return true
+	}
+	c.JSON(http.StatusForbidden, gin.H{"error": fmt.Sprintf("token is limited to namespace %q, backup name %q is outside it", namespace, name)})
+	return false
+}
@@ -0,0 +1,92 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/johann/ib/internal/notify"
+	"github.com/johann/ib/internal/storage"
+)
+
+// missedBackupCheckInterval is how often backup freshness is checked
+const missedBackupCheckInterval = 15 * time.Minute
+
+// parseMissedBackupAfter parses the per-name missed backup thresholds
+func parseMissedBackupAfter(m map[string]string) (map[string]time.Duration, error) {
+	result := make(map[string]time.Duration, len(m))
+	for name, v := range m {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid missed_backup_after for %q: %q", name, v)
+		}
+		result[name] = d
+	}
+	return result, nil
+}
+
+// runMissedBackupChecker notifies once when a name goes longer than its
+// threshold without a new manifest, and again only after it recovered
+func (s *Server) runMissedBackupChecker() {
+	ticker := time.NewTicker(missedBackupCheckInterval)
+	defer ticker.Stop()
+
+	missed := make(map[string]bool)
+	for range ticker.C {
+		missedAfter := s.settings().missedAfter
+		if len(missedAfter) == 0 {
+			continue
+		}
+		names, err := s.storage.BackupNames(context.Background())
+		if err != nil {
+			logger.Warn("failed to check for missed backups", "error", err)
+			continue
+		}
+		for _, n := range names {
+			threshold, ok := missedAfter[n.Name]
+			if !ok {
+				threshold, ok = missedAfter["*"]
+			}
+			if !ok {
+				continue
+			}
+
+			age := time.Since(n.Latest)
+			if age <= threshold {
+				delete(missed, n.Name)
+				continue
+			}
+			if missed[n.Name] {
+				continue
+			}
+			missed[n.Name] = true
+			s.settings().notifier.Notify(notify.Event{
+				Type:    notify.MissedBackup,
+				Name:    n.Name,
+				Title:   fmt.Sprintf("Backup %s is overdue", n.Name),
+				Message: fmt.Sprintf("The last backup of %s was %s ago, at %s (expected at least every %s).", n.Name, age.Round(time.Minute), n.Latest.UTC().Format(time.RFC3339), threshold),
+				Failure: true,
+			})
+		}
+	}
+}
+
+// notifyPrune reports the outcome of a retention prune
+func (s *Server) notifyPrune(result *storage.PruneResult, errs []string) {
+	ev := notify.Event{
+		Type:  notify.Prune,
+		Title: "Prune completed",
+		Message: fmt.Sprintf("Removed %d manifests, %d blocks (%d bytes) and %d DAG nodes.",
+			result.Manifests, result.Blocks, result.Bytes, result.Nodes),
+	}
+	if result.S3DeleteErrors > 0 {
+		ev.Message += fmt.Sprintf("\n%d S3 objects could not be deleted.", result.S3DeleteErrors)
+	}
+	if len(errs) > 0 {
+		ev.Title = "Prune failed"
+		ev.Message += "\n" + strings.Join(errs, "\n")
+		ev.Failure = true
+	}
+	s.settings().notifier.Notify(ev)
+}
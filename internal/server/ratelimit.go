@@ -1,27 +1,61 @@
 package server
 
 import (
-	"log"
+	"context"
 	"net"
+	"net/http"
+	"sort"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/johann/ib/internal/logging"
+	"github.com/johann/ib/internal/storage"
 )
 
-// RateLimiter tracks failed authentication attempts and blocks IPs
+// Auth failures and the audit trail get their own components so they can
+// be routed separately
+var (
+	authLogger  = logging.For("auth")
+	auditLogger = logging.For("audit")
+)
+
+// failureWindow is how long failures are remembered; an IP or token that
+// stays quiet this long starts over at the shortest block
+const failureWindow = 24 * time.Hour
+
+// RateLimiter tracks failed authentication attempts per IP and per named
+// token. Each failure blocks for twice as long as the previous one, up to
+// maxBlock; after banAfter failures the key stays blocked until cleared.
+// Blocks are persisted so they survive restarts.
 type RateLimiter struct {
 	mu          sync.RWMutex
-	blockedIPs  map[string]time.Time
+	blocks      map[string]*storage.AuthBlock
+	store       *storage.Storage // nil keeps blocks in memory only
 	blockPeriod time.Duration
+	maxBlock    time.Duration
+	banAfter    int // 0 disables bans
 }
 
-// NewRateLimiter creates a new rate limiter
-func NewRateLimiter(blockPeriod time.Duration) *RateLimiter {
+// NewRateLimiter creates a rate limiter, loading existing blocks from store
+func NewRateLimiter(store *storage.Storage, blockPeriod, maxBlock time.Duration, banAfter int) *RateLimiter {
 	rl := &RateLimiter{
-		blockedIPs:  make(map[string]time.Time),
+		blocks:      make(map[string]*storage.AuthBlock),
+		store:       store,
 		blockPeriod: blockPeriod,
+		maxBlock:    maxBlock,
+		banAfter:    banAfter,
+	}
+
+	if store != nil {
+		blocks, err := store.ListAuthBlocks(context.Background())
+		if err != nil {
+			logger.Warn("failed to load auth blocks", "error", err)
+		}
+		for i := range blocks {
+			rl.blocks[blocks[i].Key] = &blocks[i]
+		}
 	}
 
 	// Start cleanup goroutine
@@ -30,28 +64,125 @@ func NewRateLimiter(blockPeriod time.Duration) *RateLimiter {
 	return rl
 }
 
-// IsBlocked checks if an IP is currently blocked
-func (rl *RateLimiter) IsBlocked(ip string) bool {
+// IsBlocked checks if an IP or token key is currently blocked
+func (rl *RateLimiter) IsBlocked(key string) bool {
 	rl.mu.RLock()
 	defer rl.mu.RUnlock()
 
-	blockedUntil, exists := rl.blockedIPs[ip]
+	b, exists := rl.blocks[key]
 	if !exists {
 		return false
 	}
 
-	return time.Now().Before(blockedUntil)
+	return b.Banned || time.Now().Before(b.BlockedUntil)
+}
+
+// RecordFailure counts a failed attempt and blocks the key, doubling the
+// block period with each failure. Returns whether the key is now banned.
+func (rl *RateLimiter) RecordFailure(key string) bool {
+	rl.mu.Lock()
+	now := time.Now()
+	b, exists := rl.blocks[key]
+	if !exists || now.Sub(b.LastFailureAt) > failureWindow {
+		b = &storage.AuthBlock{Key: key}
+		rl.blocks[key] = b
+	}
+
+	b.Failures++
+	b.LastFailureAt = now
+	b.BlockedUntil = now.Add(rl.blockDuration(b.Failures))
+	if rl.banAfter > 0 && b.Failures >= rl.banAfter {
+		b.Banned = true
+	}
+	saved := *b
+	rl.mu.Unlock()
+
+	rl.persist(&saved)
+	return saved.Banned
 }
 
-// BlockIP blocks an IP for the configured period
-func (rl *RateLimiter) BlockIP(ip string) {
+// RecordSuccess forgets earlier failures of a key that authenticated
+func (rl *RateLimiter) RecordSuccess(key string) {
+	rl.mu.Lock()
+	_, exists := rl.blocks[key]
+	delete(rl.blocks, key)
+	rl.mu.Unlock()
+
+	if exists {
+		rl.remove(key)
+	}
+}
+
+// List returns all tracked keys
+func (rl *RateLimiter) List() []storage.AuthBlock {
+	rl.mu.RLock()
+	defer rl.mu.RUnlock()
+
+	result := make([]storage.AuthBlock, 0, len(rl.blocks))
+	for _, b := range rl.blocks {
+		result = append(result, *b)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].LastFailureAt.After(result[j].LastFailureAt)
+	})
+	return result
+}
+
+// Clear removes a block, including a ban. Returns false if the key was
+// not tracked.
+func (rl *RateLimiter) Clear(key string) bool {
+	rl.mu.Lock()
+	_, exists := rl.blocks[key]
+	delete(rl.blocks, key)
+	rl.mu.Unlock()
+
+	if exists {
+		rl.remove(key)
+	}
+	return exists
+}
+
+// setLimits changes the block durations and ban threshold for future
+// failures; existing blocks keep their expiry
+func (rl *RateLimiter) setLimits(blockPeriod, maxBlock time.Duration, banAfter int) {
 	rl.mu.Lock()
 	defer rl.mu.Unlock()
+	rl.blockPeriod = blockPeriod
+	rl.maxBlock = maxBlock
+	rl.banAfter = banAfter
+}
 
-	rl.blockedIPs[ip] = time.Now().Add(rl.blockPeriod)
+// blockDuration returns blockPeriod * 2^(failures-1), capped at maxBlock
+func (rl *RateLimiter) blockDuration(failures int) time.Duration {
+	d := rl.blockPeriod
+	for i := 1; i < failures && d < rl.maxBlock; i++ {
+		d *= 2
+	}
+	if d > rl.maxBlock {
+		d = rl.maxBlock
+	}
+	return d
+}
+
+func (rl *RateLimiter) persist(b *storage.AuthBlock) {
+	if rl.store == nil {
+		return
+	}
+	if err := rl.store.SaveAuthBlock(context.Background(), b); err != nil {
+		logger.Warn("failed to save auth block", "key", b.Key, "error", err)
+	}
+}
+
+func (rl *RateLimiter) remove(key string) {
+	if rl.store == nil {
+		return
+	}
+	if err := rl.store.DeleteAuthBlock(context.Background(), key); err != nil {
+		logger.Warn("failed to delete auth block", "key", key, "error", err)
+	}
 }
 
-// cleanup periodically removes expired blocks
+// cleanup periodically forgets keys whose failures have aged out
 func (rl *RateLimiter) cleanup() {
 	ticker := time.NewTicker(time.Minute)
 	defer ticker.Stop()
@@ -59,15 +190,42 @@ func (rl *RateLimiter) cleanup() {
 	for range ticker.C {
 		rl.mu.Lock()
 		now := time.Now()
-		for ip, blockedUntil := range rl.blockedIPs {
-			if now.After(blockedUntil) {
-				delete(rl.blockedIPs, ip)
+		var expired []string
+		for key, b := range rl.blocks {
+			if !b.Banned && now.After(b.BlockedUntil) && now.Sub(b.LastFailureAt) > failureWindow {
+				delete(rl.blocks, key)
+				expired = append(expired, key)
 			}
 		}
 		rl.mu.Unlock()
+
+		for _, key := range expired {
+			rl.remove(key)
+		}
 	}
 }
 
+// handleListAuthBlocks lists IPs and tokens with recent failed attempts
+func (s *Server) handleListAuthBlocks(c *gin.Context) {
+	c.JSON(http.StatusOK, s.rateLimiter.List())
+}
+
+// tokenBlockKey is the key failed attempts at a named token's secret from
+// an IP are tracked under
+func tokenBlockKey(id, ip string) string {
+	return "token:" + id + "@" + ip
+}
+
+// handleClearAuthBlock lifts a block or ban on an IP or "token:<id>@<ip>"
+func (s *Server) handleClearAuthBlock(c *gin.Context) {
+	key := c.Param("key")
+	if !s.rateLimiter.Clear(key) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "no block for " + key})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"cleared": key})
+}
+
 // GetRealIP extracts the real client IP from a request, handling proxies and Cloudflare
 func GetRealIP(c *gin.Context) string {
 	// Priority order for IP detection:
@@ -135,9 +293,10 @@ func parseIP(ipStr string) string {
 
 // LogFailedAuth logs a failed authentication attempt
 func LogFailedAuth(ip, reason string, blocked bool) {
-	status := "FAILED"
-	if blocked {
-		status = "BLOCKED"
-	}
-	log.Printf("[AUTH %s] ip=%s reason=%q", status, ip, reason)
+	authLogger.Warn("authentication failed", "ip", ip, "reason", reason, "blocked", blocked)
+}
+
+// LogAuthenticatedRequest logs a state-changing request with the token that made it
+func LogAuthenticatedRequest(ip, tokenName, method, path string, status int) {
+	auditLogger.Info("request", "ip", ip, "token", tokenName, "method", method, "path", path, "status", status)
 }
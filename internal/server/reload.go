@@ -0,0 +1,126 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/johann/ib/internal/backup"
+	"github.com/johann/ib/internal/config"
+	"github.com/johann/ib/internal/logging"
+	"github.com/johann/ib/internal/notify"
+)
+
+// liveSettings are the settings Reload replaces. Handlers load them once
+// per use, so a reload never changes them halfway through a request.
+type liveSettings struct {
+	retentionDays int
+	policies      map[string]backup.RetentionPolicy // Configured retention policies per name tag
+	trashPeriod   time.Duration
+	notifier      *notify.Dispatcher
+	missedAfter   map[string]time.Duration // Missed backup thresholds per name tag
+	quotas        map[string]int64         // Storage quota per namespace
+}
+
+func newLiveSettings(cfg *config.ServerConfig) (*liveSettings, error) {
+	notifier, err := notify.New(cfg.Notifications)
+	if err != nil {
+		return nil, err
+	}
+	missedAfter, err := parseMissedBackupAfter(cfg.MissedBackupAfter)
+	if err != nil {
+		return nil, err
+	}
+	quotas, err := parseNamespaceQuotas(cfg.NamespaceQuotas)
+	if err != nil {
+		return nil, err
+	}
+	policies, err := parseRetentionPolicies(cfg.RetentionPolicies)
+	if err != nil {
+		return nil, err
+	}
+	return &liveSettings{
+		retentionDays: cfg.RetentionDays,
+		policies:      policies,
+		trashPeriod:   cfg.TrashPeriod(),
+		notifier:      notifier,
+		missedAfter:   missedAfter,
+		quotas:        quotas,
+	}, nil
+}
+
+// settings returns the current reloadable settings
+func (s *Server) settings() *liveSettings {
+	return s.live.Load()
+}
+
+// Reload re-reads the configuration and applies retention periods and
+// policies, the trash period, tokens, auth rate limits, allowlists, notifications, namespace
+// quotas and the log level. Nothing is applied if any of it is invalid. Other settings need a
+// restart.
+func (s *Server) Reload() error {
+	err := s.reload()
+	if err != nil {
+		s.metrics.configReloads.WithLabelValues("error").Inc()
+		return err
+	}
+	s.metrics.configReloads.WithLabelValues("success").Inc()
+	return nil
+}
+
+func (s *Server) reload() error {
+	cfg, err := config.LoadServer()
+	if err != nil {
+		return err
+	}
+	if err := cfg.Validate(); err != nil {
+		return err
+	}
+	live, err := newLiveSettings(cfg)
+	if err != nil {
+		return err
+	}
+	lists, err := newAllowlists(cfg)
+	if err != nil {
+		return err
+	}
+	if err := logging.SetLevel(cfg.LogLevel); err != nil {
+		return err
+	}
+
+	s.live.Store(live)
+	s.allowlists.update(lists)
+	s.rateLimiter.setLimits(cfg.AuthBlockDuration(), cfg.AuthMaxBlockDuration(), cfg.AuthBanAfter)
+	s.tokens.update(cfg)
+
+	logger.Info("configuration reloaded",
+		"retention_days", cfg.RetentionDays,
+		"trash_period", cfg.TrashPeriod(),
+		"notification_channels", len(cfg.Notifications),
+		"log_level", logging.Level())
+	return nil
+}
+
+// reloadOnSignal reloads the configuration on every SIGHUP
+func (s *Server) reloadOnSignal() {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGHUP)
+	for range ch {
+		if err := s.Reload(); err != nil {
+			logger.Error("failed to reload configuration", "error", err)
+		}
+	}
+}
+
+func (s *Server) handleReloadConfig(c *gin.Context) {
+	logger.Info("configuration reload requested", "token", c.GetString("token_name"))
+	if err := s.Reload(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("reload failed, keeping the current configuration: %v", err)})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "reloaded"})
+}
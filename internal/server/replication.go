@@ -0,0 +1,163 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/johann/ib/internal/backup"
+	"github.com/johann/ib/internal/client"
+	"github.com/johann/ib/internal/config"
+	"github.com/johann/ib/internal/ipfsnode"
+	"github.com/johann/ib/internal/secrets"
+)
+
+// replicationWorkers is the number of blocks fetched concurrently over bitswap
+const replicationWorkers = 8
+
+// runReplicator periodically mirrors new manifests from the primary server.
+// Manifest metadata is read from the primary's HTTP API, blocks are pulled
+// from its IPFS node over bitswap.
+func (s *Server) runReplicator() {
+	interval := time.Duration(s.config.ReplicaInterval) * time.Second
+	if interval <= 0 {
+		interval = 5 * time.Minute
+	}
+
+	logger.Info("replicating", "primary", s.config.ReplicaOf, "peer", s.config.ReplicaPeer, "interval", interval)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := s.replicateOnce(); err != nil {
+			logger.Error("replication failed", "error", err)
+		}
+		<-ticker.C
+	}
+}
+
+// replicateOnce runs one replication pass. The token is resolved each time
+// so it can be rotated in a secret store.
+func (s *Server) replicateOnce() error {
+	ctx := context.Background()
+	token, _, err := secrets.Resolve(ctx, s.config.ReplicaToken)
+	if err != nil {
+		return err
+	}
+	primary, err := client.New(&config.ClientConfig{
+		ServerURL: s.config.ReplicaOf,
+		Token:     token,
+	})
+	if err != nil {
+		return err
+	}
+	return s.replicate(ctx, primary)
+}
+
+// replicate copies all manifests missing locally from the primary
+func (s *Server) replicate(ctx context.Context, primary *client.Client) error {
+	node := s.ipfs()
+	if node == nil {
+		return fmt.Errorf("IPFS node is not running")
+	}
+	if err := node.Connect(ctx, s.config.ReplicaPeer); err != nil {
+		return fmt.Errorf("failed to connect to primary peer: %w", err)
+	}
+
+	manifests, err := primary.ListManifests(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to list primary manifests: %w", err)
+	}
+
+	// Oldest first, so incremental chains arrive in order
+	for i := len(manifests) - 1; i >= 0; i-- {
+		info := manifests[i]
+
+		if _, err := s.storage.GetManifest(ctx, info.ID); err == nil {
+			continue
+		} else if !strings.Contains(err.Error(), "not found") {
+			return err
+		}
+
+		manifest, err := primary.GetManifest(ctx, info.ID)
+		if err != nil {
+			return fmt.Errorf("failed to fetch manifest %s: %w", info.ID, err)
+		}
+
+		fetched, err := s.fetchManifestBlocks(ctx, node, manifest)
+		if err != nil {
+			return fmt.Errorf("failed to replicate blocks for %s: %w", info.ID, err)
+		}
+
+		if err := s.storeManifest(ctx, manifest); err != nil {
+			return fmt.Errorf("failed to store manifest %s: %w", info.ID, err)
+		}
+
+		logger.Info("replicated manifest", "manifest", manifest.ID, "new_blocks", fetched)
+	}
+
+	return nil
+}
+
+// fetchManifestBlocks pulls every block referenced by the manifest that is
+// not yet stored locally. Returns the number of blocks fetched.
+func (s *Server) fetchManifestBlocks(ctx context.Context, node *ipfsnode.Node, manifest *backup.Manifest) (int, error) {
+	var missing []cid.Cid
+
+	for _, cidStr := range manifest.BlockCIDs() {
+		exists, err := s.storage.BlockExists(ctx, cidStr)
+		if err != nil {
+			return 0, err
+		}
+		if exists {
+			continue
+		}
+
+		c, err := cid.Decode(cidStr)
+		if err != nil {
+			return 0, fmt.Errorf("invalid block CID %s: %w", cidStr, err)
+		}
+		missing = append(missing, c)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	var firstErr error
+	var errOnce sync.Once
+	work := make(chan cid.Cid)
+
+	for i := 0; i < replicationWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for c := range work {
+				if err := node.FetchBlock(ctx, c); err != nil {
+					errOnce.Do(func() {
+						firstErr = fmt.Errorf("fetching block %s: %w", c, err)
+						cancel()
+					})
+				}
+			}
+		}()
+	}
+
+	for _, c := range missing {
+		select {
+		case work <- c:
+		case <-ctx.Done():
+		}
+	}
+	close(work)
+	wg.Wait()
+
+	if firstErr != nil {
+		return 0, firstErr
+	}
+	return len(missing), nil
+}
@@ -0,0 +1,131 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/johann/ib/internal/backup"
+	"github.com/johann/ib/internal/config"
+	"github.com/johann/ib/internal/storage"
+)
+
+// retentionPolicy is a retention policy as listed by GET /api/retention
+type retentionPolicy struct {
+	Name string `json:"name"`
+	backup.RetentionPolicy
+	Source string `json:"source"` // "config" or "api"
+}
+
+// parseRetentionPolicies parses retention_policies by name tag
+func parseRetentionPolicies(m map[string]string) (map[string]backup.RetentionPolicy, error) {
+	result := make(map[string]backup.RetentionPolicy, len(m))
+	for name, v := range m {
+		p, err := backup.ParseRetentionPolicy(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid retention_policies for %q: %w", name, err)
+		}
+		result[name] = p
+	}
+	return result, nil
+}
+
+// RetentionPolicies returns the retention policies the pruner applies: the
+// configured ones, overridden by those set through the API
+func RetentionPolicies(ctx context.Context, store *storage.Storage, cfg *config.ServerConfig) (map[string]backup.RetentionPolicy, error) {
+	configured, err := parseRetentionPolicies(cfg.RetentionPolicies)
+	if err != nil {
+		return nil, err
+	}
+	return mergeRetentionPolicies(ctx, store, configured)
+}
+
+func mergeRetentionPolicies(ctx context.Context, store *storage.Storage, configured map[string]backup.RetentionPolicy) (map[string]backup.RetentionPolicy, error) {
+	stored, err := store.RetentionPolicies(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load retention policies: %w", err)
+	}
+	policies := maps.Clone(configured)
+	if policies == nil {
+		policies = make(map[string]backup.RetentionPolicy, len(stored))
+	}
+	maps.Copy(policies, stored)
+	return policies, nil
+}
+
+// handleListRetention lists the retention policies by name, and the
+// retention period that applies to names without one
+func (s *Server) handleListRetention(c *gin.Context) {
+	stored, err := s.storage.RetentionPolicies(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	settings := s.settings()
+	policies := make([]retentionPolicy, 0, len(settings.policies)+len(stored))
+	for name, p := range settings.policies {
+		if _, ok := stored[name]; !ok {
+			policies = append(policies, retentionPolicy{Name: name, RetentionPolicy: p, Source: "config"})
+		}
+	}
+	for name, p := range stored {
+		policies = append(policies, retentionPolicy{Name: name, RetentionPolicy: p, Source: "api"})
+	}
+	slices.SortFunc(policies, func(a, b retentionPolicy) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+
+	c.JSON(http.StatusOK, gin.H{
+		"retention_days": settings.retentionDays,
+		"policies":       policies,
+	})
+}
+
+// handleSetRetention sets the retention policy of the backup name in the
+// path, "*" for all names without their own. An empty policy keeps every
+// backup of the name.
+func (s *Server) handleSetRetention(c *gin.Context) {
+	name := strings.TrimPrefix(c.Param("name"), "/")
+	if name == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "backup name is required"})
+		return
+	}
+	var policy backup.RetentionPolicy
+	if err := c.ShouldBindJSON(&policy); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request: " + err.Error()})
+		return
+	}
+	if policy.Last < 0 || policy.Daily < 0 || policy.Weekly < 0 || policy.Monthly < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "keep counts must not be negative"})
+		return
+	}
+
+	if err := s.storage.SetRetentionPolicy(c.Request.Context(), name, policy); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	logger.Info("retention policy set", "name", name, "policy", policy.String(), "token", c.GetString("token_name"))
+	c.JSON(http.StatusOK, retentionPolicy{Name: name, RetentionPolicy: policy, Source: "api"})
+}
+
+// handleDeleteRetention removes the retention policy set through the API
+// for the backup name in the path. A configured policy for the name
+// applies again.
+func (s *Server) handleDeleteRetention(c *gin.Context) {
+	name := strings.TrimPrefix(c.Param("name"), "/")
+	if err := s.storage.DeleteRetentionPolicy(c.Request.Context(), name); err != nil {
+		if strings.Contains(err.Error(), "not found") {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	logger.Info("retention policy removed", "name", name, "token", c.GetString("token_name"))
+	c.JSON(http.StatusOK, gin.H{"deleted": name})
+}
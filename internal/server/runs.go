@@ -0,0 +1,108 @@
+package server
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/johann/ib/internal/backup"
+	"github.com/johann/ib/internal/notify"
+	"github.com/johann/ib/internal/storage"
+)
+
+// Limits for GET /api/runs
+const (
+	defaultRunsLimit = 100
+	maxRunsLimit     = 1000
+)
+
+// handleReportRun stores the summary a client sends at the end of a backup
+func (s *Server) handleReportRun(c *gin.Context) {
+	var summary backup.RunSummary
+	if err := c.ShouldBindJSON(&summary); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid run summary"})
+		return
+	}
+	if summary.DurationMs < 0 || summary.UploadedBytes < 0 || summary.DedupBytes < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "run summary values must not be negative"})
+		return
+	}
+	if !checkNamespaceName(c, summary.Tags["name"]) {
+		return
+	}
+
+	ctx := c.Request.Context()
+	if summary.ManifestID != "" {
+		// Manifests outside the token's namespace look like missing ones
+		ok, err := s.manifestInNamespace(c, summary.ManifestID, c.GetString("token_namespace"))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if !ok {
+			c.JSON(http.StatusNotFound, gin.H{"error": "manifest not found"})
+			return
+		}
+	}
+
+	run := &storage.BackupRun{
+		RunSummary: summary,
+		ReportedBy: c.GetString("token_name"),
+		ReportedAt: time.Now(),
+	}
+	if err := s.storage.SaveBackupRun(ctx, run); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	s.metrics.observeRun(&run.RunSummary)
+	if summary.Error != "" {
+		name := summary.Tags["name"]
+		s.settings().notifier.Notify(notify.Event{
+			Type:    notify.BackupFailed,
+			Name:    name,
+			Title:   fmt.Sprintf("Backup %s failed", name),
+			Message: fmt.Sprintf("Reported by %s after %s: %s", run.ReportedBy, (time.Duration(summary.DurationMs) * time.Millisecond).Round(time.Second), summary.Error),
+			Failure: true,
+		})
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"id": run.ID})
+}
+
+// handleGetManifestRun returns the run that produced a manifest
+func (s *Server) handleGetManifestRun(c *gin.Context) {
+	run, err := s.storage.GetManifestRun(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		if strings.Contains(err.Error(), "not found") {
+			c.JSON(http.StatusNotFound, gin.H{"error": "no run reported for this manifest"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, run)
+}
+
+// handleListRuns lists recent runs, newest first, filtered by tag.* query
+// parameters like the manifest list
+func (s *Server) handleListRuns(c *gin.Context) {
+	limit := defaultRunsLimit
+	if v := c.Query("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "limit must be a positive number"})
+			return
+		}
+		limit = min(n, maxRunsLimit)
+	}
+
+	runs, err := s.storage.ListBackupRuns(c.Request.Context(), extractTags(c), c.GetString("token_namespace"), limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, runs)
+}
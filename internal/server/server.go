@@ -3,20 +3,24 @@ package server
 import (
 	"context"
 	"embed"
-	"encoding/json"
 	"fmt"
 	"net/http"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/ipfs/go-cid"
-	"github.com/johann/ib/internal/backup"
+	"github.com/johann/ib/internal/auth"
 	"github.com/johann/ib/internal/config"
 	"github.com/johann/ib/internal/ipfsnode"
+	"github.com/johann/ib/internal/logging"
 	"github.com/johann/ib/internal/storage"
+	"github.com/johann/ib/internal/version"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
+var logger = logging.For("server")
+
 // Embed placeholders - these will be populated by the cmd/server build
 var (
 	frontendFS     embed.FS
@@ -29,6 +33,14 @@ func SetEmbeddedFiles(frontend, clients embed.FS) {
 	clientBinaries = clients
 }
 
+// buildVersion is reported by GET /api/version
+var buildVersion = "dev"
+
+// SetVersion sets the build version (called from cmd/server)
+func SetVersion(v string) {
+	buildVersion = v
+}
+
 // Server represents the backup server
 type Server struct {
 	config      *config.ServerConfig
@@ -37,8 +49,20 @@ type Server struct {
 	metricsPort int
 	metrics     *Metrics
 	title       string
-	ipfsNode    *ipfsnode.Node
 	rateLimiter *RateLimiter
+	tokens      *tokenSet
+	allowlists  *allowlists
+	linkKey     []byte     // Signs download links
+	trees       *treeCache // Directory indexes for the web UI
+	events      *eventHub  // Live events for GET /api/events
+	started     time.Time
+
+	// Settings that can change at runtime, see Reload
+	live atomic.Pointer[liveSettings]
+
+	// The IPFS node can be started and stopped at runtime
+	ipfsMu   sync.RWMutex
+	ipfsNode *ipfsnode.Node
 }
 
 // New creates a new server instance
@@ -48,9 +72,27 @@ func New(cfg *config.ServerConfig, metricsPort int, title string) (*Server, erro
 		return nil, fmt.Errorf("failed to initialize storage: %w", err)
 	}
 
+	lists, err := newAllowlists(cfg)
+	if err != nil {
+		store.Close()
+		return nil, err
+	}
+
+	linkKey, err := loadLinkKey(store)
+	if err != nil {
+		store.Close()
+		return nil, err
+	}
+
+	live, err := newLiveSettings(cfg)
+	if err != nil {
+		store.Close()
+		return nil, err
+	}
+
 	gin.SetMode(gin.ReleaseMode)
 	router := gin.New()
-	router.Use(gin.Recovery())
+	router.Use(recoverPanics(), traceRequests(), reportServerErrors())
 
 	s := &Server{
 		config:      cfg,
@@ -59,42 +101,28 @@ func New(cfg *config.ServerConfig, metricsPort int, title string) (*Server, erro
 		metricsPort: metricsPort,
 		metrics:     NewMetrics(),
 		title:       title,
-		rateLimiter: NewRateLimiter(15 * time.Second),
+		rateLimiter: NewRateLimiter(store, cfg.AuthBlockDuration(), cfg.AuthMaxBlockDuration(), cfg.AuthBanAfter),
+		tokens:      newTokenSet(cfg),
+		allowlists:  lists,
+		linkKey:     linkKey,
+		trees:       newTreeCache(),
+		events:      newEventHub(),
+		started:     time.Now(),
+	}
+	s.live.Store(live)
+	router.Use(s.metrics.instrument())
+
+	// Replication pulls blocks over bitswap, so it needs the IPFS node
+	if cfg.ReplicaOf != "" && (!cfg.IPFSEnabled || cfg.ReplicaPeer == "") {
+		store.Close()
+		return nil, fmt.Errorf("replication requires ipfs_enabled and replica_peer")
 	}
 
 	// Start IPFS node if enabled
 	if cfg.IPFSEnabled {
-		ipfsCfg := ipfsnode.DefaultConfig()
-		if len(cfg.IPFSListenAddrs) > 0 {
-			ipfsCfg.ListenAddrs = cfg.IPFSListenAddrs
-		}
-		if cfg.IPFSGatewayAddr != "" {
-			ipfsCfg.GatewayAddr = cfg.IPFSGatewayAddr
-		}
-		// Set public IP for DHT announcements
-		if cfg.IPFSPublicIP != "" {
-			ipfsCfg.AnnounceAddrs = []string{
-				fmt.Sprintf("/ip4/%s/tcp/4001", cfg.IPFSPublicIP),
-				fmt.Sprintf("/ip4/%s/udp/4001/quic-v1", cfg.IPFSPublicIP),
-			}
-		}
-
-		ipfsNode, err := ipfsnode.NewNode(context.Background(), store, ipfsCfg)
-		if err != nil {
+		if err := s.startIPFS(); err != nil {
 			store.Close()
-			return nil, fmt.Errorf("failed to start IPFS node: %w", err)
-		}
-		s.ipfsNode = ipfsNode
-		fmt.Printf("IPFS node started: %s\n", ipfsNode.PeerID())
-		for _, addr := range ipfsNode.Addrs() {
-			fmt.Printf("  Listening: %s/p2p/%s\n", addr, ipfsNode.PeerID())
-		}
-		if cfg.IPFSPublicIP != "" {
-			fmt.Printf("  Announcing: /ip4/%s/tcp/4001/p2p/%s\n", cfg.IPFSPublicIP, ipfsNode.PeerID())
-			fmt.Printf("  Announcing: /ip4/%s/udp/4001/quic-v1/p2p/%s\n", cfg.IPFSPublicIP, ipfsNode.PeerID())
-		}
-		if cfg.IPFSGatewayAddr != "" {
-			fmt.Printf("  Gateway: http://localhost%s/ipfs/<cid>\n", cfg.IPFSGatewayAddr)
+			return nil, err
 		}
 	}
 
@@ -108,71 +136,43 @@ func (s *Server) Run() error {
 	// Start metrics server if configured
 	if s.metricsPort > 0 {
 		go s.runMetricsServer()
+		go s.runMetricsRefresher()
 	}
 
 	// Start pruning job
 	go s.runPruner()
 
-	// Load existing root CIDs for IPFS if enabled
-	if s.ipfsNode != nil {
-		go s.loadExistingRootCIDs()
-	}
-
-	return s.router.Run(s.config.ListenAddr)
-}
-
-// loadExistingRootCIDs loads root CIDs from existing manifests and advertises them
-func (s *Server) loadExistingRootCIDs() {
-	ctx := context.Background()
+	// Report names that stopped backing up
+	go s.runMissedBackupChecker()
 
-	manifests, err := s.storage.ListManifests(ctx, nil)
-	if err != nil {
-		fmt.Printf("Warning: failed to list manifests for IPFS: %v\n", err)
-		return
-	}
-
-	var loaded int
-	for _, info := range manifests {
-		data, err := s.storage.GetManifest(ctx, info.ID)
-		if err != nil {
-			continue
-		}
+	// Summarize upload activity for event subscribers
+	go s.events.runUploadReporter()
 
-		// Decompress
-		decompressed, err := backup.Decompress(data, int64(len(data)*10))
-		if err != nil {
-			decompressed = data
-		}
+	// Pick up token rotations from the config file, and everything
+	// reloadable on SIGHUP
+	go s.runTokenReloader()
+	go s.reloadOnSignal()
 
-		var manifest struct {
-			RootCID string `json:"root_cid"`
-		}
-		if err := json.Unmarshal(decompressed, &manifest); err != nil {
-			continue
-		}
+	// Forget signatures of signed requests once they can't be replayed
+	go s.tokens.runSignatureSweeper()
 
-		if manifest.RootCID != "" {
-			if c, err := cid.Decode(manifest.RootCID); err == nil {
-				s.ipfsNode.AddRootCID(c)
-				loaded++
-			}
-		}
+	// Load existing root CIDs for IPFS if enabled
+	if node := s.ipfs(); node != nil {
+		go s.loadExistingRootCIDs(node)
 	}
 
-	if loaded > 0 {
-		fmt.Printf("Loaded %d root CIDs for IPFS\n", loaded)
-		if err := s.ipfsNode.AdvertiseRoots(ctx); err != nil {
-			fmt.Printf("Warning: failed to advertise root CIDs: %v\n", err)
-		}
+	// Mirror manifests from the primary if this is a replica
+	if s.config.ReplicaOf != "" {
+		go s.runReplicator()
 	}
+
+	return s.serve()
 }
 
 // Close shuts down the server
 func (s *Server) Close() error {
-	if s.ipfsNode != nil {
-		if err := s.ipfsNode.Close(); err != nil {
-			fmt.Printf("Warning: failed to close IPFS node: %v\n", err)
-		}
+	if err := s.stopIPFS(); err != nil {
+		logger.Warn("failed to close IPFS node", "error", err)
 	}
 	return s.storage.Close()
 }
@@ -181,38 +181,169 @@ func (s *Server) setupRoutes() {
 	// Health check
 	s.router.GET("/api/health", s.handleHealth)
 	s.router.GET("/api/config", s.handleConfig)
+	s.router.GET("/api/version", s.handleVersion)
 
-	// Public endpoints (no auth required)
-	s.router.GET("/api/manifests", s.handleListManifests)
-	s.router.GET("/api/manifests/:id", s.handleGetManifest)
-	s.router.GET("/api/manifests/latest", s.handleGetLatestManifest)
-	s.router.GET("/api/blocks/:cid", s.handleGetBlock)
+	// Read endpoints, public unless private reads are enabled
+	reads := s.router.Group("/api")
+	if s.config.PrivateReads {
+		reads.Use(s.authMiddleware(), requireScope(auth.ScopeRead, auth.ScopeAdmin))
+	}
+	{
+		reads.GET("/blocks/:cid", s.handleGetBlock)
+
+		// Manifests and listings are compressed for clients that accept
+		// it. Tokens limited to a namespace only see manifests in it.
+		listings := reads.Group("", compressResponses(), s.requireNamespace())
+		listings.GET("/manifests", s.handleListManifests)
+		listings.GET("/manifests/:id", s.handleGetManifest)
+		listings.GET("/manifests/latest", s.handleGetLatestManifest)
+		listings.GET("/manifests/:id/run", s.handleGetManifestRun)
+		listings.GET("/manifests/:id/summary", s.handleManifestSummary)
+		listings.GET("/manifests/:id/tree", s.handleManifestTree)
+		listings.GET("/manifests/:id/dedup", s.handleManifestDedup)
+		listings.GET("/manifests/:id/diff/:other", s.handleManifestDiff)
+		listings.GET("/runs", s.handleListRuns)
+	}
 
-	// Download endpoints - specific routes first, then generic
-	s.router.GET("/api/download/:manifest_id/file/*path", s.handleDownloadFile)
-	s.router.GET("/api/download/:manifest_id/folder/*path", s.handleDownloadFolder)
-	s.router.GET("/api/download/:manifest_id", s.handleDownload)
+	// Download endpoints - specific routes first, then generic. Signed
+	// links work without a token even when reads are private.
+	downloads := s.router.Group("/api", s.signedLink())
+	if s.config.PrivateReads {
+		downloads.Use(unlessSignedLink(s.authMiddleware()), unlessSignedLink(requireScope(auth.ScopeRead, auth.ScopeAdmin)), s.requireNamespace())
+	}
+	{
+		downloads.GET("/download/:manifest_id/file/*path", s.handleDownloadFile)
+		downloads.GET("/download/:manifest_id/folder/*path", s.handleDownloadFolder)
+		downloads.GET("/download/:manifest_id", s.handleDownload)
+	}
 
 	// CLI binary downloads
+	s.router.GET("/cli/checksums", s.handleCLIChecksums)
+	s.router.GET("/cli/checksums.sig", s.handleCLISignature)
 	s.router.GET("/cli/:os/:arch", s.handleCLIDownload)
 
+	// IPFS gateway on the main server (instead of a separate port), as
+	// private as the read endpoints
+	if s.config.IPFSGatewayMount {
+		gateway := gin.IRoutes(s.router)
+		if s.config.PrivateReads {
+			gateway = s.router.Group("", s.authMiddleware(), requireScope(auth.ScopeRead, auth.ScopeAdmin))
+		}
+		mountGateway(gateway, http.HandlerFunc(s.serveGateway))
+	}
+
 	// Protected endpoints (auth required)
 	protected := s.router.Group("/api")
 	protected.Use(s.authMiddleware())
 	{
-		protected.POST("/manifests", s.handleCreateManifest)
-		protected.DELETE("/manifests/:id", s.handleDeleteManifest)
-		protected.POST("/blocks/:cid/exists", s.handleBlockExists)
-		protected.POST("/blocks", s.handleUploadBlock)
+		protected.GET("/auth/check", s.handleAuthCheck)
+
+		// Live events name tokens and namespaces, so they need a read
+		// token even without private reads
+		protected.GET("/events", requireScope(auth.ScopeRead, auth.ScopeAdmin), s.handleEvents)
+
+		// Signed download links for handing downloads to browsers or curl
+		protected.POST("/download-links", requireScope(auth.ScopeRead, auth.ScopeAdmin), s.handleCreateDownloadLink)
+
+		// Uploads: write-only tokens can add data but not read it back
+		write := protected.Group("", s.allowlists.allowNetworks("write"), requireScope(auth.ScopeWrite, auth.ScopeAdmin))
+		write.POST("/manifests", decompressRequests(maxManifestBody), s.handleCreateManifest)
+		write.POST("/blocks/:cid/exists", s.handleBlockExists)
+		write.POST("/blocks/exists", s.handleMissingBlocks)
+		write.POST("/blocks", s.handleUploadBlock)
+		write.GET("/blocks/:cid/upload", s.handleGetBlockUpload)
+		write.PATCH("/blocks/:cid/upload", s.handleAppendBlockUpload)
+		write.POST("/runs", s.handleReportRun)
+
+		// Destructive and maintenance endpoints
+		admin := protected.Group("", s.allowlists.allowNetworks("admin"), requireScope(auth.ScopeAdmin))
+		admin.DELETE("/manifests/:id", forbidAppendOnly(), s.handleDeleteManifest)
+		admin.GET("/trash", s.handleListTrash)
+		admin.POST("/trash/:id/undelete", s.handleUndeleteManifest)
+		admin.POST("/manifests/:id/verify-dag", s.handleVerifyDAG)
+
+		// IPFS node administration
+		admin.GET("/ipfs/status", s.handleIPFSStatus)
+		admin.POST("/ipfs/start", s.handleIPFSStart)
+		admin.POST("/ipfs/stop", s.handleIPFSStop)
+
+		// Log level, changeable without a restart
+		admin.GET("/log-level", s.handleGetLogLevel)
+		admin.PUT("/log-level", s.handleSetLogLevel)
+
+		// Re-read the config file, like SIGHUP
+		admin.POST("/config/reload", s.handleReloadConfig)
+
+		// Maintenance on demand, for `ib-server admin`
+		admin.GET("/admin/stats", s.handleAdminStats)
+		admin.POST("/admin/prune", forbidAppendOnly(), s.handleAdminPrune)
+		admin.POST("/admin/gc", forbidAppendOnly(), s.handleAdminGC)
+		admin.POST("/admin/scrub", s.handleAdminScrub)
+
+		// Retention policies per backup name, applied by the pruner.
+		// Names may contain slashes.
+		admin.GET("/retention", s.handleListRetention)
+		admin.PUT("/retention/*name", forbidAppendOnly(), s.handleSetRetention)
+		admin.DELETE("/retention/*name", forbidAppendOnly(), s.handleDeleteRetention)
+
+		// Authentication blocks
+		admin.GET("/auth/blocks", s.handleListAuthBlocks)
+		admin.DELETE("/auth/blocks/:key", s.handleClearAuthBlock)
+
+		// Named token management. Append-only tokens could otherwise
+		// issue themselves an unrestricted token.
+		admin.GET("/tokens", s.handleListTokens)
+		admin.POST("/tokens", forbidAppendOnly(), s.handleCreateToken)
+		admin.DELETE("/tokens/:name", forbidAppendOnly(), s.handleRevokeToken)
 	}
 
 	// Static files (web UI)
 	s.router.NoRoute(s.handleStaticFiles)
 }
 
+// mountGateway routes /ipfs and /ipfs/* to the gateway handler, so neither
+// falls through to the web UI
+func mountGateway(router gin.IRoutes, handler http.Handler) {
+	gw := gin.WrapH(handler)
+	for _, path := range []string{"/ipfs", "/ipfs/*path"} {
+		router.GET(path, gw)
+		router.HEAD(path, gw)
+	}
+}
+
+// requireScope rejects requests whose token scope is not one of scopes.
+// Must run after authMiddleware.
+func requireScope(scopes ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		scope := c.GetString("token_scope")
+		for _, allowed := range scopes {
+			if scope == allowed {
+				c.Next()
+				return
+			}
+		}
+		c.JSON(http.StatusForbidden, gin.H{"error": fmt.Sprintf("token scope %q does not allow this request", scope)})
+		c.Abort()
+	}
+}
+
+// forbidAppendOnly rejects requests made with an append-only token, for
+// endpoints that delete or change existing backups. Must run after
+// authMiddleware.
+func forbidAppendOnly() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.GetBool("token_append_only") {
+			c.JSON(http.StatusForbidden, gin.H{"error": "append-only token can't modify or delete existing backups"})
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
 func (s *Server) authMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		clientIP := GetRealIP(c)
+		clientIP := s.allowlists.clientIP(c)
 
 		// Check if IP is blocked due to previous failed attempts
 		if s.rateLimiter.IsBlocked(clientIP) {
@@ -224,8 +355,8 @@ func (s *Server) authMiddleware() gin.HandlerFunc {
 
 		token := c.GetHeader("Authorization")
 		if token == "" {
-			LogFailedAuth(clientIP, "missing authorization header", false)
-			s.rateLimiter.BlockIP(clientIP)
+			banned := s.rateLimiter.RecordFailure(clientIP)
+			LogFailedAuth(clientIP, "missing authorization header", banned)
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "missing authorization header"})
 			c.Abort()
 			return
@@ -237,21 +368,72 @@ func (s *Server) authMiddleware() gin.HandlerFunc {
 			token = token[len(prefix):]
 		}
 
-		if token != s.config.Token {
-			LogFailedAuth(clientIP, "invalid token", false)
-			s.rateLimiter.BlockIP(clientIP)
+		// Signed requests carry the token ID but not the token
+		signedID, ts, sig, signed := auth.ParseSignatureHeader(token)
+
+		// Guessing the secret of a known named token is tracked per token
+		// and IP. Token IDs aren't secret, so failures from one IP must
+		// not lock the token out everywhere.
+		tokenID := signedID
+		if !signed {
+			tokenID, _ = auth.ParseNamedToken(token)
+		}
+		tokenKey := ""
+		if tokenID != "" {
+			tokenKey = tokenBlockKey(tokenID, clientIP)
+			if s.rateLimiter.IsBlocked(tokenKey) {
+				LogFailedAuth(clientIP, "token temporarily blocked", true)
+				c.JSON(http.StatusTooManyRequests, gin.H{"error": "too many failed attempts, try again later"})
+				c.Abort()
+				return
+			}
+		}
+
+		var result *authResult
+		var ok bool
+		if signed {
+			result, ok = s.authenticateSigned(c, signedID, ts, sig)
+		} else {
+			result, ok = s.authenticate(c.Request.Context(), token)
+		}
+		if !ok {
+			banned := s.rateLimiter.RecordFailure(clientIP)
+			if tokenKey != "" && s.rateLimiter.RecordFailure(tokenKey) {
+				banned = true
+			}
+			LogFailedAuth(clientIP, "invalid token", banned)
 			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid token"})
 			c.Abort()
 			return
 		}
+		s.rateLimiter.RecordSuccess(clientIP)
+		if tokenKey != "" {
+			s.rateLimiter.RecordSuccess(tokenKey)
+		}
+		c.Set("token_name", result.name)
+		c.Set("token_scope", result.scope)
+		c.Set("token_append_only", result.appendOnly)
+		c.Set("token_id", result.id)
+		c.Set("token_namespace", result.namespace)
+
+		// Rotated-out token in its grace period: tell the client
+		if !result.expires.IsZero() {
+			c.Header(tokenExpiresHeader, result.expires.UTC().Format(time.RFC3339))
+			c.Set("token_expires", result.expires)
+		}
 
 		c.Next()
+
+		// Audit trail of which token changed what
+		if c.Request.Method != http.MethodGet && c.Request.Method != http.MethodHead {
+			LogAuthenticatedRequest(clientIP, result.name, c.Request.Method, c.Request.URL.Path, c.Writer.Status())
+		}
 	}
 }
 
 func (s *Server) runMetricsServer() {
 	mux := http.NewServeMux()
-	mux.Handle("/metrics", promhttp.Handler())
+	mux.Handle("/metrics", s.allowlists.wrapMetrics(promhttp.Handler()))
 
 	server := &http.Server{
 		Addr:    fmt.Sprintf(":%d", s.metricsPort),
@@ -266,20 +448,92 @@ func (s *Server) runPruner() {
 	defer ticker.Stop()
 
 	// Run once at startup
-	s.prune()
+	s.prune(pruneOptions{})
 
 	for range ticker.C {
-		s.prune()
+		s.prune(pruneOptions{})
 	}
 }
 
-func (s *Server) prune() {
+// pruneOptions adjust a prune requested through the admin API
+type pruneOptions struct {
+	cutoff time.Time // Prune manifests created before, instead of applying retention periods and policies
+	dryRun bool      // Only report what would be removed
+}
+
+// prune applies the retention policies, and the retention period to names
+// without one, empties the trash of manifests deleted longer ago than the
+// trash period and collects garbage, returning what was removed and any
+// errors
+func (s *Server) prune(opts pruneOptions) (*storage.PruneResult, []string) {
 	ctx := context.Background()
-	cutoff := time.Now().AddDate(0, 0, -s.config.RetentionDays)
+	start := time.Now()
+	settings := s.settings()
+	storeOpts := storage.PruneOptions{
+		Cutoff:      start.AddDate(0, 0, -settings.retentionDays),
+		TrashCutoff: start.Add(-settings.trashPeriod),
+		DryRun:      opts.dryRun,
+	}
+	if !opts.cutoff.IsZero() {
+		storeOpts.Cutoff = opts.cutoff
+	} else {
+		policies, err := mergeRetentionPolicies(ctx, s.storage, settings.policies)
+		if err != nil {
+			// Without the policies names with one would fall back to
+			// the retention period, so don't prune at all
+			logger.Error("pruning manifests failed", "error", err)
+			if !opts.dryRun {
+				s.metrics.pruneFailures.Inc()
+			}
+			return &storage.PruneResult{DryRun: opts.dryRun}, []string{err.Error()}
+		}
+		storeOpts.Policies = policies
+	}
+	cutoff := storeOpts.Cutoff
+
+	var errs []string
+	if opts.dryRun {
+		result, err := s.storage.PruneManifests(ctx, storeOpts)
+		if err != nil {
+			errs = append(errs, "pruning manifests failed: "+err.Error())
+		}
+		return result, errs
+	}
 
-	if err := s.storage.PruneManifests(ctx, cutoff); err != nil {
-		fmt.Printf("Pruning error: %v\n", err)
+	s.metrics.pruneLastRun.Set(float64(start.Unix()))
+	result, err := s.storage.PruneManifests(ctx, storeOpts)
+	if err != nil {
+		logger.Error("pruning manifests failed", "error", err)
+		errs = append(errs, "pruning manifests failed: "+err.Error())
+	}
+	s.metrics.gcDuration.Set(time.Since(start).Seconds())
+	s.metrics.prunedManifests.Add(float64(result.Manifests))
+	s.metrics.gcBlocks.Add(float64(result.Blocks))
+	s.metrics.gcBytes.Add(float64(result.Bytes))
+	s.metrics.gcS3Failures.Add(float64(result.S3DeleteErrors))
+
+	if err := s.storage.PruneDownloadLinks(ctx, time.Now()); err != nil {
+		logger.Error("pruning download links failed", "error", err)
+		errs = append(errs, "pruning download links failed: "+err.Error())
+	}
+	if err := s.storage.PruneBlockUploads(ctx, start.Add(-uploadExpiry)); err != nil {
+		logger.Error("pruning block uploads failed", "error", err)
+		errs = append(errs, "pruning block uploads failed: "+err.Error())
+	}
+	if err := s.storage.PruneBackupRuns(ctx, cutoff); err != nil {
+		logger.Error("pruning backup runs failed", "error", err)
+		errs = append(errs, "pruning backup runs failed: "+err.Error())
 	}
+
+	if len(errs) > 0 {
+		s.metrics.pruneFailures.Inc()
+	} else {
+		s.metrics.pruneLastSuccess.Set(float64(time.Now().Unix()))
+	}
+	s.notifyPrune(result, errs)
+	s.events.publish(serverEvent{Type: eventPrune, Data: gin.H{"result": result, "errors": errs}})
+	s.refreshMetrics(ctx)
+	return result, errs
 }
 
 func (s *Server) handleHealth(c *gin.Context) {
@@ -289,3 +543,29 @@ func (s *Server) handleHealth(c *gin.Context) {
 func (s *Server) handleConfig(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"title": s.title})
 }
+
+// handleVersion reports the server version and the features clients can
+// rely on
+func (s *Server) handleVersion(c *gin.Context) {
+	c.JSON(http.StatusOK, version.Server(buildVersion))
+}
+
+func (s *Server) handleGetLogLevel(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"level": logging.Level()})
+}
+
+func (s *Server) handleSetLogLevel(c *gin.Context) {
+	var req struct {
+		Level string `json:"level"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil || req.Level == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "level is required"})
+		return
+	}
+	if err := logging.SetLevel(req.Level); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	logger.Info("log level changed", "level", logging.Level(), "token", c.GetString("token_name"))
+	c.JSON(http.StatusOK, gin.H{"level": logging.Level()})
+}
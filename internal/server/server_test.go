@@ -0,0 +1,48 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestMountGatewayRoutesIPFSPaths(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+
+	gateway := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Test-Handler", "gateway")
+		w.WriteHeader(http.StatusOK)
+	})
+	mountGateway(router, gateway)
+	router.NoRoute(func(c *gin.Context) {
+		c.Header("X-Test-Handler", "static")
+		c.Status(http.StatusOK)
+	})
+
+	paths := []string{
+		"/ipfs",
+		"/ipfs/",
+		"/ipfs/bafybeigdyrzt5sfp7udm7hu76uh7y26nf3efuylqabf3oclgtqy55fbzdi",
+		"/ipfs/bafybeigdyrzt5sfp7udm7hu76uh7y26nf3efuylqabf3oclgtqy55fbzdi/dir/file.txt",
+	}
+	for _, method := range []string{http.MethodGet, http.MethodHead} {
+		for _, path := range paths {
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest(method, path, nil))
+
+			if got := w.Header().Get("X-Test-Handler"); got != "gateway" {
+				t.Errorf("%s %s: handled by %q (status %d), want gateway", method, path, got, w.Code)
+			}
+		}
+	}
+
+	// Non-gateway paths still reach the web UI fallback
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/ipfsfoo", nil))
+	if got := w.Header().Get("X-Test-Handler"); got != "static" {
+		t.Errorf("GET /ipfsfoo: handled by %q, want static", got)
+	}
+}
@@ -0,0 +1,133 @@
+package server
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/johann/ib/internal/config"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// serve runs the HTTP server on the listen address, with TLS if a
+// certificate or ACME domains are configured
+func (s *Server) serve() error {
+	server := &http.Server{
+		Addr:              s.config.ListenAddr,
+		Handler:           s.router,
+		ReadHeaderTimeout: 30 * time.Second,
+	}
+
+	switch {
+	case len(s.config.ACMEDomains) > 0:
+		manager, err := s.acmeManager()
+		if err != nil {
+			return err
+		}
+		server.TLSConfig = manager.TLSConfig()
+		go s.runACMEHTTP(manager)
+		logger.Info("serving HTTPS with ACME certificates", "domains", s.config.ACMEDomains)
+		return server.ListenAndServeTLS("", "")
+
+	case s.config.TLSCert != "":
+		certs := &certFile{certPath: s.config.TLSCert, keyPath: s.config.TLSKey}
+		if _, err := certs.load(); err != nil {
+			return err
+		}
+		server.TLSConfig = &tls.Config{GetCertificate: certs.getCertificate}
+		logger.Info("serving HTTPS", "cert", s.config.TLSCert)
+		return server.ListenAndServeTLS("", "")
+	}
+	return server.ListenAndServe()
+}
+
+// acmeManager gets and renews certificates for the ACME domains from
+// Let's Encrypt, keeping them and the account key in the cache directory
+func (s *Server) acmeManager() (*autocert.Manager, error) {
+	cacheDir := s.config.ACMECacheDir
+	if cacheDir == "" {
+		dir, err := config.Dir()
+		if err != nil {
+			return nil, fmt.Errorf("failed to find ACME cache directory: %w", err)
+		}
+		cacheDir = filepath.Join(dir, "acme")
+	}
+	if err := os.MkdirAll(cacheDir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create ACME cache directory: %w", err)
+	}
+
+	return &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		HostPolicy: autocert.HostWhitelist(s.config.ACMEDomains...),
+		Cache:      autocert.DirCache(cacheDir),
+		Email:      s.config.ACMEEmail,
+	}, nil
+}
+
+// runACMEHTTP answers HTTP-01 challenges on port 80 and redirects
+// everything else to HTTPS. Without port 80 certificates are still issued
+// over TLS-ALPN-01 on the HTTPS port.
+func (s *Server) runACMEHTTP(manager *autocert.Manager) {
+	server := &http.Server{
+		Addr:              ":80",
+		Handler:           manager.HTTPHandler(nil),
+		ReadHeaderTimeout: 30 * time.Second,
+	}
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		logger.Warn("not serving ACME HTTP challenges on :80", "error", err)
+	}
+}
+
+// certFile serves a certificate from PEM files, reloading them when the
+// certificate file changes so renewals are picked up without a restart
+type certFile struct {
+	certPath, keyPath string
+
+	mu      sync.Mutex
+	cert    *tls.Certificate
+	modTime time.Time
+}
+
+// load reads the certificate if the file changed since it was last read
+func (f *certFile) load() (*tls.Certificate, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	info, err := os.Stat(f.certPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read TLS certificate: %w", err)
+	}
+	if f.cert != nil && info.ModTime().Equal(f.modTime) {
+		return f.cert, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(f.certPath, f.keyPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load TLS certificate: %w", err)
+	}
+	if f.cert != nil {
+		logger.Info("reloaded TLS certificate", "cert", f.certPath)
+	}
+	f.cert = &cert
+	f.modTime = info.ModTime()
+	return f.cert, nil
+}
+
+func (f *certFile) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	cert, err := f.load()
+	if err != nil {
+		// A renewal may be half written, keep serving the previous one
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		if f.cert != nil {
+			logger.Warn("keeping the current TLS certificate", "error", err)
+			return f.cert, nil
+		}
+	}
+	return cert, err
+}
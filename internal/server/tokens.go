@@ -0,0 +1,368 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/johann/ib/internal/auth"
+	"github.com/johann/ib/internal/config"
+	"github.com/johann/ib/internal/secrets"
+	"github.com/johann/ib/internal/storage"
+)
+
+// tokenExpiresHeader is set on responses to requests that authenticated
+// with a rotated-out token still inside its grace period
+const tokenExpiresHeader = "X-IB-Token-Expires"
+
+// defaultTokenName identifies the server token from the config file
+const defaultTokenName = "default"
+
+// touchInterval limits how often a named token's last-used time is written
+const touchInterval = time.Minute
+
+// maxSignedBody caps the request body buffered to verify a signature
+const maxSignedBody = 64 << 20
+
+// authResult identifies the token that authenticated a request
+type authResult struct {
+	id         string    // Named token ID, empty for the server token
+	name       string    // Named token, or defaultTokenName
+	scope      string    // Token scope; the server token is admin
+	appendOnly bool      // May add backups but not delete or change them
+	namespace  string    // Backup names the token is limited to, empty for all
+	expires    time.Time // Set for a rotated-out token in its grace period
+}
+
+// tokenSet holds the accepted tokens. It is reloaded from the config file
+// so `ib-server token rotate` takes effect without a restart.
+type tokenSet struct {
+	mu          sync.RWMutex
+	token       string // Plaintext, from IB_TOKEN or a legacy config
+	hash        string
+	prevHash    string
+	prevExpires time.Time
+
+	// Last time each named token's use was recorded
+	touchMu sync.Mutex
+	touched map[string]time.Time
+
+	// Signatures of recent signed requests, until they expire
+	sigMu   sync.Mutex
+	sigSeen map[string]time.Time
+}
+
+func newTokenSet(cfg *config.ServerConfig) *tokenSet {
+	t := &tokenSet{
+		touched: make(map[string]time.Time),
+		sigSeen: make(map[string]time.Time),
+	}
+	t.update(cfg)
+	return t
+}
+
+// update replaces the accepted tokens with those from cfg. Tokens kept in a
+// secret store are fetched; if that fails the previous ones stay active.
+func (t *tokenSet) update(cfg *config.ServerConfig) {
+	token, _, err := secrets.Resolve(context.Background(), cfg.Token)
+	if err != nil {
+		logger.Warn("failed to load server token", "error", err)
+		return
+	}
+	hash, _, err := secrets.Resolve(context.Background(), cfg.TokenHash)
+	if err != nil {
+		logger.Warn("failed to load server token", "error", err)
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.token = token
+	t.hash = hash
+	t.prevHash = cfg.PreviousTokenHash
+	t.prevExpires = time.Time{}
+	if cfg.PreviousTokenExpires > 0 {
+		t.prevExpires = time.Unix(cfg.PreviousTokenExpires, 0)
+	}
+}
+
+// check validates a token. For the previous token within its grace
+// period, expires is set to when it stops being accepted.
+func (t *tokenSet) check(token string) (ok bool, expires time.Time) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	if t.token != "" && auth.Equal(token, t.token) {
+		return true, time.Time{}
+	}
+	if t.hash != "" && auth.VerifyToken(token, t.hash) {
+		return true, time.Time{}
+	}
+	if t.prevHash != "" && time.Now().Before(t.prevExpires) && auth.VerifyToken(token, t.prevHash) {
+		return true, t.prevExpires
+	}
+	return false, time.Time{}
+}
+
+// shouldTouch reports whether a named token's last-used time is due for an
+// update, so busy clients don't cause a database write per request
+func (t *tokenSet) shouldTouch(id string, now time.Time) bool {
+	t.touchMu.Lock()
+	defer t.touchMu.Unlock()
+
+	if now.Sub(t.touched[id]) < touchInterval {
+		return false
+	}
+	t.touched[id] = now
+	return true
+}
+
+// useSignature records a request signature. Returns false if it was seen
+// before, so a captured signed request can't be replayed.
+func (t *tokenSet) useSignature(sig string, now time.Time) bool {
+	t.sigMu.Lock()
+	defer t.sigMu.Unlock()
+
+	if expires, seen := t.sigSeen[sig]; seen && !now.After(expires) {
+		return false
+	}
+	// Past this the timestamp check rejects the request anyway
+	t.sigSeen[sig] = now.Add(2 * auth.MaxSignatureSkew)
+	return true
+}
+
+// runSignatureSweeper drops expired signatures once per interval, so
+// signed requests don't walk all recent ones
+func (t *tokenSet) runSignatureSweeper() {
+	ticker := time.NewTicker(auth.MaxSignatureSkew)
+	defer ticker.Stop()
+
+	for now := range ticker.C {
+		t.sigMu.Lock()
+		for sig, expires := range t.sigSeen {
+			if now.After(expires) {
+				delete(t.sigSeen, sig)
+			}
+		}
+		t.sigMu.Unlock()
+	}
+}
+
+// authenticateSigned verifies an IB-HMAC signed request for a signed
+// named token. The body is read and put back for the handler.
+func (s *Server) authenticateSigned(c *gin.Context, id string, ts int64, sig string) (*authResult, bool) {
+	ctx := c.Request.Context()
+	now := time.Now()
+	if skew := now.Sub(time.Unix(ts, 0)); skew > auth.MaxSignatureSkew || skew < -auth.MaxSignatureSkew {
+		return nil, false
+	}
+
+	info, err := s.storage.GetToken(ctx, id)
+	if err != nil || !info.Signed || info.Expired(now) {
+		return nil, false
+	}
+	key, err := hex.DecodeString(info.SigningKey)
+	if err != nil {
+		return nil, false
+	}
+
+	var body []byte
+	if c.Request.Body != nil {
+		body, err = io.ReadAll(io.LimitReader(c.Request.Body, maxSignedBody+1))
+		if err != nil || len(body) > maxSignedBody {
+			return nil, false
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+	}
+
+	expected := auth.SignRequest(key, c.Request.Method, c.Request.URL.RequestURI(), ts, body)
+	if !hmac.Equal([]byte(sig), []byte(expected)) {
+		return nil, false
+	}
+	if !s.tokens.useSignature(sig, now) {
+		return nil, false
+	}
+	return s.namedTokenResult(ctx, info), true
+}
+
+// namedTokenResult records the use of an authenticated named token
+func (s *Server) namedTokenResult(ctx context.Context, info *storage.TokenInfo) *authResult {
+	if now := time.Now(); s.tokens.shouldTouch(info.ID, now) {
+		if err := s.storage.TouchToken(ctx, info.ID, now); err != nil {
+			logger.Warn("failed to record token use", "token", info.Name, "error", err)
+		}
+	}
+	return &authResult{id: info.ID, name: info.Name, scope: info.Scope, appendOnly: info.AppendOnly, namespace: info.Namespace}
+}
+
+// authenticate resolves which token, if any, a request presented
+func (s *Server) authenticate(ctx context.Context, token string) (*authResult, bool) {
+	// Named tokens carry their ID, so they are looked up directly
+	if id, ok := auth.ParseNamedToken(token); ok {
+		info, err := s.storage.GetToken(ctx, id)
+		if err != nil || info.Signed || info.Expired(time.Now()) || !auth.VerifyToken(token, info.Hash) {
+			return nil, false
+		}
+		return s.namedTokenResult(ctx, info), true
+	}
+
+	ok, expires := s.tokens.check(token)
+	if !ok {
+		return nil, false
+	}
+	return &authResult{name: defaultTokenName, scope: auth.ScopeAdmin, expires: expires}, true
+}
+
+// runTokenReloader periodically picks up token changes from the config file
+func (s *Server) runTokenReloader() {
+	ticker := time.NewTicker(30 * time.Second)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		cfg, err := config.LoadServer()
+		if err != nil {
+			continue
+		}
+		s.tokens.update(cfg)
+	}
+}
+
+// handleAuthCheck reports whether the presented token is current or has
+// been rotated out, so clients can detect rotation
+func (s *Server) handleAuthCheck(c *gin.Context) {
+	if expires, ok := c.Get("token_expires"); ok {
+		c.JSON(http.StatusOK, gin.H{
+			"status":     "previous",
+			"expires_at": expires.(time.Time).UTC().Format(time.RFC3339),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "current"})
+}
+
+// handleListTokens lists named tokens with their last-used times
+func (s *Server) handleListTokens(c *gin.Context) {
+	tokens, err := s.storage.ListTokens(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, tokens)
+}
+
+// handleCreateToken issues a named token. The token is only returned here.
+func (s *Server) handleCreateToken(c *gin.Context) {
+	var req struct {
+		Name       string `json:"name"`
+		Scope      string `json:"scope"`
+		AppendOnly bool   `json:"append_only"`
+		Signed     bool   `json:"signed"`
+		ExpiresIn  string `json:"expires_in"` // Duration like "720h", empty for no expiry
+		Namespace  string `json:"namespace"`
+	}
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid request"})
+		return
+	}
+
+	var validFor time.Duration
+	if req.ExpiresIn != "" {
+		var err error
+		if validFor, err = time.ParseDuration(req.ExpiresIn); err != nil || validFor <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid expires_in"})
+			return
+		}
+	}
+
+	info, token, err := CreateNamedToken(c.Request.Context(), s.storage, &storage.TokenInfo{
+		Name:       req.Name,
+		Scope:      req.Scope,
+		AppendOnly: req.AppendOnly,
+		Signed:     req.Signed,
+		Namespace:  req.Namespace,
+	}, validFor)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{
+		"token": token,
+		"info":  info,
+	})
+}
+
+// handleRevokeToken deletes a named token
+func (s *Server) handleRevokeToken(c *gin.Context) {
+	name := c.Param("name")
+	if err := s.storage.DeleteToken(c.Request.Context(), name); err != nil {
+		if strings.Contains(err.Error(), "not found") {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"revoked": name})
+}
+
+// CreateNamedToken generates and stores a named token with the name, scope,
+// append-only, signed and namespace settings of req, returning its record
+// and the plaintext token. Signed tokens are only accepted on HMAC-signed
+// requests, never as a bearer token. A token with validFor set stops being
+// accepted after that long.
+func CreateNamedToken(ctx context.Context, store *storage.Storage, req *storage.TokenInfo, validFor time.Duration) (*storage.TokenInfo, string, error) {
+	if req.Name == "" {
+		return nil, "", fmt.Errorf("token name is required")
+	}
+	scope := req.Scope
+	if scope == "" {
+		scope = auth.ScopeAdmin
+	}
+	if !auth.ValidScope(scope) {
+		return nil, "", fmt.Errorf("invalid scope %q (expected read, write or admin)", scope)
+	}
+	// Admin endpoints act on the whole server
+	if req.Namespace != "" && scope == auth.ScopeAdmin {
+		return nil, "", fmt.Errorf("tokens limited to a namespace can't have admin scope")
+	}
+
+	id, token, err := auth.GenerateNamedToken()
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to generate token: %w", err)
+	}
+	hash, err := auth.HashToken(token)
+	if err != nil {
+		return nil, "", err
+	}
+
+	info := &storage.TokenInfo{
+		ID:         id,
+		Name:       req.Name,
+		Scope:      scope,
+		AppendOnly: req.AppendOnly,
+		Signed:     req.Signed,
+		Namespace:  req.Namespace,
+		CreatedAt:  time.Now(),
+		Hash:       hash,
+	}
+	if validFor > 0 {
+		info.ExpiresAt = info.CreatedAt.Add(validFor)
+	}
+	if req.Signed {
+		info.SigningKey = hex.EncodeToString(auth.SigningKey(token))
+	}
+	if err := store.CreateToken(ctx, info); err != nil {
+		return nil, "", err
+	}
+	return info, token, nil
+}
@@ -0,0 +1,46 @@
+package server
+
+import (
+	"fmt"
+
+	"github.com/gin-gonic/gin"
+	"github.com/johann/ib/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = tracing.Tracer("server")
+
+// traceRequests starts a span per request, continuing the client's trace
+// if it sent one
+func traceRequests() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := tracing.Extract(c.Request.Context(), c.Request.Header)
+
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		ctx, span := tracer.Start(ctx, c.Request.Method+" "+route,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				attribute.String("http.request.method", c.Request.Method),
+				attribute.String("http.route", route),
+				attribute.String("url.path", c.Request.URL.Path),
+			))
+		defer span.End()
+
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+
+		status := c.Writer.Status()
+		span.SetAttributes(attribute.Int("http.response.status_code", status))
+		if token := c.GetString("token_name"); token != "" {
+			span.SetAttributes(attribute.String("ib.token", token))
+		}
+		if status >= 500 {
+			span.SetStatus(codes.Error, fmt.Sprintf("HTTP %d", status))
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package server
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// handleListTrash lists deleted manifests that can still be undeleted, with
+// when their blocks become collectable
+func (s *Server) handleListTrash(c *gin.Context) {
+	trashed, err := s.storage.ListTrash(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	period := s.settings().trashPeriod
+	result := make([]gin.H, 0, len(trashed))
+	for _, m := range trashed {
+		result = append(result, gin.H{
+			"id":            m.ID,
+			"tags":          m.Tags,
+			"created_at":    m.CreatedAt.UTC(),
+			"deleted_at":    m.DeletedAt.UTC(),
+			"trashed_until": m.DeletedAt.Add(period).UTC(),
+		})
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+// handleUndeleteManifest takes a manifest out of the trash
+func (s *Server) handleUndeleteManifest(c *gin.Context) {
+	id := c.Param("id")
+	ctx := c.Request.Context()
+
+	if err := s.storage.UndeleteManifest(ctx, id); err != nil {
+		if strings.Contains(err.Error(), "not found") {
+			c.JSON(http.StatusNotFound, gin.H{"error": "manifest not found in trash"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	logger.Info("manifest undeleted", "manifest", id, "token", c.GetString("token_name"))
+	s.refreshMetrics(ctx)
+	c.JSON(http.StatusOK, gin.H{"undeleted": id})
+}
@@ -0,0 +1,318 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/johann/ib/internal/backup"
+)
+
+const (
+	// treeCacheSize is how many manifest indexes stay in memory. Building
+	// one means parsing the whole manifest, so the pages of a backup being
+	// browsed must not rebuild it.
+	treeCacheSize = 4
+
+	defaultTreeLimit = 200
+	maxTreeLimit     = 1000
+)
+
+// treeEntry is a manifest entry without its block list
+type treeEntry struct {
+	Name       string          `json:"name"`
+	Path       string          `json:"path"`
+	Type       backup.FileType `json:"type"`
+	Mode       uint32          `json:"mode,omitempty"`
+	Mtime      int64           `json:"mtime,omitempty"`
+	Size       int64           `json:"size,omitempty"`
+	CID        string          `json:"cid,omitempty"`
+	LinkTarget string          `json:"link_target,omitempty"`
+	Children   int             `json:"children,omitempty"` // Directories only
+	children   []int           // Indexes into treeIndex.entries
+}
+
+// treeIndex is a manifest's entries grouped by parent directory
+type treeIndex struct {
+	manifest  *backup.Manifest // Without entries
+	encrypted bool             // Entries are sealed, only the client can list them
+	entries   []treeEntry
+	dirs      map[string]int // Directory path -> index, "" is the root
+	files     int
+	size      int64
+}
+
+func newTreeIndex(manifest *backup.Manifest) *treeIndex {
+	t := &treeIndex{
+		entries: make([]treeEntry, 0, len(manifest.Entries)+1),
+		dirs:    make(map[string]int),
+	}
+	t.entries = append(t.entries, treeEntry{Type: backup.FileTypeDir})
+	t.dirs[""] = 0
+
+	for _, e := range manifest.Entries {
+		p := strings.Trim(e.Path, "/")
+		if p == "" || p == "." {
+			continue
+		}
+		if e.Type == backup.FileTypeFile {
+			t.files++
+			t.size += e.Size
+		}
+		if e.Type == backup.FileTypeDir {
+			if i, ok := t.dirs[p]; ok {
+				// Seen as the parent of an earlier entry
+				t.entries[i].Mode, t.entries[i].Mtime, t.entries[i].CID = e.Mode, e.Mtime, e.CID
+				continue
+			}
+		}
+		t.add(treeEntry{
+			Name:       path.Base(p),
+			Path:       p,
+			Type:       e.Type,
+			Mode:       e.Mode,
+			Mtime:      e.Mtime,
+			Size:       e.Size,
+			CID:        e.CID,
+			LinkTarget: e.LinkTarget,
+		})
+	}
+
+	for i := range t.entries {
+		t.entries[i].Children = len(t.entries[i].children)
+	}
+
+	t.encrypted = len(manifest.Encrypted) > 0
+	summary := *manifest
+	summary.Entries = nil
+	summary.Encrypted = nil
+	summary.CIDs = nil
+	t.manifest = &summary
+	return t
+}
+
+// add appends an entry below its parent directory, creating parents that
+// have no entry of their own
+func (t *treeIndex) add(e treeEntry) int {
+	parent := t.dir(path.Dir(e.Path))
+	i := len(t.entries)
+	t.entries = append(t.entries, e)
+	t.entries[parent].children = append(t.entries[parent].children, i)
+	if e.Type == backup.FileTypeDir {
+		t.dirs[e.Path] = i
+	}
+	return i
+}
+
+func (t *treeIndex) dir(p string) int {
+	if p == "." {
+		p = ""
+	}
+	if i, ok := t.dirs[p]; ok {
+		return i
+	}
+	return t.add(treeEntry{Name: path.Base(p), Path: p, Type: backup.FileTypeDir})
+}
+
+// list returns a page of a directory's children, directories first, then
+// ordered by sortBy. filter keeps names containing it (case-insensitive).
+func (t *treeIndex) list(dir, sortBy string, desc bool, filter string, offset, limit int) ([]treeEntry, int, bool) {
+	i, ok := t.dirs[strings.Trim(dir, "/")]
+	if !ok {
+		return nil, 0, false
+	}
+
+	filter = strings.ToLower(filter)
+	children := make([]int, 0, len(t.entries[i].children))
+	for _, c := range t.entries[i].children {
+		if filter == "" || strings.Contains(strings.ToLower(t.entries[c].Name), filter) {
+			children = append(children, c)
+		}
+	}
+
+	less := func(a, b *treeEntry) bool {
+		switch sortBy {
+		case "size":
+			if a.Size != b.Size {
+				return a.Size < b.Size
+			}
+		case "mtime":
+			if a.Mtime != b.Mtime {
+				return a.Mtime < b.Mtime
+			}
+		}
+		return a.Name < b.Name
+	}
+	sort.SliceStable(children, func(x, y int) bool {
+		a, b := &t.entries[children[x]], &t.entries[children[y]]
+		if aDir, bDir := a.Type == backup.FileTypeDir, b.Type == backup.FileTypeDir; aDir != bDir {
+			return aDir
+		}
+		if desc {
+			return less(b, a)
+		}
+		return less(a, b)
+	})
+
+	total := len(children)
+	if offset > total {
+		offset = total
+	}
+	end := min(offset+limit, total)
+	page := make([]treeEntry, 0, end-offset)
+	for _, c := range children[offset:end] {
+		page = append(page, t.entries[c])
+	}
+	return page, total, true
+}
+
+// treeCache keeps the indexes of recently browsed manifests
+type treeCache struct {
+	mu      sync.Mutex
+	indexes map[string]*treeIndex
+	order   []string // Least recently used first
+}
+
+func newTreeCache() *treeCache {
+	return &treeCache{indexes: make(map[string]*treeIndex)}
+}
+
+func (c *treeCache) get(id string) *treeIndex {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	t, ok := c.indexes[id]
+	if ok {
+		c.touch(id)
+	}
+	return t
+}
+
+func (c *treeCache) put(id string, t *treeIndex) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.indexes[id]; !ok && len(c.order) >= treeCacheSize {
+		delete(c.indexes, c.order[0])
+		c.order = c.order[1:]
+	}
+	c.indexes[id] = t
+	c.touch(id)
+}
+
+func (c *treeCache) remove(id string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.indexes, id)
+	c.order = removeString(c.order, id)
+}
+
+func (c *treeCache) touch(id string) {
+	c.order = append(removeString(c.order, id), id)
+}
+
+func removeString(list []string, s string) []string {
+	for i, v := range list {
+		if v == s {
+			return append(list[:i], list[i+1:]...)
+		}
+	}
+	return list
+}
+
+// manifestTree returns the index of a manifest, building it on first use
+func (s *Server) manifestTree(ctx context.Context, id string) (*treeIndex, error) {
+	if t := s.trees.get(id); t != nil {
+		return t, nil
+	}
+	manifest, err := s.loadManifest(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	t := newTreeIndex(manifest)
+	s.trees.put(id, t)
+	return t, nil
+}
+
+func (s *Server) treeError(c *gin.Context, err error) {
+	if strings.Contains(err.Error(), "not found") {
+		c.JSON(http.StatusNotFound, gin.H{"error": "manifest not found"})
+		return
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+}
+
+// handleManifestSummary returns a manifest without its entries, plus
+// totals, so the web UI doesn't need to download every entry
+func (s *Server) handleManifestSummary(c *gin.Context) {
+	t, err := s.manifestTree(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		s.treeError(c, err)
+		return
+	}
+	if s.notModified(c, c.Param("id")+"-summary", false) {
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"manifest":  t.manifest,
+		"encrypted": t.encrypted,
+		"files":     t.files,
+		"dirs":      len(t.dirs) - 1,
+		"size":      t.size,
+	})
+}
+
+// handleManifestTree returns a page of a directory's children. Query
+// parameters: path (default the root), sort (name, size or mtime), order
+// (asc or desc), filter, offset and limit.
+func (s *Server) handleManifestTree(c *gin.Context) {
+	sortBy := c.DefaultQuery("sort", "name")
+	if sortBy != "name" && sortBy != "size" && sortBy != "mtime" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "sort must be name, size or mtime"})
+		return
+	}
+	order := c.DefaultQuery("order", "asc")
+	if order != "asc" && order != "desc" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "order must be asc or desc"})
+		return
+	}
+	offset, err := strconv.Atoi(c.DefaultQuery("offset", "0"))
+	if err != nil || offset < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid offset"})
+		return
+	}
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(defaultTreeLimit)))
+	if err != nil || limit < 1 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid limit"})
+		return
+	}
+	limit = min(limit, maxTreeLimit)
+
+	t, err := s.manifestTree(c.Request.Context(), c.Param("id"))
+	if err != nil {
+		s.treeError(c, err)
+		return
+	}
+	if s.notModified(c, c.Param("id")+"-tree", true) {
+		return
+	}
+
+	dir := c.Query("path")
+	entries, total, ok := t.list(dir, sortBy, order == "desc", c.Query("filter"), offset, limit)
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{"error": "directory not found: " + dir})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"path":    strings.Trim(dir, "/"),
+		"entries": entries,
+		"total":   total,
+		"offset":  offset,
+		"limit":   limit,
+	})
+}
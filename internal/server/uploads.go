@@ -0,0 +1,179 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/johann/ib/internal/backup"
+	"github.com/johann/ib/internal/cid"
+	"github.com/johann/ib/internal/storage"
+)
+
+const (
+	// maxUploadLength is the largest block a resumable upload takes, a
+	// maximum-size chunk with room for encryption and header overhead
+	maxUploadLength = 2 * backup.MaxChunkSize
+
+	// uploadExpiry is how long an interrupted upload can be resumed
+	uploadExpiry = 24 * time.Hour
+)
+
+// uploadOwner identifies the token of a request as the owner of resumable
+// uploads, so clients uploading the same block keep separate uploads
+func uploadOwner(c *gin.Context) string {
+	if id := c.GetString("token_id"); id != "" {
+		return "token:" + id
+	}
+	return c.GetString("token_name")
+}
+
+// handleGetBlockUpload reports how much of the token's resumable block
+// upload the server has, for the client to continue where it stopped
+func (s *Server) handleGetBlockUpload(c *gin.Context) {
+	up, err := s.storage.GetBlockUpload(c.Request.Context(), uploadOwner(c), c.Param("cid"))
+	if err != nil {
+		if strings.Contains(err.Error(), "not found") {
+			c.JSON(http.StatusNotFound, gin.H{"error": "no upload in progress", "offset": 0})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, up)
+}
+
+// handleAppendBlockUpload adds the body to the token's resumable block
+// upload at X-Upload-Offset, keeping what arrived even if the connection
+// drops. Data received counts against the token's namespace quota. The
+// request that completes X-Upload-Length bytes checks the block against its
+// CID and stores it.
+func (s *Server) handleAppendBlockUpload(c *gin.Context) {
+	blockCID := c.Param("cid")
+	owner := uploadOwner(c)
+	namespace := c.GetString("token_namespace")
+
+	offset, err := strconv.ParseInt(c.GetHeader("X-Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid X-Upload-Offset header"})
+		return
+	}
+	length, err := strconv.ParseInt(c.GetHeader("X-Upload-Length"), 10, 64)
+	if err != nil || length <= 0 || length > maxUploadLength || offset > length {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid X-Upload-Length header"})
+		return
+	}
+	originalSize, _ := strconv.ParseInt(c.GetHeader("X-Original-Size"), 10, 64)
+	compression := c.GetHeader("X-Compression")
+	switch compression {
+	case "", backup.CompressionLZ4, backup.CompressionZstd, backup.CompressionHeader:
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "unsupported X-Compression " + compression})
+		return
+	}
+
+	// What arrived before a dropped connection is kept, so the write must
+	// not be canceled with the request
+	data, readErr := io.ReadAll(http.MaxBytesReader(c.Writer, c.Request.Body, length-offset))
+	ctx := context.WithoutCancel(c.Request.Context())
+	up := &storage.BlockUpload{
+		Owner:        owner,
+		Namespace:    namespace,
+		CID:          blockCID,
+		Length:       length,
+		OriginalSize: originalSize,
+		Compression:  compression,
+	}
+	current, ok, err := s.storage.AppendBlockUpload(ctx, up, offset, data, s.namespaceQuota(namespace))
+	if err != nil {
+		if errors.Is(err, storage.ErrQuotaExceeded) {
+			c.JSON(http.StatusInsufficientStorage, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if !ok {
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("upload is at offset %d with other headers or offset", current), "offset": current})
+		return
+	}
+	s.metrics.bandwidthUpload.Add(float64(len(data)))
+	s.events.recordUpload(c.GetString("token_name"), namespace, 0, int64(len(data)))
+	if readErr != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "failed to read body", "offset": current})
+		return
+	}
+	if current < length {
+		c.JSON(http.StatusOK, gin.H{"offset": current})
+		return
+	}
+
+	data, err = s.storage.BlockUploadData(ctx, owner, blockCID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	if err := s.storage.DeleteBlockUpload(ctx, owner, blockCID); err != nil {
+		logger.Warn("failed to delete finished block upload", "cid", blockCID, "error", err)
+	}
+	if compression == backup.CompressionHeader {
+		h, _, err := backup.ParseBlockHeader(data)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		originalSize = h.OriginalSize
+	}
+	if err := verifyBlockCID(blockCID, data, originalSize, compression); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := s.storage.SaveNamespaceBlock(ctx, namespace, s.namespaceQuota(namespace), blockCID, data, originalSize, compression); err != nil {
+		if errors.Is(err, storage.ErrQuotaExceeded) {
+			c.JSON(http.StatusInsufficientStorage, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	s.metrics.blocksTotal.Inc()
+	s.metrics.storageBytes.Add(float64(len(data)))
+	s.events.recordUpload(c.GetString("token_name"), namespace, 1, 0)
+
+	c.JSON(http.StatusCreated, gin.H{"cid": blockCID})
+}
+
+// verifyBlockCID checks that a block as uploaded, in one request or
+// resumed, decodes to the data its CID was computed from. Encrypted blocks are stored as is, their CID is
+// that of the ciphertext.
+func verifyBlockCID(blockCID string, data []byte, originalSize int64, compression string) error {
+	var original []byte
+	var err error
+	if compression == backup.CompressionHeader {
+		original, err = backup.DecodeBlock(data)
+	} else {
+		original, err = backup.DecompressBlock(compression, data, originalSize)
+		if err != nil && compression == "" {
+			original, err = data, nil // Stored as is
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("failed to decode block: %w", err)
+	}
+
+	got, err := cid.Generate(original)
+	if err != nil {
+		return err
+	}
+	if got != blockCID {
+		return fmt.Errorf("block data doesn't match CID %s", blockCID)
+	}
+	return nil
+}
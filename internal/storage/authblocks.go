@@ -0,0 +1,67 @@
+package storage
+
+import (
+	"context"
+	"time"
+)
+
+// AuthBlock tracks failed authentication attempts from an IP or against a
+// named token
+type AuthBlock struct {
+	Key           string    `json:"key"` // IP address, or "token:<id>@<ip>"
+	Failures      int       `json:"failures"`
+	BlockedUntil  time.Time `json:"blocked_until"`
+	Banned        bool      `json:"banned"` // Blocked until cleared by an admin
+	LastFailureAt time.Time `json:"last_failure_at"`
+}
+
+// SaveAuthBlock inserts or updates a block
+func (s *Storage) SaveAuthBlock(ctx context.Context, b *AuthBlock) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO auth_blocks (key, failures, blocked_until, banned, last_failure_at)
+		VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT(key) DO UPDATE SET
+			failures = excluded.failures,
+			blocked_until = excluded.blocked_until,
+			banned = excluded.banned,
+			last_failure_at = excluded.last_failure_at
+	`, b.Key, b.Failures, b.BlockedUntil.Unix(), b.Banned, b.LastFailureAt.Unix())
+	return err
+}
+
+// ListAuthBlocks returns all tracked blocks
+func (s *Storage) ListAuthBlocks(ctx context.Context) ([]AuthBlock, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT key, failures, blocked_until, banned, last_failure_at
+		FROM auth_blocks ORDER BY last_failure_at DESC
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := make([]AuthBlock, 0)
+	for rows.Next() {
+		var b AuthBlock
+		var blockedUntil, lastFailure int64
+		if err := rows.Scan(&b.Key, &b.Failures, &blockedUntil, &b.Banned, &lastFailure); err != nil {
+			return nil, err
+		}
+		b.BlockedUntil = time.Unix(blockedUntil, 0)
+		b.LastFailureAt = time.Unix(lastFailure, 0)
+		result = append(result, b)
+	}
+	return result, rows.Err()
+}
+
+// DeleteAuthBlock clears a block
+func (s *Storage) DeleteAuthBlock(ctx context.Context, key string) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	_, err := s.db.ExecContext(ctx, `DELETE FROM auth_blocks WHERE key = ?`, key)
+	return err
+}
@@ -0,0 +1,90 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// DedupReport shows how a manifest's blocks are shared with other manifests
+type DedupReport struct {
+	ManifestID      string         `json:"manifest_id"`
+	Blocks          int64          `json:"blocks"`
+	Bytes           int64          `json:"bytes"`          // Stored (compressed) size of its blocks
+	OriginalBytes   int64          `json:"original_bytes"` // Size before compression
+	ExclusiveBlocks int64          `json:"exclusive_blocks"`
+	ExclusiveBytes  int64          `json:"exclusive_bytes"` // Freed by deleting it and collecting garbage
+	Shared          []SharedBlocks `json:"shared"`          // Most shared bytes first
+}
+
+// SharedBlocks is what one other manifest has in common with the reported one
+type SharedBlocks struct {
+	ManifestID string            `json:"manifest_id"`
+	Tags       map[string]string `json:"tags"`
+	CreatedAt  time.Time         `json:"created_at"`
+	Blocks     int64             `json:"blocks"`
+	Bytes      int64             `json:"bytes"`
+}
+
+// ManifestDedup reports how many of a manifest's blocks are shared with
+// each other manifest, and how many only it refers to
+func (s *Storage) ManifestDedup(ctx context.Context, id string) (*DedupReport, error) {
+	var exists int
+	err := s.db.QueryRowContext(ctx, `SELECT 1 FROM manifests WHERE id = ? AND deleted_at = 0`, id).Scan(&exists)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("manifest not found: %s", id)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	r := &DedupReport{ManifestID: id, Shared: []SharedBlocks{}}
+	err = s.db.QueryRowContext(ctx, `
+		SELECT COUNT(*), COALESCE(SUM(b.size), 0), COALESCE(SUM(b.original_size), 0)
+		FROM block_refs r JOIN blocks b ON b.cid = r.cid
+		WHERE r.manifest_id = ?
+	`, id).Scan(&r.Blocks, &r.Bytes, &r.OriginalBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.db.QueryRowContext(ctx, `
+		SELECT COUNT(*), COALESCE(SUM(b.size), 0)
+		FROM block_refs r JOIN blocks b ON b.cid = r.cid
+		WHERE r.manifest_id = ? AND NOT EXISTS (
+			SELECT 1 FROM block_refs o WHERE o.cid = r.cid AND o.manifest_id != r.manifest_id
+		)
+	`, id).Scan(&r.ExclusiveBlocks, &r.ExclusiveBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT o.manifest_id, m.tags, m.created_at, COUNT(*), COALESCE(SUM(b.size), 0) AS bytes
+		FROM block_refs r
+		JOIN block_refs o ON o.cid = r.cid AND o.manifest_id != r.manifest_id
+		JOIN blocks b ON b.cid = r.cid
+		JOIN manifests m ON m.id = o.manifest_id
+		WHERE r.manifest_id = ?
+		GROUP BY o.manifest_id
+		ORDER BY bytes DESC, m.created_at DESC
+	`, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var shared SharedBlocks
+		var tagsJSON string
+		var createdAt int64
+		if err := rows.Scan(&shared.ManifestID, &tagsJSON, &createdAt, &shared.Blocks, &shared.Bytes); err != nil {
+			return nil, err
+		}
+		shared.Tags, _ = deserializeTags(tagsJSON)
+		shared.CreatedAt = time.Unix(createdAt, 0)
+		r.Shared = append(r.Shared, shared)
+	}
+	return r, rows.Err()
+}
@@ -0,0 +1,252 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"reflect"
+
+	"github.com/johann/ib/internal/backup"
+)
+
+// maxDeltaChain is how many deltas may be stacked on a full manifest.
+// Reading a manifest applies its whole chain, so every so often a full copy
+// is stored instead.
+const maxDeltaChain = 16
+
+// manifestDelta is a manifest stored against its parent: the manifest
+// without entries, plus the edits that turn the parent's entries into its
+// own. Runs of unchanged entries are copied from the parent, so the entry
+// order comes out exactly as it was uploaded.
+type manifestDelta struct {
+	Manifest backup.Manifest `json:"manifest"` // Entries is nil
+	Ops      []deltaOp       `json:"ops"`
+}
+
+// deltaOp either copies Count parent entries starting at From, or inserts
+// Entries
+type deltaOp struct {
+	From    int            `json:"from,omitempty"`
+	Count   int            `json:"count,omitempty"`
+	Entries []backup.Entry `json:"entries,omitempty"`
+}
+
+// diffManifest returns the delta from parent to m
+func diffManifest(parent, m *backup.Manifest) *manifestDelta {
+	index := make(map[string]int, len(parent.Entries))
+	for i, e := range parent.Entries {
+		index[e.Path] = i
+	}
+
+	d := &manifestDelta{Manifest: *m}
+	d.Manifest.Entries = nil
+	var op *deltaOp
+	for _, e := range m.Entries {
+		i, ok := index[e.Path]
+		if ok && reflect.DeepEqual(parent.Entries[i], e) {
+			if op != nil && op.Entries == nil && op.From+op.Count == i {
+				op.Count++
+				continue
+			}
+			d.Ops = append(d.Ops, deltaOp{From: i, Count: 1})
+		} else {
+			if op != nil && op.Entries != nil {
+				op.Entries = append(op.Entries, e)
+				continue
+			}
+			d.Ops = append(d.Ops, deltaOp{Entries: []backup.Entry{e}})
+		}
+		op = &d.Ops[len(d.Ops)-1]
+	}
+	return d
+}
+
+// apply rebuilds the manifest from its parent
+func (d *manifestDelta) apply(parent *backup.Manifest) (*backup.Manifest, error) {
+	m := d.Manifest
+	m.Entries = make([]backup.Entry, 0, len(parent.Entries))
+	for _, op := range d.Ops {
+		if op.Entries != nil {
+			m.Entries = append(m.Entries, op.Entries...)
+			continue
+		}
+		if op.From < 0 || op.Count < 0 || op.From+op.Count > len(parent.Entries) {
+			return nil, fmt.Errorf("manifest delta doesn't match its parent %s", parent.ID)
+		}
+		m.Entries = append(m.Entries, parent.Entries[op.From:op.From+op.Count]...)
+	}
+	return &m, nil
+}
+
+// encodeManifest serializes and compresses a manifest the way the server
+// uploads it
+func encodeManifest(m *backup.Manifest) ([]byte, error) {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return nil, fmt.Errorf("failed to serialize manifest: %w", err)
+	}
+	return compress(data), nil
+}
+
+func decodeManifest(data []byte) (*backup.Manifest, error) {
+	decompressed, err := backup.Decompress(data, int64(len(data)*10)) // Estimate
+	if err != nil {
+		// Might not be compressed
+		decompressed = data
+	}
+	var m backup.Manifest
+	if err := json.Unmarshal(decompressed, &m); err != nil {
+		return nil, fmt.Errorf("failed to parse manifest: %w", err)
+	}
+	return &m, nil
+}
+
+func compress(data []byte) []byte {
+	compressed := make([]byte, len(data))
+	n, err := backup.CompressBlock(data, compressed)
+	if err != nil || n >= len(data) {
+		return data
+	}
+	return compressed[:n]
+}
+
+// encodeDelta returns the stored form of a delta and its uncompressed size
+func encodeDelta(d *manifestDelta) ([]byte, int64, error) {
+	data, err := json.Marshal(d)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to serialize manifest delta: %w", err)
+	}
+	return compress(data), int64(len(data)), nil
+}
+
+func decodeDelta(data []byte, size int64) (*manifestDelta, error) {
+	decompressed, err := backup.Decompress(data, size)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress manifest delta: %w", err)
+	}
+	var d manifestDelta
+	if err := json.Unmarshal(decompressed, &d); err != nil {
+		return nil, fmt.Errorf("failed to parse manifest delta: %w", err)
+	}
+	return &d, nil
+}
+
+// deltaParent picks the manifest a new one is stored against: the latest
+// earlier manifest with exactly the same tags, unless its delta chain is
+// already at maxDeltaChain
+func (s *Storage) deltaParent(ctx context.Context, m *backup.Manifest) (*backup.Manifest, error) {
+	candidates, err := s.ListManifests(ctx, m.Tags)
+	if err != nil {
+		return nil, err
+	}
+	var parentID string
+	for _, c := range candidates {
+		if c.ID != m.ID && len(c.Tags) == len(m.Tags) && !c.CreatedAt.After(m.CreatedAt) {
+			parentID = c.ID
+			break
+		}
+	}
+	if parentID == "" {
+		return nil, nil
+	}
+
+	depth, err := s.deltaDepth(ctx, parentID)
+	if err != nil || depth >= maxDeltaChain {
+		return nil, err
+	}
+	parent, err := s.loadManifest(ctx, parentID)
+	if err != nil || parent.Encrypted != nil {
+		return nil, err
+	}
+	return parent, nil
+}
+
+// deltaDepth returns how many deltas lie between a manifest and the full
+// manifest its chain starts from
+func (s *Storage) deltaDepth(ctx context.Context, id string) (int, error) {
+	var depth int
+	err := s.db.QueryRowContext(ctx, `
+		WITH RECURSIVE chain(id, parent_id) AS (
+			SELECT id, parent_id FROM manifests WHERE id = ?
+			UNION ALL
+			SELECT m.id, m.parent_id FROM manifests m JOIN chain c ON m.id = c.parent_id
+		)
+		SELECT COUNT(*) - 1 FROM chain
+	`, id).Scan(&depth)
+	return depth, err
+}
+
+// loadManifest reads a manifest, applying its delta chain
+func (s *Storage) loadManifest(ctx context.Context, id string) (*backup.Manifest, error) {
+	var data []byte
+	var parentID string
+	var size int64
+	err := s.db.QueryRowContext(ctx, `
+		SELECT data, parent_id, data_size FROM manifests WHERE id = ?
+	`, id).Scan(&data, &parentID, &size)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("manifest not found: %s", id)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if parentID == "" {
+		return decodeManifest(data)
+	}
+
+	d, err := decodeDelta(data, size)
+	if err != nil {
+		return nil, fmt.Errorf("manifest %s: %w", id, err)
+	}
+	parent, err := s.loadManifest(ctx, parentID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load parent of manifest %s: %w", id, err)
+	}
+	return d.apply(parent)
+}
+
+// flattenChildrenLocked stores the children of the given manifests as full
+// manifests, so they survive their parents being deleted. Children that
+// are being deleted too are left alone. Must be called with writeMu held.
+func (s *Storage) flattenChildrenLocked(ctx context.Context, ids map[string]bool) error {
+	rows, err := s.db.QueryContext(ctx, `SELECT id, parent_id FROM manifests WHERE parent_id != ''`)
+	if err != nil {
+		return err
+	}
+	var children []string
+	for rows.Next() {
+		var id, parentID string
+		if err := rows.Scan(&id, &parentID); err != nil {
+			rows.Close()
+			return err
+		}
+		if ids[parentID] && !ids[id] {
+			children = append(children, id)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, id := range children {
+		m, err := s.loadManifest(ctx, id)
+		if err != nil {
+			return err
+		}
+		data, err := encodeManifest(m)
+		if err != nil {
+			return err
+		}
+		if _, err := s.db.ExecContext(ctx, `
+			UPDATE manifests SET data = ?, parent_id = '', data_size = 0 WHERE id = ?
+		`, data, id); err != nil {
+			return err
+		}
+	}
+	if len(children) > 0 {
+		logger.Info("stored delta manifests in full", "count", len(children))
+	}
+	return nil
+}
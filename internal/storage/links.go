@@ -0,0 +1,78 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+// DownloadLink is a signed URL handed out for a download endpoint
+type DownloadLink struct {
+	ID           string    `json:"id"`
+	Path         string    `json:"path"`
+	ExpiresAt    time.Time `json:"expires_at"`
+	MaxDownloads int       `json:"max_downloads"` // 0 = unlimited
+	Downloads    int       `json:"downloads"`
+	CreatedBy    string    `json:"created_by"` // Token name
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// CreateDownloadLink stores a new download link
+func (s *Storage) CreateDownloadLink(ctx context.Context, link *DownloadLink) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO download_links (id, path, expires_at, max_downloads, created_by, created_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`, link.ID, link.Path, link.ExpiresAt.Unix(), link.MaxDownloads, link.CreatedBy, link.CreatedAt.Unix())
+	return err
+}
+
+// UseDownloadLink counts a download against a link. Returns false if the
+// link doesn't exist, has expired or has no downloads left.
+func (s *Storage) UseDownloadLink(ctx context.Context, id string, now time.Time) (bool, error) {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	result, err := s.db.ExecContext(ctx, `
+		UPDATE download_links SET downloads = downloads + 1
+		WHERE id = ? AND expires_at > ? AND (max_downloads = 0 OR downloads < max_downloads)
+	`, id, now.Unix())
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+// PruneDownloadLinks removes links that expired before cutoff
+func (s *Storage) PruneDownloadLinks(ctx context.Context, cutoff time.Time) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	_, err := s.db.ExecContext(ctx, `DELETE FROM download_links WHERE expires_at < ?`, cutoff.Unix())
+	return err
+}
+
+// GetSetting returns a server setting, or "" if it isn't set
+func (s *Storage) GetSetting(ctx context.Context, key string) (string, error) {
+	var value string
+	err := s.db.QueryRowContext(ctx, `SELECT value FROM settings WHERE key = ?`, key).Scan(&value)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return value, err
+}
+
+// SetSetting stores a server setting
+func (s *Storage) SetSetting(ctx context.Context, key, value string) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO settings (key, value) VALUES (?, ?)
+		ON CONFLICT(key) DO UPDATE SET value = excluded.value
+	`, key, value)
+	return err
+}
@@ -0,0 +1,100 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+// ErrQuotaExceeded is returned for blocks that would take a namespace past
+// its storage quota
+var ErrQuotaExceeded = errors.New("namespace quota exceeded")
+
+// NamespaceUsage returns the bytes stored by blocks first uploaded into a
+// namespace. Blocks other namespaces reference as well still count only
+// against the one that uploaded them.
+func (s *Storage) NamespaceUsage(ctx context.Context, namespace string) (int64, error) {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	return s.namespaceUsageTx(ctx, s.db, namespace)
+}
+
+// rowQuerier is the database or a transaction
+type rowQuerier interface {
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// namespaceUsageTx is NamespaceUsage within a transaction. Must be called
+// with writeMu held.
+func (s *Storage) namespaceUsageTx(ctx context.Context, q rowQuerier, namespace string) (int64, error) {
+	if used, ok := s.nsUsage[namespace]; ok {
+		return used, nil
+	}
+	var used int64
+	if err := q.QueryRowContext(ctx, `SELECT COALESCE(SUM(size), 0) FROM blocks WHERE namespace = ?`, namespace).Scan(&used); err != nil {
+		return 0, err
+	}
+	if s.nsUsage == nil {
+		s.nsUsage = make(map[string]int64)
+	}
+	s.nsUsage[namespace] = used
+	return used, nil
+}
+
+// checkQuotaTx returns ErrQuotaExceeded if block cid of size bytes is new
+// and would take namespace past quota, counting the quota reserved for
+// uploads in progress. Must be called with writeMu held.
+func (s *Storage) checkQuotaTx(ctx context.Context, q rowQuerier, namespace string, quota int64, cid string, size int64) error {
+	var exists int
+	if err := q.QueryRowContext(ctx, `SELECT COUNT(*) FROM blocks WHERE cid = ?`, cid).Scan(&exists); err != nil {
+		return err
+	}
+	if exists > 0 {
+		return nil
+	}
+	used, err := s.namespaceUsageTx(ctx, q, namespace)
+	if err != nil {
+		return err
+	}
+	used += s.nsReserved[namespace]
+	if used+size > quota {
+		return fmt.Errorf("%w: namespace %s uses %d of %d bytes", ErrQuotaExceeded, namespace, used, quota)
+	}
+	return nil
+}
+
+// reserveQuota takes size bytes of namespace's quota for block cid while
+// it is uploaded, returning how much it took: nothing if the block is
+// stored already. The caller gives it back once the block is saved or the
+// upload failed.
+func (s *Storage) reserveQuota(ctx context.Context, namespace string, quota int64, cid string, size int64) (int64, error) {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	var exists int
+	if err := s.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM blocks WHERE cid = ?`, cid).Scan(&exists); err != nil {
+		return 0, err
+	}
+	if exists > 0 {
+		return 0, nil
+	}
+	if err := s.checkQuotaTx(ctx, s.db, namespace, quota, cid, size); err != nil {
+		return 0, err
+	}
+	if s.nsReserved == nil {
+		s.nsReserved = make(map[string]int64)
+	}
+	s.nsReserved[namespace] += size
+	return size, nil
+}
+
+// ManifestName returns the name tag of a manifest, in the trash or not
+func (s *Storage) ManifestName(ctx context.Context, id string) (string, error) {
+	var name string
+	err := s.db.QueryRowContext(ctx, `SELECT COALESCE(json_extract(tags, '$.name'), '') FROM manifests WHERE id = ?`, id).Scan(&name)
+	if err == sql.ErrNoRows {
+		return "", fmt.Errorf("manifest not found: %s", id)
+	}
+	return name, err
+}
@@ -0,0 +1,131 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/johann/ib/internal/backup"
+)
+
+// DefaultPolicy is the name of the retention policy for backup names
+// without their own
+const DefaultPolicy = "*"
+
+// RetentionPolicies returns the retention policies set through the API, by
+// backup name
+func (s *Storage) RetentionPolicies(ctx context.Context) (map[string]backup.RetentionPolicy, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT name, keep_last, keep_daily, keep_weekly, keep_monthly FROM retention_policies`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	policies := make(map[string]backup.RetentionPolicy)
+	for rows.Next() {
+		var name string
+		var p backup.RetentionPolicy
+		if err := rows.Scan(&name, &p.Last, &p.Daily, &p.Weekly, &p.Monthly); err != nil {
+			return nil, err
+		}
+		policies[name] = p
+	}
+	return policies, rows.Err()
+}
+
+// SetRetentionPolicy sets the retention policy of a backup name, replacing
+// any it had
+func (s *Storage) SetRetentionPolicy(ctx context.Context, name string, p backup.RetentionPolicy) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO retention_policies (name, keep_last, keep_daily, keep_weekly, keep_monthly, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT(name) DO UPDATE SET keep_last = excluded.keep_last, keep_daily = excluded.keep_daily,
+			keep_weekly = excluded.keep_weekly, keep_monthly = excluded.keep_monthly, updated_at = excluded.updated_at
+	`, name, p.Last, p.Daily, p.Weekly, p.Monthly, time.Now().Unix())
+	return err
+}
+
+// DeleteRetentionPolicy removes the retention policy set for a backup name
+func (s *Storage) DeleteRetentionPolicy(ctx context.Context, name string) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	res, err := s.db.ExecContext(ctx, `DELETE FROM retention_policies WHERE name = ?`, name)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("retention policy not found: %s", name)
+	}
+	return nil
+}
+
+// expiredManifestsLocked returns the manifests the prune options remove,
+// oldest first. Must be called with writeMu held.
+func (s *Storage) expiredManifestsLocked(ctx context.Context, opts PruneOptions) ([]ManifestInfo, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT id, tags, created_at, deleted_at FROM manifests ORDER BY created_at`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var expired []ManifestInfo
+	byName := make(map[string][]ManifestInfo) // Live manifests with a policy
+	for rows.Next() {
+		var info ManifestInfo
+		var tagsJSON string
+		var createdAt, deletedAt int64
+		if err := rows.Scan(&info.ID, &tagsJSON, &createdAt, &deletedAt); err != nil {
+			return nil, err
+		}
+		info.Tags, _ = deserializeTags(tagsJSON)
+		info.CreatedAt = time.Unix(createdAt, 0)
+
+		if deletedAt != 0 {
+			if deletedAt < opts.TrashCutoff.Unix() {
+				expired = append(expired, info)
+			}
+			continue
+		}
+		name := info.Tags["name"]
+		if _, ok := policyFor(opts.Policies, name); ok {
+			byName[name] = append(byName[name], info)
+		} else if createdAt < opts.Cutoff.Unix() {
+			expired = append(expired, info)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for name, infos := range byName {
+		policy, _ := policyFor(opts.Policies, name)
+		created := make([]time.Time, len(infos))
+		for i, info := range infos {
+			created[i] = info.CreatedAt
+		}
+		for i, keep := range policy.Keep(created) {
+			if !keep {
+				expired = append(expired, infos[i])
+			}
+		}
+	}
+	sort.SliceStable(expired, func(a, b int) bool {
+		return expired[a].CreatedAt.Before(expired[b].CreatedAt)
+	})
+	return expired, nil
+}
+
+// policyFor returns the retention policy of a backup name, falling back to
+// DefaultPolicy
+func policyFor(policies map[string]backup.RetentionPolicy, name string) (backup.RetentionPolicy, bool) {
+	if p, ok := policies[name]; ok {
+		return p, true
+	}
+	p, ok := policies[DefaultPolicy]
+	return p, ok
+}
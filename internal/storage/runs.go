@@ -0,0 +1,105 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/johann/ib/internal/backup"
+)
+
+// BackupRun is a client-reported backup run summary
+type BackupRun struct {
+	backup.RunSummary
+	ID         int64     `json:"id"`
+	ReportedBy string    `json:"reported_by"` // Token name
+	ReportedAt time.Time `json:"reported_at"`
+}
+
+const runColumns = `id, manifest_id, tags, started_at, duration_ms, total_files, unchanged_files,
+	error_files, total_bytes, uploaded_bytes, dedup_bytes, blocks_uploaded, blocks_deduped,
+	error, reported_by, reported_at`
+
+// SaveBackupRun stores a run summary and sets its ID
+func (s *Storage) SaveBackupRun(ctx context.Context, run *BackupRun) error {
+	tagsJSON, err := serializeTags(run.Tags)
+	if err != nil {
+		return fmt.Errorf("failed to serialize tags: %w", err)
+	}
+
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	result, err := s.db.ExecContext(ctx, `
+		INSERT INTO backup_runs (manifest_id, tags, started_at, duration_ms, total_files, unchanged_files,
+			error_files, total_bytes, uploaded_bytes, dedup_bytes, blocks_uploaded, blocks_deduped,
+			error, reported_by, reported_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, run.ManifestID, tagsJSON, run.StartedAt.Unix(), run.DurationMs, run.TotalFiles, run.UnchangedFiles,
+		run.ErrorFiles, run.TotalBytes, run.UploadedBytes, run.DedupBytes, run.BlocksUploaded, run.BlocksDeduped,
+		run.Error, run.ReportedBy, run.ReportedAt.Unix())
+	if err != nil {
+		return err
+	}
+	run.ID, err = result.LastInsertId()
+	return err
+}
+
+// GetManifestRun returns the run that produced a manifest
+func (s *Storage) GetManifestRun(ctx context.Context, manifestID string) (*BackupRun, error) {
+	row := s.db.QueryRowContext(ctx, `SELECT `+runColumns+` FROM backup_runs
+		WHERE manifest_id = ? ORDER BY id DESC LIMIT 1`, manifestID)
+	run, err := scanBackupRun(row)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("run not found for manifest: %s", manifestID)
+	}
+	return run, err
+}
+
+// ListBackupRuns lists the most recent runs first, optionally filtered by
+// tags and namespace
+func (s *Storage) ListBackupRuns(ctx context.Context, tags map[string]string, namespace string, limit int) ([]BackupRun, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT `+runColumns+` FROM backup_runs ORDER BY id DESC`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []BackupRun
+	for rows.Next() && len(result) < limit {
+		run, err := scanBackupRun(rows)
+		if err != nil {
+			return nil, err
+		}
+		if matchesTags(run.Tags, tags) && backup.InNamespace(run.Tags["name"], namespace) {
+			result = append(result, *run)
+		}
+	}
+	return result, rows.Err()
+}
+
+// PruneBackupRuns removes runs reported before cutoff
+func (s *Storage) PruneBackupRuns(ctx context.Context, cutoff time.Time) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	_, err := s.db.ExecContext(ctx, `DELETE FROM backup_runs WHERE reported_at < ?`, cutoff.Unix())
+	return err
+}
+
+func scanBackupRun(row rowScanner) (*BackupRun, error) {
+	var run BackupRun
+	var tagsJSON string
+	var startedAt, reportedAt int64
+	err := row.Scan(&run.ID, &run.ManifestID, &tagsJSON, &startedAt, &run.DurationMs, &run.TotalFiles,
+		&run.UnchangedFiles, &run.ErrorFiles, &run.TotalBytes, &run.UploadedBytes, &run.DedupBytes,
+		&run.BlocksUploaded, &run.BlocksDeduped, &run.Error, &run.ReportedBy, &reportedAt)
+	if err != nil {
+		return nil, err
+	}
+	run.Tags, _ = deserializeTags(tagsJSON)
+	run.StartedAt = time.Unix(startedAt, 0)
+	run.ReportedAt = time.Unix(reportedAt, 0)
+	return &run, nil
+}
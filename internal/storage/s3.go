@@ -11,6 +11,10 @@ import (
 	"github.com/aws/aws-sdk-go-v2/credentials"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	ibconfig "github.com/johann/ib/internal/config"
+	"github.com/johann/ib/internal/secrets"
+	"github.com/johann/ib/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // S3Client wraps the AWS S3 client
@@ -35,11 +39,7 @@ func NewS3Client(cfg *ibconfig.ServerConfig) (*S3Client, error) {
 
 	awsCfg, err := config.LoadDefaultConfig(context.Background(),
 		config.WithRegion(cfg.S3Region),
-		config.WithCredentialsProvider(credentials.NewStaticCredentialsProvider(
-			cfg.S3AccessKey,
-			cfg.S3SecretKey,
-			"",
-		)),
+		config.WithCredentialsProvider(s3Credentials(cfg)),
 		config.WithEndpointResolverWithOptions(customResolver),
 	)
 	if err != nil {
@@ -56,9 +56,49 @@ func NewS3Client(cfg *ibconfig.ServerConfig) (*S3Client, error) {
 	}, nil
 }
 
+// s3Credentials returns static credentials, or a provider that fetches
+// them from a secret store and refreshes them when they expire
+func s3Credentials(cfg *ibconfig.ServerConfig) aws.CredentialsProvider {
+	if !secrets.IsRef(cfg.S3AccessKey) && !secrets.IsRef(cfg.S3SecretKey) {
+		return credentials.NewStaticCredentialsProvider(cfg.S3AccessKey, cfg.S3SecretKey, "")
+	}
+
+	return aws.NewCredentialsCache(aws.CredentialsProviderFunc(func(ctx context.Context) (aws.Credentials, error) {
+		accessKey, accessExpires, err := secrets.Resolve(ctx, cfg.S3AccessKey)
+		if err != nil {
+			return aws.Credentials{}, err
+		}
+		secretKey, secretExpires, err := secrets.Resolve(ctx, cfg.S3SecretKey)
+		if err != nil {
+			return aws.Credentials{}, err
+		}
+
+		expires := accessExpires
+		if expires.IsZero() || (!secretExpires.IsZero() && secretExpires.Before(expires)) {
+			expires = secretExpires
+		}
+		return aws.Credentials{
+			AccessKeyID:     accessKey,
+			SecretAccessKey: secretKey,
+			Source:          "ib secrets",
+			CanExpire:       true,
+			Expires:         expires,
+		}, nil
+	}))
+}
+
+func startS3(ctx context.Context, op, key string) (context.Context, trace.Span) {
+	return tracer.Start(ctx, "s3."+op, trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.String("s3.key", key)))
+}
+
 // Put uploads data to S3
-func (c *S3Client) Put(ctx context.Context, key string, data []byte) error {
-	_, err := c.client.PutObject(ctx, &s3.PutObjectInput{
+func (c *S3Client) Put(ctx context.Context, key string, data []byte) (err error) {
+	ctx, span := startS3(ctx, "PutObject", key)
+	span.SetAttributes(attribute.Int("s3.size", len(data)))
+	defer func() { tracing.End(span, err) }()
+
+	_, err = c.client.PutObject(ctx, &s3.PutObjectInput{
 		Bucket: aws.String(c.bucket),
 		Key:    aws.String(key),
 		Body:   bytes.NewReader(data),
@@ -67,7 +107,10 @@ func (c *S3Client) Put(ctx context.Context, key string, data []byte) error {
 }
 
 // Get downloads data from S3
-func (c *S3Client) Get(ctx context.Context, key string) ([]byte, error) {
+func (c *S3Client) Get(ctx context.Context, key string) (data []byte, err error) {
+	ctx, span := startS3(ctx, "GetObject", key)
+	defer func() { tracing.End(span, err) }()
+
 	result, err := c.client.GetObject(ctx, &s3.GetObjectInput{
 		Bucket: aws.String(c.bucket),
 		Key:    aws.String(key),
@@ -80,9 +123,28 @@ func (c *S3Client) Get(ctx context.Context, key string) ([]byte, error) {
 	return io.ReadAll(result.Body)
 }
 
+// GetReader returns the body of an object for streaming. The caller
+// closes it.
+func (c *S3Client) GetReader(ctx context.Context, key string) (body io.ReadCloser, err error) {
+	ctx, span := startS3(ctx, "GetObject", key)
+	defer func() { tracing.End(span, err) }()
+
+	result, err := c.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(c.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result.Body, nil
+}
+
 // Delete removes an object from S3
-func (c *S3Client) Delete(ctx context.Context, key string) error {
-	_, err := c.client.DeleteObject(ctx, &s3.DeleteObjectInput{
+func (c *S3Client) Delete(ctx context.Context, key string) (err error) {
+	ctx, span := startS3(ctx, "DeleteObject", key)
+	defer func() { tracing.End(span, err) }()
+
+	_, err = c.client.DeleteObject(ctx, &s3.DeleteObjectInput{
 		Bucket: aws.String(c.bucket),
 		Key:    aws.String(key),
 	})
@@ -91,6 +153,9 @@ func (c *S3Client) Delete(ctx context.Context, key string) error {
 
 // Exists checks if an object exists in S3
 func (c *S3Client) Exists(ctx context.Context, key string) (bool, error) {
+	ctx, span := startS3(ctx, "HeadObject", key)
+	defer span.End()
+
 	_, err := c.client.HeadObject(ctx, &s3.HeadObjectInput{
 		Bucket: aws.String(c.bucket),
 		Key:    aws.String(key),
@@ -101,3 +166,23 @@ func (c *S3Client) Exists(ctx context.Context, key string) (bool, error) {
 	}
 	return true, nil
 }
+
+// CheckAccess verifies the credentials by writing, reading back and
+// deleting a small probe object
+func (c *S3Client) CheckAccess(ctx context.Context) error {
+	if _, err := c.client.HeadBucket(ctx, &s3.HeadBucketInput{Bucket: aws.String(c.bucket)}); err != nil {
+		return fmt.Errorf("bucket %q is not accessible: %w", c.bucket, err)
+	}
+
+	const key = ".ib-config-validate"
+	if err := c.Put(ctx, key, []byte("ok")); err != nil {
+		return fmt.Errorf("write failed: %w", err)
+	}
+	if _, err := c.Get(ctx, key); err != nil {
+		return fmt.Errorf("read failed: %w", err)
+	}
+	if err := c.Delete(ctx, key); err != nil {
+		return fmt.Errorf("delete failed: %w", err)
+	}
+	return nil
+}
@@ -1,18 +1,26 @@
 package storage
 
 import (
+	"bytes"
 	"context"
 	"database/sql"
+	"encoding/json"
 	"fmt"
+	"io"
+	"maps"
+	"slices"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/johann/ib/internal/backup"
 	"github.com/johann/ib/internal/config"
+	"github.com/johann/ib/internal/logging"
 	_ "modernc.org/sqlite"
 )
 
+var logger = logging.For("storage")
+
 const (
 	// InlineThreshold is the max size for inline storage in SQLite
 	InlineThreshold = 256 * 1024 // 256KB
@@ -21,6 +29,11 @@ const (
 	S3PathPrefix = "backups/ib"
 )
 
+// orphanGracePeriod keeps unreferenced blocks and nodes this young: clients
+// upload blocks before the manifest that refers to them, so they may
+// belong to a backup still running
+const orphanGracePeriod = time.Hour
+
 // blockS3Key generates the S3 key for a block: backups/ib/{hash prefix}/{cid}.lz4
 // Uses 2 chars from position 8 of the CID (in the hash portion, after "bafkrei")
 func blockS3Key(cid string) string {
@@ -33,10 +46,16 @@ func blockS3Key(cid string) string {
 
 // Storage handles manifest and block persistence
 type Storage struct {
-	db      *sql.DB
+	db      *tracedDB
 	s3      *S3Client
 	cfg     *config.ServerConfig
 	writeMu sync.Mutex // Serialize write operations
+
+	// Bytes stored per namespace, filled as namespaces are looked up and
+	// dropped when blocks are deleted, and bytes of the quota reserved by
+	// blocks being uploaded to S3. Guarded by writeMu.
+	nsUsage    map[string]int64
+	nsReserved map[string]int64
 }
 
 // New creates a new storage instance
@@ -57,7 +76,7 @@ func New(cfg *config.ServerConfig) (*Storage, error) {
 	}
 
 	s := &Storage{
-		db:  db,
+		db:  &tracedDB{db},
 		cfg: cfg,
 	}
 
@@ -77,6 +96,19 @@ func New(cfg *config.ServerConfig) (*Storage, error) {
 }
 
 func (s *Storage) migrate() error {
+	// Resumable uploads used to be keyed by CID alone, so clients uploading
+	// the same block reset each other. The primary key can't be changed in
+	// place; uploads in progress are dropped and start over.
+	var owned int
+	if err := s.db.QueryRow(`SELECT COUNT(*) FROM pragma_table_info('block_uploads') WHERE name = 'owner'`).Scan(&owned); err != nil {
+		return err
+	}
+	if owned == 0 {
+		if _, err := s.db.Exec(`DROP TABLE IF EXISTS block_upload_parts; DROP TABLE IF EXISTS block_uploads`); err != nil {
+			return err
+		}
+	}
+
 	schema := `
 	CREATE TABLE IF NOT EXISTS blocks (
 		cid TEXT PRIMARY KEY,
@@ -116,10 +148,133 @@ func (s *Storage) migrate() error {
 		PRIMARY KEY (manifest_id, cid),
 		FOREIGN KEY (manifest_id) REFERENCES manifests(id) ON DELETE CASCADE
 	);
+
+	CREATE TABLE IF NOT EXISTS tokens (
+		id TEXT PRIMARY KEY,
+		name TEXT NOT NULL UNIQUE,
+		hash TEXT NOT NULL,
+		scope TEXT NOT NULL,
+		created_at INTEGER NOT NULL,
+		last_used_at INTEGER NOT NULL DEFAULT 0
+	);
+
+	CREATE TABLE IF NOT EXISTS auth_blocks (
+		key TEXT PRIMARY KEY,
+		failures INTEGER NOT NULL,
+		blocked_until INTEGER NOT NULL,
+		banned INTEGER NOT NULL DEFAULT 0,
+		last_failure_at INTEGER NOT NULL
+	);
+
+	CREATE TABLE IF NOT EXISTS settings (
+		key TEXT PRIMARY KEY,
+		value TEXT NOT NULL
+	);
+
+	CREATE TABLE IF NOT EXISTS download_links (
+		id TEXT PRIMARY KEY,
+		path TEXT NOT NULL,
+		expires_at INTEGER NOT NULL,
+		max_downloads INTEGER NOT NULL DEFAULT 0,
+		downloads INTEGER NOT NULL DEFAULT 0,
+		created_by TEXT NOT NULL,
+		created_at INTEGER NOT NULL
+	);
+
+	CREATE TABLE IF NOT EXISTS backup_runs (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		manifest_id TEXT NOT NULL DEFAULT '',
+		tags TEXT NOT NULL,
+		started_at INTEGER NOT NULL,
+		duration_ms INTEGER NOT NULL,
+		total_files INTEGER NOT NULL,
+		unchanged_files INTEGER NOT NULL,
+		error_files INTEGER NOT NULL,
+		total_bytes INTEGER NOT NULL,
+		uploaded_bytes INTEGER NOT NULL,
+		dedup_bytes INTEGER NOT NULL,
+		blocks_uploaded INTEGER NOT NULL,
+		blocks_deduped INTEGER NOT NULL,
+		error TEXT NOT NULL DEFAULT '',
+		reported_by TEXT NOT NULL,
+		reported_at INTEGER NOT NULL
+	);
+
+	CREATE INDEX IF NOT EXISTS idx_backup_runs_manifest ON backup_runs(manifest_id);
+
+	CREATE TABLE IF NOT EXISTS block_uploads (
+		owner TEXT NOT NULL,
+		cid TEXT NOT NULL,
+		namespace TEXT NOT NULL DEFAULT '',
+		length INTEGER NOT NULL,
+		original_size INTEGER NOT NULL,
+		compression TEXT NOT NULL,
+		updated_at INTEGER NOT NULL,
+		PRIMARY KEY (owner, cid)
+	);
+	CREATE INDEX IF NOT EXISTS idx_block_uploads_namespace ON block_uploads(namespace);
+
+	CREATE TABLE IF NOT EXISTS block_upload_parts (
+		owner TEXT NOT NULL,
+		cid TEXT NOT NULL,
+		pos INTEGER NOT NULL,
+		data BLOB NOT NULL,
+		PRIMARY KEY (owner, cid, pos)
+	);
+
+	CREATE TABLE IF NOT EXISTS retention_policies (
+		name TEXT PRIMARY KEY,
+		keep_last INTEGER NOT NULL,
+		keep_daily INTEGER NOT NULL,
+		keep_weekly INTEGER NOT NULL,
+		keep_monthly INTEGER NOT NULL,
+		updated_at INTEGER NOT NULL
+	);
 	`
 
-	_, err := s.db.Exec(schema)
-	return err
+	if _, err := s.db.Exec(schema); err != nil {
+		return err
+	}
+
+	// Columns added after the table was first created
+	columns := []string{
+		`ALTER TABLE manifests ADD COLUMN parent_id TEXT NOT NULL DEFAULT ''`,    // Set for delta manifests
+		`ALTER TABLE manifests ADD COLUMN data_size INTEGER NOT NULL DEFAULT 0`,  // Uncompressed size of a delta
+		`ALTER TABLE manifests ADD COLUMN deleted_at INTEGER NOT NULL DEFAULT 0`, // Set while in the trash
+		`ALTER TABLE blocks ADD COLUMN compression TEXT NOT NULL DEFAULT ''`,     // Codec of compressed blocks, empty for LZ4
+		`ALTER TABLE tokens ADD COLUMN expires_at INTEGER NOT NULL DEFAULT 0`,    // Zero for tokens that don't expire
+		`ALTER TABLE tokens ADD COLUMN namespace TEXT NOT NULL DEFAULT ''`,       // Backup names the token is limited to
+		`ALTER TABLE blocks ADD COLUMN namespace TEXT NOT NULL DEFAULT ''`,       // Namespace whose quota the block counts against
+		`ALTER TABLE tokens ADD COLUMN append_only INTEGER NOT NULL DEFAULT 0`,   // Set for tokens that can't delete or manage tokens
+		`ALTER TABLE tokens ADD COLUMN signing_key TEXT NOT NULL DEFAULT ''`,     // Set for tokens that sign requests
+	}
+	for _, stmt := range columns {
+		if _, err := s.db.Exec(stmt); err != nil && !strings.Contains(err.Error(), "duplicate column") {
+			return err
+		}
+	}
+	if _, err := s.db.Exec(`CREATE INDEX IF NOT EXISTS idx_manifests_parent ON manifests(parent_id)`); err != nil {
+		return err
+	}
+	if _, err := s.db.Exec(`CREATE INDEX IF NOT EXISTS idx_manifests_deleted_at ON manifests(deleted_at)`); err != nil {
+		return err
+	}
+	if _, err := s.db.Exec(`CREATE INDEX IF NOT EXISTS idx_blocks_namespace ON blocks(namespace)`); err != nil {
+		return err
+	}
+
+	// Index the tags every client adds, so fleets can be filtered by host
+	for _, key := range backup.HostTagKeys {
+		if _, err := s.db.Exec(fmt.Sprintf(`CREATE INDEX IF NOT EXISTS idx_manifests_tag_%s ON manifests(%s)`, key, tagExpr(key))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// tagExpr is the SQL expression for a tag's value, matching the tag indexes
+func tagExpr(key string) string {
+	return fmt.Sprintf(`json_extract(tags, '$.%s')`, key)
 }
 
 // Close closes the storage
@@ -127,56 +282,140 @@ func (s *Storage) Close() error {
 	return s.db.Close()
 }
 
-// SaveBlock saves a block to storage
-func (s *Storage) SaveBlock(ctx context.Context, cid string, data []byte, originalSize int64) error {
+// SaveBlock saves a block to storage. compression is the codec of data if
+// it is smaller than originalSize, empty for LZ4, or
+// backup.CompressionHeader if data starts with a header.
+func (s *Storage) SaveBlock(ctx context.Context, cid string, data []byte, originalSize int64, compression string) error {
+	return s.SaveNamespaceBlock(ctx, "", 0, cid, data, originalSize, compression)
+}
+
+// SaveNamespaceBlock saves a block like SaveBlock. A new block counts
+// against namespace, and is rejected with ErrQuotaExceeded if it would take
+// the namespace past quota (0 for none). Blocks stored already are free.
+func (s *Storage) SaveNamespaceBlock(ctx context.Context, namespace string, quota int64, cid string, data []byte, originalSize int64, compression string) error {
+	size := int64(len(data))
 	var inlineData []byte
 	var s3Key string
+	var reserved int64 // Quota taken for the S3 upload, see reserveQuota
 
 	if len(data) < InlineThreshold {
 		inlineData = data
 	} else {
+		// The S3 upload is too slow to hold writeMu for, so the quota is
+		// taken before it and the check below counts it
+		if quota > 0 {
+			var err error
+			if reserved, err = s.reserveQuota(ctx, namespace, quota, cid, size); err != nil {
+				return err
+			}
+		}
 		s3Key = blockS3Key(cid)
 		if err := s.s3.Put(ctx, s3Key, data); err != nil {
+			if reserved > 0 {
+				s.writeMu.Lock()
+				s.nsReserved[namespace] -= reserved
+				s.writeMu.Unlock()
+			}
 			return fmt.Errorf("failed to upload to S3: %w", err)
 		}
 	}
 
 	s.writeMu.Lock()
 	defer s.writeMu.Unlock()
+	if reserved > 0 {
+		s.nsReserved[namespace] -= reserved
+	}
 
-	_, err := s.db.ExecContext(ctx, `
-		INSERT OR IGNORE INTO blocks (cid, size, original_size, inline_data, s3_key, created_at)
-		VALUES (?, ?, ?, ?, ?, ?)
-	`, cid, len(data), originalSize, inlineData, s3Key, time.Now().Unix())
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
 
-	return err
+	if quota > 0 && reserved == 0 {
+		if err := s.checkQuotaTx(ctx, tx, namespace, quota, cid, size); err != nil {
+			return err
+		}
+	}
+	res, err := tx.ExecContext(ctx, `
+		INSERT OR IGNORE INTO blocks (cid, size, original_size, inline_data, s3_key, created_at, compression, namespace)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`, cid, len(data), originalSize, inlineData, s3Key, time.Now().Unix(), compression, namespace)
+	if err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	if used, ok := s.nsUsage[namespace]; ok {
+		if n, _ := res.RowsAffected(); n > 0 {
+			s.nsUsage[namespace] = used + size
+		}
+	}
+	return nil
 }
 
-// GetBlock retrieves a block from storage
+// GetBlock retrieves the original data of a block, decompressing it with
+// the codec it was stored with or its header
 func (s *Storage) GetBlock(ctx context.Context, cid string) ([]byte, error) {
+	body, info, err := s.GetBlockReader(ctx, cid)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return nil, err
+	}
+	if info.Compression == backup.CompressionHeader {
+		return backup.DecodeBlock(data)
+	}
+	decompressed, err := backup.DecompressBlock(info.Compression, data, info.OriginalSize)
+	if err != nil && info.Compression == "" {
+		return data, nil // Stored as is
+	}
+	return decompressed, err
+}
+
+// BlockInfo describes a stored block
+type BlockInfo struct {
+	Size         int64  // Stored bytes
+	OriginalSize int64  // Equal to Size if the block is stored uncompressed
+	Compression  string // Codec of compressed blocks, empty for LZ4, backup.CompressionHeader for blocks with a header
+}
+
+// GetBlockReader streams a block from storage instead of loading it, so
+// S3 objects are passed through as they arrive
+func (s *Storage) GetBlockReader(ctx context.Context, cid string) (io.ReadCloser, *BlockInfo, error) {
+	var info BlockInfo
 	var inlineData []byte
 	var hasS3 bool
 
 	err := s.db.QueryRowContext(ctx, `
-		SELECT inline_data, (s3_key IS NOT NULL AND s3_key != '') FROM blocks WHERE cid = ?
-	`, cid).Scan(&inlineData, &hasS3)
+		SELECT size, original_size, compression, inline_data, (s3_key IS NOT NULL AND s3_key != '') FROM blocks WHERE cid = ?
+	`, cid).Scan(&info.Size, &info.OriginalSize, &info.Compression, &inlineData, &hasS3)
 
 	if err == sql.ErrNoRows {
-		return nil, fmt.Errorf("block not found: %s", cid)
+		return nil, nil, fmt.Errorf("block not found: %s", cid)
 	}
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	if inlineData != nil {
-		return inlineData, nil
+		return io.NopCloser(bytes.NewReader(inlineData)), &info, nil
 	}
 
 	if hasS3 {
-		return s.s3.Get(ctx, blockS3Key(cid))
+		body, err := s.s3.GetReader(ctx, blockS3Key(cid))
+		if err != nil {
+			return nil, nil, err
+		}
+		return body, &info, nil
 	}
 
-	return nil, fmt.Errorf("block has no data: %s", cid)
+	return nil, nil, fmt.Errorf("block has no data: %s", cid)
 }
 
 // BlockExists checks if a block exists
@@ -186,6 +425,99 @@ func (s *Storage) BlockExists(ctx context.Context, cid string) (bool, error) {
 	return count > 0, err
 }
 
+// MissingBlocks returns the CIDs in cids that aren't stored, in order
+func (s *Storage) MissingBlocks(ctx context.Context, cids []string) ([]string, error) {
+	stored := make(map[string]bool)
+	// In batches, to stay below SQLite's limit on query parameters
+	for batch := range slices.Chunk(cids, 500) {
+		args := make([]any, len(batch))
+		for i, c := range batch {
+			args[i] = c
+		}
+		placeholders := strings.Repeat("?,", len(batch))
+		rows, err := s.db.QueryContext(ctx, `SELECT cid FROM blocks WHERE cid IN (`+placeholders[:len(placeholders)-1]+`)`, args...)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var c string
+			if err := rows.Scan(&c); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			stored[c] = true
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	missing := []string{}
+	for _, c := range cids {
+		if !stored[c] {
+			missing = append(missing, c)
+		}
+	}
+	return missing, nil
+}
+
+// Stats summarizes what is stored
+type Stats struct {
+	Blocks    int64
+	Bytes     int64 // Stored (compressed) block bytes
+	Manifests int64
+	Trashed   int64 // Manifests in the trash, not included in Manifests
+}
+
+// Stats counts stored blocks, their size and manifests
+func (s *Storage) Stats(ctx context.Context) (*Stats, error) {
+	var st Stats
+	if err := s.db.QueryRowContext(ctx, `SELECT COUNT(*), COALESCE(SUM(size), 0) FROM blocks`).Scan(&st.Blocks, &st.Bytes); err != nil {
+		return nil, err
+	}
+	if err := s.db.QueryRowContext(ctx, `
+		SELECT COUNT(*) FILTER (WHERE deleted_at = 0), COUNT(*) FILTER (WHERE deleted_at != 0) FROM manifests
+	`).Scan(&st.Manifests, &st.Trashed); err != nil {
+		return nil, err
+	}
+	return &st, nil
+}
+
+// NameStats summarizes the manifests sharing a name tag
+type NameStats struct {
+	Name      string
+	Manifests int64
+	Latest    time.Time
+}
+
+// BackupNames returns manifest counts and the latest backup time per name
+// tag. Manifests without a name tag are left out.
+func (s *Storage) BackupNames(ctx context.Context) ([]NameStats, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT json_extract(tags, '$.name') AS name, COUNT(*), MAX(created_at) FROM manifests
+		WHERE COALESCE(json_extract(tags, '$.name'), '') != '' AND deleted_at = 0
+		GROUP BY name
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []NameStats
+	for rows.Next() {
+		var ns NameStats
+		var latest int64
+		if err := rows.Scan(&ns.Name, &ns.Manifests, &latest); err != nil {
+			return nil, err
+		}
+		ns.Latest = time.Unix(latest, 0)
+		result = append(result, ns)
+	}
+	return result, rows.Err()
+}
+
 // SaveNode saves a dag-pb node (file or directory node)
 func (s *Storage) SaveNode(ctx context.Context, cid string, data []byte) error {
 	s.writeMu.Lock()
@@ -216,11 +548,51 @@ func (s *Storage) NodeExists(ctx context.Context, cid string) (bool, error) {
 	return count > 0, err
 }
 
-// SaveManifest saves a manifest with optional node CIDs for reference tracking
+// ListCIDs returns the CIDs of all stored nodes and blocks
+func (s *Storage) ListCIDs(ctx context.Context) ([]string, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT cid FROM nodes UNION ALL SELECT cid FROM blocks`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var cids []string
+	for rows.Next() {
+		var cid string
+		if err := rows.Scan(&cid); err != nil {
+			return nil, err
+		}
+		cids = append(cids, cid)
+	}
+	return cids, rows.Err()
+}
+
+// SaveManifest saves a manifest with optional node CIDs for reference
+// tracking. If the latest manifest with the same tags shares most of its
+// entries, only the difference to it is stored.
 func (s *Storage) SaveManifest(ctx context.Context, manifest *backup.Manifest, data []byte, nodeCIDs []string) error {
 	s.writeMu.Lock()
 	defer s.writeMu.Unlock()
 
+	var parentID string
+	var dataSize int64
+	if manifest.Encrypted == nil {
+		parent, err := s.deltaParent(ctx, manifest)
+		if err != nil {
+			logger.Warn("failed to load manifest parent, storing it in full", "manifest", manifest.ID, "error", err)
+		}
+		if parent != nil {
+			delta, size, err := encodeDelta(diffManifest(parent, manifest))
+			if err != nil {
+				return err
+			}
+			// Not worth a chain link unless it saves most of the space
+			if len(delta) < len(data)/2 {
+				data, parentID, dataSize = delta, parent.ID, size
+			}
+		}
+	}
+
 	tx, err := s.db.BeginTx(ctx, nil)
 	if err != nil {
 		return err
@@ -234,23 +606,21 @@ func (s *Storage) SaveManifest(ctx context.Context, manifest *backup.Manifest, d
 	}
 
 	_, err = tx.ExecContext(ctx, `
-		INSERT INTO manifests (id, tags, created_at, data)
-		VALUES (?, ?, ?, ?)
-	`, manifest.ID, tagsJSON, manifest.CreatedAt.Unix(), data)
+		INSERT INTO manifests (id, tags, created_at, data, parent_id, data_size)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`, manifest.ID, tagsJSON, manifest.CreatedAt.Unix(), data, parentID, dataSize)
 	if err != nil {
 		return err
 	}
 
 	// Save block references
-	for _, entry := range manifest.Entries {
-		for _, cid := range entry.Blocks {
-			_, err = tx.ExecContext(ctx, `
-				INSERT OR IGNORE INTO block_refs (manifest_id, cid)
-				VALUES (?, ?)
-			`, manifest.ID, cid)
-			if err != nil {
-				return err
-			}
+	for _, cid := range manifest.BlockCIDs() {
+		_, err = tx.ExecContext(ctx, `
+			INSERT OR IGNORE INTO block_refs (manifest_id, cid)
+			VALUES (?, ?)
+		`, manifest.ID, cid)
+		if err != nil {
+			return err
 		}
 	}
 
@@ -268,22 +638,102 @@ func (s *Storage) SaveManifest(ctx context.Context, manifest *backup.Manifest, d
 	return tx.Commit()
 }
 
-// GetManifest retrieves a manifest by ID
+// GetManifest retrieves a manifest by ID. Delta manifests are materialized
+// from their parents, so callers always get the full manifest. Manifests in
+// the trash are not found.
 func (s *Storage) GetManifest(ctx context.Context, id string) ([]byte, error) {
 	var data []byte
-	err := s.db.QueryRowContext(ctx, `SELECT data FROM manifests WHERE id = ?`, id).Scan(&data)
+	var parentID string
+	err := s.db.QueryRowContext(ctx, `SELECT data, parent_id FROM manifests WHERE id = ? AND deleted_at = 0`, id).Scan(&data, &parentID)
 	if err == sql.ErrNoRows {
 		return nil, fmt.Errorf("manifest not found: %s", id)
 	}
-	return data, err
+	if err != nil || parentID == "" {
+		return data, err
+	}
+
+	manifest, err := s.loadManifest(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return encodeManifest(manifest)
 }
 
-// ListManifests lists manifests, optionally filtered by tags
+// ListManifests lists manifests outside the trash, newest first, optionally
+// filtered by tags
 func (s *Storage) ListManifests(ctx context.Context, tags map[string]string) ([]ManifestInfo, error) {
-	query := `SELECT id, tags, created_at FROM manifests ORDER BY created_at DESC`
-	rows, err := s.db.QueryContext(ctx, query)
+	result, _, err := s.QueryManifests(ctx, ManifestQuery{Tags: tags, Desc: true})
+	return result, err
+}
+
+// ManifestQuery selects a page of manifests, see QueryManifests
+type ManifestQuery struct {
+	Tags      map[string]string
+	Namespace string // Only names in this namespace, see backup.InNamespace
+	Sort      string // ManifestSortCreated (default) or ManifestSortName
+	Desc      bool
+	Offset    int
+	Limit     int // 0 for all
+}
+
+// Orders of ManifestQuery.Sort
+const (
+	ManifestSortCreated = "created_at"
+	ManifestSortName    = "name" // By name tag, newest first within a name
+)
+
+// QueryManifests lists the manifests outside the trash matching q.Tags,
+// sorted and paged by SQLite, and how many match in total
+func (s *Storage) QueryManifests(ctx context.Context, q ManifestQuery) ([]ManifestInfo, int, error) {
+	// Indexed tags use their index, the rest are looked up in the tags
+	// object. A tag filtered for an empty value matches manifests without it.
+	where := []string{`deleted_at = 0`}
+	var args []any
+	for _, key := range slices.Sorted(maps.Keys(q.Tags)) {
+		v := q.Tags[key]
+		if v != "" && slices.Contains(backup.HostTagKeys, key) {
+			where = append(where, tagExpr(key)+` = ?`)
+		} else {
+			where = append(where, `COALESCE((SELECT value FROM json_each(manifests.tags) WHERE key = ?), '') = ?`)
+			args = append(args, key)
+		}
+		args = append(args, v)
+	}
+	if q.Namespace != "" {
+		// Names below the namespace sort between "<namespace>/" and
+		// "<namespace>0", '0' being the byte after '/'
+		where = append(where, `(json_extract(tags, '$.name') = ? OR (json_extract(tags, '$.name') >= ? AND json_extract(tags, '$.name') < ?))`)
+		args = append(args, q.Namespace, q.Namespace+"/", q.Namespace+"0")
+	}
+	filter := ` WHERE ` + strings.Join(where, ` AND `)
+
+	dir := ` ASC`
+	if q.Desc {
+		dir = ` DESC`
+	}
+	var order string
+	switch q.Sort {
+	case "", ManifestSortCreated:
+		order = `created_at` + dir + `, id` + dir
+	case ManifestSortName:
+		order = `COALESCE(json_extract(tags, '$.name'), '')` + dir + `, created_at DESC, id DESC`
+	default:
+		return nil, 0, fmt.Errorf("unknown manifest sort %q", q.Sort)
+	}
+
+	query := `SELECT id, tags, created_at FROM manifests` + filter + ` ORDER BY ` + order
+	pageArgs := args
+	if q.Limit > 0 || q.Offset > 0 {
+		limit := q.Limit
+		if limit <= 0 {
+			limit = -1 // No limit in SQLite
+		}
+		query += ` LIMIT ? OFFSET ?`
+		pageArgs = append(slices.Clip(args), limit, q.Offset)
+	}
+	rows, err := s.db.QueryContext(ctx, query, pageArgs...)
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 	defer rows.Close()
 
@@ -294,24 +744,31 @@ func (s *Storage) ListManifests(ctx context.Context, tags map[string]string) ([]
 		var createdAt int64
 
 		if err := rows.Scan(&info.ID, &tagsJSON, &createdAt); err != nil {
-			return nil, err
+			return nil, 0, err
 		}
 
 		info.Tags, _ = deserializeTags(tagsJSON)
 		info.CreatedAt = time.Unix(createdAt, 0)
+		result = append(result, info)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, err
+	}
 
-		// Filter by tags if provided
-		if matchesTags(info.Tags, tags) {
-			result = append(result, info)
+	// Only a page needs counting
+	total := q.Offset + len(result)
+	if (q.Limit > 0 && len(result) == q.Limit) || (q.Offset > 0 && len(result) == 0) {
+		if err := s.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM manifests`+filter, args...).Scan(&total); err != nil {
+			return nil, 0, err
 		}
 	}
-
-	return result, rows.Err()
+	return result, total, nil
 }
 
-// GetLatestManifest gets the latest manifest matching the given tags
-func (s *Storage) GetLatestManifest(ctx context.Context, tags map[string]string) ([]byte, error) {
-	manifests, err := s.ListManifests(ctx, tags)
+// GetLatestManifest gets the latest manifest matching the given tags in a
+// namespace, empty for all
+func (s *Storage) GetLatestManifest(ctx context.Context, tags map[string]string, namespace string) ([]byte, error) {
+	manifests, _, err := s.QueryManifests(ctx, ManifestQuery{Tags: tags, Namespace: namespace, Desc: true, Limit: 1})
 	if err != nil {
 		return nil, err
 	}
@@ -323,40 +780,130 @@ func (s *Storage) GetLatestManifest(ctx context.Context, tags map[string]string)
 	return s.GetManifest(ctx, manifests[0].ID)
 }
 
-// DeleteManifest deletes a manifest
+// DeleteManifest deletes a manifest right away, whether or not it is in the
+// trash. Its blocks are removed by the next garbage collection.
 func (s *Storage) DeleteManifest(ctx context.Context, id string) error {
 	s.writeMu.Lock()
 	defer s.writeMu.Unlock()
 
+	if err := s.flattenChildrenLocked(ctx, map[string]bool{id: true}); err != nil {
+		return fmt.Errorf("failed to detach delta manifests: %w", err)
+	}
 	_, err := s.db.ExecContext(ctx, `DELETE FROM manifests WHERE id = ?`, id)
 	return err
 }
 
-// PruneManifests deletes manifests older than the cutoff and cleans up orphaned blocks
-func (s *Storage) PruneManifests(ctx context.Context, cutoff time.Time) error {
+// PruneResult reports what a prune removed, or would remove in a dry run
+type PruneResult struct {
+	Manifests      int64          `json:"manifests"`
+	Blocks         int64          `json:"blocks"`
+	Bytes          int64          `json:"bytes"` // Stored size of the removed blocks
+	Nodes          int64          `json:"nodes"`
+	S3DeleteErrors int64          `json:"s3_delete_errors"` // Orphaned S3 objects that couldn't be deleted
+	Pruned         []ManifestInfo `json:"pruned,omitempty"` // The removed manifests
+	DryRun         bool           `json:"dry_run,omitempty"`
+}
+
+// PruneOptions select the manifests PruneManifests removes
+type PruneOptions struct {
+	Cutoff      time.Time // Remove manifests created before, unless their name has a policy
+	TrashCutoff time.Time // Remove manifests trashed before
+
+	// Retention policies by name tag, with DefaultPolicy for names
+	// without their own. They decide instead of Cutoff; manifests in the
+	// trash don't count towards them.
+	Policies map[string]backup.RetentionPolicy
+
+	DryRun bool // Only report what would be removed
+}
+
+// PruneManifests deletes the manifests the options expire and cleans up
+// orphaned blocks. A dry run only reports what would be removed.
+func (s *Storage) PruneManifests(ctx context.Context, opts PruneOptions) (*PruneResult, error) {
 	s.writeMu.Lock()
 	defer s.writeMu.Unlock()
 
-	// Delete old manifests (block_refs will cascade delete)
-	_, err := s.db.ExecContext(ctx, `
-		DELETE FROM manifests WHERE created_at < ?
-	`, cutoff.Unix())
+	result := &PruneResult{DryRun: opts.DryRun}
+	expired, err := s.expiredManifestsLocked(ctx, opts)
 	if err != nil {
-		return err
+		return result, err
+	}
+	result.Pruned = expired
+
+	pruned := make(map[string]bool, len(expired))
+	ids := make([]string, 0, len(expired))
+	for _, m := range expired {
+		pruned[m.ID] = true
+		ids = append(ids, m.ID)
+	}
+	idsJSON, err := json.Marshal(ids)
+	if err != nil {
+		return result, err
+	}
+	const inPruned = `(SELECT value FROM json_each(?))`
+
+	if opts.DryRun {
+		// Blocks and nodes only the pruned manifests, or none, refer to,
+		// past the grace period like in pruneOrphanedBlocksLocked
+		result.Manifests = int64(len(pruned))
+		graceCutoff := time.Now().Add(-orphanGracePeriod).Unix()
+		err := s.db.QueryRowContext(ctx, `
+			SELECT COUNT(*), COALESCE(SUM(size), 0) FROM blocks b WHERE b.created_at < ? AND NOT EXISTS (
+				SELECT 1 FROM block_refs r WHERE r.cid = b.cid AND r.manifest_id NOT IN `+inPruned+`)
+		`, graceCutoff, string(idsJSON)).Scan(&result.Blocks, &result.Bytes)
+		if err != nil {
+			return result, err
+		}
+		err = s.db.QueryRowContext(ctx, `
+			SELECT COUNT(*) FROM nodes n WHERE n.created_at < ? AND NOT EXISTS (
+				SELECT 1 FROM node_refs r WHERE r.cid = n.cid AND r.manifest_id NOT IN `+inPruned+`)
+		`, graceCutoff, string(idsJSON)).Scan(&result.Nodes)
+		return result, err
+	}
+	// Manifests stored as deltas against the pruned ones must be kept whole
+	if err := s.flattenChildrenLocked(ctx, pruned); err != nil {
+		return result, fmt.Errorf("failed to detach delta manifests: %w", err)
+	}
+
+	// Delete old manifests with their references, which only cascade
+	// with foreign keys enabled
+	res, err := s.db.ExecContext(ctx, `DELETE FROM manifests WHERE id IN `+inPruned, string(idsJSON))
+	if err != nil {
+		return result, err
+	}
+	result.Manifests, _ = res.RowsAffected()
+	for _, table := range []string{"block_refs", "node_refs"} {
+		if _, err := s.db.ExecContext(ctx, `DELETE FROM `+table+` WHERE manifest_id IN `+inPruned, string(idsJSON)); err != nil {
+			return result, err
+		}
 	}
 
 	// Find and delete orphaned blocks
-	return s.pruneOrphanedBlocksLocked(ctx)
+	return result, s.pruneOrphanedBlocksLocked(ctx, result)
 }
 
-// pruneOrphanedBlocksLocked must be called with writeMu held
-func (s *Storage) pruneOrphanedBlocksLocked(ctx context.Context) error {
+// CollectGarbage removes blocks and nodes no manifest refers to anymore,
+// without pruning any manifests
+func (s *Storage) CollectGarbage(ctx context.Context) (*PruneResult, error) {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	result := &PruneResult{}
+	return result, s.pruneOrphanedBlocksLocked(ctx, result)
+}
+
+// pruneOrphanedBlocksLocked must be called with writeMu held. It adds what
+// it removes to result. Blocks and nodes younger than orphanGracePeriod
+// are kept.
+func (s *Storage) pruneOrphanedBlocksLocked(ctx context.Context, result *PruneResult) error {
+	graceCutoff := time.Now().Add(-orphanGracePeriod).Unix()
+
 	// Find blocks with no references
 	rows, err := s.db.QueryContext(ctx, `
-		SELECT b.cid, (b.s3_key IS NOT NULL AND b.s3_key != '') as has_s3 FROM blocks b
+		SELECT b.cid, b.size, (b.s3_key IS NOT NULL AND b.s3_key != '') as has_s3 FROM blocks b
 		LEFT JOIN block_refs br ON b.cid = br.cid
-		WHERE br.cid IS NULL
-	`)
+		WHERE br.cid IS NULL AND b.created_at < ?
+	`, graceCutoff)
 	if err != nil {
 		return err
 	}
@@ -364,14 +911,17 @@ func (s *Storage) pruneOrphanedBlocksLocked(ctx context.Context) error {
 
 	var toDelete []string
 	var s3Cids []string
+	sizes := make(map[string]int64)
 
 	for rows.Next() {
 		var cid string
+		var size int64
 		var hasS3 bool
-		if err := rows.Scan(&cid, &hasS3); err != nil {
+		if err := rows.Scan(&cid, &size, &hasS3); err != nil {
 			return err
 		}
 		toDelete = append(toDelete, cid)
+		sizes[cid] = size
 		if hasS3 {
 			s3Cids = append(s3Cids, cid)
 		}
@@ -381,7 +931,8 @@ func (s *Storage) pruneOrphanedBlocksLocked(ctx context.Context) error {
 	for _, cid := range s3Cids {
 		key := blockS3Key(cid)
 		if err := s.s3.Delete(ctx, key); err != nil {
-			fmt.Printf("Warning: failed to delete S3 object %s: %v\n", key, err)
+			logger.Warn("failed to delete S3 object", "key", key, "error", err)
+			result.S3DeleteErrors++
 		}
 	}
 
@@ -390,18 +941,21 @@ func (s *Storage) pruneOrphanedBlocksLocked(ctx context.Context) error {
 		if _, err := s.db.ExecContext(ctx, `DELETE FROM blocks WHERE cid = ?`, cid); err != nil {
 			return err
 		}
+		result.Blocks++
+		result.Bytes += sizes[cid]
 	}
 
 	if len(toDelete) > 0 {
-		fmt.Printf("Pruned %d orphaned blocks\n", len(toDelete))
+		s.nsUsage = nil
+		logger.Info("pruned orphaned blocks", "count", len(toDelete))
 	}
 
 	// Find and delete orphaned nodes (dag-pb nodes)
 	nodeRows, err := s.db.QueryContext(ctx, `
 		SELECT n.cid FROM nodes n
 		LEFT JOIN node_refs nr ON n.cid = nr.cid
-		WHERE nr.cid IS NULL
-	`)
+		WHERE nr.cid IS NULL AND n.created_at < ?
+	`, graceCutoff)
 	if err != nil {
 		return err
 	}
@@ -421,10 +975,11 @@ func (s *Storage) pruneOrphanedBlocksLocked(ctx context.Context) error {
 		if _, err := s.db.ExecContext(ctx, `DELETE FROM nodes WHERE cid = ?`, cid); err != nil {
 			return err
 		}
+		result.Nodes++
 	}
 
 	if len(nodesToDelete) > 0 {
-		fmt.Printf("Pruned %d orphaned nodes\n", len(nodesToDelete))
+		logger.Info("pruned orphaned nodes", "count", len(nodesToDelete))
 	}
 
 	return nil
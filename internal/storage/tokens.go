@@ -0,0 +1,129 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// TokenInfo describes a named API token. The token itself is never stored,
+// only its salted hash.
+type TokenInfo struct {
+	ID         string    `json:"id"`
+	Name       string    `json:"name"`
+	Scope      string    `json:"scope"`
+	AppendOnly bool      `json:"append_only"` // Can't delete manifests or change tags
+	Signed     bool      `json:"signed"`      // Requests must be HMAC-signed, not bearer
+	SigningKey string    `json:"-"`           // Hex, set for signed tokens
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at"` // Zero if never used
+	ExpiresAt  time.Time `json:"expires_at"`   // Zero if it doesn't expire
+	Namespace  string    `json:"namespace"`    // Backup names it is limited to, see backup.InNamespace
+	Hash       string    `json:"-"`
+}
+
+// CreateToken stores a new named token
+func (s *Storage) CreateToken(ctx context.Context, info *TokenInfo) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	var expiresAt int64
+	if !info.ExpiresAt.IsZero() {
+		expiresAt = info.ExpiresAt.Unix()
+	}
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO tokens (id, name, hash, scope, append_only, signing_key, created_at, expires_at, namespace)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, info.ID, info.Name, info.Hash, info.Scope, info.AppendOnly, info.SigningKey, info.CreatedAt.Unix(), expiresAt, info.Namespace)
+	if err != nil && strings.Contains(err.Error(), "UNIQUE") {
+		return fmt.Errorf("token %q already exists", info.Name)
+	}
+	return err
+}
+
+// GetToken retrieves a named token by ID
+func (s *Storage) GetToken(ctx context.Context, id string) (*TokenInfo, error) {
+	row := s.db.QueryRowContext(ctx, `
+		SELECT id, name, hash, scope, append_only, signing_key, created_at, last_used_at, expires_at, namespace FROM tokens WHERE id = ?
+	`, id)
+
+	info, err := scanToken(row)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("token not found: %s", id)
+	}
+	return info, err
+}
+
+// ListTokens lists all named tokens, oldest first
+func (s *Storage) ListTokens(ctx context.Context) ([]TokenInfo, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id, name, hash, scope, append_only, signing_key, created_at, last_used_at, expires_at, namespace FROM tokens ORDER BY created_at
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := make([]TokenInfo, 0)
+	for rows.Next() {
+		info, err := scanToken(rows)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, *info)
+	}
+	return result, rows.Err()
+}
+
+// DeleteToken revokes a named token
+func (s *Storage) DeleteToken(ctx context.Context, name string) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	res, err := s.db.ExecContext(ctx, `DELETE FROM tokens WHERE name = ?`, name)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("token not found: %s", name)
+	}
+	return nil
+}
+
+// TouchToken records that a token was used
+func (s *Storage) TouchToken(ctx context.Context, id string, at time.Time) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	_, err := s.db.ExecContext(ctx, `UPDATE tokens SET last_used_at = ? WHERE id = ?`, at.Unix(), id)
+	return err
+}
+
+// Expired reports whether the token is past its expiry
+func (t *TokenInfo) Expired(now time.Time) bool {
+	return !t.ExpiresAt.IsZero() && !now.Before(t.ExpiresAt)
+}
+
+// rowScanner is satisfied by *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanToken(row rowScanner) (*TokenInfo, error) {
+	var info TokenInfo
+	var createdAt, lastUsedAt, expiresAt int64
+	if err := row.Scan(&info.ID, &info.Name, &info.Hash, &info.Scope, &info.AppendOnly, &info.SigningKey, &createdAt, &lastUsedAt, &expiresAt, &info.Namespace); err != nil {
+		return nil, err
+	}
+	info.Signed = info.SigningKey != ""
+	info.CreatedAt = time.Unix(createdAt, 0)
+	if lastUsedAt > 0 {
+		info.LastUsedAt = time.Unix(lastUsedAt, 0)
+	}
+	if expiresAt > 0 {
+		info.ExpiresAt = time.Unix(expiresAt, 0)
+	}
+	return &info, nil
+}
@@ -0,0 +1,46 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/johann/ib/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = tracing.Tracer("storage")
+
+// tracedDB records a span for every SQLite query made with a context
+type tracedDB struct {
+	*sql.DB
+}
+
+func startQuery(ctx context.Context, op, query string) (context.Context, trace.Span) {
+	return tracer.Start(ctx, "sqlite."+op, trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("db.system", "sqlite"),
+			attribute.String("db.statement", query),
+		))
+}
+
+func (db *tracedDB) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	ctx, span := startQuery(ctx, "exec", query)
+	result, err := db.DB.ExecContext(ctx, query, args...)
+	tracing.End(span, err)
+	return result, err
+}
+
+func (db *tracedDB) QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	ctx, span := startQuery(ctx, "query", query)
+	rows, err := db.DB.QueryContext(ctx, query, args...)
+	tracing.End(span, err)
+	return rows, err
+}
+
+func (db *tracedDB) QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row {
+	ctx, span := startQuery(ctx, "query_row", query)
+	row := db.DB.QueryRowContext(ctx, query, args...)
+	tracing.End(span, row.Err())
+	return row
+}
@@ -0,0 +1,78 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// TrashedManifest is a deleted manifest that can still be undeleted
+type TrashedManifest struct {
+	ID        string            `json:"id"`
+	Tags      map[string]string `json:"tags"`
+	CreatedAt time.Time         `json:"created_at"`
+	DeletedAt time.Time         `json:"deleted_at"`
+}
+
+// TrashManifest moves a manifest to the trash. It disappears from listings
+// and downloads, but keeps its blocks until the trash is pruned.
+func (s *Storage) TrashManifest(ctx context.Context, id string) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	res, err := s.db.ExecContext(ctx, `
+		UPDATE manifests SET deleted_at = ? WHERE id = ? AND deleted_at = 0
+	`, time.Now().Unix(), id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("manifest not found: %s", id)
+	}
+	return nil
+}
+
+// UndeleteManifest takes a manifest out of the trash
+func (s *Storage) UndeleteManifest(ctx context.Context, id string) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	res, err := s.db.ExecContext(ctx, `
+		UPDATE manifests SET deleted_at = 0 WHERE id = ? AND deleted_at != 0
+	`, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return fmt.Errorf("manifest not found in trash: %s", id)
+	}
+	return nil
+}
+
+// ListTrash lists the manifests in the trash, most recently deleted first
+func (s *Storage) ListTrash(ctx context.Context) ([]TrashedManifest, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id, tags, created_at, deleted_at FROM manifests
+		WHERE deleted_at != 0
+		ORDER BY deleted_at DESC
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := []TrashedManifest{}
+	for rows.Next() {
+		var m TrashedManifest
+		var tagsJSON string
+		var createdAt, deletedAt int64
+		if err := rows.Scan(&m.ID, &tagsJSON, &createdAt, &deletedAt); err != nil {
+			return nil, err
+		}
+		m.Tags, _ = deserializeTags(tagsJSON)
+		m.CreatedAt = time.Unix(createdAt, 0)
+		m.DeletedAt = time.Unix(deletedAt, 0)
+		result = append(result, m)
+	}
+	return result, rows.Err()
+}
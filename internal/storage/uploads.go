@@ -0,0 +1,176 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// BlockUpload is a block being uploaded in parts, see AppendBlockUpload.
+// Uploads belong to their owner, so clients uploading the same block
+// don't interfere.
+type BlockUpload struct {
+	Owner        string    `json:"-"` // Token uploading it
+	Namespace    string    `json:"-"` // Whose quota the data received counts against
+	CID          string    `json:"cid"`
+	Length       int64     `json:"length"` // Stored size of the whole block
+	OriginalSize int64     `json:"original_size"`
+	Compression  string    `json:"compression"`
+	Offset       int64     `json:"offset"` // Bytes received so far
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+// GetBlockUpload returns owner's upload in progress of a block
+func (s *Storage) GetBlockUpload(ctx context.Context, owner, cid string) (*BlockUpload, error) {
+	up := BlockUpload{Owner: owner, CID: cid}
+	var updatedAt int64
+	err := s.db.QueryRowContext(ctx, `
+		SELECT u.namespace, u.length, u.original_size, u.compression, u.updated_at,
+			COALESCE((SELECT SUM(length(p.data)) FROM block_upload_parts p WHERE p.owner = u.owner AND p.cid = u.cid), 0)
+		FROM block_uploads u WHERE u.owner = ? AND u.cid = ?
+	`, owner, cid).Scan(&up.Namespace, &up.Length, &up.OriginalSize, &up.Compression, &updatedAt, &up.Offset)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("upload not found: %s", cid)
+	}
+	if err != nil {
+		return nil, err
+	}
+	up.UpdatedAt = time.Unix(updatedAt, 0)
+	return &up, nil
+}
+
+// AppendBlockUpload adds data at offset to up.Owner's upload of up.CID,
+// starting it (over) at offset 0. Returns the new offset, or the current
+// one and false if offset isn't where the upload stands or up describes
+// the block differently than when the upload started. With a quota, the
+// data received for uploads in up.Namespace counts towards it, and data
+// that would exceed it is rejected with ErrQuotaExceeded.
+func (s *Storage) AppendBlockUpload(ctx context.Context, up *BlockUpload, offset int64, data []byte, quota int64) (int64, bool, error) {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, false, err
+	}
+	defer tx.Rollback()
+
+	var length, originalSize, current int64
+	var compression string
+	err = tx.QueryRowContext(ctx, `
+		SELECT u.length, u.original_size, u.compression,
+			COALESCE((SELECT SUM(length(p.data)) FROM block_upload_parts p WHERE p.owner = u.owner AND p.cid = u.cid), 0)
+		FROM block_uploads u WHERE u.owner = ? AND u.cid = ?
+	`, up.Owner, up.CID).Scan(&length, &originalSize, &compression, &current)
+	switch {
+	case err == sql.ErrNoRows:
+		if offset != 0 {
+			return 0, false, nil
+		}
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO block_uploads (owner, cid, namespace, length, original_size, compression, updated_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?)
+		`, up.Owner, up.CID, up.Namespace, up.Length, up.OriginalSize, up.Compression, time.Now().Unix())
+		if err != nil {
+			return 0, false, err
+		}
+	case err != nil:
+		return 0, false, err
+	case offset == 0:
+		// Starting over, possibly with another codec
+		if _, err := tx.ExecContext(ctx, `DELETE FROM block_upload_parts WHERE owner = ? AND cid = ?`, up.Owner, up.CID); err != nil {
+			return 0, false, err
+		}
+		_, err = tx.ExecContext(ctx, `
+			UPDATE block_uploads SET namespace = ?, length = ?, original_size = ?, compression = ?, updated_at = ?
+			WHERE owner = ? AND cid = ?
+		`, up.Namespace, up.Length, up.OriginalSize, up.Compression, time.Now().Unix(), up.Owner, up.CID)
+		if err != nil {
+			return 0, false, err
+		}
+	case offset != current || length != up.Length || originalSize != up.OriginalSize || compression != up.Compression:
+		return current, false, nil
+	}
+	if len(data) == 0 {
+		return offset, true, tx.Commit()
+	}
+
+	if quota > 0 {
+		used, err := s.namespaceUsageTx(ctx, tx, up.Namespace)
+		if err != nil {
+			return 0, false, err
+		}
+		used += s.nsReserved[up.Namespace]
+		var staged int64
+		err = tx.QueryRowContext(ctx, `
+			SELECT COALESCE(SUM(length(p.data)), 0) FROM block_upload_parts p
+			JOIN block_uploads u ON u.owner = p.owner AND u.cid = p.cid
+			WHERE u.namespace = ?
+		`, up.Namespace).Scan(&staged)
+		if err != nil {
+			return 0, false, err
+		}
+		if used+staged+int64(len(data)) > quota {
+			return 0, false, fmt.Errorf("%w: namespace %q uses %d of %d bytes with %d bytes of uploads in progress",
+				ErrQuotaExceeded, up.Namespace, used, quota, staged)
+		}
+	}
+
+	if _, err := tx.ExecContext(ctx, `INSERT INTO block_upload_parts (owner, cid, pos, data) VALUES (?, ?, ?, ?)`, up.Owner, up.CID, offset, data); err != nil {
+		return 0, false, err
+	}
+	if _, err := tx.ExecContext(ctx, `UPDATE block_uploads SET updated_at = ? WHERE owner = ? AND cid = ?`, time.Now().Unix(), up.Owner, up.CID); err != nil {
+		return 0, false, err
+	}
+	return offset + int64(len(data)), true, tx.Commit()
+}
+
+// BlockUploadData returns the data received for owner's block upload, in
+// order
+func (s *Storage) BlockUploadData(ctx context.Context, owner, cid string) ([]byte, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT data FROM block_upload_parts WHERE owner = ? AND cid = ? ORDER BY pos`, owner, cid)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var buf bytes.Buffer
+	for rows.Next() {
+		var part []byte
+		if err := rows.Scan(&part); err != nil {
+			return nil, err
+		}
+		buf.Write(part)
+	}
+	return buf.Bytes(), rows.Err()
+}
+
+// DeleteBlockUpload removes owner's block upload and the data received
+// for it
+func (s *Storage) DeleteBlockUpload(ctx context.Context, owner, cid string) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	if _, err := s.db.ExecContext(ctx, `DELETE FROM block_upload_parts WHERE owner = ? AND cid = ?`, owner, cid); err != nil {
+		return err
+	}
+	_, err := s.db.ExecContext(ctx, `DELETE FROM block_uploads WHERE owner = ? AND cid = ?`, owner, cid)
+	return err
+}
+
+// PruneBlockUploads removes uploads that haven't received data since
+// cutoff
+func (s *Storage) PruneBlockUploads(ctx context.Context, cutoff time.Time) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+
+	if _, err := s.db.ExecContext(ctx, `
+		DELETE FROM block_upload_parts WHERE (owner, cid) IN (SELECT owner, cid FROM block_uploads WHERE updated_at < ?)
+	`, cutoff.Unix()); err != nil {
+		return err
+	}
+	_, err := s.db.ExecContext(ctx, `DELETE FROM block_uploads WHERE updated_at < ?`, cutoff.Unix())
+	return err
+}
@@ -0,0 +1,185 @@
+package systemd
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// Option is one Key=Value line of a unit file
+type Option struct {
+	Key   string
+	Value string
+}
+
+// Section is a [Name] block of a unit file
+type Section struct {
+	Name    string
+	Options []Option
+}
+
+// Unit is a unit file to install under FileName
+type Unit struct {
+	FileName string
+	Sections []Section
+}
+
+// String renders the unit file
+func (u *Unit) String() string {
+	var b strings.Builder
+	b.WriteString("# Generated by ib\n")
+	for i, s := range u.Sections {
+		if i > 0 {
+			b.WriteString("\n")
+		}
+		fmt.Fprintf(&b, "[%s]\n", s.Name)
+		for _, o := range s.Options {
+			fmt.Fprintf(&b, "%s=%s\n", o.Key, o.Value)
+		}
+	}
+	return b.String()
+}
+
+// Hardening returns sandboxing options that leave the file system
+// read-only except for writable, and drop all capabilities
+func Hardening(writable ...string) []Option {
+	opts := []Option{
+		{"NoNewPrivileges", "yes"},
+		{"PrivateTmp", "yes"},
+		{"PrivateDevices", "yes"},
+		{"ProtectSystem", "strict"},
+		{"ProtectHome", "read-only"},
+		{"ProtectKernelTunables", "yes"},
+		{"ProtectKernelModules", "yes"},
+		{"ProtectKernelLogs", "yes"},
+		{"ProtectControlGroups", "yes"},
+		{"ProtectClock", "yes"},
+		{"ProtectHostname", "yes"},
+		{"RestrictNamespaces", "yes"},
+		{"RestrictRealtime", "yes"},
+		{"RestrictSUIDSGID", "yes"},
+		{"LockPersonality", "yes"},
+		{"RestrictAddressFamilies", "AF_UNIX AF_INET AF_INET6 AF_NETLINK"},
+		{"SystemCallArchitectures", "native"},
+		{"CapabilityBoundingSet", ""},
+	}
+
+	seen := make(map[string]bool)
+	var paths []string
+	for _, p := range writable {
+		if p != "" && !seen[p] {
+			seen[p] = true
+			paths = append(paths, Quote(p))
+		}
+	}
+	if len(paths) > 0 {
+		opts = append(opts, Option{"ReadWritePaths", strings.Join(paths, " ")})
+	}
+	return opts
+}
+
+// Quote quotes a word for use in ExecStart and path lists if needed
+func Quote(s string) string {
+	if s != "" && !strings.ContainsAny(s, " \t\"'\\") {
+		return s
+	}
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+// Command returns an ExecStart value running args
+func Command(args ...string) string {
+	quoted := make([]string, len(args))
+	for i, a := range args {
+		quoted[i] = Quote(a)
+	}
+	return strings.Join(quoted, " ")
+}
+
+// UnitDir returns where units are installed: the system directory, or the
+// per-user one for user units
+func UnitDir(user bool) (string, error) {
+	if !user {
+		return "/etc/systemd/system", nil
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "systemd", "user"), nil
+}
+
+// Install writes the units and reloads systemd. Units named in enable are
+// then enabled and started.
+func Install(units []*Unit, user bool, enable ...string) (string, error) {
+	dir, err := UnitDir(user)
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create unit directory: %w", err)
+	}
+	for _, u := range units {
+		if err := os.WriteFile(filepath.Join(dir, u.FileName), []byte(u.String()), 0644); err != nil {
+			return "", fmt.Errorf("failed to write %s: %w", u.FileName, err)
+		}
+	}
+
+	if err := systemctl(user, "daemon-reload"); err != nil {
+		return dir, err
+	}
+	if len(enable) > 0 {
+		if err := systemctl(user, append([]string{"enable", "--now"}, enable...)...); err != nil {
+			return dir, err
+		}
+	}
+	return dir, nil
+}
+
+func systemctl(user bool, args ...string) error {
+	if user {
+		args = append([]string{"--user"}, args...)
+	}
+	out, err := exec.Command("systemctl", args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("systemctl %s: %w: %s", strings.Join(args, " "), err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// Uninstall disables and stops the named units, removes their files and
+// reloads systemd. Units that aren't installed are skipped, and templates
+// (name@.service) are only removed.
+func Uninstall(fileNames []string, user bool) error {
+	dir, err := UnitDir(user)
+	if err != nil {
+		return err
+	}
+
+	var installed, enabled []string
+	for _, name := range fileNames {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			continue
+		}
+		installed = append(installed, name)
+		if !strings.Contains(name, "@.") {
+			enabled = append(enabled, name)
+		}
+	}
+	if len(installed) == 0 {
+		return nil
+	}
+
+	if len(enabled) > 0 {
+		if err := systemctl(user, append([]string{"disable", "--now"}, enabled...)...); err != nil {
+			return err
+		}
+	}
+	for _, name := range installed {
+		if err := os.Remove(filepath.Join(dir, name)); err != nil {
+			return fmt.Errorf("failed to remove %s: %w", name, err)
+		}
+	}
+	return systemctl(user, "daemon-reload")
+}
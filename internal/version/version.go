@@ -0,0 +1,57 @@
+// Package version describes what a server build supports, so clients can
+// pick the code paths a server understands and warn about mismatches
+// instead of failing on unknown endpoints.
+package version
+
+import "slices"
+
+// API is the version of the HTTP API. It only changes when an existing
+// endpoint changes incompatibly; new endpoints are announced as features.
+const API = 1
+
+// Features a server can announce
+const (
+	Encryption      = "encryption"       // Zero-knowledge manifests and blocks
+	SignedManifests = "signed_manifests" // Manifests keep the client's signature
+	SignedRequests  = "signed_requests"  // HMAC-signed requests instead of bearer tokens
+	Runs            = "runs"             // Run summaries, POST and GET /api/runs
+	ManifestTree    = "manifest_tree"    // Paged manifest entries and directory listings
+	ManifestDeltas  = "manifest_deltas"  // Manifests stored as deltas against their parent
+	HostTags        = "host_tags"        // Indexed host, os, arch, user and ib_version tags
+	CLIChecksums    = "cli_checksums"    // Signed checksums of the embedded client binaries
+	Admin           = "admin"            // Stats, prune, gc and scrub under /api/admin
+	DedupReport     = "dedup_report"     // Shared and exclusive bytes per manifest
+	Trash           = "trash"            // Deleted manifests can be undeleted for a while
+	Zstd            = "zstd"             // Blocks compressed with zstd, codec sent as X-Compression
+	BlockBatch      = "block_batch"      // POST /api/blocks/exists checks many blocks at once
+	BlockHeader     = "block_header"     // Blocks with a header, X-Compression: header
+	ResumableUpload = "resumable_upload" // Interrupted block uploads continue, /api/blocks/:cid/upload
+	ManifestDiff    = "manifest_diff"    // GET /api/manifests/:id/diff/:other compares two manifests
+	Compression     = "compression"      // gzip/zstd responses, and manifest uploads with Content-Encoding
+)
+
+// Info is the response of GET /api/version
+type Info struct {
+	Version  string   `json:"version"` // Build version, "dev" for local builds
+	API      int      `json:"api"`
+	Features []string `json:"features"`
+}
+
+// Server describes this build as a server
+func Server(build string) *Info {
+	return &Info{
+		Version: build,
+		API:     API,
+		Features: []string{
+			Encryption, SignedManifests, SignedRequests, Runs, ManifestTree,
+			ManifestDeltas, HostTags, CLIChecksums, Admin, DedupReport, Trash,
+			Zstd, BlockBatch, BlockHeader, ResumableUpload, ManifestDiff,
+			Compression,
+		},
+	}
+}
+
+// Has reports whether the server announced a feature
+func (i *Info) Has(feature string) bool {
+	return slices.Contains(i.Features, feature)
+}